@@ -3,12 +3,29 @@ package config
 import (
 	"flag"
 	"os"
+	"strconv"
+	"time"
 )
 
 type Config struct {
-	SrvAddress    string
-	DBURI         string
-	AccrualAddres string
+	SrvAddress        string
+	DBURI             string
+	AccrualAddres     string
+	AccrualRateLimit  int
+	DispatchWorkers   int
+	OutboxFile        string
+	JWTKey            string
+	JWTKeysFile       string
+	JWTRandomKey      bool
+	CookiePath        string
+	CookieRefreshPath string
+	CookieSecure      bool
+	CookieHTTPOnly    bool
+	CookieSameSite    string
+	JWTIssuer         string
+	JWTAudience       string
+	JWTLeeway         time.Duration
+	ClientIPHeader    string
 }
 
 func LoadConfig() (*Config, error) {
@@ -16,8 +33,25 @@ func LoadConfig() (*Config, error) {
 	config := &Config{}
 
 	flag.StringVar(&config.SrvAddress, "a", "localhost:8080", "addres for server exposing")
-	flag.StringVar(&config.DBURI, "d", "", "database connection string")
+	flag.StringVar(&config.DBURI, "d", "", "database connection string, sqlite:///path/to/file.db selects SQLite")
 	flag.StringVar(&config.AccrualAddres, "r", "localhost:8081", "accrual address")
+	flag.IntVar(&config.AccrualRateLimit, "l", 0, "accrual requests per minute limit, 0 for unlimited")
+	flag.IntVar(&config.DispatchWorkers, "w", 4, "count of workers processing unhandled orders")
+	flag.StringVar(&config.OutboxFile, "o", "", "file domain events are appended to as JSON lines, events are logged if empty")
+	flag.StringVar(&config.JWTKey, "k", "", "hex encoded HMAC secret of auth tokens, at least 32 bytes")
+	flag.StringVar(&config.JWTKeysFile, "j", "", "JSON file with set of auth token keys, takes precedence over -k")
+	flag.BoolVar(&config.JWTRandomKey, "jwt-random-key", false, "development only: sign auth tokens with random key if neither -j nor -k is set")
+	flag.StringVar(&config.CookiePath, "cookie-path", "/", "path attribute of auth cookies")
+	flag.StringVar(&config.CookieRefreshPath, "cookie-refresh-path", "/api/user/token/refresh", "path attribute of refresh cookie")
+	flag.BoolVar(&config.CookieSecure, "cookie-secure", false, "send auth cookies only over HTTPS")
+	flag.BoolVar(&config.CookieHTTPOnly, "cookie-http-only", true, "hide auth cookies from scripts")
+	flag.StringVar(&config.CookieSameSite, "cookie-samesite", "lax", "SameSite attribute of auth cookies: lax, strict or none")
+	flag.StringVar(&config.JWTIssuer, "jwt-issuer", "gophermart", "iss claim of auth tokens")
+	flag.StringVar(&config.JWTAudience, "jwt-audience", "gophermart", "aud claim of auth tokens")
+	flag.DurationVar(&config.JWTLeeway, "jwt-leeway", 30*time.Second, "tolerated clock skew when validating auth tokens")
+	flag.StringVar(&config.ClientIPHeader, "client-ip-header", "", "header with client IP set by trusted reverse proxy, e.g. X-Real-IP, connection IP is used if empty")
+
+	flag.Parse()
 
 	if envSrvAddress := os.Getenv("RUN_ADDRESS"); envSrvAddress != "" {
 		config.SrvAddress = envSrvAddress
@@ -28,6 +62,80 @@ func LoadConfig() (*Config, error) {
 	if envAccrualAddress := os.Getenv("ACCRUAL_SYSTEM_ADDRESS"); envAccrualAddress != "" {
 		config.AccrualAddres = envAccrualAddress
 	}
+	if envAccrualRateLimit := os.Getenv("ACCRUAL_RATE_LIMIT"); envAccrualRateLimit != "" {
+		rateLimit, err := strconv.Atoi(envAccrualRateLimit)
+		if err != nil {
+			return nil, err
+		}
+		config.AccrualRateLimit = rateLimit
+	}
+	if envDispatchWorkers := os.Getenv("DISPATCH_WORKERS"); envDispatchWorkers != "" {
+		workers, err := strconv.Atoi(envDispatchWorkers)
+		if err != nil {
+			return nil, err
+		}
+		config.DispatchWorkers = workers
+	}
+
+	if envOutboxFile := os.Getenv("OUTBOX_FILE"); envOutboxFile != "" {
+		config.OutboxFile = envOutboxFile
+	}
+
+	if envJWTKey := os.Getenv("JWT_KEY"); envJWTKey != "" {
+		config.JWTKey = envJWTKey
+	}
+	if envJWTKeysFile := os.Getenv("JWT_KEYS_FILE"); envJWTKeysFile != "" {
+		config.JWTKeysFile = envJWTKeysFile
+	}
+	if envJWTRandomKey := os.Getenv("JWT_RANDOM_KEY"); envJWTRandomKey != "" {
+		randomKey, err := strconv.ParseBool(envJWTRandomKey)
+		if err != nil {
+			return nil, err
+		}
+		config.JWTRandomKey = randomKey
+	}
+
+	if envCookiePath := os.Getenv("COOKIE_PATH"); envCookiePath != "" {
+		config.CookiePath = envCookiePath
+	}
+	if envCookieRefreshPath := os.Getenv("COOKIE_REFRESH_PATH"); envCookieRefreshPath != "" {
+		config.CookieRefreshPath = envCookieRefreshPath
+	}
+	if envCookieSecure := os.Getenv("COOKIE_SECURE"); envCookieSecure != "" {
+		secure, err := strconv.ParseBool(envCookieSecure)
+		if err != nil {
+			return nil, err
+		}
+		config.CookieSecure = secure
+	}
+	if envCookieHTTPOnly := os.Getenv("COOKIE_HTTP_ONLY"); envCookieHTTPOnly != "" {
+		httpOnly, err := strconv.ParseBool(envCookieHTTPOnly)
+		if err != nil {
+			return nil, err
+		}
+		config.CookieHTTPOnly = httpOnly
+	}
+	if envCookieSameSite := os.Getenv("COOKIE_SAMESITE"); envCookieSameSite != "" {
+		config.CookieSameSite = envCookieSameSite
+	}
+
+	if envJWTIssuer := os.Getenv("JWT_ISSUER"); envJWTIssuer != "" {
+		config.JWTIssuer = envJWTIssuer
+	}
+	if envJWTAudience := os.Getenv("JWT_AUDIENCE"); envJWTAudience != "" {
+		config.JWTAudience = envJWTAudience
+	}
+	if envJWTLeeway := os.Getenv("JWT_LEEWAY"); envJWTLeeway != "" {
+		leeway, err := time.ParseDuration(envJWTLeeway)
+		if err != nil {
+			return nil, err
+		}
+		config.JWTLeeway = leeway
+	}
+
+	if envClientIPHeader := os.Getenv("CLIENT_IP_HEADER"); envClientIPHeader != "" {
+		config.ClientIPHeader = envClientIPHeader
+	}
 
 	return config, nil
 }
@@ -3,22 +3,28 @@ package main
 import (
 	"context"
 	"database/sql"
-	"embed"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/pressly/goose/v3"
 	"github.com/renatus-cartesius/gophermart/cmd/gophermart/config"
+	"github.com/renatus-cartesius/gophermart/cmd/gophermart/migrations"
 	"github.com/renatus-cartesius/gophermart/internal/accrual"
 	"github.com/renatus-cartesius/gophermart/internal/auth"
 	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/internal/outbox"
 	"github.com/renatus-cartesius/gophermart/internal/server/handlers"
+	"github.com/renatus-cartesius/gophermart/internal/server/middlewares"
 	"github.com/renatus-cartesius/gophermart/internal/storage"
+	"github.com/renatus-cartesius/gophermart/internal/storage/memory"
+	"github.com/renatus-cartesius/gophermart/internal/webhooks"
 	"github.com/renatus-cartesius/gophermart/pkg/logger"
 
 	"github.com/go-chi/chi/v5"
@@ -41,50 +47,74 @@ func main() {
 		)
 	}
 
-	a := accrual.NewAccrual(cfg.AccrualAddres)
+	a := accrual.NewAccrual(cfg.AccrualAddres, cfg.AccrualRateLimit)
 
-	db, err := sql.Open("pgx", cfg.DBURI)
+	store, err := openStorage(cfg.DBURI)
 	if err != nil {
 		logger.Log.Fatal(
-			"error on openning DB connection",
+			"error on opening storage",
 			zap.Error(err),
 		)
 	}
-	pingErr := db.Ping()
-	if pingErr != nil {
+
+	l := loyalty.NewLoyalty(
+		a,
+		store,
+		cfg.DispatchWorkers,
+		cfg.AccrualRateLimit,
+	)
+
+	publisher, err := openPublisher(cfg.OutboxFile)
+	if err != nil {
 		logger.Log.Fatal(
-			"error on checking DB connection",
-			zap.Error(pingErr),
+			"error on opening outbox publisher",
+			zap.Error(err),
 		)
 	}
-	logger.Log.Debug(
-		"successfully connected to pg db",
-	)
 
-	if err := migrationsHelper(db); err != nil {
+	wh := webhooks.NewWebhooks(store, webhooks.NewClient(30*time.Second))
+	relay := outbox.NewRelay(store, outbox.Publishers{publisher, wh})
+
+	keys, err := openKeySet(cfg.JWTKeysFile, cfg.JWTKey, cfg.JWTRandomKey)
+	if err != nil {
 		logger.Log.Fatal(
-			"error on preparing or making migrations",
+			"error on loading auth token keys",
 			zap.Error(err),
 		)
 	}
 
-	pgStorage := storage.NewPGStorage(db)
+	cookies, err := cookieConfig(cfg)
+	if err != nil {
+		logger.Log.Fatal(
+			"error on configuring auth cookies",
+			zap.Error(err),
+		)
+	}
 
-	l := loyalty.NewLoyalty(
-		a,
-		pgStorage,
+	authenticator := auth.NewAuth(
+		keys,
+		store,
+		cookies,
+		auth.TokenConfig{
+			Issuer:   cfg.JWTIssuer,
+			Audience: cfg.JWTAudience,
+			Leeway:   cfg.JWTLeeway,
+		},
+		auth.DefaultLoginThrottle(),
+		cfg.ClientIPHeader,
 	)
 
 	srv := handlers.NewServerHandler(
 		l,
-		auth.NewAuth(
-			[]byte("d6b32087c4b1f7c8b88c945234d54cfa5aa73d4b14e5e7a778448d515db00028b20db"),
-			pgStorage,
-		),
+		authenticator,
+		store,
+		wh,
 	)
 
 	r := chi.NewRouter()
 	server := &http.Server{Addr: cfg.SrvAddress, Handler: r}
+	// Event streams never become idle, closing the hub ends them so shutdown doesn`t wait for clients
+	server.RegisterOnShutdown(l.Events().Close)
 
 	handlers.Setup(r, srv)
 
@@ -94,6 +124,11 @@ func main() {
 	dispatchContext, dispatchContextCancel := context.WithCancel(context.Background())
 	defer dispatchContextCancel()
 	go l.Dispatch(dispatchContext)
+	go l.FeedEvents(dispatchContext)
+	go wh.Dispatch(dispatchContext)
+	go relay.Run(dispatchContext)
+	go authenticator.RunPruning(dispatchContext)
+	go middlewares.RunIdempotencyPruning(dispatchContext, store)
 
 	go func() {
 		<-shutdownSig
@@ -128,11 +163,128 @@ func main() {
 
 }
 
-//go:embed migrations/*.sql
-var embedMigrations embed.FS
+// storager is implemented by every storage backend
+type storager interface {
+	loyalty.LoyaltyStorager
+	auth.AuthStorager
+	middlewares.IdempotencyStorager
+	webhooks.WebhookStorager
+	outbox.OutboxStorager
+}
+
+// openKeySet returns auth token keys from key set file or hex encoded HMAC secret. Random secret is used
+// only if it is allowed explicitly, otherwise missing key fails startup.
+func openKeySet(path, secret string, allowRandom bool) (*auth.KeySet, error) {
+	if path != "" {
+		return auth.LoadKeySet(path)
+	}
+
+	if secret != "" {
+		key, err := hex.DecodeString(secret)
+		if err != nil {
+			return nil, fmt.Errorf("error on decoding JWT key: %w", err)
+		}
+		return auth.SecretKeySet(key)
+	}
+
+	if !allowRandom {
+		return nil, errors.New("auth token key isn`t configured, set -j or -k, or -jwt-random-key for development")
+	}
+
+	logger.Log.Warn(
+		"auth token key isn`t configured, using random key for development, users are logged out on restart",
+	)
+	return auth.RandomKeySet()
+}
+
+// cookieConfig returns attributes of auth cookies set in cfg
+func cookieConfig(cfg *config.Config) (auth.CookieConfig, error) {
+	cookies := auth.DefaultCookieConfig()
+	cookies.Path = cfg.CookiePath
+	cookies.RefreshPath = cfg.CookieRefreshPath
+	cookies.Secure = cfg.CookieSecure
+	cookies.HttpOnly = cfg.CookieHTTPOnly
 
-func migrationsHelper(db *sql.DB) error {
-	goose.SetBaseFS(embedMigrations)
+	sameSite, err := auth.ParseSameSite(cfg.CookieSameSite)
+	if err != nil {
+		return cookies, err
+	}
+	cookies.SameSite = sameSite
+
+	return cookies, cookies.Validate()
+}
+
+// openPublisher returns publisher appending domain events to file at path, events are logged if path is empty
+func openPublisher(path string) (outbox.Publisher, error) {
+	if path == "" {
+		return outbox.LogPublisher{}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return outbox.NewWriterPublisher(file), nil
+}
+
+// sqliteScheme is prefix of dbURI selecting SQLite storage, e.g. sqlite:///var/lib/gophermart.db or sqlite::memory:
+const sqliteScheme = "sqlite:"
+
+// openStorage connects to database selected by dbURI scheme and applies migrations,
+// in-memory storage is used if dbURI is empty
+func openStorage(dbURI string) (storager, error) {
+	if dbURI == "" {
+		logger.Log.Warn(
+			"database isn`t configured, using in-memory storage",
+		)
+		return memory.NewMemStorage(), nil
+	}
+
+	if strings.HasPrefix(dbURI, sqliteScheme) {
+		return openSQLiteStorage(strings.TrimPrefix(strings.TrimPrefix(dbURI, sqliteScheme), "//"))
+	}
+
+	db, err := sql.Open("pgx", dbURI)
+	if err != nil {
+		return nil, fmt.Errorf("error on openning DB connection: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("error on checking DB connection: %w", err)
+	}
+	logger.Log.Debug(
+		"successfully connected to pg db",
+	)
+
+	if err := migrations.Up(db); err != nil {
+		return nil, fmt.Errorf("error on preparing or making migrations: %w", err)
+	}
+
+	return storage.NewPGStorage(db), nil
+}
+
+// openSQLiteStorage opens SQLite database file at path and applies migrations
+func openSQLiteStorage(path string) (storager, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, fmt.Errorf("error on openning DB connection: %w", err)
+	}
+
+	// SQLite allows only one writer, single connection serializes all storage transactions
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("error on checking DB connection: %w", err)
+	}
+	logger.Log.Debug(
+		"successfully opened sqlite db",
+		zap.String("path", path),
+	)
+
+	if err := migrations.UpSQLite(db); err != nil {
+		return nil, fmt.Errorf("error on preparing or making migrations: %w", err)
+	}
 
-	return errors.Join(goose.SetDialect("postgres"), goose.Up(db, "migrations"))
+	return storage.NewSQLiteStorage(db), nil
 }
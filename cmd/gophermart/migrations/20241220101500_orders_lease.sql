@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE orders ADD COLUMN leaseOwner text;
+ALTER TABLE orders ADD COLUMN leaseUntil timestamp;
+
+CREATE INDEX orders_unhandled_idx ON orders (uploaded) WHERE status IN ('NEW', 'PROCESSING');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS orders_unhandled_idx;
+ALTER TABLE orders DROP COLUMN IF EXISTS leaseUntil;
+ALTER TABLE orders DROP COLUMN IF EXISTS leaseOwner;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE orders ADD COLUMN attempts integer NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN lastError text;
+ALTER TABLE orders ADD COLUMN nextCheckAt timestamp NOT NULL DEFAULT (timezone('utc', now()));
+ALTER TABLE orders ADD COLUMN reason text;
+
+DROP INDEX IF EXISTS orders_unhandled_idx;
+CREATE INDEX orders_unhandled_idx ON orders (nextCheckAt) WHERE status IN ('NEW', 'PROCESSING');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS orders_unhandled_idx;
+CREATE INDEX orders_unhandled_idx ON orders (uploaded) WHERE status IN ('NEW', 'PROCESSING');
+
+ALTER TABLE orders DROP COLUMN IF EXISTS reason;
+ALTER TABLE orders DROP COLUMN IF EXISTS nextCheckAt;
+ALTER TABLE orders DROP COLUMN IF EXISTS lastError;
+ALTER TABLE orders DROP COLUMN IF EXISTS attempts;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- float values are cast through their shortest exact decimal representation,
+-- so points with up to two fractional digits are kept as is
+ALTER TABLE orders ALTER COLUMN accrual TYPE numeric(20, 2) USING round(accrual::numeric, 2);
+ALTER TABLE orders ALTER COLUMN accrual SET DEFAULT 0;
+ALTER TABLE withdrawals ALTER COLUMN sum TYPE numeric(20, 2) USING round(sum::numeric, 2);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE withdrawals ALTER COLUMN sum TYPE float USING sum::float;
+ALTER TABLE orders ALTER COLUMN accrual TYPE float USING accrual::float;
+ALTER TABLE orders ALTER COLUMN accrual SET DEFAULT 0;
+-- +goose StatementEnd
@@ -0,0 +1,51 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TYPE ledgerEntryType as ENUM ('ACCRUAL', 'WITHDRAWAL', 'REVERSAL', 'ADJUSTMENT');
+
+CREATE TABLE ledger (
+    id bigserial PRIMARY KEY,
+    userID text NOT NULL,
+    type ledgerEntryType NOT NULL,
+    reference text NOT NULL,
+    amount numeric(20, 2) NOT NULL,
+    balance numeric(20, 2) NOT NULL,
+    withdrawn numeric(20, 2) NOT NULL,
+    created timestamp NOT NULL default (timezone('utc', now()))
+);
+
+CREATE INDEX ledger_user_idx ON ledger (userID, id);
+CREATE UNIQUE INDEX ledger_accrual_reference_idx ON ledger (reference) WHERE type = 'ACCRUAL';
+
+CREATE FUNCTION ledger_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger entries are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ledger_append_only BEFORE UPDATE OR DELETE ON ledger
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+-- moving existing accruals and withdrawals to the ledger in chronological order
+INSERT INTO ledger (userID, type, reference, amount, balance, withdrawn, created)
+SELECT
+    userID, type, reference, amount,
+    SUM(amount) OVER w,
+    SUM(CASE WHEN type = 'WITHDRAWAL' THEN -amount ELSE 0 END) OVER w,
+    created
+FROM (
+    SELECT userID, 'ACCRUAL'::ledgerEntryType AS type, id AS reference, accrual AS amount, uploaded AS created
+    FROM orders WHERE status = 'PROCESSED' AND accrual > 0
+    UNION ALL
+    SELECT userID, 'WITHDRAWAL'::ledgerEntryType, orderID, -sum, created
+    FROM withdrawals
+) entries
+WINDOW w AS (PARTITION BY userID ORDER BY created, type, reference ROWS UNBOUNDED PRECEDING)
+ORDER BY created, type, reference;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS ledger;
+DROP FUNCTION IF EXISTS ledger_append_only;
+DROP TYPE IF EXISTS ledgerEntryType;
+-- +goose StatementEnd
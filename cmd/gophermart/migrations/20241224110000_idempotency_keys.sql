@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE idempotency_keys (
+    userID text NOT NULL,
+    key text NOT NULL,
+    fingerprint text NOT NULL,
+    completed boolean NOT NULL DEFAULT false,
+    statusCode integer NOT NULL DEFAULT 0,
+    contentType text NOT NULL DEFAULT '',
+    body bytea,
+    created timestamp NOT NULL default (timezone('utc', now())),
+    PRIMARY KEY (userID, key)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS idempotency_keys;
+-- +goose StatementEnd
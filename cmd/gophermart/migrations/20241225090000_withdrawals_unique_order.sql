@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- fails if some order number was already withdrawn against twice, such withdrawals have to be resolved manually
+ALTER TABLE withdrawals ADD CONSTRAINT withdrawals_orderid_key UNIQUE (orderID);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE withdrawals DROP CONSTRAINT IF EXISTS withdrawals_orderid_key;
+-- +goose StatementEnd
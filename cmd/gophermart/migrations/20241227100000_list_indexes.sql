@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- keyset pagination of user orders and withdrawals
+CREATE INDEX IF NOT EXISTS orders_user_uploaded_idx ON orders (userID, uploaded, id);
+CREATE INDEX IF NOT EXISTS withdrawals_user_created_idx ON withdrawals (userID, created, orderID);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS orders_user_uploaded_idx;
+DROP INDEX IF EXISTS withdrawals_user_created_idx;
+-- +goose StatementEnd
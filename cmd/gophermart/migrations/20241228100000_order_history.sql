@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE orders ADD COLUMN lastCheckedAt timestamp;
+
+CREATE TABLE order_events (
+    id bigserial PRIMARY KEY,
+    orderID text NOT NULL,
+    status orderStatus NOT NULL,
+    accrual numeric(20,2) NOT NULL DEFAULT 0,
+    error text,
+    reason text,
+    created timestamp NOT NULL DEFAULT (timezone('utc', now()))
+);
+
+CREATE INDEX order_events_order_idx ON order_events (orderID, id);
+
+-- existing orders get upload event and event of their current status
+INSERT INTO order_events (orderID, status, created)
+SELECT id, 'NEW', COALESCE(uploaded, timezone('utc', now())) FROM orders;
+
+INSERT INTO order_events (orderID, status, accrual, reason, created)
+SELECT id, status, COALESCE(accrual, 0), reason, COALESCE(uploaded, timezone('utc', now())) FROM orders WHERE status != 'NEW';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS order_events;
+ALTER TABLE orders DROP COLUMN IF EXISTS lastCheckedAt;
+-- +goose StatementEnd
@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TYPE webhookDeliveryStatus as ENUM ('PENDING', 'DELIVERED', 'FAILED');
+
+CREATE TABLE webhooks (
+    id text PRIMARY KEY,
+    userID text NOT NULL,
+    url text NOT NULL,
+    secret text NOT NULL,
+    created timestamp NOT NULL DEFAULT (timezone('utc', now()))
+);
+
+CREATE INDEX webhooks_user_idx ON webhooks (userID, created);
+
+CREATE TABLE webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    webhookID text NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    userID text NOT NULL,
+    eventID text NOT NULL,
+    eventType text NOT NULL,
+    payload bytea NOT NULL,
+    status webhookDeliveryStatus NOT NULL DEFAULT 'PENDING',
+    attempts integer NOT NULL DEFAULT 0,
+    nextAttemptAt timestamp NOT NULL DEFAULT (timezone('utc', now())),
+    lastStatusCode integer NOT NULL DEFAULT 0,
+    lastError text,
+    created timestamp NOT NULL DEFAULT (timezone('utc', now())),
+    deliveredAt timestamp
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (nextAttemptAt) WHERE status = 'PENDING';
+CREATE INDEX webhook_deliveries_webhook_idx ON webhook_deliveries (webhookID, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
+DROP TYPE IF EXISTS webhookDeliveryStatus;
+-- +goose StatementEnd
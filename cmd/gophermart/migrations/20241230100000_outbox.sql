@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE outbox (
+    id bigserial PRIMARY KEY,
+    userID text NOT NULL,
+    type text NOT NULL,
+    aggregateID text NOT NULL,
+    payload jsonb NOT NULL,
+    created timestamp NOT NULL DEFAULT (timezone('utc', now())),
+    leaseOwner text,
+    leaseUntil timestamp,
+    attempts integer NOT NULL DEFAULT 0,
+    lastError text,
+    published timestamp
+);
+
+CREATE INDEX outbox_unpublished_idx ON outbox (id) WHERE published IS NULL;
+CREATE INDEX outbox_user_unpublished_idx ON outbox (userID, id) WHERE published IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS outbox;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE INDEX outbox_published_idx ON outbox (published) WHERE published IS NOT NULL;
+CREATE UNIQUE INDEX webhook_deliveries_event_idx ON webhook_deliveries (webhookID, eventID);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS webhook_deliveries_event_idx;
+DROP INDEX IF EXISTS outbox_published_idx;
+-- +goose StatementEnd
@@ -0,0 +1,104 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE ledger_transactions (
+    id bigserial PRIMARY KEY,
+    userID text NOT NULL,
+    type ledgerEntryType NOT NULL,
+    reference text NOT NULL,
+    created timestamp NOT NULL default (timezone('utc', now()))
+);
+
+CREATE INDEX ledger_transactions_user_idx ON ledger_transactions (userID, id);
+CREATE UNIQUE INDEX ledger_transactions_accrual_reference_idx ON ledger_transactions (reference) WHERE type = 'ACCRUAL';
+
+-- balance is running balance of account after the posting, it is tracked only for accounts of users
+CREATE TABLE ledger_postings (
+    id bigserial PRIMARY KEY,
+    transactionID bigint NOT NULL REFERENCES ledger_transactions (id),
+    account text NOT NULL,
+    amount numeric(20, 2) NOT NULL,
+    balance numeric(20, 2)
+);
+
+CREATE INDEX ledger_postings_account_idx ON ledger_postings (account, id);
+CREATE INDEX ledger_postings_transaction_idx ON ledger_postings (transactionID);
+
+CREATE TRIGGER ledger_transactions_append_only BEFORE UPDATE OR DELETE ON ledger_transactions
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+CREATE TRIGGER ledger_postings_append_only BEFORE UPDATE OR DELETE ON ledger_postings
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+-- legs of every transaction must sum to zero when the database transaction commits
+CREATE FUNCTION ledger_transaction_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT SUM(amount) FROM ledger_postings WHERE transactionID = NEW.transactionID) != 0 THEN
+        RAISE EXCEPTION 'ledger transaction % is unbalanced', NEW.transactionID;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER ledger_postings_balanced AFTER INSERT ON ledger_postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION ledger_transaction_balanced();
+
+-- moving single-entry ledger to transactions balanced by counter accounts
+INSERT INTO ledger_transactions (id, userID, type, reference, created)
+SELECT id, userID, type, reference, created FROM ledger ORDER BY id;
+
+SELECT setval(pg_get_serial_sequence('ledger_transactions', 'id'), COALESCE((SELECT MAX(id) FROM ledger_transactions), 0) + 1, false);
+
+INSERT INTO ledger_postings (transactionID, account, amount, balance)
+SELECT transactionID, account, amount, balance FROM (
+    SELECT id AS transactionID, 0 AS leg, 'user:' || userID AS account, amount, balance FROM ledger
+    UNION ALL
+    SELECT id, 1,
+        CASE type
+            WHEN 'WITHDRAWAL' THEN 'withdrawn:' || userID
+            WHEN 'ACCRUAL' THEN 'system:accrual'
+            ELSE 'system:adjustment'
+        END,
+        -amount,
+        CASE WHEN type = 'WITHDRAWAL' THEN withdrawn END
+    FROM ledger
+) legs
+ORDER BY transactionID, leg;
+
+DROP TABLE ledger;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE TABLE ledger (
+    id bigserial PRIMARY KEY,
+    userID text NOT NULL,
+    type ledgerEntryType NOT NULL,
+    reference text NOT NULL,
+    amount numeric(20, 2) NOT NULL,
+    balance numeric(20, 2) NOT NULL,
+    withdrawn numeric(20, 2) NOT NULL,
+    created timestamp NOT NULL default (timezone('utc', now()))
+);
+
+CREATE INDEX ledger_user_idx ON ledger (userID, id);
+CREATE UNIQUE INDEX ledger_accrual_reference_idx ON ledger (reference) WHERE type = 'ACCRUAL';
+
+INSERT INTO ledger (id, userID, type, reference, amount, balance, withdrawn, created)
+SELECT
+    t.id, t.userID, t.type, t.reference, p.amount, p.balance,
+    SUM(CASE WHEN t.type = 'WITHDRAWAL' THEN -p.amount ELSE 0 END) OVER (PARTITION BY t.userID ORDER BY t.id ROWS UNBOUNDED PRECEDING),
+    t.created
+FROM ledger_transactions t
+JOIN ledger_postings p ON p.transactionID = t.id AND p.account = 'user:' || t.userID
+ORDER BY t.id;
+
+SELECT setval(pg_get_serial_sequence('ledger', 'id'), COALESCE((SELECT MAX(id) FROM ledger), 0) + 1, false);
+
+CREATE TRIGGER ledger_append_only BEFORE UPDATE OR DELETE ON ledger
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+DROP TABLE IF EXISTS ledger_postings;
+DROP TABLE IF EXISTS ledger_transactions;
+DROP FUNCTION IF EXISTS ledger_transaction_balanced;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE outbox ADD COLUMN nextAttemptAt timestamp;
+ALTER TABLE outbox ADD COLUMN failed timestamp;
+
+-- failed events are never claimed again
+DROP INDEX IF EXISTS outbox_unpublished_idx;
+DROP INDEX IF EXISTS outbox_user_unpublished_idx;
+CREATE INDEX outbox_unpublished_idx ON outbox (id) WHERE published IS NULL AND failed IS NULL;
+CREATE INDEX outbox_user_unpublished_idx ON outbox (userID, id) WHERE published IS NULL AND failed IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS outbox_user_unpublished_idx;
+DROP INDEX IF EXISTS outbox_unpublished_idx;
+CREATE INDEX outbox_unpublished_idx ON outbox (id) WHERE published IS NULL;
+CREATE INDEX outbox_user_unpublished_idx ON outbox (userID, id) WHERE published IS NULL;
+
+ALTER TABLE outbox DROP COLUMN IF EXISTS failed;
+ALTER TABLE outbox DROP COLUMN IF EXISTS nextAttemptAt;
+-- +goose StatementEnd
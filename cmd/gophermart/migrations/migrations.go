@@ -0,0 +1,26 @@
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+
+	"github.com/pressly/goose/v3"
+)
+
+//go:embed *.sql sqlite/*.sql
+var embedMigrations embed.FS
+
+// Up applies all postgres migrations to db
+func Up(db *sql.DB) error {
+	goose.SetBaseFS(embedMigrations)
+
+	return errors.Join(goose.SetDialect("postgres"), goose.Up(db, "."))
+}
+
+// UpSQLite applies all sqlite migrations from sqlite directory to db
+func UpSQLite(db *sql.DB) error {
+	goose.SetBaseFS(embedMigrations)
+
+	return errors.Join(goose.SetDialect("sqlite3"), goose.Up(db, "sqlite"))
+}
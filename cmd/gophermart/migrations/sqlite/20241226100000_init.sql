@@ -0,0 +1,84 @@
+-- +goose Up
+-- +goose StatementBegin
+-- points are stored as decimal text and times as fixed width UTC text,
+-- so both are kept exactly and times are ordered as strings
+CREATE TABLE users (
+    id text PRIMARY KEY,
+    passwordHash text NOT NULL
+);
+
+CREATE TABLE orders (
+    id text PRIMARY KEY,
+    userID text NOT NULL,
+    status text NOT NULL CHECK (status IN ('NEW', 'INVALID', 'PROCESSING', 'PROCESSED')),
+    accrual text NOT NULL DEFAULT '0',
+    uploaded text NOT NULL,
+    leaseOwner text,
+    leaseUntil text,
+    attempts integer NOT NULL DEFAULT 0,
+    lastError text,
+    nextCheckAt text NOT NULL,
+    reason text
+);
+
+CREATE INDEX orders_user_idx ON orders (userID, uploaded);
+CREATE INDEX orders_unhandled_idx ON orders (nextCheckAt) WHERE status IN ('NEW', 'PROCESSING');
+
+CREATE TABLE withdrawals (
+    orderID text PRIMARY KEY,
+    userID text NOT NULL,
+    sum text NOT NULL,
+    created text NOT NULL
+);
+
+CREATE INDEX withdrawals_user_idx ON withdrawals (userID, created);
+
+CREATE TABLE ledger (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    userID text NOT NULL,
+    type text NOT NULL CHECK (type IN ('ACCRUAL', 'WITHDRAWAL', 'REVERSAL', 'ADJUSTMENT')),
+    reference text NOT NULL,
+    amount text NOT NULL,
+    balance text NOT NULL,
+    withdrawn text NOT NULL,
+    created text NOT NULL
+);
+
+CREATE INDEX ledger_user_idx ON ledger (userID, id);
+CREATE UNIQUE INDEX ledger_accrual_reference_idx ON ledger (reference) WHERE type = 'ACCRUAL';
+
+CREATE TABLE idempotency_keys (
+    userID text NOT NULL,
+    key text NOT NULL,
+    fingerprint text NOT NULL,
+    completed integer NOT NULL DEFAULT 0,
+    statusCode integer NOT NULL DEFAULT 0,
+    contentType text NOT NULL DEFAULT '',
+    body blob,
+    created text NOT NULL,
+    PRIMARY KEY (userID, key)
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER ledger_append_only_update BEFORE UPDATE ON ledger
+BEGIN
+    SELECT RAISE(ABORT, 'ledger entries are immutable');
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER ledger_append_only_delete BEFORE DELETE ON ledger
+BEGIN
+    SELECT RAISE(ABORT, 'ledger entries are immutable');
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS ledger;
+DROP TABLE IF EXISTS withdrawals;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS users;
+-- +goose StatementEnd
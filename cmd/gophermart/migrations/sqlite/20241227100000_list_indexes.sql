@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- keyset pagination of user orders and withdrawals
+DROP INDEX IF EXISTS orders_user_idx;
+DROP INDEX IF EXISTS withdrawals_user_idx;
+CREATE INDEX orders_user_idx ON orders (userID, uploaded, id);
+CREATE INDEX withdrawals_user_idx ON withdrawals (userID, created, orderID);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS orders_user_idx;
+DROP INDEX IF EXISTS withdrawals_user_idx;
+CREATE INDEX orders_user_idx ON orders (userID, uploaded);
+CREATE INDEX withdrawals_user_idx ON withdrawals (userID, created);
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE orders ADD COLUMN lastCheckedAt text;
+
+CREATE TABLE order_events (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    orderID text NOT NULL,
+    status text NOT NULL,
+    accrual text NOT NULL DEFAULT '0',
+    error text,
+    reason text,
+    created text NOT NULL
+);
+
+CREATE INDEX order_events_order_idx ON order_events (orderID, id);
+
+-- existing orders get upload event and event of their current status
+INSERT INTO order_events (orderID, status, created)
+SELECT id, 'NEW', uploaded FROM orders;
+
+INSERT INTO order_events (orderID, status, accrual, reason, created)
+SELECT id, status, accrual, reason, uploaded FROM orders WHERE status != 'NEW';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS order_events;
+ALTER TABLE orders DROP COLUMN lastCheckedAt;
+-- +goose StatementEnd
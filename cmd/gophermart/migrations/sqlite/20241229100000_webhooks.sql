@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE webhooks (
+    id text PRIMARY KEY,
+    userID text NOT NULL,
+    url text NOT NULL,
+    secret text NOT NULL,
+    created text NOT NULL
+);
+
+CREATE INDEX webhooks_user_idx ON webhooks (userID, created);
+
+CREATE TABLE webhook_deliveries (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    webhookID text NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    userID text NOT NULL,
+    eventID text NOT NULL,
+    eventType text NOT NULL,
+    payload blob NOT NULL,
+    status text NOT NULL DEFAULT 'PENDING' CHECK (status IN ('PENDING', 'DELIVERED', 'FAILED')),
+    attempts integer NOT NULL DEFAULT 0,
+    nextAttemptAt text NOT NULL,
+    lastStatusCode integer NOT NULL DEFAULT 0,
+    lastError text,
+    created text NOT NULL,
+    deliveredAt text
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (nextAttemptAt) WHERE status = 'PENDING';
+CREATE INDEX webhook_deliveries_webhook_idx ON webhook_deliveries (webhookID, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE sessions (
+    id text PRIMARY KEY,
+    userID text NOT NULL,
+    userAgent text NOT NULL DEFAULT '',
+    ip text NOT NULL DEFAULT '',
+    created text NOT NULL,
+    lastUsed text NOT NULL,
+    expires text NOT NULL,
+    revoked text
+);
+
+CREATE INDEX sessions_user_idx ON sessions (userID, created) WHERE revoked IS NULL;
+
+CREATE TABLE refresh_tokens (
+    hash text PRIMARY KEY,
+    sessionID text NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+    used integer NOT NULL DEFAULT 0,
+    created text NOT NULL
+);
+
+CREATE INDEX refresh_tokens_session_idx ON refresh_tokens (sessionID);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS sessions;
+-- +goose StatementEnd
@@ -0,0 +1,122 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE ledger_transactions (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    userID text NOT NULL,
+    type text NOT NULL CHECK (type IN ('ACCRUAL', 'WITHDRAWAL', 'REVERSAL', 'ADJUSTMENT')),
+    reference text NOT NULL,
+    created text NOT NULL
+);
+
+CREATE INDEX ledger_transactions_user_idx ON ledger_transactions (userID, id);
+CREATE UNIQUE INDEX ledger_transactions_accrual_reference_idx ON ledger_transactions (reference) WHERE type = 'ACCRUAL';
+
+-- balance is running balance of account after the posting, it is tracked only for accounts of users
+CREATE TABLE ledger_postings (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    transactionID integer NOT NULL REFERENCES ledger_transactions (id),
+    account text NOT NULL,
+    amount text NOT NULL,
+    balance text
+);
+
+CREATE INDEX ledger_postings_account_idx ON ledger_postings (account, id);
+CREATE INDEX ledger_postings_transaction_idx ON ledger_postings (transactionID);
+
+-- moving single-entry ledger to transactions balanced by counter accounts
+INSERT INTO ledger_transactions (id, userID, type, reference, created)
+SELECT id, userID, type, reference, created FROM ledger ORDER BY id;
+
+INSERT INTO ledger_postings (transactionID, account, amount, balance)
+SELECT transactionID, account, amount, balance FROM (
+    SELECT id AS transactionID, 0 AS leg, 'user:' || userID AS account, amount, balance FROM ledger
+    UNION ALL
+    SELECT id, 1,
+        CASE type
+            WHEN 'WITHDRAWAL' THEN 'withdrawn:' || userID
+            WHEN 'ACCRUAL' THEN 'system:accrual'
+            ELSE 'system:adjustment'
+        END,
+        CASE WHEN amount LIKE '-%' THEN substr(amount, 2) ELSE '-' || amount END,
+        CASE WHEN type = 'WITHDRAWAL' THEN withdrawn END
+    FROM ledger
+) legs
+ORDER BY transactionID, leg;
+
+DROP TABLE ledger;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER ledger_transactions_append_only_update BEFORE UPDATE ON ledger_transactions
+BEGIN
+    SELECT RAISE(ABORT, 'ledger entries are immutable');
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER ledger_transactions_append_only_delete BEFORE DELETE ON ledger_transactions
+BEGIN
+    SELECT RAISE(ABORT, 'ledger entries are immutable');
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER ledger_postings_append_only_update BEFORE UPDATE ON ledger_postings
+BEGIN
+    SELECT RAISE(ABORT, 'ledger entries are immutable');
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER ledger_postings_append_only_delete BEFORE DELETE ON ledger_postings
+BEGIN
+    SELECT RAISE(ABORT, 'ledger entries are immutable');
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE TABLE ledger (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    userID text NOT NULL,
+    type text NOT NULL CHECK (type IN ('ACCRUAL', 'WITHDRAWAL', 'REVERSAL', 'ADJUSTMENT')),
+    reference text NOT NULL,
+    amount text NOT NULL,
+    balance text NOT NULL,
+    withdrawn text NOT NULL,
+    created text NOT NULL
+);
+
+CREATE INDEX ledger_user_idx ON ledger (userID, id);
+CREATE UNIQUE INDEX ledger_accrual_reference_idx ON ledger (reference) WHERE type = 'ACCRUAL';
+
+INSERT INTO ledger (id, userID, type, reference, amount, balance, withdrawn, created)
+SELECT
+    t.id, t.userID, t.type, t.reference, p.amount, p.balance,
+    COALESCE((
+        SELECT w.balance FROM ledger_postings w
+        WHERE w.account = 'withdrawn:' || t.userID AND w.transactionID <= t.id
+        ORDER BY w.id DESC LIMIT 1
+    ), '0'),
+    t.created
+FROM ledger_transactions t
+JOIN ledger_postings p ON p.transactionID = t.id AND p.account = 'user:' || t.userID
+ORDER BY t.id;
+
+DROP TABLE IF EXISTS ledger_postings;
+DROP TABLE IF EXISTS ledger_transactions;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER ledger_append_only_update BEFORE UPDATE ON ledger
+BEGIN
+    SELECT RAISE(ABORT, 'ledger entries are immutable');
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER ledger_append_only_delete BEFORE DELETE ON ledger
+BEGIN
+    SELECT RAISE(ABORT, 'ledger entries are immutable');
+END;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE INDEX idempotency_keys_created_idx ON idempotency_keys (created);
+CREATE INDEX sessions_expires_idx ON sessions (expires);
+CREATE INDEX sessions_revoked_idx ON sessions (revoked) WHERE revoked IS NOT NULL;
+CREATE INDEX login_attempts_last_failure_idx ON login_attempts (lastFailure);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS login_attempts_last_failure_idx;
+DROP INDEX IF EXISTS sessions_revoked_idx;
+DROP INDEX IF EXISTS sessions_expires_idx;
+DROP INDEX IF EXISTS idempotency_keys_created_idx;
+-- +goose StatementEnd
@@ -5,10 +5,15 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"github.com/renatus-cartesius/gophermart/pkg/money"
 	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -18,38 +23,135 @@ const (
 	TypeStatusProcessed  = "PROCESSED"
 )
 
+const (
+	// maxRetries is how many times a single request is repeated after accrual answered 429
+	maxRetries = 3
+	// defaultRetryAfter is used when accrual answered 429 without a usable Retry-After header
+	defaultRetryAfter = 60 * time.Second
+)
+
 var (
 	ErrOrderNotFound     = errors.New("the order wasn`t registered in accrual")
 	ErrOrderNotProcessed = errors.New("the order isn`t processed")
+	ErrTooManyRequests   = errors.New("accrual rate limit exceeded")
+	ErrUnexpectedStatus  = errors.New("unexpected accrual response status")
 )
 
 type OrderInfo struct {
-	Order   string  `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float64 `json:"accrual"`
+	Order   string       `json:"order"`
+	Status  string       `json:"status"`
+	Accrual money.Amount `json:"accrual"`
+}
+
+// UnmarshalJSON decodes order info of accrual, accrual computed by the service is rounded to minor units
+// instead of being rejected as client input with more than two fractional digits
+func (oi *OrderInfo) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Order   string      `json:"order"`
+		Status  string      `json:"status"`
+		Accrual json.Number `json:"accrual"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	oi.Order = raw.Order
+	oi.Status = raw.Status
+	oi.Accrual = 0
+
+	if raw.Accrual != "" {
+		accrual, err := money.ParseRounded(raw.Accrual.String())
+		if err != nil {
+			return err
+		}
+		oi.Accrual = accrual
+	}
+
+	return nil
 }
 
 type Accrualler interface {
 	GetOrder(context.Context, string) (*OrderInfo, error)
 }
 
+// Accrual is a client of the accrual system. All requests made through one
+// Accrual share a requests-per-minute budget and a pause window set by the
+// Retry-After header of the last 429 response.
 type Accrual struct {
 	accrualAddress string
 	httpClient     *resty.Client
+	limiter        *rate.Limiter
+
+	mu          sync.Mutex
+	pausedUntil time.Time
 }
 
-func NewAccrual(aAddress string) *Accrual {
-	httpClient := resty.New()
-	httpClient.
-		SetRetryCount(3).
-		AddRetryCondition(func(r *resty.Response, err error) bool {
-			return r.StatusCode() == http.StatusTooManyRequests
-		})
+// NewAccrual creates accrual client for aAddress allowing at most rpm requests
+// per minute. Non-positive rpm disables the limit.
+func NewAccrual(aAddress string, rpm int) *Accrual {
+	limit := rate.Inf
+	if rpm > 0 {
+		limit = rate.Every(time.Minute / time.Duration(rpm))
+	}
 
 	return &Accrual{
 		accrualAddress: aAddress,
-		httpClient:     httpClient,
+		httpClient:     resty.New(),
+		limiter:        rate.NewLimiter(limit, 1),
+	}
+}
+
+// pause blocks all outgoing requests for d
+func (a *Accrual) pause(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(a.pausedUntil) {
+		a.pausedUntil = until
+	}
+}
+
+// wait blocks until the pause window is over and the limiter allows one more request
+func (a *Accrual) wait(ctx context.Context) error {
+	for {
+		a.mu.Lock()
+		delay := time.Until(a.pausedUntil)
+		a.mu.Unlock()
+
+		if delay <= 0 {
+			break
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return a.limiter.Wait(ctx)
+}
+
+// parseRetryAfter reads Retry-After header value given either in seconds or as HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return defaultRetryAfter
 	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if d := date.Sub(now); d > 0 {
+			return d
+		}
+		return 0
+	}
+
+	return defaultRetryAfter
 }
 
 func (a *Accrual) GetOrder(ctx context.Context, orderID string) (*OrderInfo, error) {
@@ -59,20 +161,49 @@ func (a *Accrual) GetOrder(ctx context.Context, orderID string) (*OrderInfo, err
 		zap.String("orderID", orderID),
 	)
 
-	req := a.httpClient.R()
+	var (
+		orderInfoRaw *resty.Response
+		err          error
+	)
 
-	orderInfoRaw, err := req.Get(a.accrualAddress + "/api/orders/" + orderID)
-	if err != nil {
-		logger.Log.Debug(
-			"error on making request to accrual",
-			zap.Error(err),
+	for attempt := 0; ; attempt++ {
+		if err := a.wait(ctx); err != nil {
+			return nil, err
+		}
+
+		orderInfoRaw, err = a.httpClient.R().
+			SetContext(ctx).
+			Get(a.accrualAddress + "/api/orders/" + orderID)
+		if err != nil {
+			logger.Log.Debug(
+				"error on making request to accrual",
+				zap.Error(err),
+			)
+			return nil, err
+		}
+
+		if orderInfoRaw.StatusCode() != http.StatusTooManyRequests {
+			break
+		}
+
+		retryAfter := parseRetryAfter(orderInfoRaw.Header().Get("Retry-After"), time.Now())
+		a.pause(retryAfter)
+
+		logger.Log.Info(
+			"accrual rate limit exceeded, pausing requests",
+			zap.String("orderID", orderID),
+			zap.Duration("retryAfter", retryAfter),
+			zap.Int("attempt", attempt),
 		)
-		return nil, err
+
+		if attempt >= maxRetries {
+			return nil, ErrTooManyRequests
+		}
 	}
 
 	orderInfo := &OrderInfo{}
 
-	if orderInfoRaw.StatusCode() == 204 {
+	if orderInfoRaw.StatusCode() == http.StatusNoContent {
 		logger.Log.Debug(
 			"order wan not found in accrual",
 			zap.String("orderID", orderID),
@@ -80,6 +211,15 @@ func (a *Accrual) GetOrder(ctx context.Context, orderID string) (*OrderInfo, err
 		return nil, ErrOrderNotFound
 	}
 
+	if orderInfoRaw.StatusCode() != http.StatusOK {
+		logger.Log.Debug(
+			"unexpected response from accrual",
+			zap.String("orderID", orderID),
+			zap.String("code", orderInfoRaw.Status()),
+		)
+		return nil, ErrUnexpectedStatus
+	}
+
 	if err := json.Unmarshal(orderInfoRaw.Body(), &orderInfo); err != nil {
 		logger.Log.Debug(
 			"error on reading result from accrual",
@@ -87,13 +227,14 @@ func (a *Accrual) GetOrder(ctx context.Context, orderID string) (*OrderInfo, err
 			zap.String("resp", string(orderInfoRaw.Body())),
 			zap.String("code", orderInfoRaw.Status()),
 		)
+		return nil, err
 	}
 
 	logger.Log.Debug(
 		"order was found in accrual",
 		zap.String("orderID", orderInfo.Order),
 		zap.String("staus", orderInfo.Status),
-		zap.Float64("accrual", orderInfo.Accrual),
+		zap.Stringer("accrual", orderInfo.Accrual),
 	)
 	return orderInfo, nil
 }
@@ -0,0 +1,148 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/pkg/money"
+)
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 12, 20, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{
+			name:  "Seconds",
+			value: "60",
+			want:  60 * time.Second,
+		},
+		{
+			name:  "HTTPDate",
+			value: now.Add(90 * time.Second).Format(http.TimeFormat),
+			want:  90 * time.Second,
+		},
+		{
+			name:  "PastHTTPDate",
+			value: now.Add(-time.Minute).Format(http.TimeFormat),
+			want:  0,
+		},
+		{
+			name:  "Empty",
+			value: "",
+			want:  defaultRetryAfter,
+		},
+		{
+			name:  "Garbage",
+			value: "soon",
+			want:  defaultRetryAfter,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value, now); got != tt.want {
+				t.Errorf("parseRetryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccrual_GetOrderRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order":"79927398713","status":"PROCESSED","accrual":500}`))
+	}))
+	defer srv.Close()
+
+	a := NewAccrual(srv.URL, 0)
+
+	start := time.Now()
+	orderInfo, err := a.GetOrder(context.Background(), "79927398713")
+	if err != nil {
+		t.Fatalf("Accrual.GetOrder() error = %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Accrual.GetOrder() didn`t wait for Retry-After, elapsed %v", elapsed)
+	}
+
+	if orderInfo.Status != TypeStatusProcessed {
+		t.Errorf("Accrual.GetOrder() status = %v, want %v", orderInfo.Status, TypeStatusProcessed)
+	}
+
+	if got := calls.Load(); got != 2 {
+		t.Errorf("accrual was called %v times, want 2", got)
+	}
+}
+
+func TestAccrual_GetOrderTooManyRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	a := NewAccrual(srv.URL, 0)
+
+	if _, err := a.GetOrder(context.Background(), "79927398713"); err != ErrTooManyRequests {
+		t.Errorf("Accrual.GetOrder() error = %v, want %v", err, ErrTooManyRequests)
+	}
+}
+
+func TestOrderInfo_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    money.Amount
+		wantErr bool
+	}{
+		{
+			name: "Fraction",
+			data: `{"order":"79927398713","status":"PROCESSED","accrual":729.98}`,
+			want: money.New(729, 98),
+		},
+		{
+			name: "RoundedFraction",
+			data: `{"order":"79927398713","status":"PROCESSED","accrual":729.985}`,
+			want: money.New(729, 99),
+		},
+		{
+			name: "NoAccrual",
+			data: `{"order":"79927398713","status":"PROCESSING"}`,
+			want: 0,
+		},
+		{
+			name:    "InvalidAccrual",
+			data:    `{"order":"79927398713","status":"PROCESSED","accrual":"much"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orderInfo := &OrderInfo{}
+			err := json.Unmarshal([]byte(tt.data), orderInfo)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OrderInfo.UnmarshalJSON() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if orderInfo.Accrual != tt.want {
+				t.Errorf("OrderInfo.UnmarshalJSON() accrual = %v, want %v", orderInfo.Accrual, tt.want)
+			}
+		})
+	}
+}
@@ -4,10 +4,11 @@ import (
 	"context"
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"github.com/renatus-cartesius/gophermart/pkg/problem"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -15,8 +16,13 @@ import (
 var (
 	ErrUserAlreadyExists        = errors.New("user with such login already registered")
 	ErrIncorrectUserCredentials = errors.New("user credentials isn`t valid")
+	ErrAuthorizationHeader      = errors.New("authorization header must be Bearer token")
+	ErrNoAccessToken            = errors.New("request has no access token")
 )
 
+// BearerScheme is scheme of Authorization header carrying access token
+const BearerScheme = "Bearer"
+
 type Username string
 
 type AuthRequest struct {
@@ -25,31 +31,77 @@ type AuthRequest struct {
 }
 
 type Auther interface {
-	RegisterUser(ctx context.Context, ar *AuthRequest) (*http.Cookie, error)
-	LoginUser(ctx context.Context, ar *AuthRequest) (*http.Cookie, error)
+	RegisterUser(ctx context.Context, ar *AuthRequest, client *Client) (*Tokens, error)
+	LoginUser(ctx context.Context, ar *AuthRequest, client *Client) (*Tokens, error)
+	Refresh(ctx context.Context, refreshToken string) (*Tokens, error)
+	GetSessions(ctx context.Context, userID string) ([]*Session, error)
+	RevokeSession(ctx context.Context, userID, sessionID string) error
+	SetCookies(w http.ResponseWriter, tokens *Tokens)
+	ClearCookies(w http.ResponseWriter)
 	AuthMiddleWare(h http.HandlerFunc) http.HandlerFunc
+	JWKS() *JWKS
+	ClientFromRequest(r *http.Request) *Client
 }
 
 type AuthStorager interface {
 	IsUserExists(ctx context.Context, userID string) (bool, error)
 	AddUser(ctx context.Context, userID, passwordHash string) error
 	GetHash(ctx context.Context, userID string) (string, error)
+
+	// AddSession saves session with its first refresh token
+	AddSession(ctx context.Context, session *Session, tokenHash string) error
+	// RotateRefreshToken marks refresh token used and replaces it with nextHash prolonging the session till expires.
+	// Unknown token or token of revoked or expired session gives ErrRefreshTokenInvalid,
+	// already used token revokes its session and gives ErrRefreshTokenReused.
+	RotateRefreshToken(ctx context.Context, tokenHash, nextHash string, expires time.Time) (*Session, error)
+	// GetSessions returns active sessions of user from the oldest
+	GetSessions(ctx context.Context, userID string) ([]*Session, error)
+	// RevokeSession revokes active session of user, otherwise ErrSessionNotFound is returned
+	RevokeSession(ctx context.Context, userID, sessionID string) error
+	// IsSessionActive reports whether session of user exists and isn`t revoked or expired
+	IsSessionActive(ctx context.Context, userID, sessionID string) (bool, error)
+
+	// ReserveLoginAttempt atomically counts login attempt by key at now before password is checked and returns
+	// count of attempts in a row with zero time. Attempts are counted from scratch if the previous one was earlier
+	// than window ago. Attempt reaching threshold locks further attempts for hold while it is checked.
+	// Locked attempt isn`t counted, the current count and time attempts are locked until are returned.
+	ReserveLoginAttempt(ctx context.Context, key string, now time.Time, window time.Duration, threshold int, hold time.Duration) (int, time.Time, error)
+	// ReleaseLoginAttempt uncounts attempt reserved by key which turned out successful
+	ReleaseLoginAttempt(ctx context.Context, key string) error
+	// LockLogin locks login attempts counted by key until, lock is never shortened
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	// ResetLoginFailures forgets failed login attempts counted by key
+	ResetLoginFailures(ctx context.Context, key string) error
+	// PruneLoginAttempts deletes counters of attempts made and locked before the given time and returns their count
+	PruneLoginAttempts(ctx context.Context, before time.Time) (int64, error)
+	// PruneSessions deletes sessions expired or revoked before the given time with their refresh tokens
+	// and returns count of deleted sessions
+	PruneSessions(ctx context.Context, before time.Time) (int64, error)
 }
 
 type Auth struct {
-	key     []byte
-	storage AuthStorager
+	keys     *KeySet
+	storage  AuthStorager
+	cookies  CookieConfig
+	tokens   TokenConfig
+	throttle LoginThrottle
+	// clientIPHeader is header with client IP set by trusted reverse proxy, IP of connection is used if it`s empty
+	clientIPHeader string
 }
 
-func NewAuth(key []byte, storage AuthStorager) *Auth {
+func NewAuth(keys *KeySet, storage AuthStorager, cookies CookieConfig, tokens TokenConfig, throttle LoginThrottle, clientIPHeader string) *Auth {
 
 	return &Auth{
-		key:     key,
-		storage: storage,
+		keys:           keys,
+		storage:        storage,
+		cookies:        cookies,
+		tokens:         tokens,
+		throttle:       throttle,
+		clientIPHeader: clientIPHeader,
 	}
 }
 
-func (a *Auth) RegisterUser(ctx context.Context, ar *AuthRequest) (*http.Cookie, error) {
+func (a *Auth) RegisterUser(ctx context.Context, ar *AuthRequest, client *Client) (*Tokens, error) {
 	// Check if user not exists
 	userExists, err := a.storage.IsUserExists(ctx, ar.Login)
 	if err != nil {
@@ -75,10 +127,16 @@ func (a *Auth) RegisterUser(ctx context.Context, ar *AuthRequest) (*http.Cookie,
 		return nil, err
 	}
 
-	return a.GenerateToken(ctx, ar)
+	return a.startSession(ctx, ar.Login, client)
 }
 
-func (a *Auth) LoginUser(ctx context.Context, ar *AuthRequest) (*http.Cookie, error) {
+func (a *Auth) LoginUser(ctx context.Context, ar *AuthRequest, client *Client) (*Tokens, error) {
+
+	// Attempt is counted before checking password, so concurrent attempts can`t pass the throttle together
+	attempts, err := a.reserveLoginAttempt(ctx, ar.Login, client)
+	if err != nil {
+		return nil, err
+	}
 
 	// Check if user not exists
 	userExists, err := a.storage.IsUserExists(ctx, ar.Login)
@@ -86,106 +144,124 @@ func (a *Auth) LoginUser(ctx context.Context, ar *AuthRequest) (*http.Cookie, er
 		return nil, err
 	}
 
+	if !userExists {
+		logger.Log.Debug(
+			"trying to login unknown user",
+			zap.String("userID", ar.Login),
+		)
+		return nil, a.rejectLogin(ctx, ar.Login, client, attempts)
+	}
+
 	// Get passwordHash from db
 	realpasswordHash, err := a.storage.GetHash(ctx, ar.Login)
 	if err != nil {
+		if errors.Is(err, ErrIncorrectUserCredentials) {
+			return nil, a.rejectLogin(ctx, ar.Login, client, attempts)
+		}
 		return nil, err
 	}
 
-	// if !userExists || strings.Compare(string(passwordHash), realpasswordHash) == 0 {
-	if err := bcrypt.CompareHashAndPassword([]byte(realpasswordHash), []byte(ar.Password)); err != nil || !userExists {
+	if err := bcrypt.CompareHashAndPassword([]byte(realpasswordHash), []byte(ar.Password)); err != nil {
 		logger.Log.Debug(
 			"incorrect password",
 			zap.Error(err),
 		)
-		return nil, ErrIncorrectUserCredentials
+		return nil, a.rejectLogin(ctx, ar.Login, client, attempts)
 	}
 
-	return a.GenerateToken(ctx, ar)
-}
-
-func (a *Auth) GenerateToken(ctx context.Context, ar *AuthRequest) (*http.Cookie, error) {
-	expires := time.Now().Add(30 * 24 * time.Hour)
+	if err := a.loginSucceeded(ctx, attempts); err != nil {
+		return nil, err
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID":  ar.Login,
-		"expires": expires,
-	})
+	return a.startSession(ctx, ar.Login, client)
+}
 
-	tokenString, err := token.SignedString(a.key)
-	if err != nil {
-		return nil, err
+// rejectLogin locks further attempts after failed one and returns error client is answered with
+func (a *Auth) rejectLogin(ctx context.Context, login string, client *Client, attempts []loginAttempt) error {
+	if err := a.loginFailed(ctx, login, client, attempts); err != nil {
+		return err
 	}
+	return ErrIncorrectUserCredentials
+}
 
-	authCookie := &http.Cookie{
-		Name:    "gophermart-auth",
-		Value:   tokenString,
-		Expires: expires,
+// accessToken returns access token of r from Authorization header, or from cookie if there is no header
+func accessToken(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, BearerScheme) || token == "" {
+			return "", ErrAuthorizationHeader
+		}
+		return token, nil
 	}
 
-	return authCookie, nil
+	cookie, err := r.Cookie(AccessCookie)
+	if err != nil {
+		return "", ErrNoAccessToken
+	}
+	return cookie.Value, nil
 }
 
 func (a *Auth) AuthMiddleWare(h http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		authCookie, err := r.Cookie("gophermart-auth")
+		tokenString, err := accessToken(r)
 
 		if err != nil {
 			logger.Log.Debug(
 				"unauthorized request",
+				zap.Error(err),
 			)
-			w.WriteHeader(http.StatusUnauthorized)
+			problem.Unauthorized.Write(w, r, err.Error())
 			return
 		}
 
-		token, err := jwt.Parse(authCookie.Value, func(t *jwt.Token) (interface{}, error) {
-			return a.key, nil
-		})
-
+		claims, err := a.parseToken(tokenString)
 		if err != nil {
 			logger.Log.Debug(
-				"cannot parse jwt token passed from client",
-			)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok || !token.Valid {
-			logger.Log.Debug(
-				"unauthorized request",
+				"passed invalid access token",
+				zap.Error(err),
 			)
-			w.WriteHeader(http.StatusUnauthorized)
+			detail := ErrTokenInvalid.Error()
+			if errors.Is(err, ErrTokenExpired) {
+				detail = ErrTokenExpired.Error()
+			}
+			problem.Unauthorized.Write(w, r, detail)
 			return
 		}
 
-		expire, err := time.Parse(time.RFC3339Nano, claims["expires"].(string))
+		// Access token of revoked session is rejected before it expires
+		active, err := a.storage.IsSessionActive(r.Context(), claims.Subject, claims.SessionID)
 		if err != nil {
-			logger.Log.Debug(
-				"error when parsing expire in token",
+			logger.Log.Error(
+				"error on checking session",
+				zap.String("sessionID", claims.SessionID),
 				zap.Error(err),
 			)
+			problem.Internal.Write(w, r, "")
+			return
 		}
-
-		now := time.Now()
-		if now.After(expire) {
+		if !active {
 			logger.Log.Debug(
-				"passed outdated token",
-				zap.Time("expire", expire),
-				zap.Time("now", now),
+				"passed access token of inactive session",
+				zap.String("sessionID", claims.SessionID),
 			)
-			w.WriteHeader(http.StatusUnauthorized)
+			problem.Unauthorized.Write(w, r, ErrSessionRevoked.Error())
 			return
 		}
 
 		logger.Log.Debug(
 			"user passed by auth middleware",
-			zap.String("userID", claims["userID"].(string)),
+			zap.String("userID", claims.Subject),
 		)
 
-		ctx := context.WithValue(r.Context(), Username("userID"), claims["userID"])
+		ctx := context.WithValue(r.Context(), Username("userID"), claims.Subject)
+		ctx = context.WithValue(ctx, Username("sessionID"), claims.SessionID)
 
 		h(w, r.WithContext(ctx))
 	})
 }
+
+// JWKS returns public keys tokens may be verified with
+func (a *Auth) JWKS() *JWKS {
+	return a.keys.JWKS()
+}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// sessionStorage knows only sessions which are active
+type sessionStorage struct {
+	AuthStorager
+	active map[string]bool
+}
+
+func (ss *sessionStorage) IsSessionActive(ctx context.Context, userID, sessionID string) (bool, error) {
+	return ss.active[userID+"/"+sessionID], nil
+}
+
+func testAuth(t *testing.T, cookies CookieConfig) (*Auth, *Tokens) {
+	t.Helper()
+
+	keys, err := RandomKeySet()
+	if err != nil {
+		t.Fatalf("RandomKeySet() error = %v", err)
+	}
+
+	a := NewAuth(keys, &sessionStorage{active: map[string]bool{"user/session": true}}, cookies, DefaultTokenConfig(), DefaultLoginThrottle(), "")
+	tokens, err := a.issueTokens(&Session{ID: "session", UserID: "user", Expires: time.Now().Add(RefreshTokenTTL)}, "refresh")
+	if err != nil {
+		t.Fatalf("Auth.issueTokens() error = %v", err)
+	}
+
+	return a, tokens
+}
+
+func TestAuth_AuthMiddleWare(t *testing.T) {
+	a, tokens := testAuth(t, DefaultCookieConfig())
+
+	revoked, err := a.issueTokens(&Session{ID: "revoked", UserID: "user", Expires: time.Now().Add(RefreshTokenTTL)}, "refresh")
+	if err != nil {
+		t.Fatalf("Auth.issueTokens() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		header     string
+		cookie     string
+		wantStatus int
+	}{
+		{
+			name:       "BearerHeader",
+			header:     "Bearer " + tokens.AccessToken,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "LowercaseScheme",
+			header:     "bearer " + tokens.AccessToken,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Cookie",
+			cookie:     tokens.AccessToken,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "HeaderTakesPrecedenceOverCookie",
+			header:     "Basic dXNlcjpwYXNz",
+			cookie:     tokens.AccessToken,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "InvalidToken",
+			header:     "Bearer invalid",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "RevokedSession",
+			header:     "Bearer " + revoked.AccessToken,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "BasicScheme",
+			header:     "Basic dXNlcjpwYXNz",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "EmptyBearer",
+			header:     "Bearer ",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "NoToken",
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var userID, sessionID any
+			h := a.AuthMiddleWare(func(w http.ResponseWriter, r *http.Request) {
+				userID = r.Context().Value(Username("userID"))
+				sessionID = r.Context().Value(Username("sessionID"))
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: AccessCookie, Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			h(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Auth.AuthMiddleWare() status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && (userID != "user" || sessionID != "session") {
+				t.Errorf("Auth.AuthMiddleWare() user = %v, session = %v, want user and session", userID, sessionID)
+			}
+		})
+	}
+}
+
+func TestAuth_SetCookies(t *testing.T) {
+	cookies := CookieConfig{
+		Path:        "/api",
+		RefreshPath: "/api/user/token/refresh",
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    http.SameSiteStrictMode,
+	}
+	a, tokens := testAuth(t, cookies)
+
+	rec := httptest.NewRecorder()
+	a.SetCookies(rec, tokens)
+
+	set := rec.Result().Cookies()
+	if len(set) != 2 {
+		t.Fatalf("Auth.SetCookies() set %v cookies, want 2", len(set))
+	}
+	for _, cookie := range set {
+		if !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode {
+			t.Errorf("Auth.SetCookies() cookie %v = %+v, want configured attributes", cookie.Name, cookie)
+		}
+	}
+	if set[0].Name != AccessCookie || set[0].Value != tokens.AccessToken || set[1].Name != RefreshCookie || set[1].Value != "refresh" {
+		t.Errorf("Auth.SetCookies() = %v, %v, want access and refresh cookies", set[0].Name, set[1].Name)
+	}
+
+	// Refresh token is sent only to the refresh endpoint
+	if set[0].Path != "/api" || set[1].Path != "/api/user/token/refresh" {
+		t.Errorf("Auth.SetCookies() paths = %v, %v, want /api and /api/user/token/refresh", set[0].Path, set[1].Path)
+	}
+
+	rec = httptest.NewRecorder()
+	a.ClearCookies(rec)
+
+	cleared := rec.Result().Cookies()
+	if len(cleared) != 2 || cleared[0].Path != set[0].Path || cleared[1].Path != set[1].Path || cleared[0].MaxAge >= 0 || cleared[1].MaxAge >= 0 {
+		t.Errorf("Auth.ClearCookies() = %+v, want both cookies expired at their paths", cleared)
+	}
+}
+
+func TestCookieConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  CookieConfig
+		wantErr bool
+	}{
+		{
+			name:   "Default",
+			config: DefaultCookieConfig(),
+		},
+		{
+			name:    "RelativePath",
+			config:  CookieConfig{Path: "api", RefreshPath: "/api/user/token/refresh", SameSite: http.SameSiteLaxMode},
+			wantErr: true,
+		},
+		{
+			name:    "RelativeRefreshPath",
+			config:  CookieConfig{Path: "/", RefreshPath: "api/user/token/refresh", SameSite: http.SameSiteLaxMode},
+			wantErr: true,
+		},
+		{
+			name:    "SameSiteNoneWithoutSecure",
+			config:  CookieConfig{Path: "/", RefreshPath: "/api/user/token/refresh", SameSite: http.SameSiteNoneMode},
+			wantErr: true,
+		},
+		{
+			name:   "SameSiteNoneSecure",
+			config: CookieConfig{Path: "/", RefreshPath: "/api/user/token/refresh", Secure: true, SameSite: http.SameSiteNoneMode},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("CookieConfig.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuth_ClientFromRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		values map[string]string
+		want   string
+	}{
+		{
+			name:   "ConnectionIP",
+			values: map[string]string{"X-Forwarded-For": "203.0.113.7"},
+			want:   "192.0.2.1",
+		},
+		{
+			name:   "RealIP",
+			header: "X-Real-IP",
+			values: map[string]string{"X-Real-IP": "203.0.113.7"},
+			want:   "203.0.113.7",
+		},
+		{
+			// Addresses before the one appended by trusted proxy are passed by client
+			name:   "ForwardedForLastAddress",
+			header: "X-Forwarded-For",
+			values: map[string]string{"X-Forwarded-For": "198.51.100.9, 203.0.113.7"},
+			want:   "203.0.113.7",
+		},
+		{
+			name:   "MissingHeader",
+			header: "X-Real-IP",
+			want:   "192.0.2.1",
+		},
+		{
+			name:   "InvalidHeader",
+			header: "X-Real-IP",
+			values: map[string]string{"X-Real-IP": "unknown"},
+			want:   "192.0.2.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAuth(nil, nil, DefaultCookieConfig(), DefaultTokenConfig(), DefaultLoginThrottle(), tt.header)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/user/login", nil)
+			req.RemoteAddr = "192.0.2.1:41234"
+			for name, value := range tt.values {
+				req.Header.Set(name, value)
+			}
+
+			if got := a.ClientFromRequest(req); got.IP != tt.want {
+				t.Errorf("Auth.ClientFromRequest() IP = %v, want %v", got.IP, tt.want)
+			}
+		})
+	}
+}
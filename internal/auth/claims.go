@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrTokenInvalid = errors.New("access token isn`t valid")
+	ErrTokenExpired = errors.New("access token is expired")
+)
+
+// Claims is content of access token
+type Claims struct {
+	// SessionID is id of session the token is issued for
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// TokenConfig is what access tokens are issued for and how strictly they are checked
+type TokenConfig struct {
+	Issuer   string
+	Audience string
+	// Leeway tolerates clock skew between token issuer and verifiers
+	Leeway time.Duration
+}
+
+// DefaultTokenConfig returns config of tokens issued and verified by gophermart itself
+func DefaultTokenConfig() TokenConfig {
+	return TokenConfig{
+		Issuer:   "gophermart",
+		Audience: "gophermart",
+		Leeway:   30 * time.Second,
+	}
+}
+
+// newClaims returns claims of access token of session expiring at expires
+func (a *Auth) newClaims(session *Session, now, expires time.Time) (*Claims, error) {
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Claims{
+		SessionID: session.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Subject:   session.UserID,
+			Issuer:    a.tokens.Issuer,
+			Audience:  jwt.ClaimStrings{a.tokens.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+	}, nil
+}
+
+// parseToken verifies signature of access token and its claims, every failure is ErrTokenInvalid or ErrTokenExpired
+func (a *Auth) parseToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+
+	// Claims are validated by validateClaims, because parser of jwt/v4 has no leeway
+	parser := jwt.NewParser(jwt.WithValidMethods(a.keys.Methods()), jwt.WithoutClaimsValidation())
+	if _, err := parser.ParseWithClaims(tokenString, claims, a.keys.Keyfunc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
+	}
+
+	if err := a.tokens.validateClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// validateClaims checks registered claims of token at now allowing clock skew up to leeway
+func (t TokenConfig) validateClaims(claims *Claims, now time.Time) error {
+	if claims.ExpiresAt == nil {
+		return fmt.Errorf("%w: no exp claim", ErrTokenInvalid)
+	}
+	if !now.Before(claims.ExpiresAt.Add(t.Leeway)) {
+		return fmt.Errorf("%w at %v", ErrTokenExpired, claims.ExpiresAt.Time)
+	}
+	if claims.IssuedAt == nil || claims.IssuedAt.After(now.Add(t.Leeway)) {
+		return fmt.Errorf("%w: iat claim is missing or in the future", ErrTokenInvalid)
+	}
+	if claims.NotBefore != nil && claims.NotBefore.After(now.Add(t.Leeway)) {
+		return fmt.Errorf("%w: token isn`t valid yet", ErrTokenInvalid)
+	}
+	if claims.Issuer != t.Issuer {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrTokenInvalid, claims.Issuer)
+	}
+	if !slices.Contains(claims.Audience, t.Audience) {
+		return fmt.Errorf("%w: token isn`t issued for %q", ErrTokenInvalid, t.Audience)
+	}
+	if claims.Subject == "" || claims.SessionID == "" || claims.ID == "" {
+		return fmt.Errorf("%w: sub, sid and jti claims are required", ErrTokenInvalid)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestAuth_parseToken(t *testing.T) {
+	a, _ := testAuth(t, DefaultCookieConfig())
+	another, _ := testAuth(t, DefaultCookieConfig())
+
+	now := time.Now()
+	session := &Session{ID: "session", UserID: "user"}
+
+	// sign signs claims of session changed by modify with key set of a
+	sign := func(a *Auth, modify func(c *Claims)) string {
+		claims, err := a.newClaims(session, now, now.Add(AccessTokenTTL))
+		if err != nil {
+			t.Fatalf("Auth.newClaims() error = %v", err)
+		}
+		modify(claims)
+
+		token, err := a.keys.Sign(claims)
+		if err != nil {
+			t.Fatalf("KeySet.Sign() error = %v", err)
+		}
+		return token
+	}
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "user"}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("error on making unsigned token: %v", err)
+	}
+	malformedExp, err := a.keys.Sign(jwt.MapClaims{"sub": "user", "sid": "session", "exp": "tomorrow"})
+	if err != nil {
+		t.Fatalf("KeySet.Sign() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{
+			name:  "Valid",
+			token: sign(a, func(c *Claims) {}),
+		},
+		{
+			name: "ExpiredWithinLeeway",
+			token: sign(a, func(c *Claims) {
+				c.ExpiresAt = jwt.NewNumericDate(now.Add(-10 * time.Second))
+			}),
+		},
+		{
+			name: "Expired",
+			token: sign(a, func(c *Claims) {
+				c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+			}),
+			wantErr: ErrTokenExpired,
+		},
+		{
+			name: "NoExpiration",
+			token: sign(a, func(c *Claims) {
+				c.ExpiresAt = nil
+			}),
+			wantErr: ErrTokenInvalid,
+		},
+		{
+			name: "IssuedInFuture",
+			token: sign(a, func(c *Claims) {
+				c.IssuedAt = jwt.NewNumericDate(now.Add(time.Hour))
+			}),
+			wantErr: ErrTokenInvalid,
+		},
+		{
+			name: "NotValidYet",
+			token: sign(a, func(c *Claims) {
+				c.NotBefore = jwt.NewNumericDate(now.Add(time.Hour))
+			}),
+			wantErr: ErrTokenInvalid,
+		},
+		{
+			name: "AnotherIssuer",
+			token: sign(a, func(c *Claims) {
+				c.Issuer = "accrual"
+			}),
+			wantErr: ErrTokenInvalid,
+		},
+		{
+			name: "AnotherAudience",
+			token: sign(a, func(c *Claims) {
+				c.Audience = jwt.ClaimStrings{"accrual"}
+			}),
+			wantErr: ErrTokenInvalid,
+		},
+		{
+			name: "NoSubject",
+			token: sign(a, func(c *Claims) {
+				c.Subject = ""
+			}),
+			wantErr: ErrTokenInvalid,
+		},
+		{
+			name: "NoSession",
+			token: sign(a, func(c *Claims) {
+				c.SessionID = ""
+			}),
+			wantErr: ErrTokenInvalid,
+		},
+		{
+			name:    "SignedWithAnotherSecret",
+			token:   sign(another, func(c *Claims) {}),
+			wantErr: ErrTokenInvalid,
+		},
+		{
+			name:    "Unsigned",
+			token:   unsigned,
+			wantErr: ErrTokenInvalid,
+		},
+		{
+			name:    "MalformedExpiration",
+			token:   malformedExp,
+			wantErr: ErrTokenInvalid,
+		},
+		{
+			name:    "Garbage",
+			token:   "not a token",
+			wantErr: ErrTokenInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := a.parseToken(tt.token)
+			if tt.wantErr == nil {
+				if err != nil || claims.Subject != "user" || claims.SessionID != "session" {
+					t.Errorf("Auth.parseToken() = %+v, error = %v, want claims of session", claims, err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Auth.parseToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
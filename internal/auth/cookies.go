@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// CookieConfig is attributes of cookies carrying tokens
+type CookieConfig struct {
+	Path string
+	// RefreshPath is path of refresh cookie, it is sent only to the refresh endpoint
+	RefreshPath string
+	Secure      bool
+	HttpOnly    bool
+	SameSite    http.SameSite
+}
+
+// DefaultCookieConfig returns cookie attributes for server behind plain HTTP,
+// Secure should be enabled when the server is exposed through TLS
+func DefaultCookieConfig() CookieConfig {
+	return CookieConfig{
+		Path:        "/",
+		RefreshPath: "/api/user/token/refresh",
+		HttpOnly:    true,
+		SameSite:    http.SameSiteLaxMode,
+	}
+}
+
+// Validate checks that browsers accept cookies with the attributes
+func (c CookieConfig) Validate() error {
+	for _, path := range []string{c.Path, c.RefreshPath} {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("cookie path %q must start with /", path)
+		}
+	}
+	if c.SameSite == http.SameSiteNoneMode && !c.Secure {
+		return errors.New("cookie with SameSite=None must be Secure")
+	}
+	return nil
+}
+
+// ParseSameSite parses SameSite attribute of cookie: lax, strict or none
+func ParseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("unknown SameSite value %q, want lax, strict or none", value)
+}
+
+// cookie returns cookie with configured attributes
+func (c CookieConfig) cookie(name, value string) *http.Cookie {
+	path := c.Path
+	if name == RefreshCookie {
+		path = c.RefreshPath
+	}
+
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Secure:   c.Secure,
+		HttpOnly: c.HttpOnly,
+		SameSite: c.SameSite,
+	}
+}
+
+// SetCookies sets cookies carrying tokens
+func (a *Auth) SetCookies(w http.ResponseWriter, tokens *Tokens) {
+	access := a.cookies.cookie(AccessCookie, tokens.AccessToken)
+	access.Expires = tokens.AccessExpires
+	http.SetCookie(w, access)
+
+	refresh := a.cookies.cookie(RefreshCookie, tokens.RefreshToken)
+	refresh.Expires = tokens.RefreshExpires
+	http.SetCookie(w, refresh)
+}
+
+// ClearCookies removes cookies carrying tokens
+func (a *Auth) ClearCookies(w http.ResponseWriter) {
+	for _, name := range []string{AccessCookie, RefreshCookie} {
+		cookie := a.cookies.cookie(name, "")
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
@@ -0,0 +1,321 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrKeyUnknown      = errors.New("token is signed with unknown key")
+	ErrKeyRetired      = errors.New("token is signed with retired key")
+	ErrKeyAlgMismatch  = errors.New("token algorithm doesn`t match algorithm of key")
+	ErrNoSigningKey    = errors.New("key set has no active key for signing")
+	ErrKeyAlgUnknown   = errors.New("key algorithm isn`t supported")
+	ErrKeyDuplicateKID = errors.New("key set has duplicate key id")
+)
+
+// minSecretLength is minimal length of HMAC secret in bytes
+const minSecretLength = 32
+
+// Key is key tokens are signed and verified with, identified in token by kid header
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// Retired keys aren`t used anymore, tokens signed with them are rejected
+	Retired bool
+
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewHMACKey returns HS256 key with secret shared by signer and verifiers
+func NewHMACKey(id string, secret []byte) (*Key, error) {
+	if len(secret) < minSecretLength {
+		return nil, fmt.Errorf("HMAC secret of key %v must be at least %v bytes", id, minSecretLength)
+	}
+
+	return &Key{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}, nil
+}
+
+// NewEd25519Key returns EdDSA key, other services may verify tokens with its public key
+func NewEd25519Key(id string, private ed25519.PrivateKey) *Key {
+	return &Key{
+		ID:        id,
+		Method:    jwt.SigningMethodEdDSA,
+		signKey:   private,
+		verifyKey: private.Public(),
+	}
+}
+
+// NewRSAKey returns RS256 key, other services may verify tokens with its public key
+func NewRSAKey(id string, private *rsa.PrivateKey) *Key {
+	return &Key{
+		ID:        id,
+		Method:    jwt.SigningMethodRS256,
+		signKey:   private,
+		verifyKey: &private.PublicKey,
+	}
+}
+
+// KeySet is keys ordered from the oldest to the newest, the newest active key signs tokens
+type KeySet struct {
+	keys []*Key
+}
+
+// NewKeySet returns set of keys ordered from the oldest to the newest
+func NewKeySet(keys ...*Key) (*KeySet, error) {
+	ids := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if _, ok := ids[key.ID]; ok {
+			return nil, fmt.Errorf("%w: %v", ErrKeyDuplicateKID, key.ID)
+		}
+		ids[key.ID] = struct{}{}
+	}
+
+	ks := &KeySet{keys: keys}
+	if _, err := ks.SigningKey(); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// SigningKey returns the newest active key
+func (ks *KeySet) SigningKey() (*Key, error) {
+	for i := len(ks.keys) - 1; i >= 0; i-- {
+		if !ks.keys[i].Retired {
+			return ks.keys[i], nil
+		}
+	}
+	return nil, ErrNoSigningKey
+}
+
+// Sign signs claims with the newest active key and sets its id as kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key, err := ks.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.signKey)
+}
+
+// Methods returns algorithms of active keys, tokens signed with other algorithms are rejected
+func (ks *KeySet) Methods() []string {
+	methods := make([]string, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		if !key.Retired && !slices.Contains(methods, key.Method.Alg()) {
+			methods = append(methods, key.Method.Alg())
+		}
+	}
+	return methods
+}
+
+// Keyfunc returns verification key of active key token is signed with
+func (ks *KeySet) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	for _, key := range ks.keys {
+		if key.ID != kid {
+			continue
+		}
+		if key.Retired {
+			return nil, fmt.Errorf("%w: %v", ErrKeyRetired, kid)
+		}
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("%w: %v", ErrKeyAlgMismatch, t.Method.Alg())
+		}
+		return key.verifyKey, nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrKeyUnknown, kid)
+}
+
+// JWK is public key in JSON Web Key format
+type JWK struct {
+	KeyType string `json:"kty"`
+	Use     string `json:"use"`
+	ID      string `json:"kid"`
+	Alg     string `json:"alg"`
+	// Curve and X are set for Ed25519 keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	// N and E are set for RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+// JWKS is set of public keys other services verify tokens with
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns public keys of active asymmetric keys, HMAC secrets are never published
+func (ks *KeySet) JWKS() *JWKS {
+	jwks := &JWKS{Keys: []JWK{}}
+
+	for _, key := range ks.keys {
+		if key.Retired {
+			continue
+		}
+
+		jwk := JWK{
+			Use: "sig",
+			ID:  key.ID,
+			Alg: key.Method.Alg(),
+		}
+
+		switch public := key.verifyKey.(type) {
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		default:
+			continue
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	return jwks
+}
+
+// keyFileEntry is key in key set file
+type keyFileEntry struct {
+	ID      string `json:"kid"`
+	Alg     string `json:"alg"`
+	Retired bool   `json:"retired"`
+	// Secret is hex encoded secret of HS256 key
+	Secret string `json:"secret"`
+	// PrivateKey is PEM encoded private key of EdDSA or RS256 key, PrivateKeyFile is path to it
+	PrivateKey     string `json:"private_key"`
+	PrivateKeyFile string `json:"private_key_file"`
+}
+
+// LoadKeySet reads key set from JSON file at path with keys ordered from the oldest to the newest:
+//
+//	{"keys": [
+//	  {"kid": "2024-12", "alg": "HS256", "secret": "<hex>", "retired": true},
+//	  {"kid": "2025-01", "alg": "EdDSA", "private_key_file": "/etc/gophermart/jwt-2025-01.pem"}
+//	]}
+func LoadKeySet(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Keys []keyFileEntry `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error on parsing key set file: %w", err)
+	}
+
+	keys := make([]*Key, 0, len(file.Keys))
+	for _, entry := range file.Keys {
+		key, err := entry.key()
+		if err != nil {
+			return nil, err
+		}
+		key.Retired = entry.Retired
+		keys = append(keys, key)
+	}
+
+	return NewKeySet(keys...)
+}
+
+func (e keyFileEntry) key() (*Key, error) {
+	if e.ID == "" {
+		return nil, errors.New("key in key set file has no kid")
+	}
+
+	if e.Alg == jwt.SigningMethodHS256.Alg() {
+		secret, err := hex.DecodeString(e.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("error on decoding secret of key %v: %w", e.ID, err)
+		}
+		return NewHMACKey(e.ID, secret)
+	}
+
+	pem := []byte(e.PrivateKey)
+	if e.PrivateKeyFile != "" {
+		var err error
+		if pem, err = os.ReadFile(e.PrivateKeyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	switch e.Alg {
+	case jwt.SigningMethodEdDSA.Alg():
+		private, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("error on parsing private key of key %v: %w", e.ID, err)
+		}
+		edPrivate, ok := private.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("private key of key %v isn`t Ed25519 key", e.ID)
+		}
+		return NewEd25519Key(e.ID, edPrivate), nil
+	case jwt.SigningMethodRS256.Alg():
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("error on parsing private key of key %v: %w", e.ID, err)
+		}
+		return NewRSAKey(e.ID, private), nil
+	}
+
+	return nil, fmt.Errorf("%w: %v of key %v", ErrKeyAlgUnknown, e.Alg, e.ID)
+}
+
+// SecretKeySet returns key set of single HMAC key, its kid is derived from secret
+// so tokens signed with previous secret are rejected as signed with unknown key
+func SecretKeySet(secret []byte) (*KeySet, error) {
+	sum := sha256.Sum256(secret)
+
+	key, err := NewHMACKey(hex.EncodeToString(sum[:8]), secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeySet(key)
+}
+
+// RandomKeySet returns key set of single HMAC key with random secret,
+// tokens signed with it become invalid after restart
+func RandomKeySet() (*KeySet, error) {
+	secret := make([]byte, minSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	key, err := NewHMACKey("random", secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeySet(key)
+}
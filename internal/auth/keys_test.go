@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func testHMACKey(t *testing.T, id string) *Key {
+	t.Helper()
+
+	key, err := NewHMACKey(id, []byte(strings.Repeat(id, minSecretLength)))
+	if err != nil {
+		t.Fatalf("NewHMACKey() error = %v", err)
+	}
+	return key
+}
+
+func testEd25519Key(t *testing.T, id string) *Key {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error on generating Ed25519 key: %v", err)
+	}
+	return NewEd25519Key(id, private)
+}
+
+func TestKeySet_Keyfunc(t *testing.T) {
+	old := testHMACKey(t, "1")
+	current := testEd25519Key(t, "2")
+	retired := testHMACKey(t, "0")
+	retired.Retired = true
+
+	oldToken, err := (&KeySet{keys: []*Key{old}}).Sign(jwt.MapClaims{"userID": "user"})
+	if err != nil {
+		t.Fatalf("KeySet.Sign() error = %v", err)
+	}
+	retiredToken, err := (&KeySet{keys: []*Key{testHMACKey(t, "0")}}).Sign(jwt.MapClaims{"userID": "user"})
+	if err != nil {
+		t.Fatalf("KeySet.Sign() error = %v", err)
+	}
+	unknownToken, err := (&KeySet{keys: []*Key{testHMACKey(t, "3")}}).Sign(jwt.MapClaims{"userID": "user"})
+	if err != nil {
+		t.Fatalf("KeySet.Sign() error = %v", err)
+	}
+
+	// HMAC token claiming kid of Ed25519 key must not be verified with its public key as secret
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"userID": "user"})
+	confused.Header["kid"] = current.ID
+	confusedToken, err := confused.SignedString([]byte(current.verifyKey.(ed25519.PublicKey)))
+	if err != nil {
+		t.Fatalf("error on signing token: %v", err)
+	}
+
+	ks, err := NewKeySet(retired, old, current)
+	if err != nil {
+		t.Fatalf("NewKeySet() error = %v", err)
+	}
+
+	currentToken, err := ks.Sign(jwt.MapClaims{"userID": "user"})
+	if err != nil {
+		t.Fatalf("KeySet.Sign() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{
+			name:  "SignedWithNewestKey",
+			token: currentToken,
+		},
+		{
+			name:  "SignedWithOlderActiveKey",
+			token: oldToken,
+		},
+		{
+			name:    "SignedWithRetiredKey",
+			token:   retiredToken,
+			wantErr: ErrKeyRetired,
+		},
+		{
+			name:    "SignedWithUnknownKey",
+			token:   unknownToken,
+			wantErr: ErrKeyUnknown,
+		},
+		{
+			name:    "AlgorithmOfAnotherKey",
+			token:   confusedToken,
+			wantErr: ErrKeyAlgMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := jwt.Parse(tt.token, ks.Keyfunc)
+			if tt.wantErr == nil {
+				if err != nil || !token.Valid {
+					t.Errorf("jwt.Parse() error = %v, want valid token", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("jwt.Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	key, _ := ks.SigningKey()
+	if key != current {
+		t.Errorf("KeySet.SigningKey() = %v, want %v", key.ID, current.ID)
+	}
+}
+
+func TestNewKeySet(t *testing.T) {
+	retired := testHMACKey(t, "1")
+	retired.Retired = true
+
+	if _, err := NewKeySet(testHMACKey(t, "1"), testHMACKey(t, "1")); !errors.Is(err, ErrKeyDuplicateKID) {
+		t.Errorf("NewKeySet() with duplicate kid error = %v, wantErr %v", err, ErrKeyDuplicateKID)
+	}
+	if _, err := NewKeySet(retired); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("NewKeySet() with retired keys only error = %v, wantErr %v", err, ErrNoSigningKey)
+	}
+	if _, err := NewHMACKey("short", []byte("secret")); err == nil {
+		t.Errorf("NewHMACKey() with short secret error = nil, want error")
+	}
+}
+
+func TestKeySet_JWKS(t *testing.T) {
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error on generating RSA key: %v", err)
+	}
+	retired := testEd25519Key(t, "retired")
+	retired.Retired = true
+
+	ks, err := NewKeySet(retired, testHMACKey(t, "hmac"), NewRSAKey("rsa", rsaPrivate), testEd25519Key(t, "ed"))
+	if err != nil {
+		t.Fatalf("NewKeySet() error = %v", err)
+	}
+
+	jwks := ks.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("KeySet.JWKS() = %v keys, want 2 active asymmetric keys", len(jwks.Keys))
+	}
+	if jwk := jwks.Keys[0]; jwk.ID != "rsa" || jwk.KeyType != "RSA" || jwk.Alg != "RS256" || jwk.E != "AQAB" {
+		t.Errorf("KeySet.JWKS()[0] = %+v, want RSA key", jwk)
+	}
+	if jwk := jwks.Keys[1]; jwk.ID != "ed" || jwk.KeyType != "OKP" || jwk.Curve != "Ed25519" || jwk.X == "" {
+		t.Errorf("KeySet.JWKS()[1] = %+v, want Ed25519 key", jwk)
+	}
+}
+
+func TestLoadKeySet(t *testing.T) {
+	dir := t.TempDir()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error on generating Ed25519 key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("error on marshalling Ed25519 key: %v", err)
+	}
+	pemPath := filepath.Join(dir, "ed25519.pem")
+	if err := os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("error on writing key file: %v", err)
+	}
+
+	secret := hex.EncodeToString([]byte(strings.Repeat("s", minSecretLength)))
+	keysPath := filepath.Join(dir, "keys.json")
+	keysFile := `{"keys": [
+		{"kid": "old", "alg": "HS256", "secret": "` + secret + `", "retired": true},
+		{"kid": "new", "alg": "EdDSA", "private_key_file": "` + pemPath + `"}
+	]}`
+	if err := os.WriteFile(keysPath, []byte(keysFile), 0o600); err != nil {
+		t.Fatalf("error on writing key set file: %v", err)
+	}
+
+	ks, err := LoadKeySet(keysPath)
+	if err != nil {
+		t.Fatalf("LoadKeySet() error = %v", err)
+	}
+
+	key, err := ks.SigningKey()
+	if err != nil || key.ID != "new" || key.Method != jwt.SigningMethodEdDSA {
+		t.Errorf("LoadKeySet() signing key = %v, error = %v, want EdDSA key new", key, err)
+	}
+	if !ks.keys[0].Retired {
+		t.Errorf("LoadKeySet() key old isn`t retired")
+	}
+
+	if err := os.WriteFile(keysPath, []byte(`{"keys": [{"kid": "new", "alg": "none"}]}`), 0o600); err != nil {
+		t.Fatalf("error on writing key set file: %v", err)
+	}
+	if _, err := LoadKeySet(keysPath); !errors.Is(err, ErrKeyAlgUnknown) {
+		t.Errorf("LoadKeySet() with unknown alg error = %v, wantErr %v", err, ErrKeyAlgUnknown)
+	}
+}
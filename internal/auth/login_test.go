@@ -0,0 +1,136 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+	"github.com/renatus-cartesius/gophermart/internal/storage/memory"
+)
+
+func TestAuth_LoginUser_Throttle(t *testing.T) {
+	ctx := context.Background()
+
+	keys, err := auth.RandomKeySet()
+	if err != nil {
+		t.Fatalf("RandomKeySet() error = %v", err)
+	}
+
+	throttle := auth.LoginThrottle{
+		Login: auth.Throttle{Threshold: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+		IP:    auth.Throttle{Threshold: 5, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+	}
+	a := auth.NewAuth(keys, memory.NewMemStorage(), auth.DefaultCookieConfig(), auth.DefaultTokenConfig(), throttle, "")
+
+	client := &auth.Client{IP: "192.0.2.1"}
+	anotherClient := &auth.Client{IP: "192.0.2.2"}
+	for _, login := range []string{"alice", "bob", "carol"} {
+		if _, err := a.RegisterUser(ctx, &auth.AuthRequest{Login: login, Password: "secret"}, client); err != nil {
+			t.Fatalf("Auth.RegisterUser() error = %v", err)
+		}
+	}
+
+	login := func(login, password string, client *auth.Client) error {
+		_, err := a.LoginUser(ctx, &auth.AuthRequest{Login: login, Password: password}, client)
+		return err
+	}
+
+	// Successful login forgets failures of login
+	for i := 0; i < 2; i++ {
+		if err := login("bob", "wrong", anotherClient); !errors.Is(err, auth.ErrIncorrectUserCredentials) {
+			t.Fatalf("Auth.LoginUser() with wrong password error = %v, want %v", err, auth.ErrIncorrectUserCredentials)
+		}
+	}
+	if err := login("bob", "secret", anotherClient); err != nil {
+		t.Fatalf("Auth.LoginUser() error = %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := login("alice", "wrong", client); !errors.Is(err, auth.ErrIncorrectUserCredentials) {
+			t.Fatalf("Auth.LoginUser() failure %v error = %v, want %v", i+1, err, auth.ErrIncorrectUserCredentials)
+		}
+	}
+
+	// Locked login is rejected even with right password from another address
+	err = login("alice", "secret", anotherClient)
+	var lockedErr *auth.LoginLockedError
+	if !errors.As(err, &lockedErr) || !errors.Is(err, auth.ErrLoginLocked) {
+		t.Fatalf("Auth.LoginUser() of locked login error = %v, want %v", err, auth.ErrLoginLocked)
+	}
+	if wait := lockedErr.RetryAfter(time.Now()); wait <= 0 || wait > time.Minute {
+		t.Errorf("LoginLockedError.RetryAfter() = %v, want up to 1m", wait)
+	}
+
+	if err := login("bob", "secret", anotherClient); err != nil {
+		t.Errorf("Auth.LoginUser() of another login error = %v", err)
+	}
+
+	// Failures of unknown logins are counted against address too
+	for i := 0; i < 2; i++ {
+		if err := login("mallory", "wrong", client); !errors.Is(err, auth.ErrIncorrectUserCredentials) {
+			t.Fatalf("Auth.LoginUser() of unknown user error = %v, want %v", err, auth.ErrIncorrectUserCredentials)
+		}
+	}
+	if err := login("carol", "secret", client); !errors.Is(err, auth.ErrLoginLocked) {
+		t.Errorf("Auth.LoginUser() from locked address error = %v, want %v", err, auth.ErrLoginLocked)
+	}
+	if err := login("carol", "secret", anotherClient); err != nil {
+		t.Errorf("Auth.LoginUser() from another address error = %v", err)
+	}
+}
+
+func TestAuth_LoginUser_ThrottleConcurrent(t *testing.T) {
+	ctx := context.Background()
+
+	keys, err := auth.RandomKeySet()
+	if err != nil {
+		t.Fatalf("RandomKeySet() error = %v", err)
+	}
+
+	throttle := auth.LoginThrottle{
+		Login: auth.Throttle{Threshold: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+		IP:    auth.Throttle{Threshold: 100, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour},
+	}
+	a := auth.NewAuth(keys, memory.NewMemStorage(), auth.DefaultCookieConfig(), auth.DefaultTokenConfig(), throttle, "")
+
+	client := &auth.Client{IP: "192.0.2.1"}
+	if _, err := a.RegisterUser(ctx, &auth.AuthRequest{Login: "alice", Password: "secret"}, client); err != nil {
+		t.Fatalf("Auth.RegisterUser() error = %v", err)
+	}
+
+	const attempts = 30
+
+	var (
+		wg              sync.WaitGroup
+		mu              sync.Mutex
+		checked, locked int
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := a.LoginUser(ctx, &auth.AuthRequest{Login: "alice", Password: "wrong"}, client)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case errors.Is(err, auth.ErrIncorrectUserCredentials):
+				checked++
+			case errors.Is(err, auth.ErrLoginLocked):
+				locked++
+			default:
+				t.Errorf("Auth.LoginUser() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Burst of attempts checks no more passwords than threshold allows
+	if checked != 3 || locked != attempts-3 {
+		t.Errorf("Auth.LoginUser() checked %v and locked %v of %v concurrent attempts, want 3 checked", checked, locked, attempts)
+	}
+}
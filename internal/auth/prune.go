@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// pruneInterval is delay between deletions of stale sessions and login attempts
+	pruneInterval = time.Hour
+	// sessionRetention is how long expired and revoked sessions are kept, so reuse
+	// of their refresh tokens is still reported as reuse for a while
+	sessionRetention = 24 * time.Hour
+)
+
+// RunPruning deletes stale sessions and login attempts once per pruneInterval until ctx is done
+func (a *Auth) RunPruning(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.Prune(ctx); err != nil {
+				logger.Log.Error(
+					"error on pruning sessions and login attempts",
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+// Prune deletes sessions expired or revoked earlier than sessionRetention ago and counters
+// of login attempts which are out of throttling window and aren`t locked
+func (a *Auth) Prune(ctx context.Context) error {
+	now := time.Now().UTC()
+
+	sessions, err := a.storage.PruneSessions(ctx, now.Add(-sessionRetention))
+	if err != nil {
+		return err
+	}
+
+	attempts, err := a.storage.PruneLoginAttempts(ctx, now.Add(-max(a.throttle.Login.Window, a.throttle.IP.Window)))
+	if err != nil {
+		return err
+	}
+
+	if sessions > 0 || attempts > 0 {
+		logger.Log.Info(
+			"sessions and login attempts pruned",
+			zap.Int64("sessions", sessions),
+			zap.Int64("loginAttempts", attempts),
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// AccessTokenTTL is lifetime of access token, it is rejected earlier if its session is revoked
+	AccessTokenTTL = 15 * time.Minute
+	// RefreshTokenTTL is how long session lives without refreshing
+	RefreshTokenTTL = 30 * 24 * time.Hour
+
+	AccessCookie  = "gophermart-auth"
+	RefreshCookie = "gophermart-refresh"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token isn`t valid")
+	ErrRefreshTokenReused  = errors.New("refresh token is already used, session is revoked")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrSessionRevoked      = errors.New("session is revoked or expired")
+)
+
+// Session is login of user on some device, it lives while its refresh tokens are rotated.
+// Every refresh token of session may be used once, reusing one revokes the whole session.
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"-"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	Created   time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used_at"`
+	Expires   time.Time `json:"expires_at"`
+	// Current is set for session of the request listing sessions
+	Current bool `json:"current"`
+}
+
+// Client describes where authentication request came from
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
+// ClientFromRequest returns client of r. Client IP is taken from header set by trusted proxy if it is configured,
+// otherwise it is IP of the connection, so client behind no proxy can`t spoof it.
+func (a *Auth) ClientFromRequest(r *http.Request) *Client {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if a.clientIPHeader != "" {
+		if proxied := proxiedClientIP(r.Header.Get(a.clientIPHeader)); proxied != "" {
+			ip = proxied
+		}
+	}
+
+	return &Client{
+		IP:        ip,
+		UserAgent: r.UserAgent(),
+	}
+}
+
+// proxiedClientIP returns client IP from value of proxy header, list of addresses like X-Forwarded-For
+// is taken from the end since the last address is appended by the trusted proxy and the rest may be forged
+func proxiedClientIP(value string) string {
+	addresses := strings.Split(value, ",")
+	ip := net.ParseIP(strings.TrimSpace(addresses[len(addresses)-1]))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// Tokens is pair of access and refresh tokens issued for session
+type Tokens struct {
+	SessionID      string
+	AccessToken    string
+	AccessExpires  time.Time
+	RefreshToken   string
+	RefreshExpires time.Time
+}
+
+// hashRefreshToken returns hash refresh token is stored by, so leaked storage can`t be used to refresh
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// randomID returns random identifier of session or token
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// startSession creates session of user and issues its first tokens
+func (a *Auth) startSession(ctx context.Context, userID string, client *Client) (*Tokens, error) {
+	sessionID, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	session := &Session{
+		ID:        sessionID,
+		UserID:    userID,
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+		Created:   now,
+		LastUsed:  now,
+		Expires:   now.Add(RefreshTokenTTL),
+	}
+
+	if err := a.storage.AddSession(ctx, session, hashRefreshToken(refreshToken)); err != nil {
+		return nil, err
+	}
+
+	return a.issueTokens(session, refreshToken)
+}
+
+// issueTokens signs access token of session and pairs it with refreshToken
+func (a *Auth) issueTokens(session *Session, refreshToken string) (*Tokens, error) {
+	now := time.Now()
+	expires := now.Add(AccessTokenTTL)
+
+	claims, err := a.newClaims(session, now, expires)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := a.keys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tokens{
+		SessionID:      session.ID,
+		AccessToken:    accessToken,
+		AccessExpires:  expires,
+		RefreshToken:   refreshToken,
+		RefreshExpires: session.Expires,
+	}, nil
+}
+
+// Refresh exchanges refresh token for new pair of tokens, the passed refresh token can`t be used again
+func (a *Auth) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	if refreshToken == "" {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	next, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := a.storage.RotateRefreshToken(ctx, hashRefreshToken(refreshToken), hashRefreshToken(next), time.Now().UTC().Add(RefreshTokenTTL))
+	if err != nil {
+		if errors.Is(err, ErrRefreshTokenReused) {
+			logger.Log.Warn(
+				"refresh token reused, session is revoked",
+				zap.String("tokenHash", hashRefreshToken(refreshToken)),
+			)
+		}
+		return nil, err
+	}
+
+	return a.issueTokens(session, next)
+}
+
+// GetSessions returns active sessions of user
+func (a *Auth) GetSessions(ctx context.Context, userID string) ([]*Session, error) {
+	return a.storage.GetSessions(ctx, userID)
+}
+
+// RevokeSession revokes session of user, its refresh token can`t be used anymore
+func (a *Auth) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	return a.storage.RevokeSession(ctx, userID, sessionID)
+}
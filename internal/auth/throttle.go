@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+var ErrLoginLocked = errors.New("too many failed login attempts")
+
+// LoginLockedError is returned while login attempts are locked, it wraps ErrLoginLocked
+type LoginLockedError struct {
+	Until time.Time
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", ErrLoginLocked, e.Until.Format(time.RFC3339))
+}
+
+func (e *LoginLockedError) Unwrap() error {
+	return ErrLoginLocked
+}
+
+// RetryAfter returns how long client has to wait since now, rounded up to whole seconds
+func (e *LoginLockedError) RetryAfter(now time.Time) time.Duration {
+	wait := e.Until.Sub(now)
+	if wait <= 0 {
+		return 0
+	}
+	return (wait + time.Second - 1).Truncate(time.Second)
+}
+
+// Throttle is policy of delays after failed login attempts. Every failure over Threshold
+// locks attempts for doubled delay starting from BaseDelay, up to MaxDelay.
+type Throttle struct {
+	Threshold int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Window is time without failures after which failures are forgotten
+	Window time.Duration
+}
+
+// delay returns how long attempts are locked after failures in a row
+func (t Throttle) delay(failures int) time.Duration {
+	if failures < t.Threshold {
+		return 0
+	}
+
+	delay := t.BaseDelay
+	for i := t.Threshold; i < failures && delay < t.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, t.MaxDelay)
+}
+
+// LoginThrottle is throttling of failed attempts per login and per client IP,
+// IP threshold is higher since clients may share address behind NAT
+type LoginThrottle struct {
+	Login Throttle
+	IP    Throttle
+}
+
+// DefaultLoginThrottle returns throttling which locks login for 15 minutes after 13 failures in a row
+func DefaultLoginThrottle() LoginThrottle {
+	return LoginThrottle{
+		Login: Throttle{
+			Threshold: 3,
+			BaseDelay: time.Second,
+			MaxDelay:  15 * time.Minute,
+			Window:    time.Hour,
+		},
+		IP: Throttle{
+			Threshold: 20,
+			BaseDelay: time.Second,
+			MaxDelay:  15 * time.Minute,
+			Window:    time.Hour,
+		},
+	}
+}
+
+// throttleKey is key failed attempts are counted by
+type throttleKey struct {
+	key      string
+	throttle Throttle
+	// forget is set if attempts counted by key are forgotten after successful login,
+	// successful attempt is only uncounted otherwise
+	forget bool
+}
+
+func loginThrottleKey(login string) string {
+	return "login:" + login
+}
+
+func (a *Auth) throttleKeys(login string, client *Client) []throttleKey {
+	return []throttleKey{
+		{key: loginThrottleKey(login), throttle: a.throttle.Login, forget: true},
+		{key: "ip:" + client.IP, throttle: a.throttle.IP},
+	}
+}
+
+// loginAttempt is attempt reserved by throttle key
+type loginAttempt struct {
+	throttleKey
+	// count is count of attempts in a row including this one
+	count int
+}
+
+// reserveLoginAttempt counts attempt of login and client, LoginLockedError is returned if attempts of login
+// or client are locked. Attempt reaching threshold holds further ones for BaseDelay until it is checked.
+func (a *Auth) reserveLoginAttempt(ctx context.Context, login string, client *Client) ([]loginAttempt, error) {
+	now := time.Now().UTC()
+
+	attempts := make([]loginAttempt, 0, 2)
+	for _, tk := range a.throttleKeys(login, client) {
+		count, lockedUntil, err := a.storage.ReserveLoginAttempt(ctx, tk.key, now, tk.throttle.Window, tk.throttle.Threshold, tk.throttle.BaseDelay)
+		if err == nil && lockedUntil.IsZero() {
+			attempts = append(attempts, loginAttempt{throttleKey: tk, count: count})
+			continue
+		}
+
+		// Attempt rejected by one key isn`t counted by the others
+		for _, attempt := range attempts {
+			if err := a.storage.ReleaseLoginAttempt(context.WithoutCancel(ctx), attempt.key); err != nil {
+				logger.Log.Error(
+					"error on releasing login attempt",
+					zap.String("key", attempt.key),
+					zap.Error(err),
+				)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		return nil, &LoginLockedError{Until: lockedUntil}
+	}
+
+	return attempts, nil
+}
+
+// loginSucceeded forgets attempts of login and uncounts successful attempt of client
+func (a *Auth) loginSucceeded(ctx context.Context, attempts []loginAttempt) error {
+	for _, attempt := range attempts {
+		release := a.storage.ReleaseLoginAttempt
+		if attempt.forget {
+			release = a.storage.ResetLoginFailures
+		}
+
+		if err := release(ctx, attempt.key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loginFailed locks further attempts of login and client by throttle policy, failed attempt is already counted
+func (a *Auth) loginFailed(ctx context.Context, login string, client *Client, attempts []loginAttempt) error {
+	now := time.Now().UTC()
+
+	for _, attempt := range attempts {
+		delay := attempt.throttle.delay(attempt.count)
+		if delay == 0 {
+			continue
+		}
+
+		until := now.Add(delay)
+		if err := a.storage.LockLogin(ctx, attempt.key, until); err != nil {
+			return err
+		}
+
+		logger.Log.Warn(
+			"login attempts locked",
+			zap.String("audit", "auth.login_locked"),
+			zap.String("key", attempt.key),
+			zap.String("login", login),
+			zap.String("ip", client.IP),
+			zap.String("userAgent", client.UserAgent),
+			zap.Int("failures", attempt.count),
+			zap.Time("until", until),
+		)
+	}
+
+	return nil
+}
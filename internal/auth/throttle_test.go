@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottle_delay(t *testing.T) {
+	throttle := Throttle{
+		Threshold: 3,
+		BaseDelay: time.Second,
+		MaxDelay:  time.Minute,
+	}
+
+	tests := []struct {
+		name     string
+		failures int
+		want     time.Duration
+	}{
+		{
+			name:     "BelowThreshold",
+			failures: 2,
+			want:     0,
+		},
+		{
+			name:     "Threshold",
+			failures: 3,
+			want:     time.Second,
+		},
+		{
+			name:     "Doubled",
+			failures: 5,
+			want:     4 * time.Second,
+		},
+		{
+			name:     "Capped",
+			failures: 100,
+			want:     time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := throttle.delay(tt.failures); got != tt.want {
+				t.Errorf("Throttle.delay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginLockedError_RetryAfter(t *testing.T) {
+	now := time.Now()
+
+	if got := (&LoginLockedError{Until: now.Add(1500 * time.Millisecond)}).RetryAfter(now); got != 2*time.Second {
+		t.Errorf("LoginLockedError.RetryAfter() = %v, want rounded up 2s", got)
+	}
+	if got := (&LoginLockedError{Until: now.Add(-time.Second)}).RetryAfter(now); got != 0 {
+		t.Errorf("LoginLockedError.RetryAfter() of passed lock = %v, want 0", got)
+	}
+}
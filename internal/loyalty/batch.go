@@ -0,0 +1,85 @@
+package loyalty
+
+import (
+	"context"
+	"errors"
+)
+
+// MaxBatchSize limits count of order numbers uploaded in one batch
+const MaxBatchSize = 1000
+
+const (
+	TypeUploadAccepted        = "accepted"
+	TypeUploadAlreadyUploaded = "already_uploaded"
+	TypeUploadConflict        = "conflict"
+	TypeUploadInvalid         = "invalid"
+)
+
+var (
+	ErrBatchEmpty    = errors.New("batch has no order numbers")
+	ErrBatchTooLarge = errors.New("batch has too many order numbers")
+)
+
+// UploadResult is outcome of uploading one order number of the batch
+type UploadResult struct {
+	Number string `json:"number"`
+	Result string `json:"result"`
+}
+
+// UploadOrders uploads batch of order numbers and returns result of every number in order of the batch.
+// Numbers with not only digits or failed Luhn check are invalid, repeated numbers of the batch are already uploaded.
+func (l *Loyalty) UploadOrders(ctx context.Context, userID string, orderIDs []string) ([]*UploadResult, error) {
+	if len(orderIDs) == 0 {
+		return nil, ErrBatchEmpty
+	}
+	if len(orderIDs) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	results := make([]*UploadResult, len(orderIDs))
+	// first position of every valid number in the batch
+	positions := make(map[string]int, len(orderIDs))
+	valid := make([]string, 0, len(orderIDs))
+
+	for i, orderID := range orderIDs {
+		results[i] = &UploadResult{Number: orderID}
+
+		if !validOrderNumber(orderID) {
+			results[i].Result = TypeUploadInvalid
+			continue
+		}
+
+		if _, ok := positions[orderID]; ok {
+			results[i].Result = TypeUploadAlreadyUploaded
+			continue
+		}
+
+		positions[orderID] = i
+		valid = append(valid, orderID)
+	}
+
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	errs, err := l.storage.AddOrders(ctx, userID, valid)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, orderID := range valid {
+		result := results[positions[orderID]]
+		switch {
+		case errs[i] == nil:
+			result.Result = TypeUploadAccepted
+		case errors.Is(errs[i], ErrOrderAlreadyUploaded):
+			result.Result = TypeUploadAlreadyUploaded
+		case errors.Is(errs[i], ErrOrderUploadedAnotherUser), errors.Is(errs[i], ErrOrderUsedByWithdrawal):
+			result.Result = TypeUploadConflict
+		default:
+			return nil, errs[i]
+		}
+	}
+
+	return results, nil
+}
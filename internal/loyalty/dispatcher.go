@@ -2,12 +2,30 @@ package loyalty
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/renatus-cartesius/gophermart/internal/accrual"
 	"github.com/renatus-cartesius/gophermart/pkg/logger"
 	"go.uber.org/zap"
 )
 
+// PassSummary describes results of one unhandled orders processing pass
+type PassSummary struct {
+	// Processed is count of orders successfully checked in accrual and updated
+	Processed int
+	// Failed is count of orders which processing ended with error
+	Failed int
+	// Skipped is count of orders not yet registered in accrual, throttled by accrual or not reached before pass cancellation
+	Skipped int
+	// Expired is count of orders moved to INVALID status after orderMaxAge
+	Expired int
+}
+
+var errOrderExpired = errors.New("order wasn`t processed in time")
+
 func (l *Loyalty) Dispatch(ctx context.Context) error {
 	dispatchTicker := time.NewTicker(time.Second * 10)
 	defer dispatchTicker.Stop()
@@ -23,43 +41,206 @@ func (l *Loyalty) Dispatch(ctx context.Context) error {
 			logger.Log.Debug(
 				"begin unhandled order processing",
 			)
+
+			summary, err := l.ProcessUnhandledOrders(ctx)
+			if err != nil {
+				logger.Log.Error(
+					"error on unhandled order processing",
+					zap.Error(err),
+				)
+				continue
+			}
+
 			logger.Log.Debug(
 				"order processing ended",
-				zap.Error(l.ProcessUnhandledOrders(ctx)),
+				zap.Int("processed", summary.Processed),
+				zap.Int("failed", summary.Failed),
+				zap.Int("skipped", summary.Skipped),
+				zap.Int("expired", summary.Expired),
 			)
 		}
 	}
 }
 
-func (l *Loyalty) ProcessUnhandledOrders(ctx context.Context) error {
-	orders, err := l.storage.GetUnhandledOrders(ctx)
+// ProcessUnhandledOrders fans unhandled orders out to the pool of l.workers workers.
+// Failure of one order doesn`t stop the others, returned error is only about getting orders from storage.
+// Orders not started before their lease expires are left to be claimed again.
+func (l *Loyalty) ProcessUnhandledOrders(ctx context.Context) (*PassSummary, error) {
+	deadline := time.Now().Add(orderLeaseTimeout)
+
+	orders, err := l.storage.GetUnhandledOrders(ctx, l.instanceID, orderLeaseTimeout, l.claimLimit)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	workers := l.workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan *Order)
+	results := make(chan error)
+
+	go func() {
+		defer close(jobs)
+		for _, order := range orders {
+			if time.Now().After(deadline) {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- order:
+			}
+		}
+	}()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for order := range jobs {
+				err := l.processOrder(ctx, order)
+				if err != nil && !errors.Is(err, accrual.ErrOrderNotFound) && !errors.Is(err, accrual.ErrTooManyRequests) && !errors.Is(err, errOrderExpired) {
+					logger.Log.Error(
+						"error on processing order",
+						zap.String("orderID", order.ID),
+						zap.Error(err),
+					)
+				}
+				results <- err
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	summary := &PassSummary{}
+	for err := range results {
+		switch {
+		case err == nil:
+			summary.Processed++
+		case errors.Is(err, accrual.ErrOrderNotFound), errors.Is(err, accrual.ErrTooManyRequests):
+			summary.Skipped++
+		case errors.Is(err, errOrderExpired):
+			summary.Expired++
+		default:
+			summary.Failed++
+		}
 	}
+	summary.Skipped += len(orders) - summary.Processed - summary.Failed - summary.Skipped - summary.Expired
 
-	for _, order := range orders {
-		err := l.UpdateOrderStatus(ctx, order)
-		if err != nil {
+	return summary, nil
+}
+
+// processOrder checks leased order in accrual. Orders older than orderMaxAge are expired,
+// failed checks are rescheduled with exponential backoff.
+func (l *Loyalty) processOrder(ctx context.Context, order *Order) error {
+	if age := time.Since(order.Uploaded); age > orderMaxAge {
+		reason := fmt.Sprintf("order wasn`t processed by accrual in %s", orderMaxAge)
+		if err := l.storage.ExpireOrder(ctx, l.instanceID, order.ID, reason); err != nil {
+			l.releaseOrder(ctx, order.ID)
 			return err
 		}
+
+		logger.Log.Info(
+			"order expired",
+			zap.String("orderID", order.ID),
+			zap.Int("attempts", order.Attempts),
+			zap.String("lastError", order.LastError),
+		)
+		return errOrderExpired
 	}
 
-	return nil
+	err := l.UpdateOrderStatus(ctx, order)
+	if err == nil || errors.Is(err, ErrOrderLeaseLost) {
+		return err
+	}
 
+	// Throttling by accrual isn`t failure of the order, it is checked again on the next pass without backoff
+	if ctx.Err() != nil || errors.Is(err, accrual.ErrTooManyRequests) {
+		l.releaseOrder(ctx, order.ID)
+		return err
+	}
+
+	if rescheduleErr := l.storage.RescheduleOrder(ctx, l.instanceID, order.ID, err.Error(), checkBackoff(order.Attempts)); rescheduleErr != nil {
+		logger.Log.Error(
+			"error on rescheduling order",
+			zap.String("orderID", order.ID),
+			zap.Error(rescheduleErr),
+		)
+		l.releaseOrder(ctx, order.ID)
+	}
+
+	return err
+}
+
+// checkBackoff returns delay before the next check of order already checked attempts times
+func checkBackoff(attempts int) time.Duration {
+	backoff := orderCheckBackoff
+	for i := 0; i < attempts && backoff < orderCheckMaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, orderCheckMaxBackoff)
+}
+
+// releaseOrder gives up the lease of not updated order so it is picked up on the next pass
+func (l *Loyalty) releaseOrder(ctx context.Context, orderID string) {
+	if err := l.storage.ReleaseOrder(context.WithoutCancel(ctx), l.instanceID, orderID); err != nil {
+		logger.Log.Error(
+			"error on releasing order lease",
+			zap.String("orderID", orderID),
+			zap.Error(err),
+		)
+	}
 }
 
-func (l *Loyalty) UpdateOrderStatus(ctx context.Context, orderID string) error {
-	orderInfo, err := l.accrual.GetOrder(ctx, orderID)
+// UpdateOrderStatus checks order in accrual and saves its new status and accrual.
+// Unknown accrual statuses and backward status transitions are rejected.
+func (l *Loyalty) UpdateOrderStatus(ctx context.Context, order *Order) error {
+	orderInfo, err := l.accrual.GetOrder(ctx, order.ID)
 	if err != nil {
 		return err
 	}
 
+	status, err := StatusFromAccrual(orderInfo.Status)
+	if err != nil {
+		logger.Log.Warn(
+			"accrual returned unknown order status",
+			zap.String("orderID", order.ID),
+			zap.String("accrualStatus", orderInfo.Status),
+		)
+		return err
+	}
+
+	if err := CheckTransition(order.Status, status); err != nil {
+		logger.Log.Warn(
+			"rejected order status transition",
+			zap.String("orderID", order.ID),
+			zap.String("status", order.Status),
+			zap.String("newStatus", status),
+		)
+		return err
+	}
+
 	logger.Log.Info(
 		"updaing order",
-		zap.String("orderID", orderID),
-		zap.String("newStatus", orderInfo.Status),
-		zap.Float64("accrual", orderInfo.Accrual),
+		zap.String("orderID", order.ID),
+		zap.String("newStatus", status),
+		zap.Stringer("accrual", orderInfo.Accrual),
 	)
-	err = l.storage.UpdateOrder(ctx, orderInfo)
-	return err
+
+	updated := &Order{
+		ID:      order.ID,
+		UserID:  order.UserID,
+		Status:  status,
+		Accrual: orderInfo.Accrual,
+	}
+
+	return l.storage.UpdateOrder(ctx, l.instanceID, updated, checkBackoff(order.Attempts))
 }
@@ -0,0 +1,227 @@
+package loyalty
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"github.com/renatus-cartesius/gophermart/pkg/money"
+	"go.uber.org/zap"
+)
+
+const (
+	// eventsHistory is count of the newest events kept for replay to reconnecting subscribers
+	eventsHistory = 1024
+	// subscriberBuffer is count of events queued for one subscriber, slow subscriber is disconnected
+	// when its queue is full and has to reconnect with the last received event ID
+	subscriberBuffer = 64
+	// eventsFeedInterval is period of polling storage for new order status events
+	eventsFeedInterval = time.Second
+	// eventsFeedLag is age of events left for the next poll, events are recorded in transactions
+	// which can commit out of order of event IDs and the younger ones may still be invisible
+	eventsFeedLag = 2 * time.Second
+	// eventsFeedBatch is count of events read from storage at once
+	eventsFeedBatch = 500
+)
+
+// OrderStatusEvent notifies user about change of order status or accrual
+type OrderStatusEvent struct {
+	ID      uint64       `json:"-"`
+	UserID  string       `json:"-"`
+	OrderID string       `json:"number"`
+	Status  string       `json:"status"`
+	Accrual money.Amount `json:"accrual,omitempty"`
+	Reason  string       `json:"reason,omitempty"`
+	Created time.Time    `json:"created_at"`
+}
+
+type subscription struct {
+	userID string
+	events chan *OrderStatusEvent
+}
+
+// EventHub is in-process pub/sub of order status events fed from the order history in storage,
+// so subscribers of every instance get events of orders processed by any of them under the same IDs.
+// The newest events are kept for replay after reconnect.
+type EventHub struct {
+	mu          sync.Mutex
+	lastID      uint64
+	history     []*OrderStatusEvent
+	subscribers map[string]map[*subscription]struct{}
+	closed      bool
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{
+		history:     make([]*OrderStatusEvent, 0, eventsHistory),
+		subscribers: make(map[string]map[*subscription]struct{}),
+	}
+}
+
+// Publish sends event to subscribers of the event user. Events must be published in order of IDs,
+// event without ID gets the next one and event with already published ID is dropped.
+func (h *EventHub) Publish(event *OrderStatusEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+
+	if event.ID == 0 {
+		event.ID = h.lastID + 1
+	}
+	if event.ID <= h.lastID {
+		return
+	}
+	h.lastID = event.ID
+	if event.Created.IsZero() {
+		event.Created = time.Now().UTC()
+	}
+
+	if len(h.history) == eventsHistory {
+		copy(h.history, h.history[1:])
+		h.history = h.history[:eventsHistory-1]
+	}
+	h.history = append(h.history, event)
+
+	for sub := range h.subscribers[event.UserID] {
+		select {
+		case sub.events <- event:
+		default:
+			h.unsubscribe(sub)
+		}
+	}
+}
+
+// Subscribe returns kept events of user published after lastEventID and channel of the new events.
+// Channel is closed when subscriber falls behind or hub is closed, cancel must be called when
+// subscriber stops reading.
+func (h *EventHub) Subscribe(userID string, lastEventID uint64) ([]*OrderStatusEvent, <-chan *OrderStatusEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	replay := make([]*OrderStatusEvent, 0)
+	if lastEventID > 0 {
+		for _, event := range h.history {
+			if event.UserID == userID && event.ID > lastEventID {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	sub := &subscription{
+		userID: userID,
+		events: make(chan *OrderStatusEvent, subscriberBuffer),
+	}
+
+	if h.closed {
+		close(sub.events)
+		return replay, sub.events, func() {}
+	}
+
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[*subscription]struct{})
+	}
+	h.subscribers[userID][sub] = struct{}{}
+
+	cancel := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.unsubscribe(sub)
+	}
+
+	return replay, sub.events, cancel
+}
+
+// unsubscribe removes subscription and closes its channel, h.mu must be held
+func (h *EventHub) unsubscribe(sub *subscription) {
+	subs, ok := h.subscribers[sub.userID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.subscribers, sub.userID)
+	}
+	close(sub.events)
+}
+
+// Close disconnects all subscribers, events published after Close are dropped
+func (h *EventHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, subs := range h.subscribers {
+		for sub := range subs {
+			h.unsubscribe(sub)
+		}
+	}
+}
+
+// FeedEvents publishes order status changes recorded in storage to the event hub until ctx is done.
+// Feed starts after the newest event recorded before the start.
+func (l *Loyalty) FeedEvents(ctx context.Context) {
+	feedTicker := time.NewTicker(eventsFeedInterval)
+	defer feedTicker.Stop()
+
+	var (
+		cursor  uint64
+		started bool
+		err     error
+	)
+
+	for {
+		if !started {
+			cursor, err = l.storage.GetLastOrderEventID(ctx)
+			started = err == nil
+		} else {
+			cursor, err = l.feedEvents(ctx, cursor)
+		}
+		if err != nil && ctx.Err() == nil {
+			logger.Log.Error(
+				"error on feeding order status events",
+				zap.Error(err),
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Log.Info(
+				"closing order status events feed",
+			)
+			return
+		case <-feedTicker.C:
+		}
+	}
+}
+
+// feedEvents publishes events recorded after cursor and older than eventsFeedLag,
+// returns ID of the last published event
+func (l *Loyalty) feedEvents(ctx context.Context, cursor uint64) (uint64, error) {
+	for {
+		events, err := l.storage.GetOrderStatusEvents(ctx, cursor, eventsFeedBatch)
+		if err != nil {
+			return cursor, err
+		}
+
+		settled := time.Now().Add(-eventsFeedLag)
+		for _, event := range events {
+			if !event.Created.Before(settled) {
+				return cursor, nil
+			}
+			l.events.Publish(event)
+			cursor = event.ID
+		}
+
+		if len(events) < eventsFeedBatch {
+			return cursor, nil
+		}
+	}
+}
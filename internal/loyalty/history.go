@@ -0,0 +1,80 @@
+package loyalty
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/pkg/money"
+)
+
+// OrderEvent is a record of order processing history: upload, status change, failed check or expiration
+type OrderEvent struct {
+	Status  string       `json:"status"`
+	Accrual money.Amount `json:"accrual,omitempty"`
+	// Error is error of failed accrual check, only changes of the error are recorded
+	Error string `json:"error,omitempty"`
+	// Reason explains why order was moved to terminal status by gophermart itself
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created_at"`
+}
+
+// OrderDetails is order with its processing history
+type OrderDetails struct {
+	Order   *Order
+	History []*OrderEvent
+}
+
+func (d *OrderDetails) MarshalJSON() ([]byte, error) {
+	details := struct {
+		ID            string        `json:"number"`
+		Status        string        `json:"status"`
+		Accrual       *money.Amount `json:"accrual,omitempty"`
+		Uploaded      time.Time     `json:"uploaded"`
+		Reason        string        `json:"reason,omitempty"`
+		LastCheckedAt *time.Time    `json:"last_checked_at,omitempty"`
+		History       []*OrderEvent `json:"history"`
+	}{
+		ID:       d.Order.ID,
+		Status:   d.Order.Status,
+		Uploaded: d.Order.Uploaded,
+		Reason:   d.Order.Reason,
+		History:  d.History,
+	}
+
+	// Accrual is shown only for processed orders like in orders list
+	if d.Order.Status == TypeStatusProcessed {
+		details.Accrual = &d.Order.Accrual
+	}
+	if !d.Order.LastCheckedAt.IsZero() {
+		details.LastCheckedAt = &d.Order.LastCheckedAt
+	}
+	if details.History == nil {
+		details.History = make([]*OrderEvent, 0)
+	}
+
+	return json.Marshal(details)
+}
+
+// GetUserOrder returns order uploaded by user with its processing history,
+// orders of other users aren`t found
+func (l *Loyalty) GetUserOrder(ctx context.Context, userID string, orderID string) (*OrderDetails, error) {
+	order, err := l.storage.GetOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if order.UserID != userID {
+		return nil, ErrOrderNotFound
+	}
+
+	history, err := l.storage.GetOrderHistory(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrderDetails{
+		Order:   order,
+		History: history,
+	}, nil
+}
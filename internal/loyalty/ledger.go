@@ -0,0 +1,97 @@
+package loyalty
+
+import (
+	"errors"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/pkg/money"
+)
+
+const (
+	TypeEntryAccrual    = "ACCRUAL"
+	TypeEntryWithdrawal = "WITHDRAWAL"
+	TypeEntryReversal   = "REVERSAL"
+	TypeEntryAdjustment = "ADJUSTMENT"
+)
+
+var (
+	ErrLedgerNegativeBalance = errors.New("ledger entry makes balance negative")
+	ErrLedgerInvalidEntry    = errors.New("ledger entry is invalid")
+)
+
+const (
+	// AccountAccrual is system account points accrued to users are taken from
+	AccountAccrual = "system:accrual"
+	// AccountAdjustment is system account balancing manual adjustments and reversals
+	AccountAdjustment = "system:adjustment"
+)
+
+// UserAccount returns account of points available to user
+func UserAccount(userID string) string {
+	return "user:" + userID
+}
+
+// WithdrawnAccount returns account of points withdrawn by user
+func WithdrawnAccount(userID string) string {
+	return "withdrawn:" + userID
+}
+
+// Posting is one leg of ledger transaction, legs of one transaction sum to zero.
+// Balance is running balance of account after the posting, it is tracked only for
+// accounts of users, so system accounts don`t serialize ledgers of different users.
+type Posting struct {
+	Account string
+	Amount  money.Amount
+	Balance money.Amount
+	Tracked bool
+}
+
+// LedgerEntry is immutable ledger transaction as seen by user.
+// Amount is leg of the user account, Balance and Withdrawn are running totals of user ledger after the entry is applied.
+type LedgerEntry struct {
+	ID     int64  `json:"id"`
+	UserID string `json:"-"`
+	Type   string `json:"type"`
+	// Reference is order number for accruals and withdrawals and free-form reason for other entries
+	Reference string `json:"reference"`
+	// Amount is signed change of balance
+	Amount    money.Amount `json:"amount"`
+	Balance   money.Amount `json:"balance"`
+	Withdrawn money.Amount `json:"-"`
+	Created   time.Time    `json:"created_at"`
+}
+
+// withdrawnDelta returns change of withdrawn total made by entry of entryType, only withdrawals count
+func withdrawnDelta(entryType string, amount money.Amount) money.Amount {
+	if entryType == TypeEntryWithdrawal {
+		return -amount
+	}
+	return 0
+}
+
+// NextLedgerEntry fills running totals of entry appended after last entry of user ledger, last is nil for empty ledger
+func NextLedgerEntry(last *LedgerEntry, entry *LedgerEntry) {
+	entry.Balance = entry.Amount
+	entry.Withdrawn = withdrawnDelta(entry.Type, entry.Amount)
+
+	if last != nil {
+		entry.Balance += last.Balance
+		entry.Withdrawn += last.Withdrawn
+	}
+}
+
+// Postings returns balanced legs of transaction recording entry with filled running totals.
+// Withdrawals move points from user account to withdrawn account of the same user, accruals
+// take points from AccountAccrual and other entries are balanced by AccountAdjustment.
+func Postings(entry *LedgerEntry) []Posting {
+	userLeg := Posting{Account: UserAccount(entry.UserID), Amount: entry.Amount, Balance: entry.Balance, Tracked: true}
+
+	switch entry.Type {
+	case TypeEntryWithdrawal:
+		return []Posting{userLeg, {Account: WithdrawnAccount(entry.UserID), Amount: -entry.Amount, Balance: entry.Withdrawn, Tracked: true}}
+	case TypeEntryAccrual:
+		return []Posting{userLeg, {Account: AccountAccrual, Amount: -entry.Amount}}
+	default:
+		return []Posting{userLeg, {Account: AccountAdjustment, Amount: -entry.Amount}}
+	}
+}
@@ -2,11 +2,18 @@ package loyalty
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/renatus-cartesius/gophermart/internal/accrual"
 	"github.com/renatus-cartesius/gophermart/pkg/luhn"
+	"github.com/renatus-cartesius/gophermart/pkg/money"
 )
 
 const (
@@ -20,7 +27,22 @@ var (
 	ErrOrderUploadedAnotherUser = errors.New("the order has already been uploaded by another user")
 	ErrOrderInvalid             = errors.New("order is invalid")
 	ErrOrderAlreadyUploaded     = errors.New("order is already uploaded")
+	ErrOrderUsedByWithdrawal    = errors.New("order number is already used by withdrawal")
 	ErrOrderNotFound            = errors.New("order not found in storage")
+	ErrOrderLeaseLost           = errors.New("order is not leased by this instance")
+)
+
+const (
+	// orderLeaseTimeout is how long claimed order is owned by instance before another one may reclaim it
+	orderLeaseTimeout = time.Minute
+	// unhandledOrdersBatch is max count of orders claimed by one pass
+	unhandledOrdersBatch = 1000
+	// orderCheckBackoff is delay before the first recheck of order, doubled with every attempt
+	orderCheckBackoff = 10 * time.Second
+	// orderCheckMaxBackoff limits delay between checks of one order
+	orderCheckMaxBackoff = 30 * time.Minute
+	// orderMaxAge is time after upload when not processed order is moved to INVALID status
+	orderMaxAge = 7 * 24 * time.Hour
 )
 
 // type Loyaltier interface {
@@ -28,41 +50,100 @@ var (
 // }
 
 type Balance struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	Current   money.Amount `json:"current"`
+	Withdrawn money.Amount `json:"withdrawn"`
 }
 
 type LoyaltyStorager interface {
 	AddOrder(ctx context.Context, userID string, orderID string) error
-	GetOrders(ctx context.Context, userID string) ([]*Order, error)
-	GetWithdrawals(ctx context.Context, userID string) ([]*Withdraw, error)
+	// AddOrders adds distinct orders of user at once and returns error of every order in order of orderIDs,
+	// ErrOrderAlreadyUploaded, ErrOrderUploadedAnotherUser and ErrOrderUsedByWithdrawal are reported per order
+	AddOrders(ctx context.Context, userID string, orderIDs []string) ([]error, error)
+	GetOrders(ctx context.Context, userID string, filter ListFilter) ([]*Order, *Cursor, error)
+	GetWithdrawals(ctx context.Context, userID string, filter ListFilter) ([]*Withdraw, *Cursor, error)
 	GetOrder(ctx context.Context, orderID string) (*Order, error)
+	GetOrderHistory(ctx context.Context, orderID string) ([]*OrderEvent, error)
+	// GetOrderStatusEvents returns up to limit changes of order status recorded after event afterID
+	// in order of IDs, uploads and failed checks of orders aren`t included
+	GetOrderStatusEvents(ctx context.Context, afterID uint64, limit int) ([]*OrderStatusEvent, error)
+	// GetLastOrderEventID returns ID of the newest recorded order event, 0 if there are none
+	GetLastOrderEventID(ctx context.Context) (uint64, error)
 	GetBalance(ctx context.Context, userID string) (*Balance, error)
 	AddWithdraw(ctx context.Context, wr *Withdraw) error
-	GetUnhandledOrders(ctx context.Context) ([]string, error)
-	UpdateOrder(ctx context.Context, orderInfo *accrual.OrderInfo) error
+	GetUnhandledOrders(ctx context.Context, owner string, lease time.Duration, limit int) ([]*Order, error)
+	UpdateOrder(ctx context.Context, owner string, order *Order, nextCheck time.Duration) error
+	RescheduleOrder(ctx context.Context, owner string, orderID string, lastError string, nextCheck time.Duration) error
+	ExpireOrder(ctx context.Context, owner string, orderID string, reason string) error
+	ReleaseOrder(ctx context.Context, owner string, orderID string) error
+	GetLedger(ctx context.Context, userID string) ([]*LedgerEntry, error)
+	AddLedgerEntry(ctx context.Context, entry *LedgerEntry) error
 }
 
 type Loyalty struct {
-	accrual accrual.Accrualler
-	storage LoyaltyStorager
+	accrual    accrual.Accrualler
+	storage    LoyaltyStorager
+	workers    int
+	claimLimit int
+	instanceID string
+	events     *EventHub
 }
 
-func NewLoyalty(accrual accrual.Accrualler, storage LoyaltyStorager) *Loyalty {
+// NewLoyalty creates Loyalty which processes unhandled orders with pool of workers goroutines.
+// accrualRPM is requests per minute limit of accrual, one pass claims only orders which may be
+// checked within the lease under this limit. Non-positive accrualRPM means unlimited accrual.
+func NewLoyalty(accrual accrual.Accrualler, storage LoyaltyStorager, workers int, accrualRPM int) *Loyalty {
 	return &Loyalty{
-		accrual: accrual,
-		storage: storage,
+		accrual:    accrual,
+		storage:    storage,
+		workers:    workers,
+		claimLimit: claimLimit(accrualRPM),
+		instanceID: newInstanceID(),
+		events:     NewEventHub(),
 	}
 }
 
-func (l *Loyalty) UploadOrder(ctx context.Context, userID string, orderID string) error {
-	// Validating order num with Luhn algorithm (409)
-	number, err := strconv.ParseInt(orderID, 10, 64)
+// claimLimit returns count of orders checked within orderLeaseTimeout at rpm accrual requests per minute
+func claimLimit(rpm int) int {
+	if rpm <= 0 {
+		return unhandledOrdersBatch
+	}
+
+	return max(1, min(unhandledOrdersBatch, int(float64(rpm)*orderLeaseTimeout.Minutes())))
+}
+
+// Events returns hub of order status events published by FeedEvents
+func (l *Loyalty) Events() *EventHub {
+	return l.events
+}
+
+// newInstanceID returns identifier of running instance used as owner of leased orders
+func newInstanceID() string {
+	hostname, err := os.Hostname()
 	if err != nil {
-		return err
+		hostname = "gophermart"
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return hostname
+	}
+
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+// validOrderNumber reports whether orderID consists of digits only and passes Luhn check
+func validOrderNumber(orderID string) bool {
+	if orderID == "" || strings.Trim(orderID, "0123456789") != "" {
+		return false
 	}
 
-	if !luhn.Valid(number) {
+	number, err := strconv.ParseInt(orderID, 10, 64)
+	return err == nil && luhn.Valid(number)
+}
+
+func (l *Loyalty) UploadOrder(ctx context.Context, userID string, orderID string) error {
+	// Validating order num with Luhn algorithm (422)
+	if !validOrderNumber(orderID) {
 		return ErrOrderInvalid
 	}
 
@@ -70,12 +151,23 @@ func (l *Loyalty) UploadOrder(ctx context.Context, userID string, orderID string
 
 }
 
-func (l *Loyalty) GetOrders(ctx context.Context, userID string) ([]*Order, error) {
-	return l.storage.GetOrders(ctx, userID)
+// GetOrders returns page of user orders selected by filter and cursor of the next page
+func (l *Loyalty) GetOrders(ctx context.Context, userID string, filter ListFilter) ([]*Order, *Cursor, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return l.storage.GetOrders(ctx, userID, filter)
 }
 
-func (l *Loyalty) GetWithdrawals(ctx context.Context, userID string) ([]*Withdraw, error) {
-	return l.storage.GetWithdrawals(ctx, userID)
+// GetWithdrawals returns page of user withdrawals selected by filter and cursor of the next page
+func (l *Loyalty) GetWithdrawals(ctx context.Context, userID string, filter ListFilter) ([]*Withdraw, *Cursor, error) {
+	if filter.Status != "" {
+		return nil, nil, fmt.Errorf("%w: withdrawals have no status", ErrInvalidFilter)
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return l.storage.GetWithdrawals(ctx, userID, filter)
 }
 
 func (l *Loyalty) GetOrder(ctx context.Context, orderID string) (*Order, error) {
@@ -86,6 +178,11 @@ func (l *Loyalty) GetBalance(ctx context.Context, userID string) (*Balance, erro
 	return l.storage.GetBalance(ctx, userID)
 }
 
+// GetBalanceHistory returns user ledger entries from the oldest to the newest
+func (l *Loyalty) GetBalanceHistory(ctx context.Context, userID string) ([]*LedgerEntry, error) {
+	return l.storage.GetLedger(ctx, userID)
+}
+
 // func (l *Loyalty) AddWithdraw(ctx context.Context, wr *WithdrawRequest) error {
 // 	return l.storage.AddWithdraw(ctx, wr)
 // }
@@ -101,14 +198,12 @@ func (l *Loyalty) Withdraw(ctx context.Context, wr *Withdraw) error {
 	// 	return accrual.ErrOrderNotProcessed
 	// }
 
-	// Check if orderID is Luhn-valid
-
-	number, err := strconv.ParseInt(wr.OrderID, 10, 64)
-	if err != nil {
-		return err
+	if wr.Sum <= 0 {
+		return ErrWithdrawInvalidSum
 	}
 
-	if !luhn.Valid(number) {
+	// Check if orderID is Luhn-valid
+	if !validOrderNumber(wr.OrderID) {
 		return ErrOrderInvalid
 	}
 
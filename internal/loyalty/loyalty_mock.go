@@ -2,6 +2,7 @@ package loyalty
 
 import (
 	"context"
+	"sort"
 	"time"
 
 	"github.com/renatus-cartesius/gophermart/internal/accrual"
@@ -9,9 +10,15 @@ import (
 
 type MockAccrualler struct {
 	Orders map[string]*accrual.OrderInfo
+	// Errors are returned for orders instead of their info
+	Errors map[string]error
 }
 
 func (ma MockAccrualler) GetOrder(ctx context.Context, orderID string) (*accrual.OrderInfo, error) {
+	if err, ok := ma.Errors[orderID]; ok {
+		return nil, err
+	}
+
 	order, ok := ma.Orders[orderID]
 	if !ok {
 		return nil, accrual.ErrOrderNotFound
@@ -22,6 +29,40 @@ func (ma MockAccrualler) GetOrder(ctx context.Context, orderID string) (*accrual
 type MockLoyaltyStorager struct {
 	Records     map[string]*Order
 	Withdrawals map[string]*Withdraw
+	Ledger      map[string][]*LedgerEntry
+	// History is recorded only if it isn`t nil
+	History map[string][]*OrderEvent
+	// StatusEvents are returned by GetOrderStatusEvents, they aren`t recorded on order changes
+	StatusEvents []*OrderStatusEvent
+}
+
+func (mls MockLoyaltyStorager) addEvent(orderID string, event *OrderEvent) {
+	if mls.History == nil {
+		return
+	}
+	event.Created = time.Now()
+	mls.History[orderID] = append(mls.History[orderID], event)
+}
+
+func (mls MockLoyaltyStorager) GetOrderHistory(ctx context.Context, orderID string) ([]*OrderEvent, error) {
+	return append(make([]*OrderEvent, 0), mls.History[orderID]...), nil
+}
+
+func (mls MockLoyaltyStorager) GetOrderStatusEvents(ctx context.Context, afterID uint64, limit int) ([]*OrderStatusEvent, error) {
+	events := make([]*OrderStatusEvent, 0)
+	for _, event := range mls.StatusEvents {
+		if event.ID > afterID && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (mls MockLoyaltyStorager) GetLastOrderEventID(ctx context.Context) (uint64, error) {
+	if len(mls.StatusEvents) == 0 {
+		return 0, nil
+	}
+	return mls.StatusEvents[len(mls.StatusEvents)-1].ID, nil
 }
 
 func (mls MockLoyaltyStorager) AddOrder(ctx context.Context, userID string, orderID string) error {
@@ -32,6 +73,9 @@ func (mls MockLoyaltyStorager) AddOrder(ctx context.Context, userID string, orde
 		}
 		return nil
 	}
+	if _, ok := mls.Withdrawals[orderID]; ok {
+		return ErrOrderUsedByWithdrawal
+	}
 
 	mls.Records[orderID] = &Order{
 		UserID:   userID,
@@ -40,9 +84,26 @@ func (mls MockLoyaltyStorager) AddOrder(ctx context.Context, userID string, orde
 		Accrual:  0,
 		Uploaded: time.Now(),
 	}
+	mls.addEvent(orderID, &OrderEvent{Status: TypeStatusNew})
 	return nil
 }
 
+func (mls MockLoyaltyStorager) AddOrders(ctx context.Context, userID string, orderIDs []string) ([]error, error) {
+	errs := make([]error, len(orderIDs))
+	for i, orderID := range orderIDs {
+		if orderRecord, ok := mls.Records[orderID]; ok {
+			if orderRecord.UserID != userID {
+				errs[i] = ErrOrderUploadedAnotherUser
+			} else {
+				errs[i] = ErrOrderAlreadyUploaded
+			}
+			continue
+		}
+		errs[i] = mls.AddOrder(ctx, userID, orderID)
+	}
+	return errs, nil
+}
+
 func (mls MockLoyaltyStorager) GetOrder(ctx context.Context, orderID string) (*Order, error) {
 	orderRecord, ok := mls.Records[orderID]
 	if !ok {
@@ -51,57 +112,183 @@ func (mls MockLoyaltyStorager) GetOrder(ctx context.Context, orderID string) (*O
 	return orderRecord, nil
 }
 
-func (mls MockLoyaltyStorager) GetOrders(ctx context.Context, userID string) ([]*Order, error) {
+func (mls MockLoyaltyStorager) GetOrders(ctx context.Context, userID string, filter ListFilter) ([]*Order, *Cursor, error) {
 	res := make([]*Order, 0)
 
 	for _, record := range mls.Records {
-		if record.UserID == userID {
+		if record.UserID == userID && filter.Match(record.Status, record.Uploaded, record.ID) {
 			res = append(res, record)
 		}
 	}
 
-	return res, nil
+	// Storages return orders from the oldest to the newest
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Uploaded.Equal(res[j].Uploaded) {
+			return res[i].ID < res[j].ID
+		}
+		return res[i].Uploaded.Before(res[j].Uploaded)
+	})
+
+	res, next := Paginate(res, filter.Limit, OrderCursor)
+	return res, next, nil
 }
 
-func (mls MockLoyaltyStorager) GetWithdrawals(ctx context.Context, userID string) ([]*Withdraw, error) {
+func (mls MockLoyaltyStorager) GetWithdrawals(ctx context.Context, userID string, filter ListFilter) ([]*Withdraw, *Cursor, error) {
 	res := make([]*Withdraw, 0)
 
 	for _, withdraw := range mls.Withdrawals {
-		if withdraw.UserID == userID {
+		if withdraw.UserID == userID && filter.Match("", withdraw.Created, withdraw.OrderID) {
 			res = append(res, withdraw)
 		}
 	}
 
-	return res, nil
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Created.Equal(res[j].Created) {
+			return res[i].OrderID < res[j].OrderID
+		}
+		return res[i].Created.Before(res[j].Created)
+	})
+
+	res, next := Paginate(res, filter.Limit, WithdrawCursor)
+	return res, next, nil
 }
 
 func (mls MockLoyaltyStorager) AddWithdraw(ctx context.Context, wr *Withdraw) error {
+	if _, ok := mls.Withdrawals[wr.OrderID]; ok {
+		return ErrWithdrawOrderDuplicate
+	}
+	if _, ok := mls.Records[wr.OrderID]; ok {
+		return ErrWithdrawOrderDuplicate
+	}
+
+	balance, _ := mls.GetBalance(ctx, wr.UserID)
+	if wr.Sum > balance.Current {
+		return ErrWithdrawNotEnoughPoints
+	}
+
 	mls.Withdrawals[wr.OrderID] = wr
+	mls.appendLedgerEntry(&LedgerEntry{
+		UserID:    wr.UserID,
+		Type:      TypeEntryWithdrawal,
+		Reference: wr.OrderID,
+		Amount:    -wr.Sum,
+	})
 	return nil
 }
 
 func (mls MockLoyaltyStorager) GetBalance(ctx context.Context, userID string) (*Balance, error) {
 	balance := &Balance{}
 
-	for _, v := range mls.Withdrawals {
-		if v.UserID == userID {
-			balance.Withdrawn += v.Sum
-		}
+	if entries := mls.Ledger[userID]; len(entries) > 0 {
+		balance.Current = entries[len(entries)-1].Balance
+		balance.Withdrawn = entries[len(entries)-1].Withdrawn
+	}
+
+	return balance, nil
+}
+
+func (mls MockLoyaltyStorager) appendLedgerEntry(entry *LedgerEntry) {
+	entries := mls.Ledger[entry.UserID]
+
+	var last *LedgerEntry
+	if len(entries) > 0 {
+		last = entries[len(entries)-1]
 	}
 
-	for _, v := range mls.Records {
-		if v.UserID == userID {
-			balance.Current += v.Accrual
+	NextLedgerEntry(last, entry)
+	entry.ID = int64(len(entries) + 1)
+	entry.Created = time.Now()
+
+	mls.Ledger[entry.UserID] = append(entries, entry)
+}
+
+func (mls MockLoyaltyStorager) GetLedger(ctx context.Context, userID string) ([]*LedgerEntry, error) {
+	return append(make([]*LedgerEntry, 0), mls.Ledger[userID]...), nil
+}
+
+func (mls MockLoyaltyStorager) AddLedgerEntry(ctx context.Context, entry *LedgerEntry) error {
+	balance, _ := mls.GetBalance(ctx, entry.UserID)
+	if balance.Current+entry.Amount < 0 {
+		return ErrLedgerNegativeBalance
+	}
+
+	mls.appendLedgerEntry(entry)
+	return nil
+}
+
+func (mls MockLoyaltyStorager) GetUnhandledOrders(ctx context.Context, owner string, lease time.Duration, limit int) ([]*Order, error) {
+	res := make([]*Order, 0)
+
+	now := time.Now()
+	for _, record := range mls.Records {
+		if len(res) == limit {
+			break
+		}
+		if record.Status != TypeStatusProcessed && record.Status != TypeStatusInvalid && !record.NextCheckAt.After(now) {
+			res = append(res, record)
 		}
 	}
 
-	balance.Current = balance.Current - balance.Withdrawn
-	return balance, nil
+	return res, nil
 }
 
-func (mls MockLoyaltyStorager) GetUnhandledOrders(ctx context.Context) ([]string, error) {
-	return nil, nil
+func (mls MockLoyaltyStorager) UpdateOrder(ctx context.Context, owner string, order *Order, nextCheck time.Duration) error {
+	orderRecord, ok := mls.Records[order.ID]
+	if !ok {
+		return ErrOrderNotFound
+	}
+
+	if orderRecord.Status != order.Status || orderRecord.Accrual != order.Accrual {
+		mls.addEvent(order.ID, &OrderEvent{Status: order.Status, Accrual: order.Accrual})
+	}
+
+	orderRecord.Status = order.Status
+	orderRecord.Accrual = order.Accrual
+	orderRecord.Attempts++
+	orderRecord.LastCheckedAt = time.Now()
+
+	if order.Status == TypeStatusProcessed && order.Accrual > 0 {
+		mls.appendLedgerEntry(&LedgerEntry{
+			UserID:    orderRecord.UserID,
+			Type:      TypeEntryAccrual,
+			Reference: order.ID,
+			Amount:    order.Accrual,
+		})
+	}
+	orderRecord.LastError = ""
+	orderRecord.NextCheckAt = time.Now().Add(nextCheck)
+	return nil
 }
-func (mls MockLoyaltyStorager) UpdateOrder(ctx context.Context, orderInfo *accrual.OrderInfo) error {
+
+func (mls MockLoyaltyStorager) RescheduleOrder(ctx context.Context, owner string, orderID string, lastError string, nextCheck time.Duration) error {
+	orderRecord, ok := mls.Records[orderID]
+	if !ok {
+		return ErrOrderNotFound
+	}
+
+	if orderRecord.LastError != lastError {
+		mls.addEvent(orderID, &OrderEvent{Status: orderRecord.Status, Error: lastError})
+	}
+
+	orderRecord.Attempts++
+	orderRecord.LastError = lastError
+	orderRecord.LastCheckedAt = time.Now()
+	orderRecord.NextCheckAt = time.Now().Add(nextCheck)
+	return nil
+}
+
+func (mls MockLoyaltyStorager) ExpireOrder(ctx context.Context, owner string, orderID string, reason string) error {
+	orderRecord, ok := mls.Records[orderID]
+	if !ok {
+		return ErrOrderNotFound
+	}
+
+	orderRecord.Status = TypeStatusInvalid
+	orderRecord.Reason = reason
+	mls.addEvent(orderID, &OrderEvent{Status: TypeStatusInvalid, Reason: reason})
+	return nil
+}
+
+func (mls MockLoyaltyStorager) ReleaseOrder(ctx context.Context, owner string, orderID string) error {
 	return nil
 }
@@ -3,10 +3,12 @@ package loyalty
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/renatus-cartesius/gophermart/internal/accrual"
+	"github.com/renatus-cartesius/gophermart/pkg/money"
 )
 
 func TestLoyalty_UploadOrder(t *testing.T) {
@@ -17,12 +19,12 @@ func TestLoyalty_UploadOrder(t *testing.T) {
 		Orders: map[string]*accrual.OrderInfo{
 			"79927398713": {
 				Order:   "79927398713",
-				Accrual: 400,
+				Accrual: money.MustParse("400"),
 				Status:  accrual.TypeStatusProcessed,
 			},
 			"4929972884676289": {
 				Order:   "4929972884676289",
-				Accrual: 999999,
+				Accrual: money.MustParse("999999"),
 				Status:  accrual.TypeStatusProcessed,
 			},
 			"1984": {
@@ -42,7 +44,7 @@ func TestLoyalty_UploadOrder(t *testing.T) {
 				UserID:   "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8",
 				ID:       "4929972884676289",
 				Status:   accrual.TypeStatusProcessed,
-				Accrual:  999999,
+				Accrual:  money.MustParse("999999"),
 				Uploaded: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
 			},
 		},
@@ -108,6 +110,30 @@ func TestLoyalty_UploadOrder(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "UploadNegativeOrder",
+			fields: fields{
+				accrual: mockAccrualler,
+				storage: mockLoyaltyStorager,
+			},
+			args: args{
+				userID:  "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8",
+				orderID: "-10",
+			},
+			wantErr: true,
+		},
+		{
+			name: "UploadSignedOrder",
+			fields: fields{
+				accrual: mockAccrualler,
+				storage: mockLoyaltyStorager,
+			},
+			args: args{
+				userID:  "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8",
+				orderID: "+18",
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -133,7 +159,7 @@ func TestLoyalty_GetOrders(t *testing.T) {
 		Orders: map[string]*accrual.OrderInfo{
 			"79927398713": {
 				Order:   "79927398713",
-				Accrual: 331.3,
+				Accrual: money.MustParse("331.3"),
 				Status:  accrual.TypeStatusProcessing,
 			},
 			"3938230889": {
@@ -143,7 +169,7 @@ func TestLoyalty_GetOrders(t *testing.T) {
 			},
 			"4929972884676289": {
 				Order:   "4929972884676289",
-				Accrual: 999999,
+				Accrual: money.MustParse("999999"),
 				Status:  accrual.TypeStatusProcessed,
 			},
 		},
@@ -155,21 +181,21 @@ func TestLoyalty_GetOrders(t *testing.T) {
 				UserID:   "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8",
 				ID:       "4929972884676289",
 				Status:   accrual.TypeStatusProcessed,
-				Accrual:  999999,
+				Accrual:  money.MustParse("999999"),
 				Uploaded: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
 			},
 			"3938230889": {
 				UserID:   "5c18f4b8-bbb8-11ef-bd1a-8bd0750e0c51",
 				ID:       "3938230889",
 				Status:   accrual.TypeStatusProcessed,
-				Accrual:  331.3,
+				Accrual:  money.MustParse("331.3"),
 				Uploaded: time.Date(2012, 3, 10, 5, 4, 0, 0, time.UTC),
 			},
 			"79927398713": {
 				UserID:   "5c18f4b8-bbb8-11ef-bd1a-8bd0750e0c51",
 				ID:       "79927398713",
 				Status:   accrual.TypeStatusProcessed,
-				Accrual:  331.3,
+				Accrual:  money.MustParse("331.3"),
 				Uploaded: time.Date(2012, 3, 10, 5, 4, 0, 0, time.UTC),
 			},
 		},
@@ -217,7 +243,7 @@ func TestLoyalty_GetOrders(t *testing.T) {
 				accrual: tt.fields.accrual,
 				storage: tt.fields.storage,
 			}
-			got, err := l.GetOrders(ctx, tt.args.userID)
+			got, _, err := l.GetOrders(ctx, tt.args.userID, ListFilter{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Loyalty.GetOrders() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -236,3 +262,682 @@ func TestLoyalty_GetOrders(t *testing.T) {
 		})
 	}
 }
+
+func TestLoyalty_ProcessUnhandledOrders(t *testing.T) {
+
+	ctx := context.Background()
+
+	mockAccrualler := MockAccrualler{
+		Orders: map[string]*accrual.OrderInfo{
+			"79927398713": {
+				Order:   "79927398713",
+				Accrual: money.MustParse("400"),
+				Status:  accrual.TypeStatusProcessed,
+			},
+			"3938230889": {
+				Order:  "3938230889",
+				Status: accrual.TypeStatusInvalid,
+			},
+			"6014736448": {
+				Order:  "6014736448",
+				Status: accrual.TypeStatusRegistered,
+			},
+			"4111111111111111": {
+				Order:  "4111111111111111",
+				Status: "CANCELLED",
+			},
+		},
+		Errors: map[string]error{
+			"371449635398431": accrual.ErrTooManyRequests,
+		},
+	}
+
+	mockLoyaltyStorager := MockLoyaltyStorager{
+		Records: map[string]*Order{
+			"79927398713": {
+				UserID:   "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8",
+				ID:       "79927398713",
+				Status:   TypeStatusNew,
+				Uploaded: time.Now(),
+			},
+			"3938230889": {
+				UserID:   "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8",
+				ID:       "3938230889",
+				Status:   TypeStatusProcessing,
+				Uploaded: time.Now(),
+			},
+			"6014736448": {
+				UserID:   "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8",
+				ID:       "6014736448",
+				Status:   TypeStatusNew,
+				Uploaded: time.Now(),
+			},
+			"4111111111111111": {
+				UserID:   "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8",
+				ID:       "4111111111111111",
+				Status:   TypeStatusNew,
+				Uploaded: time.Now(),
+			},
+			"4532733309529845": {
+				UserID:   "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8",
+				ID:       "4532733309529845",
+				Status:   TypeStatusNew,
+				Uploaded: time.Now(),
+			},
+			"4929972884676289": {
+				UserID: "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8",
+				ID:     "4929972884676289",
+				Status: TypeStatusProcessed,
+			},
+			"6011000990139424": {
+				UserID:   "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8",
+				ID:       "6011000990139424",
+				Status:   TypeStatusNew,
+				Uploaded: time.Now().Add(-orderMaxAge - time.Hour),
+			},
+			"5105105105105100": {
+				UserID:      "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8",
+				ID:          "5105105105105100",
+				Status:      TypeStatusNew,
+				Uploaded:    time.Now(),
+				NextCheckAt: time.Now().Add(time.Hour),
+			},
+			"371449635398431": {
+				UserID:   "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8",
+				ID:       "371449635398431",
+				Status:   TypeStatusNew,
+				Uploaded: time.Now(),
+			},
+		},
+		Ledger: map[string][]*LedgerEntry{},
+	}
+
+	l := NewLoyalty(mockAccrualler, mockLoyaltyStorager, 2, 0)
+
+	summary, err := l.ProcessUnhandledOrders(ctx)
+	if err != nil {
+		t.Fatalf("Loyalty.ProcessUnhandledOrders() error = %v", err)
+	}
+
+	want := PassSummary{Processed: 3, Failed: 1, Skipped: 2, Expired: 1}
+	if *summary != want {
+		t.Errorf("Loyalty.ProcessUnhandledOrders() = %+v, want %+v", *summary, want)
+	}
+
+	if status := mockLoyaltyStorager.Records["79927398713"].Status; status != TypeStatusProcessed {
+		t.Errorf("order status = %v, want %v", status, TypeStatusProcessed)
+	}
+
+	balance, _ := mockLoyaltyStorager.GetBalance(ctx, "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8")
+	if balance.Current != money.New(400, 0) {
+		t.Errorf("balance after accrual = %v, want 400", balance.Current)
+	}
+
+	if registered := mockLoyaltyStorager.Records["6014736448"].Status; registered != TypeStatusProcessing {
+		t.Errorf("registered order status = %v, want %v", registered, TypeStatusProcessing)
+	}
+
+	if unknown := mockLoyaltyStorager.Records["4111111111111111"]; unknown.Status != TypeStatusNew || unknown.LastError == "" {
+		t.Errorf("order with unknown status = %v, lastError = %q, want unchanged with error", unknown.Status, unknown.LastError)
+	}
+
+	if expired := mockLoyaltyStorager.Records["6011000990139424"]; expired.Status != TypeStatusInvalid || expired.Reason == "" {
+		t.Errorf("expired order status = %v, reason = %q, want %v with reason", expired.Status, expired.Reason, TypeStatusInvalid)
+	}
+
+	if unknown := mockLoyaltyStorager.Records["4532733309529845"]; unknown.Attempts != 1 || !unknown.NextCheckAt.After(time.Now()) {
+		t.Errorf("unknown order attempts = %v, nextCheckAt = %v, want rescheduled", unknown.Attempts, unknown.NextCheckAt)
+	}
+
+	// Order throttled by accrual isn`t penalized
+	if throttled := mockLoyaltyStorager.Records["371449635398431"]; throttled.Attempts != 0 || !throttled.NextCheckAt.IsZero() || throttled.LastError != "" {
+		t.Errorf("throttled order attempts = %v, nextCheckAt = %v, lastError = %q, want untouched", throttled.Attempts, throttled.NextCheckAt, throttled.LastError)
+	}
+}
+
+func Test_checkBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		want     time.Duration
+	}{
+		{
+			name:     "FirstCheck",
+			attempts: 0,
+			want:     orderCheckBackoff,
+		},
+		{
+			name:     "ThirdCheck",
+			attempts: 2,
+			want:     4 * orderCheckBackoff,
+		},
+		{
+			name:     "Capped",
+			attempts: 100,
+			want:     orderCheckMaxBackoff,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkBackoff(tt.attempts); got != tt.want {
+				t.Errorf("checkBackoff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusFromAccrual(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "Registered",
+			status: accrual.TypeStatusRegistered,
+			want:   TypeStatusProcessing,
+		},
+		{
+			name:   "Processed",
+			status: accrual.TypeStatusProcessed,
+			want:   TypeStatusProcessed,
+		},
+		{
+			name:    "Unknown",
+			status:  "CANCELLED",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := StatusFromAccrual(tt.status)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("StatusFromAccrual() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("StatusFromAccrual() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckTransition(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		wantErr bool
+	}{
+		{
+			name: "NewToProcessing",
+			from: TypeStatusNew,
+			to:   TypeStatusProcessing,
+		},
+		{
+			name: "ProcessingToProcessing",
+			from: TypeStatusProcessing,
+			to:   TypeStatusProcessing,
+		},
+		{
+			name: "NewToProcessed",
+			from: TypeStatusNew,
+			to:   TypeStatusProcessed,
+		},
+		{
+			name:    "ProcessedToProcessing",
+			from:    TypeStatusProcessed,
+			to:      TypeStatusProcessing,
+			wantErr: true,
+		},
+		{
+			name:    "InvalidToProcessed",
+			from:    TypeStatusInvalid,
+			to:      TypeStatusProcessed,
+			wantErr: true,
+		},
+		{
+			name:    "ProcessingToNew",
+			from:    TypeStatusProcessing,
+			to:      TypeStatusNew,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckTransition(tt.from, tt.to); (err != nil) != tt.wantErr {
+				t.Errorf("CheckTransition() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoyalty_Withdraw(t *testing.T) {
+
+	ctx := context.Background()
+
+	userID := "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8"
+
+	mockLoyaltyStorager := MockLoyaltyStorager{
+		Records:     map[string]*Order{},
+		Withdrawals: map[string]*Withdraw{},
+		Ledger:      map[string][]*LedgerEntry{},
+	}
+
+	l := NewLoyalty(MockAccrualler{}, mockLoyaltyStorager, 1, 0)
+
+	if err := mockLoyaltyStorager.AddLedgerEntry(ctx, &LedgerEntry{UserID: userID, Type: TypeEntryAdjustment, Reference: "welcome bonus", Amount: money.MustParse("729.98")}); err != nil {
+		t.Fatalf("AddLedgerEntry() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		wr      *Withdraw
+		wantErr error
+	}{
+		{
+			name: "Withdraw",
+			wr:   &Withdraw{OrderID: "2377225624", UserID: userID, Sum: money.MustParse("0.1")},
+		},
+		{
+			name:    "NotEnoughPoints",
+			wr:      &Withdraw{OrderID: "4532733309529845", UserID: userID, Sum: money.MustParse("729.98")},
+			wantErr: ErrWithdrawNotEnoughPoints,
+		},
+		{
+			name:    "DuplicateOrder",
+			wr:      &Withdraw{OrderID: "2377225624", UserID: userID, Sum: money.MustParse("0.1")},
+			wantErr: ErrWithdrawOrderDuplicate,
+		},
+		{
+			name:    "NegativeSum",
+			wr:      &Withdraw{OrderID: "4532733309529845", UserID: userID, Sum: money.MustParse("-1")},
+			wantErr: ErrWithdrawInvalidSum,
+		},
+		{
+			name:    "NegativeOrder",
+			wr:      &Withdraw{OrderID: "-10", UserID: userID, Sum: money.MustParse("0.1")},
+			wantErr: ErrOrderInvalid,
+		},
+		{
+			name:    "SignedOrder",
+			wr:      &Withdraw{OrderID: "+18", UserID: userID, Sum: money.MustParse("0.1")},
+			wantErr: ErrOrderInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := l.Withdraw(ctx, tt.wr); err != tt.wantErr {
+				t.Errorf("Loyalty.Withdraw() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	history, err := l.GetBalanceHistory(ctx, userID)
+	if err != nil {
+		t.Fatalf("Loyalty.GetBalanceHistory() error = %v", err)
+	}
+
+	if len(history) != 2 {
+		t.Fatalf("Loyalty.GetBalanceHistory() returned %v entries, want 2", len(history))
+	}
+
+	last := history[len(history)-1]
+	if last.Type != TypeEntryWithdrawal || last.Balance != money.MustParse("729.88") || last.Withdrawn != money.MustParse("0.1") {
+		t.Errorf("last ledger entry = %+v, want withdrawal with balance 729.88 and withdrawn 0.1", last)
+	}
+}
+
+func TestParseCursor(t *testing.T) {
+	cursor := &Cursor{Time: time.Date(2024, 12, 27, 10, 0, 0, 123456789, time.UTC), ID: "79927398713"}
+
+	got, err := ParseCursor(cursor.String())
+	if err != nil {
+		t.Fatalf("ParseCursor() error = %v", err)
+	}
+	if !got.Time.Equal(cursor.Time) || got.ID != cursor.ID {
+		t.Errorf("ParseCursor() = %+v, want %+v", got, cursor)
+	}
+
+	for _, value := range []string{"", "!!!", "bm90LWEtY3Vyc29y", "MTIz"} {
+		if _, err := ParseCursor(value); err != ErrInvalidCursor {
+			t.Errorf("ParseCursor(%q) error = %v, want %v", value, err, ErrInvalidCursor)
+		}
+	}
+}
+
+func TestListFilter_Validate(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		filter  ListFilter
+		wantErr bool
+	}{
+		{
+			name:   "Empty",
+			filter: ListFilter{},
+		},
+		{
+			name:   "Valid",
+			filter: ListFilter{Status: TypeStatusProcessed, From: now.Add(-time.Hour), To: now, Limit: MaxPageLimit},
+		},
+		{
+			name:    "LimitTooBig",
+			filter:  ListFilter{Limit: MaxPageLimit + 1},
+			wantErr: true,
+		},
+		{
+			name:    "UnknownStatus",
+			filter:  ListFilter{Status: "REGISTERED"},
+			wantErr: true,
+		},
+		{
+			name:    "EmptyRange",
+			filter:  ListFilter{From: now, To: now},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("ListFilter.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoyalty_GetUserOrder(t *testing.T) {
+	ctx := context.Background()
+
+	storage := MockLoyaltyStorager{
+		Records: map[string]*Order{
+			"79927398713": {
+				UserID:        "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8",
+				ID:            "79927398713",
+				Status:        TypeStatusProcessed,
+				Accrual:       money.MustParse("331.3"),
+				Uploaded:      time.Date(2012, 3, 10, 5, 4, 0, 0, time.UTC),
+				LastCheckedAt: time.Date(2012, 3, 10, 5, 5, 0, 0, time.UTC),
+			},
+		},
+		History: map[string][]*OrderEvent{
+			"79927398713": {
+				{Status: TypeStatusNew, Created: time.Date(2012, 3, 10, 5, 4, 0, 0, time.UTC)},
+				{Status: TypeStatusProcessed, Accrual: money.MustParse("331.3"), Created: time.Date(2012, 3, 10, 5, 5, 0, 0, time.UTC)},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		userID  string
+		orderID string
+		want    string
+		wantErr error
+	}{
+		{
+			name:    "OwnOrder",
+			userID:  "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8",
+			orderID: "79927398713",
+			want:    `{"number":"79927398713","status":"PROCESSED","accrual":331.3,"uploaded":"2012-03-10T05:04:00Z","last_checked_at":"2012-03-10T05:05:00Z","history":[{"status":"NEW","created_at":"2012-03-10T05:04:00Z"},{"status":"PROCESSED","accrual":331.3,"created_at":"2012-03-10T05:05:00Z"}]}`,
+		},
+		{
+			name:    "AnotherUserOrder",
+			userID:  "5c18f4b8-bbb8-11ef-bd1a-8bd0750e0c51",
+			orderID: "79927398713",
+			wantErr: ErrOrderNotFound,
+		},
+		{
+			name:    "UnknownOrder",
+			userID:  "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8",
+			orderID: "4532733309529845",
+			wantErr: ErrOrderNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Loyalty{
+				storage: storage,
+			}
+			got, err := l.GetUserOrder(ctx, tt.userID, tt.orderID)
+			if err != tt.wantErr {
+				t.Fatalf("Loyalty.GetUserOrder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			gotJSON, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("Can`t marshall, error: %v", err)
+			}
+
+			if string(gotJSON) != tt.want {
+				t.Errorf("Loyalty.GetUserOrder() = %v, want %v", string(gotJSON), tt.want)
+			}
+		})
+	}
+}
+
+func TestEventHub(t *testing.T) {
+	hub := NewEventHub()
+
+	hub.Publish(&OrderStatusEvent{UserID: "first", OrderID: "79927398713", Status: TypeStatusProcessing})
+	missed := &OrderStatusEvent{UserID: "first", OrderID: "79927398713", Status: TypeStatusProcessed}
+	hub.Publish(missed)
+
+	replay, events, cancel := hub.Subscribe("first", missed.ID-1)
+	defer cancel()
+
+	if len(replay) != 1 || replay[0] != missed {
+		t.Fatalf("EventHub.Subscribe() replay = %v, want only event after Last-Event-ID", replay)
+	}
+
+	hub.Publish(&OrderStatusEvent{UserID: "second", OrderID: "4532733309529845", Status: TypeStatusProcessed})
+	own := &OrderStatusEvent{UserID: "first", OrderID: "1984", Status: TypeStatusInvalid}
+	hub.Publish(own)
+
+	select {
+	case got := <-events:
+		if got != own || got.ID <= missed.ID {
+			t.Errorf("EventHub subscriber got %+v, want %+v with ID after %v", got, own, missed.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("EventHub subscriber didn`t get own event")
+	}
+
+	// Slow subscriber is disconnected instead of blocking publisher
+	for i := 0; i <= subscriberBuffer; i++ {
+		hub.Publish(&OrderStatusEvent{UserID: "first", OrderID: "1984", Status: TypeStatusInvalid})
+	}
+	for range events {
+	}
+
+	_, events, cancel = hub.Subscribe("first", 0)
+	defer cancel()
+	hub.Close()
+
+	if _, ok := <-events; ok {
+		t.Errorf("EventHub.Close() didn`t close subscriber channel")
+	}
+}
+
+func TestLoyalty_feedEvents(t *testing.T) {
+	settled := time.Now().Add(-time.Minute)
+	storage := MockLoyaltyStorager{
+		StatusEvents: []*OrderStatusEvent{
+			{ID: 3, UserID: "first", OrderID: "79927398713", Status: TypeStatusProcessing, Created: settled},
+			{ID: 5, UserID: "first", OrderID: "79927398713", Status: TypeStatusProcessed, Created: settled},
+			// Young event may have neighbours with lower IDs still not committed, it waits for the next poll with the rest
+			{ID: 7, UserID: "first", OrderID: "1984", Status: TypeStatusInvalid, Created: time.Now()},
+			{ID: 9, UserID: "first", OrderID: "4532733309529845", Status: TypeStatusInvalid, Created: settled},
+		},
+	}
+	l := NewLoyalty(MockAccrualler{}, storage, 1, 0)
+
+	_, events, cancel := l.Events().Subscribe("first", 0)
+	defer cancel()
+
+	cursor, err := l.feedEvents(context.Background(), 3)
+	if err != nil || cursor != 5 {
+		t.Fatalf("Loyalty.feedEvents() = %v, %v, want cursor 5", cursor, err)
+	}
+
+	// Already published event is dropped
+	l.Events().Publish(storage.StatusEvents[1])
+	l.Events().Close()
+
+	got := make([]uint64, 0)
+	for event := range events {
+		got = append(got, event.ID)
+	}
+	if len(got) != 1 || got[0] != 5 {
+		t.Errorf("Loyalty.feedEvents() published events %v, want [5]", got)
+	}
+}
+
+func TestLoyalty_UploadOrders(t *testing.T) {
+	storage := MockLoyaltyStorager{
+		Records: map[string]*Order{
+			"79927398713":      {UserID: "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8", ID: "79927398713", Status: TypeStatusProcessed},
+			"4532733309529845": {UserID: "5c18f4b8-bbb8-11ef-bd1a-8bd0750e0c51", ID: "4532733309529845", Status: TypeStatusNew},
+		},
+	}
+
+	l := NewLoyalty(nil, storage, 1, 0)
+
+	tests := []struct {
+		name     string
+		orderIDs []string
+		want     []string
+		wantErr  error
+	}{
+		{
+			name:     "MixedBatch",
+			orderIDs: []string{"2377225624", "79927398713", "4532733309529845", "79927398710", "abc", "12345678903", "2377225624"},
+			want: []string{
+				TypeUploadAccepted,
+				TypeUploadAlreadyUploaded,
+				TypeUploadConflict,
+				TypeUploadInvalid,
+				TypeUploadInvalid,
+				TypeUploadAccepted,
+				TypeUploadAlreadyUploaded,
+			},
+		},
+		{
+			name:     "AllInvalid",
+			orderIDs: []string{"79927398710", "-10", "+18"},
+			want:     []string{TypeUploadInvalid, TypeUploadInvalid, TypeUploadInvalid},
+		},
+		{
+			name:    "EmptyBatch",
+			wantErr: ErrBatchEmpty,
+		},
+		{
+			name:     "TooLargeBatch",
+			orderIDs: make([]string, MaxBatchSize+1),
+			wantErr:  ErrBatchTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := l.UploadOrders(context.Background(), "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8", tt.orderIDs)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Loyalty.UploadOrders() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if len(results) != len(tt.want) {
+				t.Fatalf("Loyalty.UploadOrders() returned %v results, want %v", len(results), len(tt.want))
+			}
+			for i, result := range results {
+				if result.Number != tt.orderIDs[i] || result.Result != tt.want[i] {
+					t.Errorf("Loyalty.UploadOrders()[%v] = %+v, want %v", i, result, tt.want[i])
+				}
+			}
+		})
+	}
+
+	if order, ok := storage.Records["12345678903"]; !ok || order.UserID != "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8" {
+		t.Errorf("accepted order wasn`t added to storage")
+	}
+}
+
+func Test_claimLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		rpm  int
+		want int
+	}{
+		{
+			name: "Unlimited",
+			rpm:  0,
+			want: unhandledOrdersBatch,
+		},
+		{
+			name: "FitsInLease",
+			rpm:  60,
+			want: 60,
+		},
+		{
+			name: "CappedByBatch",
+			rpm:  100000,
+			want: unhandledOrdersBatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := claimLimit(tt.rpm); got != tt.want {
+				t.Errorf("claimLimit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostings(t *testing.T) {
+	userID := "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8"
+
+	tests := []struct {
+		name        string
+		entry       *LedgerEntry
+		wantAccount string
+	}{
+		{
+			name:        "Accrual",
+			entry:       &LedgerEntry{UserID: userID, Type: TypeEntryAccrual, Amount: money.New(500, 0), Balance: money.New(500, 0)},
+			wantAccount: AccountAccrual,
+		},
+		{
+			name:        "Withdrawal",
+			entry:       &LedgerEntry{UserID: userID, Type: TypeEntryWithdrawal, Amount: -money.New(100, 0), Balance: money.New(400, 0), Withdrawn: money.New(100, 0)},
+			wantAccount: WithdrawnAccount(userID),
+		},
+		{
+			name:        "Reversal",
+			entry:       &LedgerEntry{UserID: userID, Type: TypeEntryReversal, Amount: -money.New(400, 0)},
+			wantAccount: AccountAdjustment,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			postings := Postings(tt.entry)
+
+			var sum money.Amount
+			for _, posting := range postings {
+				sum += posting.Amount
+			}
+			if sum != 0 {
+				t.Errorf("Postings() sum = %v, want 0", sum)
+			}
+
+			if len(postings) != 2 || postings[0].Account != UserAccount(userID) || postings[0].Balance != tt.entry.Balance || postings[1].Account != tt.wantAccount {
+				t.Errorf("Postings() = %+v, want user leg and %v leg", postings, tt.wantAccount)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package loyalty
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxPageLimit is maximal count of orders or withdrawals returned on one page
+const MaxPageLimit = 1000
+
+var (
+	ErrInvalidCursor = errors.New("page cursor is invalid")
+	ErrInvalidFilter = errors.New("list filter is invalid")
+)
+
+// Cursor points to the last item of the page, the next page starts right after it.
+// Items are ordered by time and then by ID, so the cursor is stable when new items are added.
+type Cursor struct {
+	Time time.Time
+	ID   string
+}
+
+// String encodes cursor into opaque URL safe string
+func (c *Cursor) String() string {
+	raw := strconv.FormatInt(c.Time.UnixNano(), 10) + ":" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor decodes cursor returned by Cursor.String
+func ParseCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &Cursor{Time: time.Unix(0, n).UTC(), ID: id}, nil
+}
+
+// ListFilter selects page of user orders or withdrawals
+type ListFilter struct {
+	// Status keeps only orders in the status, withdrawals have no status
+	Status string
+	// From and To limit time of upload or withdrawal, From is inclusive and To is exclusive
+	From, To time.Time
+	// Limit is maximal count of items on the page, zero means all items
+	Limit int
+	// After is cursor of the previous page
+	After *Cursor
+}
+
+// Validate returns ErrInvalidFilter if filter can`t be applied
+func (f ListFilter) Validate() error {
+	if f.Limit < 0 || f.Limit > MaxPageLimit {
+		return fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidFilter, MaxPageLimit)
+	}
+
+	if _, ok := statusRanks[f.Status]; f.Status != "" && !ok {
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidFilter, f.Status)
+	}
+
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidFilter)
+	}
+
+	return nil
+}
+
+// FetchLimit returns count of items storage has to fetch to find out whether the next page exists,
+// zero means all items
+func (f ListFilter) FetchLimit() int {
+	if f.Limit == 0 {
+		return 0
+	}
+	return f.Limit + 1
+}
+
+// Match reports whether item with status, time t and id passes the filter.
+// It is used by storages which can`t filter items in the query.
+func (f ListFilter) Match(status string, t time.Time, id string) bool {
+	if f.Status != "" && status != f.Status {
+		return false
+	}
+	if !f.From.IsZero() && t.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !t.Before(f.To) {
+		return false
+	}
+	if f.After != nil && !after(t, id, f.After) {
+		return false
+	}
+	return true
+}
+
+// after reports whether item with time t and id follows the cursor
+func after(t time.Time, id string, c *Cursor) bool {
+	if t.Equal(c.Time) {
+		return id > c.ID
+	}
+	return t.After(c.Time)
+}
+
+// Paginate cuts items fetched with FetchLimit of filter with limit down to the page
+// and returns cursor of the next page, cursor is nil for the last page
+func Paginate[T any](items []T, limit int, cursor func(T) *Cursor) ([]T, *Cursor) {
+	if limit == 0 || len(items) <= limit {
+		return items, nil
+	}
+
+	items = items[:limit]
+	return items, cursor(items[limit-1])
+}
+
+// OrderCursor returns cursor pointing to order
+func OrderCursor(order *Order) *Cursor {
+	return &Cursor{Time: order.Uploaded, ID: order.ID}
+}
+
+// WithdrawCursor returns cursor pointing to withdrawal
+func WithdrawCursor(withdraw *Withdraw) *Cursor {
+	return &Cursor{Time: withdraw.Created, ID: withdraw.OrderID}
+}
@@ -3,14 +3,27 @@ package loyalty
 import (
 	"encoding/json"
 	"time"
+
+	"github.com/renatus-cartesius/gophermart/pkg/money"
 )
 
 type Order struct {
-	UserID   string    `json:"-"`
-	ID       string    `json:"number"`
-	Status   string    `json:"status"`
-	Accrual  float64   `json:"accrual"`
-	Uploaded time.Time `json:"uploaded"`
+	UserID   string       `json:"-"`
+	ID       string       `json:"number"`
+	Status   string       `json:"status"`
+	Accrual  money.Amount `json:"accrual"`
+	Uploaded time.Time    `json:"uploaded"`
+	// Reason explains why order was moved to terminal status by gophermart itself
+	Reason string `json:"reason,omitempty"`
+
+	// Attempts is count of checks made in accrual
+	Attempts int `json:"-"`
+	// LastError is error of the last failed check
+	LastError string `json:"-"`
+	// NextCheckAt is time when order is due to be checked again
+	NextCheckAt time.Time `json:"-"`
+	// LastCheckedAt is time of the last check in accrual, zero if order wasn`t checked yet
+	LastCheckedAt time.Time `json:"-"`
 }
 
 func (o *Order) MarshalJSON() ([]byte, error) {
@@ -22,20 +35,22 @@ func (o *Order) MarshalJSON() ([]byte, error) {
 				ID       string    `json:"number"`
 				Status   string    `json:"status"`
 				Uploaded time.Time `json:"uploaded"`
+				Reason   string    `json:"reason,omitempty"`
 			}{
 				UserID:   o.UserID,
 				ID:       o.ID,
 				Status:   o.Status,
 				Uploaded: o.Uploaded,
+				Reason:   o.Reason,
 			})
 	} else {
 		return json.Marshal(
 			struct {
-				UserID   string    `json:"-"`
-				ID       string    `json:"number"`
-				Status   string    `json:"status"`
-				Accrual  float64   `json:"accrual"`
-				Uploaded time.Time `json:"uploaded"`
+				UserID   string       `json:"-"`
+				ID       string       `json:"number"`
+				Status   string       `json:"status"`
+				Accrual  money.Amount `json:"accrual"`
+				Uploaded time.Time    `json:"uploaded"`
 			}{
 				UserID:   o.UserID,
 				ID:       o.ID,
@@ -0,0 +1,64 @@
+package loyalty
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/renatus-cartesius/gophermart/internal/accrual"
+)
+
+var (
+	ErrUnknownAccrualStatus    = errors.New("unknown accrual order status")
+	ErrInvalidStatusTransition = errors.New("invalid order status transition")
+)
+
+// accrualStatuses maps statuses of accrual system to loyalty ones
+var accrualStatuses = map[string]string{
+	accrual.TypeStatusRegistered: TypeStatusProcessing,
+	accrual.TypeStatusProcessing: TypeStatusProcessing,
+	accrual.TypeStatusInvalid:    TypeStatusInvalid,
+	accrual.TypeStatusProcessed:  TypeStatusProcessed,
+}
+
+// statusRanks orders loyalty statuses, order status can only move to the status with greater rank
+var statusRanks = map[string]int{
+	TypeStatusNew:        0,
+	TypeStatusProcessing: 1,
+	TypeStatusInvalid:    2,
+	TypeStatusProcessed:  2,
+}
+
+// StatusFromAccrual returns loyalty status corresponding to accrual status
+func StatusFromAccrual(status string) (string, error) {
+	loyaltyStatus, ok := accrualStatuses[status]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownAccrualStatus, status)
+	}
+
+	return loyaltyStatus, nil
+}
+
+// IsTerminalStatus reports whether order in status is never checked again
+func IsTerminalStatus(status string) bool {
+	return status == TypeStatusInvalid || status == TypeStatusProcessed
+}
+
+// CheckTransition returns ErrInvalidStatusTransition if order can`t move from status to status.
+// Staying in the same status is allowed for not terminal statuses only.
+func CheckTransition(from, to string) error {
+	fromRank, ok := statusRanks[from]
+	if !ok {
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidStatusTransition, from)
+	}
+
+	toRank, ok := statusRanks[to]
+	if !ok {
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidStatusTransition, to)
+	}
+
+	if IsTerminalStatus(from) || toRank < fromRank {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidStatusTransition, from, to)
+	}
+
+	return nil
+}
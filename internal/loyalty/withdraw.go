@@ -3,15 +3,19 @@ package loyalty
 import (
 	"errors"
 	"time"
+
+	"github.com/renatus-cartesius/gophermart/pkg/money"
 )
 
 var (
 	ErrWithdrawNotEnoughPoints = errors.New("not enough points for withdraw")
+	ErrWithdrawInvalidSum      = errors.New("withdraw sum must be positive")
+	ErrWithdrawOrderDuplicate  = errors.New("order number is already used")
 )
 
 type Withdraw struct {
-	OrderID string    `json:"order"`
-	UserID  string    `json:"-"`
-	Sum     float64   `json:"sum"`
-	Created time.Time `json:"processed_at"`
+	OrderID string       `json:"order"`
+	UserID  string       `json:"-"`
+	Sum     money.Amount `json:"sum"`
+	Created time.Time    `json:"processed_at"`
 }
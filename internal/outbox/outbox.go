@@ -0,0 +1,108 @@
+// Package outbox publishes domain events recorded by storage in the same transaction as state changes
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/pkg/money"
+)
+
+const (
+	TypeEventOrderUploaded      = "order.uploaded"
+	TypeEventOrderStatusChanged = "order.status_changed"
+	TypeEventWithdrawCreated    = "withdrawal.created"
+)
+
+var ErrEventLeaseLost = errors.New("outbox event is not leased by this relay")
+
+// Event is domain event waiting in outbox to be published
+type Event struct {
+	ID     int64  `json:"id"`
+	UserID string `json:"user_id"`
+	Type   string `json:"type"`
+	// AggregateID is number of order or withdrawal the event is about
+	AggregateID string          `json:"aggregate_id"`
+	Payload     json.RawMessage `json:"payload"`
+	Created     time.Time       `json:"created_at"`
+
+	// Attempts is count of failed publications
+	Attempts int `json:"-"`
+}
+
+type orderPayload struct {
+	Number  string       `json:"number"`
+	Status  string       `json:"status"`
+	Accrual money.Amount `json:"accrual,omitempty"`
+	Reason  string       `json:"reason,omitempty"`
+}
+
+type withdrawPayload struct {
+	Order string       `json:"order"`
+	Sum   money.Amount `json:"sum"`
+}
+
+func newEvent(userID string, eventType string, aggregateID string, payload any) *Event {
+	// payloads are plain structs of strings and amounts, so marshalling can`t fail
+	data, _ := json.Marshal(payload)
+
+	return &Event{
+		UserID:      userID,
+		Type:        eventType,
+		AggregateID: aggregateID,
+		Payload:     data,
+	}
+}
+
+// OrderUploaded returns event about order uploaded by user
+func OrderUploaded(userID string, orderID string) *Event {
+	return newEvent(userID, TypeEventOrderUploaded, orderID, orderPayload{
+		Number: orderID,
+		Status: loyalty.TypeStatusNew,
+	})
+}
+
+// OrderStatusChanged returns event about new status, accrual or reason of order
+func OrderStatusChanged(order *loyalty.Order) *Event {
+	return newEvent(order.UserID, TypeEventOrderStatusChanged, order.ID, orderPayload{
+		Number:  order.ID,
+		Status:  order.Status,
+		Accrual: order.Accrual,
+		Reason:  order.Reason,
+	})
+}
+
+// WithdrawCreated returns event about withdrawal of user points
+func WithdrawCreated(wr *loyalty.Withdraw) *Event {
+	return newEvent(wr.UserID, TypeEventWithdrawCreated, wr.OrderID, withdrawPayload{
+		Order: wr.OrderID,
+		Sum:   wr.Sum,
+	})
+}
+
+// OutboxStorager keeps events written together with state changes until they are published
+type OutboxStorager interface {
+	// ClaimOutboxEvents leases up to limit the oldest unpublished events for owner. Events of user are
+	// claimed only while no other owner holds lease on any of them and none of them waits for retry,
+	// so events of one user are published in order.
+	ClaimOutboxEvents(ctx context.Context, owner string, lease time.Duration, limit int) ([]*Event, error)
+	// MarkOutboxPublished marks event leased by owner as published, returns ErrEventLeaseLost if event isn`t leased by owner
+	MarkOutboxPublished(ctx context.Context, owner string, eventID int64) error
+	// FailOutboxEvent records failed publication of event, sets its retry time after retryAfter
+	// and releases all the events of its user leased by owner
+	FailOutboxEvent(ctx context.Context, owner string, eventID int64, lastError string, retryAfter time.Duration) error
+	// DeadLetterOutboxEvent records the last failed publication of event and moves it to terminal FAILED state,
+	// failed event is never claimed again and the following events of its user are claimed without it
+	DeadLetterOutboxEvent(ctx context.Context, owner string, eventID int64, lastError string) error
+	// PruneOutboxEvents deletes events published before the given time and returns count of deleted events
+	PruneOutboxEvents(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Publisher delivers events to downstream consumers. Event may be published again after failure,
+// so publishers have to tolerate duplicates of event with the same ID.
+type Publisher interface {
+	Publish(ctx context.Context, event *Event) error
+}
@@ -0,0 +1,65 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// LogPublisher writes events to the application log
+type LogPublisher struct{}
+
+func (LogPublisher) Publish(ctx context.Context, event *Event) error {
+	logger.Log.Info(
+		"domain event",
+		zap.Int64("id", event.ID),
+		zap.String("type", event.Type),
+		zap.String("userID", event.UserID),
+		zap.String("aggregateID", event.AggregateID),
+		zap.ByteString("payload", event.Payload),
+	)
+	return nil
+}
+
+// WriterPublisher writes events to w as newline delimited JSON
+type WriterPublisher struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterPublisher(w io.Writer) *WriterPublisher {
+	return &WriterPublisher{
+		w: w,
+	}
+}
+
+func (wp *WriterPublisher) Publish(ctx context.Context, event *Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	_, err = wp.w.Write(append(line, '\n'))
+	return err
+}
+
+// Publishers passes event to every publisher in turn. Event is failed by the first failed publisher
+// and is published to all of them again on retry.
+type Publishers []Publisher
+
+func (ps Publishers) Publish(ctx context.Context, event *Event) error {
+	for _, publisher := range ps {
+		if err := publisher.Publish(ctx, event); err != nil {
+			return fmt.Errorf("%T: %w", publisher, err)
+		}
+	}
+	return nil
+}
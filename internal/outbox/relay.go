@@ -0,0 +1,202 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// relayInterval is delay between publication passes
+	relayInterval = time.Second
+	// relayBatch is max count of events claimed by one pass
+	relayBatch = 100
+	// relayLease is how long claimed events are owned by relay before another one may reclaim them
+	relayLease = time.Minute
+	// relayRetention is how long published events are kept in outbox
+	relayRetention = 7 * 24 * time.Hour
+	// relayPruneInterval is delay between deletions of published events
+	relayPruneInterval = time.Hour
+	// relayBackoff is delay before the first retry of failed event, doubled with every attempt
+	relayBackoff = 10 * time.Second
+	// relayMaxBackoff limits delay between publication attempts of event
+	relayMaxBackoff = 30 * time.Minute
+	// relayMaxAttempts is count of failed publications after which event is moved to FAILED state
+	relayMaxAttempts = 10
+)
+
+// Relay moves events from outbox to publisher. Events are marked published only after publisher accepted them,
+// so they are delivered at least once, event of user isn`t published before the older events of the same user.
+// Failed event is retried with backoff and after relayMaxAttempts it is moved to FAILED state, so it doesn`t
+// hold back the following events of its user forever.
+type Relay struct {
+	storage   OutboxStorager
+	publisher Publisher
+	owner     string
+}
+
+func NewRelay(storage OutboxStorager, publisher Publisher) *Relay {
+	return &Relay{
+		storage:   storage,
+		publisher: publisher,
+		owner:     newOwner(),
+	}
+}
+
+// newOwner returns identifier of relay used as owner of leased events
+func newOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "gophermart"
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return hostname + "-outbox"
+	}
+
+	return hostname + "-outbox-" + hex.EncodeToString(suffix)
+}
+
+// Run publishes outbox events and prunes the published ones until ctx is done
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(relayInterval)
+	defer ticker.Stop()
+
+	pruneTicker := time.NewTicker(relayPruneInterval)
+	defer pruneTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Info(
+				"closing outbox relay",
+			)
+			return
+		case <-ticker.C:
+			if _, err := r.Publish(ctx); err != nil {
+				logger.Log.Error(
+					"error on publishing outbox events",
+					zap.Error(err),
+				)
+			}
+		case <-pruneTicker.C:
+			if _, err := r.Prune(ctx); err != nil {
+				logger.Log.Error(
+					"error on pruning outbox events",
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+// Prune deletes events published earlier than retention period ago and returns their count
+func (r *Relay) Prune(ctx context.Context) (int64, error) {
+	pruned, err := r.storage.PruneOutboxEvents(ctx, time.Now().UTC().Add(-relayRetention))
+	if err != nil {
+		return 0, err
+	}
+
+	if pruned > 0 {
+		logger.Log.Info(
+			"outbox events pruned",
+			zap.Int64("count", pruned),
+		)
+	}
+
+	return pruned, nil
+}
+
+// Publish makes one pass over outbox and returns count of published events.
+// Failed event stops publication of the following events of its user until the next pass.
+func (r *Relay) Publish(ctx context.Context) (int, error) {
+	events, err := r.storage.ClaimOutboxEvents(ctx, r.owner, relayLease, relayBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	failedUsers := make(map[string]struct{})
+	for _, event := range events {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if _, failed := failedUsers[event.UserID]; failed {
+			continue
+		}
+
+		if err := r.publisher.Publish(ctx, event); err != nil {
+			failedUsers[event.UserID] = struct{}{}
+			r.fail(ctx, event, err)
+			continue
+		}
+
+		if err := r.storage.MarkOutboxPublished(context.WithoutCancel(ctx), r.owner, event.ID); err != nil {
+			// Event is published again after lease expires, the following events of user wait for it
+			failedUsers[event.UserID] = struct{}{}
+
+			logger.Log.Error(
+				"error on marking outbox event published",
+				zap.Int64("eventID", event.ID),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		published++
+	}
+
+	return published, nil
+}
+
+// fail records failed publication of event, event is retried with backoff until relayMaxAttempts
+// and then moved to FAILED state
+func (r *Relay) fail(ctx context.Context, event *Event, publishErr error) {
+	attempts := event.Attempts + 1
+
+	var err error
+	if attempts >= relayMaxAttempts {
+		logger.Log.Error(
+			"outbox event failed",
+			zap.Int64("eventID", event.ID),
+			zap.String("type", event.Type),
+			zap.Int("attempts", attempts),
+			zap.Error(publishErr),
+		)
+		err = r.storage.DeadLetterOutboxEvent(context.WithoutCancel(ctx), r.owner, event.ID, publishErr.Error())
+	} else {
+		logger.Log.Warn(
+			"error on publishing outbox event",
+			zap.Int64("eventID", event.ID),
+			zap.String("type", event.Type),
+			zap.Int("attempts", attempts),
+			zap.Error(publishErr),
+		)
+		err = r.storage.FailOutboxEvent(context.WithoutCancel(ctx), r.owner, event.ID, publishErr.Error(), retryBackoff(attempts))
+	}
+
+	if err != nil {
+		logger.Log.Error(
+			"error on recording failed outbox event",
+			zap.Int64("eventID", event.ID),
+			zap.Error(err),
+		)
+	}
+}
+
+// retryBackoff returns delay before the next publication of event failed attempts times
+func retryBackoff(attempts int) time.Duration {
+	backoff := relayBackoff
+	for i := 1; i < attempts && backoff < relayMaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, relayMaxBackoff)
+}
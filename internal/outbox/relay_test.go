@@ -0,0 +1,209 @@
+package outbox_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/internal/outbox"
+	"github.com/renatus-cartesius/gophermart/internal/storage/memory"
+	"github.com/renatus-cartesius/gophermart/pkg/money"
+)
+
+// flakyPublisher fails the first publication of events of failUser and records the published ones
+type flakyPublisher struct {
+	failUser  string
+	failed    bool
+	published []*outbox.Event
+}
+
+func (fp *flakyPublisher) Publish(ctx context.Context, event *outbox.Event) error {
+	if event.UserID == fp.failUser && !fp.failed {
+		fp.failed = true
+		return errors.New("broker is unavailable")
+	}
+	fp.published = append(fp.published, event)
+	return nil
+}
+
+// noBackoffStorage retries failed events without waiting
+type noBackoffStorage struct {
+	*memory.MemStorage
+}
+
+func (s noBackoffStorage) FailOutboxEvent(ctx context.Context, owner string, eventID int64, lastError string, retryAfter time.Duration) error {
+	return s.MemStorage.FailOutboxEvent(ctx, owner, eventID, lastError, 0)
+}
+
+// rejectingPublisher fails every publication of events about order and records the published ones
+type rejectingPublisher struct {
+	order     string
+	rejected  int
+	published []*outbox.Event
+}
+
+func (rp *rejectingPublisher) Publish(ctx context.Context, event *outbox.Event) error {
+	if event.AggregateID == rp.order {
+		rp.rejected++
+		return errors.New("payload is rejected")
+	}
+	rp.published = append(rp.published, event)
+	return nil
+}
+
+func TestRelay_Publish(t *testing.T) {
+	ctx := context.Background()
+	ms := memory.NewMemStorage()
+
+	const (
+		firstUser  = "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8"
+		secondUser = "5c18f4b8-bbb8-11ef-bd1a-8bd0750e0c51"
+	)
+
+	for _, upload := range []struct{ userID, orderID string }{
+		{firstUser, "79927398713"},
+		{secondUser, "4532733309529845"},
+		{firstUser, "2377225624"},
+	} {
+		if err := ms.AddOrder(ctx, upload.userID, upload.orderID); err != nil {
+			t.Fatalf("MemStorage.AddOrder() error = %v", err)
+		}
+	}
+
+	publisher := &flakyPublisher{failUser: firstUser}
+	relay := outbox.NewRelay(noBackoffStorage{ms}, publisher)
+
+	// Failed event of the first user holds back the next one
+	published, err := relay.Publish(ctx)
+	if err != nil || published != 1 {
+		t.Fatalf("Relay.Publish() = %v, %v, want 1 published", published, err)
+	}
+	if publisher.published[0].UserID != secondUser {
+		t.Errorf("Relay.Publish() published event of %v, want only event of %v", publisher.published[0].UserID, secondUser)
+	}
+
+	published, err = relay.Publish(ctx)
+	if err != nil || published != 2 {
+		t.Fatalf("Relay.Publish() = %v, %v, want 2 published", published, err)
+	}
+
+	var orders []string
+	for _, event := range publisher.published[1:] {
+		if event.UserID != firstUser || event.Type != outbox.TypeEventOrderUploaded {
+			t.Errorf("Relay.Publish() published %+v, want upload event of %v", event, firstUser)
+		}
+		orders = append(orders, event.AggregateID)
+	}
+	if strings.Join(orders, ",") != "79927398713,2377225624" {
+		t.Errorf("Relay.Publish() published orders %v, want them in upload order", orders)
+	}
+
+	if published, _ := relay.Publish(ctx); published != 0 {
+		t.Errorf("Relay.Publish() published %v events again, want 0", published)
+	}
+}
+
+func TestRelay_PublishRetry(t *testing.T) {
+	ctx := context.Background()
+
+	const userID = "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8"
+	newStorage := func() *memory.MemStorage {
+		ms := memory.NewMemStorage()
+		for _, orderID := range []string{"79927398713", "2377225624"} {
+			if err := ms.AddOrder(ctx, userID, orderID); err != nil {
+				t.Fatalf("MemStorage.AddOrder() error = %v", err)
+			}
+		}
+		return ms
+	}
+
+	// Failed event waits for backoff with the following events of its user
+	ms := newStorage()
+	publisher := &rejectingPublisher{order: "79927398713"}
+	for i := 0; i < 2; i++ {
+		if published, err := outbox.NewRelay(ms, publisher).Publish(ctx); err != nil || published != 0 {
+			t.Fatalf("Relay.Publish() = %v, %v, want 0 published", published, err)
+		}
+	}
+	if publisher.rejected != 1 {
+		t.Errorf("Relay.Publish() made %v attempts before retry time, want 1", publisher.rejected)
+	}
+
+	// Event failing every attempt is moved to FAILED state and unblocks the following event
+	publisher = &rejectingPublisher{order: "79927398713"}
+	relay := outbox.NewRelay(noBackoffStorage{newStorage()}, publisher)
+	for i := 0; i < 20 && len(publisher.published) == 0; i++ {
+		if _, err := relay.Publish(ctx); err != nil {
+			t.Fatalf("Relay.Publish() error = %v", err)
+		}
+	}
+	if len(publisher.published) != 1 || publisher.published[0].AggregateID != "2377225624" {
+		t.Fatalf("Relay.Publish() published %+v, want only the following event", publisher.published)
+	}
+
+	// Event is attempted relayMaxAttempts times
+	if publisher.rejected != 10 {
+		t.Errorf("Relay.Publish() made %v attempts of failing event, want 10", publisher.rejected)
+	}
+	if published, _ := relay.Publish(ctx); published != 0 || publisher.rejected != 10 {
+		t.Errorf("Relay.Publish() retried failed event, %v attempts", publisher.rejected)
+	}
+}
+
+func TestWriterPublisher_Publish(t *testing.T) {
+	buf := &bytes.Buffer{}
+	publisher := outbox.NewWriterPublisher(buf)
+
+	events := []*outbox.Event{
+		outbox.OrderStatusChanged(&loyalty.Order{UserID: "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8", ID: "79927398713", Status: loyalty.TypeStatusProcessed, Accrual: money.MustParse("729.98")}),
+		outbox.WithdrawCreated(&loyalty.Withdraw{UserID: "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8", OrderID: "2377225624", Sum: money.New(500, 0)}),
+	}
+	for _, event := range events {
+		if err := publisher.Publish(context.Background(), event); err != nil {
+			t.Fatalf("WriterPublisher.Publish() error = %v", err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(events) {
+		t.Fatalf("WriterPublisher.Publish() wrote %v lines, want %v", len(lines), len(events))
+	}
+
+	want := []string{
+		`{"number":"79927398713","status":"PROCESSED","accrual":729.98}`,
+		`{"order":"2377225624","sum":500}`,
+	}
+	for i, line := range lines {
+		event := &outbox.Event{}
+		if err := json.Unmarshal([]byte(line), event); err != nil {
+			t.Fatalf("error on unmarshalling published event: %v", err)
+		}
+		if event.Type != events[i].Type || string(event.Payload) != want[i] {
+			t.Errorf("published event = %v, want type %v and payload %v", line, events[i].Type, want[i])
+		}
+	}
+}
+
+func TestPublishers_Publish(t *testing.T) {
+	first, second := &flakyPublisher{}, &flakyPublisher{failUser: "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8"}
+	publishers := outbox.Publishers{first, second}
+
+	event := outbox.OrderUploaded("e713ebf8-bb4b-11ef-9718-a7e5292ccfb8", "79927398713")
+
+	if err := publishers.Publish(context.Background(), event); err == nil {
+		t.Fatalf("Publishers.Publish() error = nil, want error of failed publisher")
+	}
+	if err := publishers.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Publishers.Publish() error = %v", err)
+	}
+
+	// Event failed by one publisher is published again to all of them
+	if len(first.published) != 2 || len(second.published) != 1 {
+		t.Errorf("Publishers.Publish() published %v and %v events, want 2 and 1", len(first.published), len(second.published))
+	}
+}
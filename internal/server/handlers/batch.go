@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"github.com/renatus-cartesius/gophermart/pkg/problem"
+	"go.uber.org/zap"
+)
+
+// maxBatchBody limits size of batch upload request body
+const maxBatchBody = 1 << 20
+
+var errBatchBody = errors.New("batch must be JSON array or newline delimited order numbers")
+
+// parseBatch returns order numbers of batch body. JSON body is array of numbers or strings,
+// any other body is order numbers delimited by new lines.
+func parseBatch(contentType string, body []byte) ([]string, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "application/json" {
+		numbers := make([]string, 0)
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			if number := strings.TrimSpace(scanner.Text()); number != "" {
+				numbers = append(numbers, number)
+			}
+		}
+		return numbers, scanner.Err()
+	}
+
+	items := make([]json.RawMessage, 0)
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, errBatchBody
+	}
+
+	numbers := make([]string, 0, len(items))
+	for _, item := range items {
+		var number string
+		if err := json.Unmarshal(item, &number); err != nil {
+			var n json.Number
+			if err := json.Unmarshal(item, &n); err != nil {
+				return nil, errBatchBody
+			}
+			number = n.String()
+		}
+		numbers = append(numbers, number)
+	}
+
+	return numbers, nil
+}
+
+// UploadOrders uploads batch of order numbers and responds with result of every number
+func (s ServerHandler) UploadOrders(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.Username("userID")).(string)
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBody))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			problem.TooLarge.Write(w, r, "batch request body is too large")
+			return
+		}
+		writeError(w, r, "error on reading request body", err, zap.String("userID", userID))
+		return
+	}
+
+	numbers, err := parseBatch(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		logger.Log.Debug(
+			"client passed invalid batch",
+			zap.String("userID", userID),
+			zap.Error(err),
+		)
+		problem.BadRequest.Write(w, r, errBatchBody.Error())
+		return
+	}
+
+	results, err := s.l.UploadOrders(r.Context(), userID, numbers)
+	if err != nil {
+		writeError(w, r, "something went wrong when uploading batch of orders", err, zap.String("userID", userID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		logger.Log.Error(
+			"error on marshalling batch upload results",
+			zap.String("userID", userID),
+			zap.Error(err),
+		)
+		return
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_parseBatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        []string
+		wantErr     error
+	}{
+		{
+			name:        "JSONStrings",
+			contentType: "application/json",
+			body:        `["79927398713", "2377225624"]`,
+			want:        []string{"79927398713", "2377225624"},
+		},
+		{
+			name:        "JSONNumbers",
+			contentType: "application/json; charset=utf-8",
+			body:        `[79927398713, "2377225624"]`,
+			want:        []string{"79927398713", "2377225624"},
+		},
+		{
+			name:        "JSONNotArray",
+			contentType: "application/json",
+			body:        `{"number": "79927398713"}`,
+			wantErr:     errBatchBody,
+		},
+		{
+			name:        "JSONObjectItem",
+			contentType: "application/json",
+			body:        `[{"number": "79927398713"}]`,
+			wantErr:     errBatchBody,
+		},
+		{
+			name:        "NewlineDelimited",
+			contentType: "text/plain",
+			body:        "79927398713\r\n\n  2377225624  \nabc\n",
+			want:        []string{"79927398713", "2377225624", "abc"},
+		},
+		{
+			name: "NoContentType",
+			body: "79927398713",
+			want: []string{"79927398713"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBatch(tt.contentType, []byte(tt.body))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parseBatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("parseBatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
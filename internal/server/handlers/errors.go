@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/internal/webhooks"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"github.com/renatus-cartesius/gophermart/pkg/problem"
+	"go.uber.org/zap"
+)
+
+var (
+	problemOrderInvalid       = problem.New(http.StatusUnprocessableEntity, "order-invalid", "Order number is invalid")
+	problemOrderConflict      = problem.New(http.StatusConflict, "order-uploaded-by-another-user", "Order is uploaded by another user")
+	problemOrderWithdrawn     = problem.New(http.StatusConflict, "order-used-by-withdrawal", "Order number is already used by withdrawal")
+	problemOrderNotFound      = problem.New(http.StatusNotFound, "order-not-found", "Order not found")
+	problemNotEnoughPoints    = problem.New(http.StatusPaymentRequired, "not-enough-points", "Not enough points on balance")
+	problemWithdrawInvalidSum = problem.New(http.StatusUnprocessableEntity, "withdraw-invalid-sum", "Withdrawal sum must be positive")
+	problemWithdrawDuplicate  = problem.New(http.StatusConflict, "withdraw-order-used", "Order number is already used")
+	problemInvalidFilter      = problem.New(http.StatusBadRequest, "invalid-list-filter", "List filter is invalid")
+	problemInvalidCursor      = problem.New(http.StatusBadRequest, "invalid-cursor", "List cursor is invalid")
+	problemBatchEmpty         = problem.New(http.StatusBadRequest, "batch-empty", "Batch has no order numbers")
+	problemBatchTooLarge      = problem.New(http.StatusRequestEntityTooLarge, "batch-too-large", "Batch has too many order numbers")
+	problemUserExists         = problem.New(http.StatusConflict, "user-exists", "Login is already registered")
+	problemInvalidCredentials = problem.New(http.StatusUnauthorized, "invalid-credentials", "Login or password is incorrect")
+	problemWebhookNotFound    = problem.New(http.StatusNotFound, "webhook-not-found", "Webhook not found")
+	problemWebhookInvalidURL  = problem.New(http.StatusBadRequest, "webhook-invalid-url", "Webhook URL must be absolute https URL of public host")
+	problemWebhookSecret      = problem.New(http.StatusBadRequest, "webhook-invalid-secret", "Webhook secret is too short")
+	problemWebhookLimit       = problem.New(http.StatusConflict, "webhook-limit", "Too many webhooks registered")
+	problemDeliveryNotFound   = problem.New(http.StatusNotFound, "webhook-delivery-not-found", "Webhook delivery not found")
+	problemRefreshInvalid     = problem.New(http.StatusUnauthorized, "refresh-token-invalid", "Refresh token is invalid or expired")
+	problemRefreshReused      = problem.New(http.StatusUnauthorized, "refresh-token-reused", "Refresh token is reused, session is revoked")
+	problemSessionNotFound    = problem.New(http.StatusNotFound, "session-not-found", "Session not found")
+	problemLoginLocked        = problem.New(http.StatusTooManyRequests, "login-locked", "Too many failed login attempts")
+)
+
+// errorProblems maps sentinel errors returned to handlers to kinds of problems and details shown to client
+var errorProblems = []struct {
+	err    error
+	kind   problem.Kind
+	detail string
+}{
+	{loyalty.ErrOrderInvalid, problemOrderInvalid, "order number must consist of digits and pass Luhn check"},
+	{loyalty.ErrOrderUploadedAnotherUser, problemOrderConflict, "order number is already uploaded by another user"},
+	{loyalty.ErrOrderUsedByWithdrawal, problemOrderWithdrawn, "order number is already used by withdrawal"},
+	{loyalty.ErrOrderNotFound, problemOrderNotFound, "order isn`t uploaded by user"},
+	{loyalty.ErrWithdrawNotEnoughPoints, problemNotEnoughPoints, "withdrawal sum is greater than current balance"},
+	{loyalty.ErrWithdrawInvalidSum, problemWithdrawInvalidSum, "withdrawal sum must be positive"},
+	{loyalty.ErrWithdrawOrderDuplicate, problemWithdrawDuplicate, "order number is already used by another withdrawal"},
+	{loyalty.ErrInvalidFilter, problemInvalidFilter, fmt.Sprintf("limit must be between 1 and %d, status must be order status, from must be before to", loyalty.MaxPageLimit)},
+	{loyalty.ErrInvalidCursor, problemInvalidCursor, "cursor must be taken from the previous page"},
+	{loyalty.ErrBatchEmpty, problemBatchEmpty, "batch must have at least one order number"},
+	{loyalty.ErrBatchTooLarge, problemBatchTooLarge, fmt.Sprintf("batch must have at most %d order numbers", loyalty.MaxBatchSize)},
+	{auth.ErrUserAlreadyExists, problemUserExists, "login is already registered"},
+	{auth.ErrIncorrectUserCredentials, problemInvalidCredentials, "login or password is incorrect"},
+	{auth.ErrRefreshTokenInvalid, problemRefreshInvalid, "refresh token is invalid or expired"},
+	{auth.ErrRefreshTokenReused, problemRefreshReused, "refresh token is already used, session is revoked"},
+	{auth.ErrSessionNotFound, problemSessionNotFound, "session isn`t found among user sessions"},
+	{auth.ErrLoginLocked, problemLoginLocked, "login is locked after too many failed attempts, retry later"},
+	{webhooks.ErrWebhookNotFound, problemWebhookNotFound, "webhook isn`t registered by user"},
+	{webhooks.ErrWebhookInvalidURL, problemWebhookInvalidURL, "webhook url must be absolute https url of public host"},
+	{webhooks.ErrWebhookSecret, problemWebhookSecret, "webhook secret must be at least 32 bytes"},
+	{webhooks.ErrWebhookLimit, problemWebhookLimit, "user has registered maximum count of webhooks"},
+	{webhooks.ErrDeliveryNotFound, problemDeliveryNotFound, "delivery isn`t found among deliveries of user webhook"},
+}
+
+// writeError responds with problem of err. Known errors are explained to client with fixed detail of their
+// problem, unknown ones are hidden behind internal problem. Underlying error is only logged with msg and request ID.
+func writeError(w http.ResponseWriter, r *http.Request, msg string, err error, fields ...zap.Field) {
+	fields = append(fields, zap.String("requestID", middleware.GetReqID(r.Context())), zap.Error(err))
+
+	for _, ep := range errorProblems {
+		if errors.Is(err, ep.err) {
+			logger.Log.Debug(
+				msg,
+				append(fields, zap.String("problem", ep.kind.Type))...,
+			)
+			ep.kind.Write(w, r, ep.detail)
+			return
+		}
+	}
+
+	logger.Log.Error(
+		msg,
+		fields...,
+	)
+	problem.Internal.Write(w, r, "")
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/internal/server/middlewares"
+	"github.com/renatus-cartesius/gophermart/pkg/problem"
+)
+
+func Test_writeError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantType   string
+		wantDetail string
+	}{
+		{
+			name:       "KnownError",
+			err:        loyalty.ErrWithdrawNotEnoughPoints,
+			wantStatus: http.StatusPaymentRequired,
+			wantType:   problemNotEnoughPoints.Type,
+			wantDetail: "withdrawal sum is greater than current balance",
+		},
+		{
+			name:       "WrappedKnownError",
+			err:        fmt.Errorf("parsing cursor 1734567890: %w", loyalty.ErrInvalidCursor),
+			wantStatus: http.StatusBadRequest,
+			wantType:   problemInvalidCursor.Type,
+			wantDetail: "cursor must be taken from the previous page",
+		},
+		{
+			name:       "UnknownError",
+			err:        errors.New("connection refused"),
+			wantStatus: http.StatusInternalServerError,
+			wantType:   problem.Internal.Type,
+			wantDetail: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := middlewares.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, r, "test error", tt.err)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+			req.Header.Set("X-Request-Id", "b5a0c6c2-8e5e-4bb4-a0b1-6f7a5e9c1d2e")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("writeError() status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != problem.ContentType {
+				t.Errorf("writeError() Content-Type = %v, want %v", ct, problem.ContentType)
+			}
+			if id := rec.Header().Get("X-Request-Id"); id != "b5a0c6c2-8e5e-4bb4-a0b1-6f7a5e9c1d2e" {
+				t.Errorf("writeError() X-Request-Id = %v, want passed request ID", id)
+			}
+
+			var p problem.Problem
+			if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+				t.Fatalf("error on decoding problem: %v", err)
+			}
+			if p.Type != tt.wantType || p.Status != tt.wantStatus {
+				t.Errorf("writeError() problem = %v %v, want %v %v", p.Type, p.Status, tt.wantType, tt.wantStatus)
+			}
+			// Underlying error isn`t shown to client
+			if p.Detail != tt.wantDetail {
+				t.Errorf("writeError() detail = %q, want %q", p.Detail, tt.wantDetail)
+			}
+			if p.RequestID != "b5a0c6c2-8e5e-4bb4-a0b1-6f7a5e9c1d2e" || p.Instance != "/api/user/orders" {
+				t.Errorf("writeError() request_id = %v, instance = %v", p.RequestID, p.Instance)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"github.com/renatus-cartesius/gophermart/pkg/problem"
+	"go.uber.org/zap"
+)
+
+// eventsHeartbeat is interval of comments keeping idle event stream alive through proxies
+const eventsHeartbeat = 15 * time.Second
+
+// OrderEvents streams status changes of the user orders as Server-Sent Events.
+// Client reconnecting with Last-Event-ID header gets events it has missed first.
+func (s ServerHandler) OrderEvents(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.Username("userID")).(string)
+
+	var lastEventID uint64
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			logger.Log.Debug(
+				"client passed invalid Last-Event-ID",
+				zap.String("lastEventID", value),
+			)
+			problem.BadRequest.Write(w, r, "Last-Event-ID header must be unsigned integer")
+			return
+		}
+		lastEventID = id
+	}
+
+	rc := http.NewResponseController(w)
+
+	replay, events, cancel := s.l.Events().Subscribe(userID, lastEventID)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range replay {
+		if err := writeOrderEvent(w, event); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		logger.Log.Error(
+			"event stream can`t be flushed",
+			zap.Error(err),
+		)
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				// Subscriber fell behind or server is shutting down, client reconnects with Last-Event-ID
+				return
+			}
+			if err := writeOrderEvent(w, event); err != nil {
+				return
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeOrderEvent(w http.ResponseWriter, event *loyalty.OrderStatusEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: order\ndata: %s\n\n", event.ID, data)
+	return err
+}
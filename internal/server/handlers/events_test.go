@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/pkg/money"
+)
+
+func TestServerHandler_OrderEvents(t *testing.T) {
+	l := loyalty.NewLoyalty(nil, nil, 1, 0)
+	s := ServerHandler{l: l}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), auth.Username("userID"), "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8")
+		s.OrderEvents(w, r.WithContext(ctx))
+	}))
+	defer srv.Close()
+
+	// IDs are given by order history in storage
+	missed := &loyalty.OrderStatusEvent{ID: 41, UserID: "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8", OrderID: "79927398713", Status: loyalty.TypeStatusProcessing}
+	l.Events().Publish(missed)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(missed.ID-1, 10))
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("error on requesting events: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %v, want text/event-stream", ct)
+	}
+
+	// Handler is subscribed when headers are sent
+	l.Events().Publish(&loyalty.OrderStatusEvent{ID: 42, UserID: "5c18f4b8-bbb8-11ef-bd1a-8bd0750e0c51", OrderID: "4532733309529845", Status: loyalty.TypeStatusProcessed})
+	live := &loyalty.OrderStatusEvent{ID: 43, UserID: "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8", OrderID: "79927398713", Status: loyalty.TypeStatusProcessed, Accrual: money.MustParse("729.98")}
+	l.Events().Publish(live)
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	want := []string{
+		"id: " + strconv.FormatUint(missed.ID, 10),
+		"event: order",
+		`data: {"number":"79927398713","status":"PROCESSING","created_at":"`,
+		"",
+		"id: " + strconv.FormatUint(live.ID, 10),
+		"event: order",
+		`data: {"number":"79927398713","status":"PROCESSED","accrual":729.98,"created_at":"`,
+		"",
+	}
+	for _, prefix := range want {
+		select {
+		case line := <-lines:
+			if !strings.HasPrefix(line, prefix) {
+				t.Fatalf("event stream line = %q, want prefix %q", line, prefix)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event stream line with prefix %q wasn`t received", prefix)
+		}
+	}
+
+	l.Events().Close()
+	select {
+	case _, ok := <-lines:
+		if ok {
+			t.Errorf("event stream got unexpected data after hub was closed")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("event stream wasn`t closed with the hub")
+	}
+}
@@ -5,125 +5,131 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/renatus-cartesius/gophermart/internal/auth"
 	"github.com/renatus-cartesius/gophermart/internal/loyalty"
 	"github.com/renatus-cartesius/gophermart/internal/server/middlewares"
+	"github.com/renatus-cartesius/gophermart/internal/webhooks"
 	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"github.com/renatus-cartesius/gophermart/pkg/problem"
 	"go.uber.org/zap"
 )
 
 func Setup(r *chi.Mux, srv *ServerHandler) {
 
+	r.Use(middlewares.RequestID)
+
+	r.Get("/.well-known/jwks.json", logger.RequestLogger(srv.JWKS))
+
 	r.Route("/api", func(r chi.Router) {
 		r.Route("/user", func(r chi.Router) {
 			r.Get("/orders", srv.a.AuthMiddleWare(middlewares.Gzipper(logger.RequestLogger(srv.GetOrders))))
+			r.Get("/orders/events", srv.a.AuthMiddleWare(logger.RequestLogger(srv.OrderEvents)))
+			r.Get("/orders/{number}", srv.a.AuthMiddleWare(middlewares.Gzipper(logger.RequestLogger(srv.GetOrder))))
+			r.Post("/orders/batch", srv.a.AuthMiddleWare(middlewares.Gzipper(middlewares.Idempotency(srv.idempotency, logger.RequestLogger(srv.UploadOrders)))))
 			r.Get("/withdrawals", srv.a.AuthMiddleWare(middlewares.Gzipper(logger.RequestLogger(srv.GetWithdrawals))))
-			r.Post("/orders", middlewares.ValidateJSON(middlewares.ValidateNumber(srv.a.AuthMiddleWare(middlewares.Gzipper(logger.RequestLogger(srv.UploadOrder))))))
+			r.Post("/orders", middlewares.ValidateJSON(middlewares.ValidateNumber(srv.a.AuthMiddleWare(middlewares.Gzipper(middlewares.Idempotency(srv.idempotency, logger.RequestLogger(srv.UploadOrder)))))))
 			r.Route("/balance", func(r chi.Router) {
 				r.Get("/", srv.a.AuthMiddleWare(middlewares.Gzipper(logger.RequestLogger(srv.GetBalance))))
-				r.Post("/withdraw", middlewares.ValidateJSON(srv.a.AuthMiddleWare(middlewares.Gzipper(logger.RequestLogger(srv.Withdraw)))))
+				r.Get("/history", srv.a.AuthMiddleWare(middlewares.Gzipper(logger.RequestLogger(srv.GetBalanceHistory))))
+				r.Post("/withdraw", middlewares.ValidateJSON(srv.a.AuthMiddleWare(middlewares.Gzipper(middlewares.Idempotency(srv.idempotency, logger.RequestLogger(srv.Withdraw))))))
+			})
+			r.Route("/webhooks", func(r chi.Router) {
+				r.Get("/", srv.a.AuthMiddleWare(middlewares.Gzipper(logger.RequestLogger(srv.GetWebhooks))))
+				r.Post("/", middlewares.ValidateJSON(srv.a.AuthMiddleWare(middlewares.Gzipper(logger.RequestLogger(srv.RegisterWebhook)))))
+				r.Delete("/{id}", srv.a.AuthMiddleWare(logger.RequestLogger(srv.DeleteWebhook)))
+				r.Get("/{id}/deliveries", srv.a.AuthMiddleWare(middlewares.Gzipper(logger.RequestLogger(srv.GetWebhookDeliveries))))
+				r.Post("/deliveries/{id}/redeliver", srv.a.AuthMiddleWare(logger.RequestLogger(srv.RedeliverWebhook)))
 			})
 			r.Post("/register", middlewares.ValidateJSON(middlewares.Gzipper(logger.RequestLogger(srv.RegisterUser))))
 			r.Post("/login", middlewares.ValidateJSON(middlewares.Gzipper(logger.RequestLogger(srv.LoginUser))))
+			r.Post("/token/refresh", logger.RequestLogger(srv.RefreshToken))
+			r.Post("/logout", srv.a.AuthMiddleWare(logger.RequestLogger(srv.Logout)))
+			r.Get("/sessions", srv.a.AuthMiddleWare(middlewares.Gzipper(logger.RequestLogger(srv.GetSessions))))
+			r.Delete("/sessions/{id}", srv.a.AuthMiddleWare(logger.RequestLogger(srv.RevokeSession)))
 		})
 	})
 }
 
 type ServerHandler struct {
-	l *loyalty.Loyalty
-	a auth.Auther
+	l           *loyalty.Loyalty
+	a           auth.Auther
+	idempotency middlewares.IdempotencyStorager
+	webhooks    *webhooks.Webhooks
 }
 
-func NewServerHandler(l *loyalty.Loyalty, a auth.Auther) *ServerHandler {
+func NewServerHandler(l *loyalty.Loyalty, a auth.Auther, idempotency middlewares.IdempotencyStorager, webhooks *webhooks.Webhooks) *ServerHandler {
 	return &ServerHandler{
-		l: l,
-		a: a,
+		l:           l,
+		a:           a,
+		idempotency: idempotency,
+		webhooks:    webhooks,
 	}
 }
 
 func (s ServerHandler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	ar := &auth.AuthRequest{}
 	if err := json.NewDecoder(r.Body).Decode(&ar); err != nil {
-		logger.Log.Error(
+		logger.Log.Debug(
 			"error on unmarshalling auth request body",
 			zap.Error(err),
 		)
-		w.WriteHeader(http.StatusBadRequest)
+		problem.BadRequest.Write(w, r, "auth request body must be JSON object with login and password")
 		return
 	}
 
-	authCookie, err := s.a.RegisterUser(r.Context(), ar)
+	tokens, err := s.a.RegisterUser(r.Context(), ar, s.a.ClientFromRequest(r))
 	if err != nil {
-		if errors.Is(err, auth.ErrUserAlreadyExists) {
-			logger.Log.Error(
-				"trying to register user with already registered login",
-				zap.Error(err),
-			)
-			w.WriteHeader(http.StatusConflict)
-			return
-		} else {
-			logger.Log.Error(
-				"error when registering user",
-				zap.Error(err),
-			)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+		writeError(w, r, "error when registering user", err)
+		return
 	}
 
-	http.SetCookie(w, authCookie)
-	w.WriteHeader(http.StatusOK)
+	s.writeTokens(w, tokens)
 }
 
 func (s ServerHandler) LoginUser(w http.ResponseWriter, r *http.Request) {
 	ar := &auth.AuthRequest{}
 	if err := json.NewDecoder(r.Body).Decode(&ar); err != nil {
-		logger.Log.Error(
+		logger.Log.Debug(
 			"error on unmarshalling auth request body",
 			zap.Error(err),
 		)
-		w.WriteHeader(http.StatusBadRequest)
+		problem.BadRequest.Write(w, r, "auth request body must be JSON object with login and password")
 		return
 	}
 
-	authCookie, err := s.a.LoginUser(r.Context(), ar)
+	tokens, err := s.a.LoginUser(r.Context(), ar, s.a.ClientFromRequest(r))
 	if err != nil {
-		if errors.Is(err, auth.ErrIncorrectUserCredentials) {
-			logger.Log.Error(
-				"trying to login user with invalid credentials",
-				zap.Error(err),
-			)
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		} else {
-			logger.Log.Error(
-				"error when login user",
-				zap.Error(err),
-			)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		var lockedErr *auth.LoginLockedError
+		if errors.As(err, &lockedErr) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(lockedErr.RetryAfter(time.Now()).Seconds())))
 		}
+		writeError(w, r, "error when login user", err)
+		return
 	}
 
-	http.SetCookie(w, authCookie)
-	w.WriteHeader(http.StatusOK)
+	s.writeTokens(w, tokens)
 }
 
 func (s ServerHandler) GetOrders(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(auth.Username("userID")).(string)
 
-	orders, err := s.l.GetOrders(r.Context(), userID)
+	filter, err := parseListFilter(r)
 	if err != nil {
-		logger.Log.Error(
-			"error on getting orders from loyalty storage",
-			zap.Error(err),
-		)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, "client passed invalid list filter", err)
+		return
+	}
+
+	orders, next, err := s.l.GetOrders(r.Context(), userID, filter)
+	if err != nil {
+		writeError(w, r, "error on getting orders from loyalty storage", err, zap.String("userID", userID))
 		return
 	}
 
+	setNextCursor(w, next)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(orders); err != nil {
@@ -137,19 +143,45 @@ func (s ServerHandler) GetOrders(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s ServerHandler) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
+// GetOrder returns order of the user with its processing history
+func (s ServerHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(auth.Username("userID")).(string)
+	orderID := chi.URLParam(r, "number")
 
-	withdrawals, err := s.l.GetWithdrawals(r.Context(), userID)
+	details, err := s.l.GetUserOrder(r.Context(), userID, orderID)
 	if err != nil {
+		writeError(w, r, "error on getting order from loyalty storage", err, zap.String("userID", userID), zap.String("orderID", orderID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(details); err != nil {
 		logger.Log.Error(
-			"error on getting withdrawals from loyalty storage",
+			"error on marshalling order for user",
+			zap.String("userID", userID),
 			zap.Error(err),
 		)
-		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s ServerHandler) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.Username("userID")).(string)
+
+	filter, err := parseListFilter(r)
+	if err != nil {
+		writeError(w, r, "client passed invalid list filter", err)
 		return
 	}
 
+	withdrawals, next, err := s.l.GetWithdrawals(r.Context(), userID, filter)
+	if err != nil {
+		writeError(w, r, "error on getting withdrawals from loyalty storage", err, zap.String("userID", userID))
+		return
+	}
+
+	setNextCursor(w, next)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(withdrawals); err != nil {
@@ -168,33 +200,11 @@ func (s ServerHandler) UploadOrder(w http.ResponseWriter, r *http.Request) {
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		logger.Log.Error(
-			"error on reading request body",
-			zap.Error(err),
-		)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, "error on reading request body", err)
 		return
 	}
 
 	if err = s.l.UploadOrder(r.Context(), userID, string(body)); err != nil {
-		if errors.Is(err, loyalty.ErrOrderInvalid) {
-			logger.Log.Error(
-				"client passed invalid order number",
-				zap.Error(err),
-				zap.String("userID", userID),
-			)
-			w.WriteHeader(http.StatusUnprocessableEntity)
-			return
-		}
-		if errors.Is(err, loyalty.ErrOrderUploadedAnotherUser) {
-			logger.Log.Error(
-				"client passed order already uploaded by another user",
-				zap.Error(err),
-				zap.String("userID", userID),
-			)
-			w.WriteHeader(http.StatusConflict)
-			return
-		}
 		if errors.Is(err, loyalty.ErrOrderAlreadyUploaded) {
 			logger.Log.Debug(
 				"client passed order already by this user",
@@ -203,11 +213,7 @@ func (s ServerHandler) UploadOrder(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
-		logger.Log.Error(
-			"something went wrong when uploading order",
-			zap.Error(err),
-		)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, "something went wrong when uploading order", err, zap.String("userID", userID))
 		return
 	}
 
@@ -219,11 +225,7 @@ func (s ServerHandler) GetBalance(w http.ResponseWriter, r *http.Request) {
 
 	balance, err := s.l.GetBalance(r.Context(), userID)
 	if err != nil {
-		logger.Log.Error(
-			"error when getting balance",
-			zap.Error(err),
-		)
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, "error when getting balance", err, zap.String("userID", userID))
 		return
 	}
 
@@ -240,39 +242,61 @@ func (s ServerHandler) GetBalance(w http.ResponseWriter, r *http.Request) {
 
 }
 
+func (s ServerHandler) GetBalanceHistory(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.Username("userID")).(string)
+
+	history, err := s.l.GetBalanceHistory(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, "error when getting balance history", err, zap.String("userID", userID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		logger.Log.Error(
+			"error when marshalling balance history",
+			zap.String("userID", userID),
+			zap.Error(err),
+		)
+		return
+	}
+}
+
 func (s ServerHandler) Withdraw(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(auth.Username("userID")).(string)
 
 	withdrawRequest := &loyalty.Withdraw{}
 
 	if err := json.NewDecoder(r.Body).Decode(&withdrawRequest); err != nil {
-		logger.Log.Error(
+		logger.Log.Debug(
 			"error on unmarshalling withdrawRequest body",
 			zap.Error(err),
 		)
-		w.WriteHeader(http.StatusBadRequest)
+		problem.BadRequest.Write(w, r, "withdraw request body must be JSON object with order and sum")
 		return
 	}
 
 	withdrawRequest.UserID = userID
 
 	if err := s.l.Withdraw(r.Context(), withdrawRequest); err != nil {
-		if errors.Is(err, loyalty.ErrWithdrawNotEnoughPoints) {
-			w.WriteHeader(http.StatusPaymentRequired)
-		}
-		if errors.Is(err, loyalty.ErrOrderInvalid) {
-			w.WriteHeader(http.StatusUnprocessableEntity)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
+		writeError(w, r, "error when making withdraw", err, zap.String("userID", userID), zap.String("orderID", withdrawRequest.OrderID))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// JWKS returns public keys other services may verify auth tokens with
+func (s ServerHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=300")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(s.a.JWKS()); err != nil {
 		logger.Log.Error(
-			"error when making withdraw",
-			zap.String("userID", userID),
-			zap.String("orderID", withdrawRequest.OrderID),
+			"error on marshalling JWKS",
 			zap.Error(err),
 		)
 		return
 	}
-
-	w.WriteHeader(http.StatusOK)
 }
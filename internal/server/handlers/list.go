@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+)
+
+// NextCursorHeader carries cursor of the next page of orders or withdrawals,
+// it isn`t set for the last page, so response body stays a plain array
+const NextCursorHeader = "X-Next-Cursor"
+
+// parseListFilter reads limit, cursor, status, from and to query parameters.
+// Times are accepted in RFC 3339 format or as dates in UTC.
+func parseListFilter(r *http.Request) (loyalty.ListFilter, error) {
+	query := r.URL.Query()
+	filter := loyalty.ListFilter{
+		Status: query.Get("status"),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return filter, fmt.Errorf("%w: invalid limit %q", loyalty.ErrInvalidFilter, limit)
+		}
+		filter.Limit = n
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err := loyalty.ParseCursor(cursor)
+		if err != nil {
+			return filter, err
+		}
+		filter.After = after
+	}
+
+	var err error
+	if filter.From, err = parseListTime(query.Get("from")); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseListTime(query.Get("to")); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+func parseListTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("%w: invalid time %q", loyalty.ErrInvalidFilter, value)
+}
+
+// setNextCursor sets NextCursorHeader if there is the next page
+func setNextCursor(w http.ResponseWriter, next *loyalty.Cursor) {
+	if next != nil {
+		w.Header().Set(NextCursorHeader, next.String())
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+)
+
+func Test_parseListFilter(t *testing.T) {
+	cursor := &loyalty.Cursor{Time: time.Date(2024, 12, 27, 10, 0, 0, 0, time.UTC), ID: "79927398713"}
+
+	tests := []struct {
+		name    string
+		query   string
+		want    loyalty.ListFilter
+		wantErr error
+	}{
+		{
+			name:  "Empty",
+			query: "",
+			want:  loyalty.ListFilter{},
+		},
+		{
+			name:  "All",
+			query: "?limit=10&status=PROCESSED&from=2024-12-01&to=2024-12-27T10:00:00Z&cursor=" + cursor.String(),
+			want: loyalty.ListFilter{
+				Status: loyalty.TypeStatusProcessed,
+				From:   time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC),
+				To:     time.Date(2024, 12, 27, 10, 0, 0, 0, time.UTC),
+				Limit:  10,
+				After:  cursor,
+			},
+		},
+		{
+			name:    "InvalidLimit",
+			query:   "?limit=-1",
+			wantErr: loyalty.ErrInvalidFilter,
+		},
+		{
+			name:    "InvalidTime",
+			query:   "?from=yesterday",
+			wantErr: loyalty.ErrInvalidFilter,
+		},
+		{
+			name:    "InvalidCursor",
+			query:   "?cursor=!!!",
+			wantErr: loyalty.ErrInvalidCursor,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseListFilter(httptest.NewRequest("GET", "/api/user/orders"+tt.query, nil))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parseListFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			if got.Status != tt.want.Status || got.Limit != tt.want.Limit || !got.From.Equal(tt.want.From) || !got.To.Equal(tt.want.To) {
+				t.Errorf("parseListFilter() = %+v, want %+v", got, tt.want)
+			}
+			if (got.After == nil) != (tt.want.After == nil) || got.After != nil && (!got.After.Time.Equal(tt.want.After.Time) || got.After.ID != tt.want.After.ID) {
+				t.Errorf("parseListFilter() cursor = %+v, want %+v", got.After, tt.want.After)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/pkg/problem"
+)
+
+func TestServerHandler_InvalidOrder(t *testing.T) {
+	s := ServerHandler{l: loyalty.NewLoyalty(nil, &loyalty.MockLoyaltyStorager{}, 1, 0)}
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		body     string
+		wantType string
+	}{
+		{
+			name:     "UploadNonNumericOrder",
+			handler:  s.UploadOrder,
+			body:     "abc",
+			wantType: problemOrderInvalid.Type,
+		},
+		{
+			name:     "WithdrawNonNumericOrder",
+			handler:  s.Withdraw,
+			body:     `{"order":"abc","sum":1}`,
+			wantType: problemOrderInvalid.Type,
+		},
+		{
+			name:     "WithdrawLuhnInvalidOrder",
+			handler:  s.Withdraw,
+			body:     `{"order":"2","sum":1}`,
+			wantType: problemOrderInvalid.Type,
+		},
+		{
+			name:     "WithdrawNonPositiveSum",
+			handler:  s.Withdraw,
+			body:     `{"order":"abc","sum":0}`,
+			wantType: problemWithdrawInvalidSum.Type,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), auth.Username("userID"), "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8"))
+			rec := httptest.NewRecorder()
+			tt.handler(rec, req)
+
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Errorf("status = %v, want %v", rec.Code, http.StatusUnprocessableEntity)
+			}
+
+			p := &problem.Problem{}
+			if err := json.NewDecoder(rec.Body).Decode(p); err != nil {
+				t.Fatalf("error on decoding problem: %v", err)
+			}
+			if p.Type != tt.wantType {
+				t.Errorf("problem type = %v, want %v", p.Type, tt.wantType)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"github.com/renatus-cartesius/gophermart/pkg/problem"
+	"go.uber.org/zap"
+)
+
+// tokenResponse is body of responses issuing tokens for clients which don`t keep cookies
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// writeTokens responds with tokens in cookies, Authorization header and body
+func (s ServerHandler) writeTokens(w http.ResponseWriter, tokens *auth.Tokens) {
+	s.a.SetCookies(w, tokens)
+	w.Header().Set("Authorization", auth.BearerScheme+" "+tokens.AccessToken)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	now := time.Now()
+	err := json.NewEncoder(w).Encode(tokenResponse{
+		AccessToken:      tokens.AccessToken,
+		TokenType:        auth.BearerScheme,
+		ExpiresIn:        int64(tokens.AccessExpires.Sub(now).Seconds()),
+		RefreshToken:     tokens.RefreshToken,
+		RefreshExpiresIn: int64(tokens.RefreshExpires.Sub(now).Seconds()),
+	})
+	if err != nil {
+		logger.Log.Error(
+			"error on marshalling tokens",
+			zap.Error(err),
+		)
+	}
+}
+
+// RefreshToken exchanges refresh token for new pair of tokens,
+// token is taken from JSON body and from cookie if body has none
+func (s ServerHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	req := &refreshRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil && !errors.Is(err, io.EOF) {
+		logger.Log.Debug(
+			"error on unmarshalling refresh request body",
+			zap.Error(err),
+		)
+		problem.BadRequest.Write(w, r, "refresh request body must be empty or JSON object with refresh_token")
+		return
+	}
+
+	refreshToken := req.RefreshToken
+	if cookie, err := r.Cookie(auth.RefreshCookie); err == nil && refreshToken == "" {
+		refreshToken = cookie.Value
+	}
+
+	tokens, err := s.a.Refresh(r.Context(), refreshToken)
+	if err != nil {
+		if errors.Is(err, auth.ErrRefreshTokenInvalid) || errors.Is(err, auth.ErrRefreshTokenReused) {
+			s.a.ClearCookies(w)
+		}
+		writeError(w, r, "error when refreshing tokens", err)
+		return
+	}
+
+	s.writeTokens(w, tokens)
+}
+
+// Logout revokes session of the request, its access and refresh tokens aren`t accepted anymore
+func (s ServerHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.Username("userID")).(string)
+	sessionID := r.Context().Value(auth.Username("sessionID")).(string)
+
+	if err := s.a.RevokeSession(r.Context(), userID, sessionID); err != nil && !errors.Is(err, auth.ErrSessionNotFound) {
+		writeError(w, r, "error on revoking session", err, zap.String("userID", userID), zap.String("sessionID", sessionID))
+		return
+	}
+
+	s.a.ClearCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetSessions returns active sessions of the user marking session of the request as current
+func (s ServerHandler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.Username("userID")).(string)
+	sessionID := r.Context().Value(auth.Username("sessionID")).(string)
+
+	sessions, err := s.a.GetSessions(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, "error on getting sessions", err, zap.String("userID", userID))
+		return
+	}
+
+	for _, session := range sessions {
+		session.Current = session.ID == sessionID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(sessions); err != nil {
+		logger.Log.Error(
+			"error on marshalling sessions",
+			zap.String("userID", userID),
+			zap.Error(err),
+		)
+		return
+	}
+}
+
+// RevokeSession revokes session of the user, it can`t be refreshed anymore
+func (s ServerHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.Username("userID")).(string)
+	sessionID := chi.URLParam(r, "id")
+
+	if err := s.a.RevokeSession(r.Context(), userID, sessionID); err != nil {
+		writeError(w, r, "error on revoking session", err, zap.String("userID", userID), zap.String("sessionID", sessionID))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
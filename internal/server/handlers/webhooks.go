@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"github.com/renatus-cartesius/gophermart/pkg/problem"
+	"go.uber.org/zap"
+)
+
+type webhookRequest struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// RegisterWebhook registers callback URL of the user, secret of signatures is returned only here
+func (s ServerHandler) RegisterWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.Username("userID")).(string)
+
+	req := &webhookRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		logger.Log.Debug(
+			"error on unmarshalling webhook request body",
+			zap.Error(err),
+		)
+		problem.BadRequest.Write(w, r, "webhook request body must be JSON object with url")
+		return
+	}
+
+	hook, err := s.webhooks.Register(r.Context(), userID, req.URL, req.Secret)
+	if err != nil {
+		writeError(w, r, "error on registering webhook", err, zap.String("userID", userID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(hook); err != nil {
+		logger.Log.Error(
+			"error on marshalling webhook",
+			zap.String("userID", userID),
+			zap.Error(err),
+		)
+		return
+	}
+}
+
+func (s ServerHandler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.Username("userID")).(string)
+
+	hooks, err := s.webhooks.GetWebhooks(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, "error on getting webhooks", err, zap.String("userID", userID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(hooks); err != nil {
+		logger.Log.Error(
+			"error on marshalling webhooks",
+			zap.String("userID", userID),
+			zap.Error(err),
+		)
+		return
+	}
+}
+
+func (s ServerHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.Username("userID")).(string)
+	webhookID := chi.URLParam(r, "id")
+
+	if err := s.webhooks.Delete(r.Context(), userID, webhookID); err != nil {
+		writeError(w, r, "error on deleting webhook", err, zap.String("userID", userID), zap.String("webhookID", webhookID))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetWebhookDeliveries returns delivery log of the user webhook from the newest delivery
+func (s ServerHandler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.Username("userID")).(string)
+	webhookID := chi.URLParam(r, "id")
+
+	deliveries, err := s.webhooks.GetDeliveries(r.Context(), userID, webhookID)
+	if err != nil {
+		writeError(w, r, "error on getting webhook deliveries", err, zap.String("userID", userID), zap.String("webhookID", webhookID))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(deliveries); err != nil {
+		logger.Log.Error(
+			"error on marshalling webhook deliveries",
+			zap.String("userID", userID),
+			zap.Error(err),
+		)
+		return
+	}
+}
+
+// RedeliverWebhook schedules delivery of the user to be sent again
+func (s ServerHandler) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.Username("userID")).(string)
+
+	deliveryID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		problemDeliveryNotFound.Write(w, r, "delivery id must be integer")
+		return
+	}
+
+	if err := s.webhooks.Redeliver(r.Context(), userID, deliveryID); err != nil {
+		writeError(w, r, "error on redelivering webhook delivery", err, zap.String("userID", userID), zap.Int64("deliveryID", deliveryID))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"github.com/renatus-cartesius/gophermart/pkg/problem"
 	"go.uber.org/zap"
 )
 
@@ -91,7 +92,7 @@ func Gzipper(h http.HandlerFunc) http.HandlerFunc {
 					"error on creating new gzip reader",
 					zap.Error(err),
 				)
-				w.WriteHeader(http.StatusInternalServerError)
+				problem.Internal.Write(w, r, "")
 				return
 			}
 
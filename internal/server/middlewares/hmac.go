@@ -9,6 +9,7 @@ import (
 	"net/http"
 
 	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"github.com/renatus-cartesius/gophermart/pkg/problem"
 	"go.uber.org/zap"
 )
 
@@ -22,7 +23,7 @@ func HmacValidator(key string, h http.HandlerFunc) http.HandlerFunc {
 
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			problem.Internal.Write(w, r, "")
 			logger.Log.Error(
 				"error on reading request body",
 				zap.Error(err),
@@ -33,7 +34,7 @@ func HmacValidator(key string, h http.HandlerFunc) http.HandlerFunc {
 
 		sum, err := base64.StdEncoding.DecodeString(r.Header.Get("HashSHA256"))
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			problem.Internal.Write(w, r, "")
 			logger.Log.Error(
 				"error on decoding base64 sha256 hash sum",
 				zap.Error(err),
@@ -45,7 +46,7 @@ func HmacValidator(key string, h http.HandlerFunc) http.HandlerFunc {
 		hash.Write(body)
 
 		if !hmac.Equal(sum, hash.Sum(nil)) {
-			w.WriteHeader(http.StatusBadRequest)
+			problem.BadRequest.Write(w, r, "request signature doesn`t match body")
 			logger.Log.Error(
 				"captured invalid sha256 sum",
 				zap.Error(err),
@@ -0,0 +1,239 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"github.com/renatus-cartesius/gophermart/pkg/problem"
+	"go.uber.org/zap"
+)
+
+const (
+	// IdempotencyKeyHeader is request header carrying client generated idempotency key
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses replayed from idempotency storage
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	// idempotencyKeyTTL is how long saved response is replayed for the same key
+	idempotencyKeyTTL = 24 * time.Hour
+	// idempotencyReservationTTL is how long key of unfinished request stays reserved,
+	// so key left by crashed process can be retried soon
+	idempotencyReservationTTL = time.Minute
+	// idempotencyKeyMaxLength limits length of idempotency key
+	idempotencyKeyMaxLength = 255
+	// idempotencyPruneInterval is delay between deletions of keys older than idempotencyKeyTTL
+	idempotencyPruneInterval = time.Hour
+)
+
+var (
+	problemIdempotencyKeyReused  = problem.New(http.StatusUnprocessableEntity, "idempotency-key-reused", "Idempotency key is used with another request")
+	problemIdempotencyInProgress = problem.New(http.StatusConflict, "idempotency-key-in-progress", "Request with idempotency key is still in progress")
+)
+
+// IdempotentRecord is request fingerprint and saved response stored under idempotency key
+type IdempotentRecord struct {
+	UserID      string
+	Key         string
+	Fingerprint string
+	// Completed is false while the first request with the key is being handled
+	Completed   bool
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+type IdempotencyStorager interface {
+	// StartIdempotent reserves key for the request. Completed keys older than ttl and
+	// unfinished keys older than reservationTTL are reserved again.
+	// If key is already reserved the existing record is returned, otherwise nil.
+	StartIdempotent(ctx context.Context, rec *IdempotentRecord, ttl, reservationTTL time.Duration) (*IdempotentRecord, error)
+	// FinishIdempotent saves the response of reserved key
+	FinishIdempotent(ctx context.Context, rec *IdempotentRecord) error
+	// CancelIdempotent removes reservation of key so the request may be retried
+	CancelIdempotent(ctx context.Context, userID, key string) error
+	// PruneIdempotent deletes keys created before the given time and returns their count
+	PruneIdempotent(ctx context.Context, before time.Time) (int64, error)
+}
+
+type recordingWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(statusCode int) {
+	if rw.statusCode == 0 {
+		rw.statusCode = statusCode
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.statusCode == 0 {
+		rw.statusCode = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// requestFingerprint identifies request by method, path and body
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Idempotency saves response of authorized request sent with Idempotency-Key header and replays it
+// for repeated requests with the same key. Reusing key with another payload is answered with 422,
+// concurrent request with key still being handled with 409. Server errors aren`t saved.
+func Idempotency(storage IdempotencyStorager, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			h(w, r)
+			return
+		}
+
+		if len(key) > idempotencyKeyMaxLength {
+			problem.BadRequest.Write(w, r, "idempotency key is too long")
+			logger.Log.Debug(
+				"passed too long idempotency key",
+			)
+			return
+		}
+
+		userID, _ := r.Context().Value(auth.Username("userID")).(string)
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			problem.Internal.Write(w, r, "")
+			logger.Log.Error(
+				"error on reading request body",
+				zap.Error(err),
+			)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+		rec := &IdempotentRecord{
+			UserID:      userID,
+			Key:         key,
+			Fingerprint: requestFingerprint(r, body),
+		}
+
+		existing, err := storage.StartIdempotent(r.Context(), rec, idempotencyKeyTTL, idempotencyReservationTTL)
+		if err != nil {
+			problem.Internal.Write(w, r, "")
+			logger.Log.Error(
+				"error on reserving idempotency key",
+				zap.Error(err),
+			)
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != rec.Fingerprint:
+				problemIdempotencyKeyReused.Write(w, r, "")
+				logger.Log.Debug(
+					"idempotency key reused with another request",
+					zap.String("userID", userID),
+					zap.String("key", key),
+				)
+			case !existing.Completed:
+				problemIdempotencyInProgress.Write(w, r, "")
+				logger.Log.Debug(
+					"request with idempotency key is still in progress",
+					zap.String("userID", userID),
+					zap.String("key", key),
+				)
+			default:
+				if existing.ContentType != "" {
+					w.Header().Set("Content-Type", existing.ContentType)
+				}
+				w.Header().Set(IdempotentReplayedHeader, "true")
+				w.WriteHeader(existing.StatusCode)
+				w.Write(existing.Body)
+			}
+			return
+		}
+
+		ctx := context.WithoutCancel(r.Context())
+
+		// Reservation is released if handler panics or fails with server error, so the request may be retried
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if err := storage.CancelIdempotent(ctx, userID, key); err != nil {
+				logger.Log.Error(
+					"error on cancelling idempotency key",
+					zap.Error(err),
+				)
+			}
+		}()
+
+		rw := &recordingWriter{ResponseWriter: w}
+		h(rw, r)
+
+		if rw.statusCode == 0 {
+			rw.statusCode = http.StatusOK
+		}
+
+		if rw.statusCode >= http.StatusInternalServerError {
+			return
+		}
+
+		completed = true
+		rec.Completed = true
+		rec.StatusCode = rw.statusCode
+		rec.ContentType = w.Header().Get("Content-Type")
+		rec.Body = rw.body.Bytes()
+
+		if err := storage.FinishIdempotent(ctx, rec); err != nil {
+			logger.Log.Error(
+				"error on saving idempotent response",
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// RunIdempotencyPruning deletes idempotency keys older than idempotencyKeyTTL once per
+// idempotencyPruneInterval until ctx is done, expired keys are never replayed anyway
+func RunIdempotencyPruning(ctx context.Context, storage IdempotencyStorager) {
+	ticker := time.NewTicker(idempotencyPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pruned, err := storage.PruneIdempotent(ctx, time.Now().UTC().Add(-idempotencyKeyTTL))
+			if err != nil {
+				logger.Log.Error(
+					"error on pruning idempotency keys",
+					zap.Error(err),
+				)
+				continue
+			}
+
+			if pruned > 0 {
+				logger.Log.Info(
+					"idempotency keys pruned",
+					zap.Int64("count", pruned),
+				)
+			}
+		}
+	}
+}
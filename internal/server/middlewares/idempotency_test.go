@@ -0,0 +1,171 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+)
+
+type mockIdempotencyStorager struct {
+	mu      sync.Mutex
+	records map[string]*IdempotentRecord
+}
+
+func (m *mockIdempotencyStorager) StartIdempotent(ctx context.Context, rec *IdempotentRecord, ttl, reservationTTL time.Duration) (*IdempotentRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.records[rec.UserID+rec.Key]; ok {
+		return existing, nil
+	}
+	m.records[rec.UserID+rec.Key] = rec
+	return nil, nil
+}
+
+func (m *mockIdempotencyStorager) FinishIdempotent(ctx context.Context, rec *IdempotentRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.records[rec.UserID+rec.Key] = rec
+	return nil
+}
+
+func (m *mockIdempotencyStorager) CancelIdempotent(ctx context.Context, userID, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, userID+key)
+	return nil
+}
+
+func (m *mockIdempotencyStorager) PruneIdempotent(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestIdempotency(t *testing.T) {
+	calls := 0
+	status := http.StatusOK
+	h := Idempotency(&mockIdempotencyStorager{records: map[string]*IdempotentRecord{}}, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"call":` + string(rune('0'+calls)) + `}`))
+	})
+
+	tests := []struct {
+		name       string
+		key        string
+		body       string
+		status     int
+		wantStatus int
+		wantBody   string
+		wantCalls  int
+	}{
+		{
+			name:       "WithoutKey",
+			body:       `{"order":"2377225624","sum":751}`,
+			status:     http.StatusOK,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"call":1}`,
+			wantCalls:  1,
+		},
+		{
+			name:       "FirstRequest",
+			key:        "f0c1e4d2",
+			body:       `{"order":"2377225624","sum":751}`,
+			status:     http.StatusOK,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"call":2}`,
+			wantCalls:  2,
+		},
+		{
+			name:       "Replay",
+			key:        "f0c1e4d2",
+			body:       `{"order":"2377225624","sum":751}`,
+			status:     http.StatusOK,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"call":2}`,
+			wantCalls:  2,
+		},
+		{
+			name:       "AnotherPayload",
+			key:        "f0c1e4d2",
+			body:       `{"order":"2377225624","sum":1}`,
+			status:     http.StatusOK,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantCalls:  2,
+		},
+		{
+			name:       "ServerError",
+			key:        "9a7b3c11",
+			body:       `{"order":"2377225624","sum":751}`,
+			status:     http.StatusInternalServerError,
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   `{"call":3}`,
+			wantCalls:  3,
+		},
+		{
+			name:       "RetryAfterServerError",
+			key:        "9a7b3c11",
+			body:       `{"order":"2377225624","sum":751}`,
+			status:     http.StatusOK,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"call":4}`,
+			wantCalls:  4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status = tt.status
+
+			r := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(tt.body))
+			r = r.WithContext(context.WithValue(r.Context(), auth.Username("userID"), "user"))
+			if tt.key != "" {
+				r.Header.Set(IdempotencyKeyHeader, tt.key)
+			}
+			w := httptest.NewRecorder()
+
+			h(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", w.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %v, want %v", w.Body.String(), tt.wantBody)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("handler calls = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestIdempotency_Panic(t *testing.T) {
+	storage := &mockIdempotencyStorager{records: map[string]*IdempotentRecord{}}
+	h := Idempotency(storage, func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/api/user/orders", strings.NewReader("2377225624"))
+	r = r.WithContext(context.WithValue(r.Context(), auth.Username("userID"), "user"))
+	r.Header.Set(IdempotencyKeyHeader, "5e1d7a90")
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("panic of handler isn`t propagated")
+			}
+		}()
+		h(httptest.NewRecorder(), r)
+	}()
+
+	if _, ok := storage.records["user5e1d7a90"]; ok {
+		t.Errorf("idempotency key is still reserved after panic of handler")
+	}
+}
@@ -0,0 +1,16 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// RequestID assigns ID to the request, taking it from X-Request-Id header if client passed one,
+// and returns it in the response header so client can refer to the request
+func RequestID(h http.Handler) http.Handler {
+	return middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(middleware.RequestIDHeader, middleware.GetReqID(r.Context()))
+		h.ServeHTTP(w, r)
+	}))
+}
@@ -8,6 +8,7 @@ import (
 	"strconv"
 
 	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"github.com/renatus-cartesius/gophermart/pkg/problem"
 	"go.uber.org/zap"
 )
 
@@ -16,7 +17,7 @@ func ValidateNumber(h http.HandlerFunc) http.HandlerFunc {
 
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			problem.Internal.Write(w, r, "")
 			logger.Log.Error(
 				"error on reading request body",
 				zap.Error(err),
@@ -27,7 +28,7 @@ func ValidateNumber(h http.HandlerFunc) http.HandlerFunc {
 
 		_, err = strconv.ParseInt(string(body), 10, 64)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			problem.BadRequest.Write(w, r, "request body must be order number")
 			logger.Log.Debug(
 				"passed invalid number",
 				zap.Error(err),
@@ -44,7 +45,7 @@ func ValidateJSON(h http.HandlerFunc) http.HandlerFunc {
 
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			problem.Internal.Write(w, r, "")
 			logger.Log.Error(
 				"error on reading request body",
 				zap.Error(err),
@@ -54,7 +55,7 @@ func ValidateJSON(h http.HandlerFunc) http.HandlerFunc {
 		r.Body = io.NopCloser(bytes.NewBuffer(body))
 
 		if !json.Valid(body) {
-			w.WriteHeader(http.StatusBadRequest)
+			problem.BadRequest.Write(w, r, "request body must be valid JSON")
 			logger.Log.Debug(
 				"passed invalid json",
 				zap.Error(err),
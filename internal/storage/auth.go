@@ -2,7 +2,10 @@ package storage
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 
+	"github.com/renatus-cartesius/gophermart/internal/auth"
 	"github.com/renatus-cartesius/gophermart/pkg/logger"
 	"go.uber.org/zap"
 )
@@ -26,6 +29,9 @@ func (pg *PGStorage) IsUserExists(ctx context.Context, userID string) (bool, err
 
 func (pg *PGStorage) AddUser(ctx context.Context, userID, passwordHash string) error {
 	_, err := pg.db.ExecContext(ctx, "INSERT INTO users (id, passwordHash) VALUES ($1, $2)", userID, passwordHash)
+	if isUniqueViolation(err) {
+		return auth.ErrUserAlreadyExists
+	}
 	return err
 }
 
@@ -33,6 +39,9 @@ func (pg *PGStorage) GetHash(ctx context.Context, userID string) (string, error)
 	var realpasswordHash string
 	hashRow := pg.db.QueryRowContext(ctx, "SELECT passwordHash from users where id = $1", userID)
 	if err := hashRow.Scan(&realpasswordHash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", auth.ErrIncorrectUserCredentials
+		}
 		logger.Log.Debug(
 			"error on scanning row into string",
 			zap.Error(err),
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/internal/outbox"
+)
+
+// AddOrders inserts new orders of user together with their history and outbox events in one statement.
+// Orders uploaded before are reported per order.
+func (pg *PGStorage) AddOrders(ctx context.Context, userID string, orderIDs []string) ([]error, error) {
+	payloads := make([]string, len(orderIDs))
+	for i, orderID := range orderIDs {
+		payloads[i] = string(outbox.OrderUploaded(userID, orderID).Payload)
+	}
+
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Order numbers are locked before outbox like in single upload, so they can`t be withdrawn meanwhile
+	if err := lockOrderNumbers(ctx, tx, orderIDs...); err != nil {
+		return nil, err
+	}
+
+	if err := lockOutbox(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	// Orders table in the final select is read from the statement snapshot, so it shows owners of existing orders only
+	rows, err := tx.QueryContext(ctx, `
+		WITH input AS (
+			SELECT id, payload, n FROM unnest($2::text[], $3::text[]) WITH ORDINALITY AS input(id, payload, n)
+		),
+		inserted AS (
+			INSERT INTO orders (id, userID, status) SELECT id, $1, $4::orderStatus FROM input
+			WHERE NOT EXISTS (SELECT * FROM withdrawals WHERE withdrawals.orderID = input.id)
+			ORDER BY n
+			ON CONFLICT (id) DO NOTHING
+			RETURNING id, status
+		),
+		events AS (
+			INSERT INTO order_events (orderID, status) SELECT id, status FROM inserted
+		),
+		outboxed AS (
+			INSERT INTO outbox (userID, type, aggregateID, payload)
+			SELECT $1, $5, input.id, input.payload::jsonb FROM input JOIN inserted ON inserted.id = input.id ORDER BY input.n
+		)
+		SELECT inserted.id IS NOT NULL, COALESCE(orders.userID, '') FROM input
+		LEFT JOIN inserted ON inserted.id = input.id
+		LEFT JOIN orders ON orders.id = input.id
+		ORDER BY input.n
+	`, userID, orderIDs, payloads, loyalty.TypeStatusNew, outbox.TypeEventOrderUploaded)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	errs := make([]error, 0, len(orderIDs))
+	for rows.Next() {
+		var (
+			inserted bool
+			owner    string
+		)
+		if err := rows.Scan(&inserted, &owner); err != nil {
+			return nil, err
+		}
+		errs = append(errs, uploadError(inserted, owner, userID))
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return errs, tx.Commit()
+}
+
+// uploadError returns error of uploading order by userID, owner is user of the order uploaded before.
+// Order which isn`t inserted and has no owner is used by withdrawal.
+func uploadError(inserted bool, owner string, userID string) error {
+	switch {
+	case inserted:
+		return nil
+	case owner == "":
+		return loyalty.ErrOrderUsedByWithdrawal
+	case owner != userID:
+		return loyalty.ErrOrderUploadedAnotherUser
+	default:
+		return loyalty.ErrOrderAlreadyUploaded
+	}
+}
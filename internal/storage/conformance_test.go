@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/renatus-cartesius/gophermart/cmd/gophermart/migrations"
+	"github.com/renatus-cartesius/gophermart/internal/storage/storagetest"
+)
+
+// newTestPGStorage connects to postgres passed in TEST_DATABASE_URI and applies migrations,
+// tests are skipped if database isn`t set
+func newTestPGStorage(t *testing.T) *PGStorage {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI isn`t set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("error on opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := migrations.Up(db); err != nil {
+		t.Fatalf("error on applying migrations: %v", err)
+	}
+
+	return NewPGStorage(db)
+}
+
+func TestPGStorage_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storager {
+		return newTestPGStorage(t)
+	})
+}
+
+func TestSQLiteStorage_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storager {
+		return newTestSQLiteStorage(t)
+	})
+}
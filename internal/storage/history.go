@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// addOrderEvent appends event to the order processing history in tx
+func addOrderEvent(ctx context.Context, tx *sql.Tx, orderID string, event *loyalty.OrderEvent) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO order_events (orderID, status, accrual, error, reason) VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''))
+	`, orderID, event.Status, event.Accrual, event.Error, event.Reason)
+	return err
+}
+
+// GetOrderHistory returns processing history of order from the oldest event to the newest
+func (pg *PGStorage) GetOrderHistory(ctx context.Context, orderID string) ([]*loyalty.OrderEvent, error) {
+
+	history := make([]*loyalty.OrderEvent, 0)
+
+	rows, err := pg.db.QueryContext(ctx, `
+		SELECT status, accrual, COALESCE(error, ''), COALESCE(reason, ''), created FROM order_events WHERE orderID = $1 ORDER BY id
+	`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event := &loyalty.OrderEvent{}
+		if err := rows.Scan(&event.Status, &event.Accrual, &event.Error, &event.Reason, &event.Created); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to OrderEvent",
+				zap.Error(err),
+			)
+			continue
+		}
+		history = append(history, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// GetOrderStatusEvents returns up to limit changes of order status recorded after event afterID
+func (pg *PGStorage) GetOrderStatusEvents(ctx context.Context, afterID uint64, limit int) ([]*loyalty.OrderStatusEvent, error) {
+
+	events := make([]*loyalty.OrderStatusEvent, 0)
+
+	rows, err := pg.db.QueryContext(ctx, `
+		SELECT e.id, o.userID, e.orderID, e.status, e.accrual, COALESCE(e.reason, ''), e.created
+		FROM order_events e JOIN orders o ON o.id = e.orderID
+		WHERE e.id > $1 AND e.error IS NULL AND e.status != 'NEW'
+		ORDER BY e.id LIMIT $2
+	`, int64(afterID), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event := &loyalty.OrderStatusEvent{}
+		if err := rows.Scan(&event.ID, &event.UserID, &event.OrderID, &event.Status, &event.Accrual, &event.Reason, &event.Created); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to OrderStatusEvent",
+				zap.Error(err),
+			)
+			continue
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// GetLastOrderEventID returns ID of the newest recorded order event, 0 if there are none
+func (pg *PGStorage) GetLastOrderEventID(ctx context.Context) (uint64, error) {
+	var id int64
+	err := pg.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM order_events").Scan(&id)
+	return uint64(id), err
+}
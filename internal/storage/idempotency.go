@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/server/middlewares"
+)
+
+func (pg *PGStorage) StartIdempotent(ctx context.Context, rec *middlewares.IdempotentRecord, ttl, reservationTTL time.Duration) (*middlewares.IdempotentRecord, error) {
+	// Reserving new key or taking over the expired one or unfinished one left by crashed request
+	var reserved bool
+	err := pg.db.QueryRowContext(ctx, `
+		INSERT INTO idempotency_keys (userID, key, fingerprint) VALUES ($1, $2, $3)
+		ON CONFLICT (userID, key) DO UPDATE
+			SET fingerprint = EXCLUDED.fingerprint, completed = false, statusCode = 0, contentType = '', body = NULL,
+				created = timezone('utc', now())
+			WHERE idempotency_keys.created < timezone('utc', now()) - make_interval(secs => $4)
+				OR (NOT idempotency_keys.completed AND idempotency_keys.created < timezone('utc', now()) - make_interval(secs => $5))
+		RETURNING true
+	`, rec.UserID, rec.Key, rec.Fingerprint, ttl.Seconds(), reservationTTL.Seconds()).Scan(&reserved)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	existing := &middlewares.IdempotentRecord{
+		UserID: rec.UserID,
+		Key:    rec.Key,
+	}
+	err = pg.db.QueryRowContext(ctx, `
+		SELECT fingerprint, completed, statusCode, contentType, body FROM idempotency_keys WHERE userID = $1 AND key = $2
+	`, rec.UserID, rec.Key).Scan(&existing.Fingerprint, &existing.Completed, &existing.StatusCode, &existing.ContentType, &existing.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return existing, nil
+}
+
+func (pg *PGStorage) FinishIdempotent(ctx context.Context, rec *middlewares.IdempotentRecord) error {
+	_, err := pg.db.ExecContext(ctx, `
+		UPDATE idempotency_keys SET completed = true, statusCode = $1, contentType = $2, body = $3
+		WHERE userID = $4 AND key = $5
+	`, rec.StatusCode, rec.ContentType, rec.Body, rec.UserID, rec.Key)
+	return err
+}
+
+func (pg *PGStorage) CancelIdempotent(ctx context.Context, userID, key string) error {
+	_, err := pg.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE userID = $1 AND key = $2 AND NOT completed", userID, key)
+	return err
+}
+
+// PruneIdempotent deletes keys created before the given time
+func (pg *PGStorage) PruneIdempotent(ctx context.Context, before time.Time) (int64, error) {
+	res, err := pg.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created < $1", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// ledgerLockSpace is the first key of advisory locks serializing changes of one user ledger
+const ledgerLockSpace = 1
+
+// lockLedger takes transaction-level advisory lock on the user ledger, so concurrent
+// balance checks and ledger appends of one user are executed one after another
+func lockLedger(ctx context.Context, tx *sql.Tx, userID string) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1, hashtext($2))", ledgerLockSpace, userID)
+	return err
+}
+
+// lastBalancesQuery selects running balances of the newest postings to user and withdrawn accounts,
+// there are no rows if user ledger is empty
+const lastBalancesQuery = `
+	SELECT p.balance, COALESCE((SELECT w.balance FROM ledger_postings w WHERE w.account = $2 ORDER BY w.id DESC LIMIT 1), 0)
+	FROM ledger_postings p WHERE p.account = $1 ORDER BY p.id DESC LIMIT 1
+`
+
+// lastLedgerEntry returns running totals of the newest user ledger entry or nil if user ledger is empty
+func lastLedgerEntry(ctx context.Context, tx *sql.Tx, userID string) (*loyalty.LedgerEntry, error) {
+	row := tx.QueryRowContext(ctx, lastBalancesQuery, loyalty.UserAccount(userID), loyalty.WithdrawnAccount(userID))
+
+	last := &loyalty.LedgerEntry{UserID: userID}
+	if err := row.Scan(&last.Balance, &last.Withdrawn); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return last, nil
+}
+
+// appendLedgerEntry locks the user ledger, computes running totals of entry and appends it
+// in tx as transaction with balanced postings
+func appendLedgerEntry(ctx context.Context, tx *sql.Tx, entry *loyalty.LedgerEntry) error {
+	if err := lockLedger(ctx, tx, entry.UserID); err != nil {
+		return err
+	}
+
+	last, err := lastLedgerEntry(ctx, tx, entry.UserID)
+	if err != nil {
+		return err
+	}
+
+	loyalty.NextLedgerEntry(last, entry)
+
+	row := tx.QueryRowContext(ctx, `
+		INSERT INTO ledger_transactions (userID, type, reference)
+		VALUES ($1, $2, $3)
+		RETURNING id, created
+	`, entry.UserID, entry.Type, entry.Reference)
+	if err := row.Scan(&entry.ID, &entry.Created); err != nil {
+		return err
+	}
+
+	for _, posting := range loyalty.Postings(entry) {
+		var balance any
+		if posting.Tracked {
+			balance = posting.Balance
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO ledger_postings (transactionID, account, amount, balance)
+			VALUES ($1, $2, $3, $4)
+		`, entry.ID, posting.Account, posting.Amount, balance)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddLedgerEntry appends manual entry to the user ledger, balance can`t become negative
+func (pg *PGStorage) AddLedgerEntry(ctx context.Context, entry *loyalty.LedgerEntry) error {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := appendLedgerEntry(ctx, tx, entry); err != nil {
+		return err
+	}
+
+	if entry.Balance < 0 {
+		return loyalty.ErrLedgerNegativeBalance
+	}
+
+	return tx.Commit()
+}
+
+func (pg *PGStorage) GetLedger(ctx context.Context, userID string) ([]*loyalty.LedgerEntry, error) {
+
+	entries := make([]*loyalty.LedgerEntry, 0)
+
+	// withdrawn total is sum of the withdrawn account legs up to every transaction
+	rows, err := pg.db.QueryContext(ctx, `
+		SELECT t.id, t.userID, t.type, t.reference, p.amount, p.balance,
+			SUM(COALESCE(w.amount, 0)) OVER (ORDER BY t.id ROWS UNBOUNDED PRECEDING), t.created
+		FROM ledger_transactions t
+		JOIN ledger_postings p ON p.transactionID = t.id AND p.account = $2
+		LEFT JOIN ledger_postings w ON w.transactionID = t.id AND w.account = $3
+		WHERE t.userID = $1
+		ORDER BY t.id
+	`, userID, loyalty.UserAccount(userID), loyalty.WithdrawnAccount(userID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry := &loyalty.LedgerEntry{}
+		if err := rows.Scan(&entry.ID, &entry.UserID, &entry.Type, &entry.Reference, &entry.Amount, &entry.Balance, &entry.Withdrawn, &entry.Created); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to LedgerEntry",
+				zap.Error(err),
+			)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// GetLedgerPostings returns legs of ledger transaction
+func (pg *PGStorage) GetLedgerPostings(ctx context.Context, transactionID int64) ([]loyalty.Posting, error) {
+	postings := make([]loyalty.Posting, 0)
+
+	rows, err := pg.db.QueryContext(ctx, "SELECT account, amount, balance IS NOT NULL, COALESCE(balance, 0) FROM ledger_postings WHERE transactionID = $1 ORDER BY id", transactionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		posting := loyalty.Posting{}
+		if err := rows.Scan(&posting.Account, &posting.Amount, &posting.Tracked, &posting.Balance); err != nil {
+			return nil, err
+		}
+		postings = append(postings, posting)
+	}
+
+	return postings, rows.Err()
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+)
+
+// listQuery builds keyset pagination query for user orders or withdrawals
+type listQuery struct {
+	// timeColumn and idColumn are keys of items ordering
+	timeColumn   string
+	idColumn     string
+	statusColumn string
+	// placeholder returns placeholder of n-th query argument
+	placeholder func(n int) string
+	// timeArg converts time to query argument
+	timeArg func(t time.Time) any
+}
+
+// build appends filter conditions, ordering and limit to base query which selects items by userID
+func (q listQuery) build(base string, userID string, filter loyalty.ListFilter) (string, []any) {
+	sb := &strings.Builder{}
+	sb.WriteString(base)
+
+	args := []any{userID}
+	arg := func(v any) string {
+		args = append(args, v)
+		return q.placeholder(len(args))
+	}
+
+	if filter.Status != "" {
+		fmt.Fprintf(sb, " AND %s = %s", q.statusColumn, arg(filter.Status))
+	}
+	if !filter.From.IsZero() {
+		fmt.Fprintf(sb, " AND %s >= %s", q.timeColumn, arg(q.timeArg(filter.From)))
+	}
+	if !filter.To.IsZero() {
+		fmt.Fprintf(sb, " AND %s < %s", q.timeColumn, arg(q.timeArg(filter.To)))
+	}
+	if filter.After != nil {
+		fmt.Fprintf(sb, " AND (%s, %s) > (%s, %s)", q.timeColumn, q.idColumn, arg(q.timeArg(filter.After.Time)), arg(filter.After.ID))
+	}
+
+	fmt.Fprintf(sb, " ORDER BY %s, %s", q.timeColumn, q.idColumn)
+
+	if limit := filter.FetchLimit(); limit > 0 {
+		fmt.Fprintf(sb, " LIMIT %s", arg(limit))
+	}
+
+	return sb.String(), args
+}
+
+func pgPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// pgTimeArg passes time in UTC, as timestamps are stored without time zone
+func pgTimeArg(t time.Time) any {
+	return t.UTC()
+}
+
+var (
+	pgOrdersQuery = listQuery{
+		timeColumn:   "uploaded",
+		idColumn:     "id",
+		statusColumn: "status",
+		placeholder:  pgPlaceholder,
+		timeArg:      pgTimeArg,
+	}
+	pgWithdrawalsQuery = listQuery{
+		timeColumn:  "created",
+		idColumn:    "orderID",
+		placeholder: pgPlaceholder,
+		timeArg:     pgTimeArg,
+	}
+)
+
+func sqlitePlaceholder(int) string {
+	return "?"
+}
+
+func sqliteTimeArg(t time.Time) any {
+	return sqliteTime(t)
+}
+
+var (
+	sqliteOrdersQuery = listQuery{
+		timeColumn:   "uploaded",
+		idColumn:     "id",
+		statusColumn: "status",
+		placeholder:  sqlitePlaceholder,
+		timeArg:      sqliteTimeArg,
+	}
+	sqliteWithdrawalsQuery = listQuery{
+		timeColumn:  "created",
+		idColumn:    "orderID",
+		placeholder: sqlitePlaceholder,
+		timeArg:     sqliteTimeArg,
+	}
+)
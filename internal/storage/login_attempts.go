@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+func (pg *PGStorage) ReserveLoginAttempt(ctx context.Context, key string, now time.Time, window time.Duration, threshold int, hold time.Duration) (int, time.Time, error) {
+	now = now.UTC()
+
+	// The first attempt holds further ones if threshold is 1
+	var held sql.NullTime
+	if threshold <= 1 {
+		held = sql.NullTime{Time: now.Add(hold), Valid: true}
+	}
+
+	// Counting and holding are done by one statement, so concurrent attempts see each other
+	var attempts int
+	err := pg.db.QueryRowContext(ctx, `
+		INSERT INTO login_attempts (key, failures, lastFailure, lockedUntil)
+		VALUES ($1, 1, $2, $6)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.lastFailure < $3 THEN 1 ELSE login_attempts.failures + 1 END,
+			lastFailure = excluded.lastFailure,
+			lockedUntil = CASE
+				WHEN (CASE WHEN login_attempts.lastFailure < $3 THEN 1 ELSE login_attempts.failures + 1 END) >= $4
+				THEN GREATEST(COALESCE(login_attempts.lockedUntil, $5), $5)
+				ELSE login_attempts.lockedUntil
+			END
+		WHERE login_attempts.lockedUntil IS NULL OR login_attempts.lockedUntil <= $2
+		RETURNING failures
+	`, key, now, now.Add(-window), threshold, now.Add(hold), held).Scan(&attempts)
+	if err == nil {
+		return attempts, time.Time{}, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, time.Time{}, err
+	}
+
+	// Nothing is updated while attempts are locked
+	var lockedUntil time.Time
+	err = pg.db.QueryRowContext(ctx, "SELECT failures, lockedUntil FROM login_attempts WHERE key = $1", key).Scan(&attempts, &lockedUntil)
+	return attempts, lockedUntil, err
+}
+
+func (pg *PGStorage) ReleaseLoginAttempt(ctx context.Context, key string) error {
+	_, err := pg.db.ExecContext(ctx, "UPDATE login_attempts SET failures = GREATEST(failures - 1, 0) WHERE key = $1", key)
+	return err
+}
+
+func (pg *PGStorage) LockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := pg.db.ExecContext(ctx, `
+		UPDATE login_attempts SET lockedUntil = GREATEST(COALESCE(lockedUntil, $2), $2) WHERE key = $1
+	`, key, until.UTC())
+	return err
+}
+
+func (pg *PGStorage) ResetLoginFailures(ctx context.Context, key string) error {
+	_, err := pg.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE key = $1", key)
+	return err
+}
+
+// PruneLoginAttempts deletes counters of attempts made and locked before the given time
+func (pg *PGStorage) PruneLoginAttempts(ctx context.Context, before time.Time) (int64, error) {
+	res, err := pg.db.ExecContext(ctx, `
+		DELETE FROM login_attempts WHERE lastFailure < $1 AND (lockedUntil IS NULL OR lockedUntil < $1)
+	`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
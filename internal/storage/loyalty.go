@@ -4,13 +4,31 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"time"
 
-	"github.com/renatus-cartesius/gophermart/internal/accrual"
 	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/internal/outbox"
 	"github.com/renatus-cartesius/gophermart/pkg/logger"
 	"go.uber.org/zap"
 )
 
+// orderNumberLockSpace is the first key of advisory locks serializing uses of one order number
+const orderNumberLockSpace = 4
+
+// lockOrderNumbers takes transaction-level advisory locks on order numbers, so one number can`t be
+// uploaded as accrual order and used by withdrawal at the same time. Locks are taken in order of keys,
+// so concurrent batches don`t deadlock.
+func lockOrderNumbers(ctx context.Context, tx *sql.Tx, orderIDs ...string) error {
+	_, err := tx.ExecContext(ctx, `
+		SELECT pg_advisory_xact_lock($1, key) FROM (
+			SELECT DISTINCT hashtext(id) AS key FROM unnest($2::text[]) AS id ORDER BY key
+		) AS keys
+	`, orderNumberLockSpace, orderIDs)
+	return err
+}
+
+// AddOrder inserts new order with upload event in its history and in outbox. Owner of uploaded
+// order is checked under the order number lock, so concurrent uploads of one number see each other.
 func (pg *PGStorage) AddOrder(ctx context.Context, userID string, orderID string) error {
 
 	logger.Log.Debug(
@@ -18,64 +36,76 @@ func (pg *PGStorage) AddOrder(ctx context.Context, userID string, orderID string
 		zap.String("orderID", orderID),
 	)
 
-	// Check if order already in db by that user
-	var uID string
-
-	existingOrderRow := pg.db.QueryRowContext(ctx, "SELECT userID FROM orders WHERE id = $1", orderID)
-
-	err := existingOrderRow.Err()
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	logger.Log.Debug(
-		"checked order in storage",
-		zap.String("orderID", orderID),
-		zap.Error(err),
-	)
+	if err := lockOrderNumbers(ctx, tx, orderID); err != nil {
+		return err
+	}
 
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		logger.Log.Debug(
-			"error on executing query",
-			zap.Error(err),
-		)
+	// Check if order already in db by that user
+	var owner string
+	err = tx.QueryRowContext(ctx, "SELECT userID FROM orders WHERE id = $1", orderID).Scan(&owner)
+	if err == nil {
+		return uploadError(false, owner, userID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
 	}
 
-	if err := existingOrderRow.Scan(&uID); err != nil {
+	// Order number spent by withdrawal can`t be uploaded as accrual order
+	var withdrawn bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT * FROM withdrawals WHERE orderID = $1)", orderID).Scan(&withdrawn)
+	if err != nil {
+		return err
+	}
+	if withdrawn {
+		return loyalty.ErrOrderUsedByWithdrawal
+	}
 
-		if errors.Is(err, sql.ErrNoRows) {
-			logger.Log.Debug(
-				"inserting order to database",
-				zap.String("orderID", orderID),
-				zap.Error(err),
-			)
-			_, err = pg.db.ExecContext(ctx, "INSERT INTO orders (id, userID, status) VALUES ($1, $2, $3)", orderID, userID, loyalty.TypeStatusNew)
-			return err
+	_, err = tx.ExecContext(ctx, "INSERT INTO orders (id, userID, status) VALUES ($1, $2, $3)", orderID, userID, loyalty.TypeStatusNew)
+	if err != nil {
+		// Every upload takes the order number lock, so it is only a safety net
+		if isUniqueViolation(err) {
+			tx.Rollback()
+			if err := pg.db.QueryRowContext(ctx, "SELECT userID FROM orders WHERE id = $1", orderID).Scan(&owner); err != nil {
+				return err
+			}
+			return uploadError(false, owner, userID)
 		}
+		return err
+	}
 
-		logger.Log.Debug(
-			"error on scanning into string",
-			zap.Error(err),
-		)
+	if err := addOrderEvent(ctx, tx, orderID, &loyalty.OrderEvent{Status: loyalty.TypeStatusNew}); err != nil {
+		return err
 	}
 
-	if uID != userID {
-		return loyalty.ErrOrderUploadedAnotherUser
-	} else {
-		return loyalty.ErrOrderAlreadyUploaded
+	if err := addOutboxEvent(ctx, tx, outbox.OrderUploaded(userID, orderID)); err != nil {
+		return err
 	}
 
+	return tx.Commit()
 }
 
-func (pg *PGStorage) GetOrders(ctx context.Context, userID string) ([]*loyalty.Order, error) {
+// GetOrders returns page of user orders in order of upload selected by filter
+func (pg *PGStorage) GetOrders(ctx context.Context, userID string, filter loyalty.ListFilter) ([]*loyalty.Order, *loyalty.Cursor, error) {
 
 	orders := make([]*loyalty.Order, 0)
 
-	rows, err := pg.db.QueryContext(ctx, "SELECT * FROM orders WHERE userID = $1 ORDER BY uploaded", userID)
+	query, args := pgOrdersQuery.build("SELECT id, userID, status, accrual, uploaded, COALESCE(reason, '') FROM orders WHERE userID = $1", userID, filter)
+
+	rows, err := pg.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		order := &loyalty.Order{}
-		if err := rows.Scan(&order.ID, &order.UserID, &order.Status, &order.Accrual, &order.Uploaded); err != nil {
+		if err := rows.Scan(&order.ID, &order.UserID, &order.Status, &order.Accrual, &order.Uploaded, &order.Reason); err != nil {
 			logger.Log.Debug(
 				"error on scanning row to Order",
 				zap.Error(err),
@@ -86,20 +116,25 @@ func (pg *PGStorage) GetOrders(ctx context.Context, userID string) ([]*loyalty.O
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return orders, nil
+	orders, next := loyalty.Paginate(orders, filter.Limit, loyalty.OrderCursor)
+	return orders, next, nil
 }
 
-func (pg *PGStorage) GetWithdrawals(ctx context.Context, userID string) ([]*loyalty.Withdraw, error) {
+// GetWithdrawals returns page of user withdrawals in order of creation selected by filter
+func (pg *PGStorage) GetWithdrawals(ctx context.Context, userID string, filter loyalty.ListFilter) ([]*loyalty.Withdraw, *loyalty.Cursor, error) {
 
 	withdrawals := make([]*loyalty.Withdraw, 0)
 
-	rows, err := pg.db.QueryContext(ctx, "SELECT * FROM withdrawals WHERE userID = $1 ORDER BY created", userID)
+	query, args := pgWithdrawalsQuery.build("SELECT orderID, userID, sum, created FROM withdrawals WHERE userID = $1", userID, filter)
+
+	rows, err := pg.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		withdraw := &loyalty.Withdraw{}
@@ -114,18 +149,20 @@ func (pg *PGStorage) GetWithdrawals(ctx context.Context, userID string) ([]*loya
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return withdrawals, nil
+	withdrawals, next := loyalty.Paginate(withdrawals, filter.Limit, loyalty.WithdrawCursor)
+	return withdrawals, next, nil
 }
 
 func (pg *PGStorage) GetOrder(ctx context.Context, orderID string) (*loyalty.Order, error) {
-	orderRow := pg.db.QueryRowContext(ctx, "SELECT * FROM orders where id = $1", orderID)
+	orderRow := pg.db.QueryRowContext(ctx, "SELECT id, userID, status, accrual, uploaded, COALESCE(reason, ''), lastCheckedAt FROM orders where id = $1", orderID)
 
 	order := &loyalty.Order{}
+	lastChecked := sql.NullTime{}
 
-	if err := orderRow.Scan(&order.ID, &order.UserID, &order.Status, &order.Accrual, &order.Uploaded); err != nil {
+	if err := orderRow.Scan(&order.ID, &order.UserID, &order.Status, &order.Accrual, &order.Uploaded, &order.Reason, &lastChecked); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, loyalty.ErrOrderNotFound
 		}
@@ -135,23 +172,22 @@ func (pg *PGStorage) GetOrder(ctx context.Context, orderID string) (*loyalty.Ord
 		)
 		return nil, err
 	}
+	order.LastCheckedAt = lastChecked.Time
 
 	return order, orderRow.Err()
 
 }
 
+// GetBalance returns running balances of the newest postings to user accounts
 func (pg *PGStorage) GetBalance(ctx context.Context, userID string) (*loyalty.Balance, error) {
-	row := pg.db.QueryRowContext(ctx, `
-		select 
-			((select COALESCE(SUM(accrual), 0) from orders where userID = $1 and status = $2) -
-			(select COALESCE(SUM(sum), 0) from withdrawals where userID = $1)) as balance,
-			(select COALESCE(SUM(sum), 0) from withdrawals where userID = $1) as withdrawn;
-		;
-	`, userID, loyalty.TypeStatusProcessed)
+	row := pg.db.QueryRowContext(ctx, lastBalancesQuery, loyalty.UserAccount(userID), loyalty.WithdrawnAccount(userID))
 
 	balance := &loyalty.Balance{}
 	err := row.Scan(&balance.Current, &balance.Withdrawn)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return balance, nil
+		}
 		logger.Log.Debug(
 			"error on scanning to row to balance",
 		)
@@ -162,57 +198,106 @@ func (pg *PGStorage) GetBalance(ctx context.Context, userID string) (*loyalty.Ba
 }
 
 func (pg *PGStorage) AddWithdraw(ctx context.Context, wr *loyalty.Withdraw) error {
-	tx, err := pg.db.Begin()
+	tx, err := pg.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	// Getting current balance
-	row := pg.db.QueryRowContext(ctx, `
-		select
-			((select COALESCE(SUM(accrual), 0) from orders where userID = $1 and status = $2) -
-			(select COALESCE(SUM(sum), 0) from withdrawals where userID = $1)) as balance,
-			(select COALESCE(SUM(sum), 0) from withdrawals where userID = $1) as withdrawn;
-		;
-	`, wr.UserID, loyalty.TypeStatusProcessed)
+	// Withdrawals of one user are serialized, so the balance can`t change between check and insert
+	if err := lockLedger(ctx, tx, wr.UserID); err != nil {
+		return err
+	}
 
-	balance := &loyalty.Balance{}
+	// Order number is locked against concurrent upload of the same number as accrual order
+	if err := lockOrderNumbers(ctx, tx, wr.OrderID); err != nil {
+		return err
+	}
+
+	// Withdrawal order number must be used neither by another withdrawal nor by uploaded accrual order
+	var orderUsed bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT * FROM withdrawals WHERE orderID = $1) OR EXISTS(SELECT * FROM orders WHERE id = $1)
+	`, wr.OrderID).Scan(&orderUsed)
+	if err != nil {
+		return err
+	}
+	if orderUsed {
+		return loyalty.ErrWithdrawOrderDuplicate
+	}
 
-	err = row.Scan(&balance.Current, &balance.Withdrawn)
+	// Getting current balance
+	last, err := lastLedgerEntry(ctx, tx, wr.UserID)
 	if err != nil {
 		logger.Log.Debug(
-			"error on scanning to row to balance",
+			"error on getting last ledger entry",
+			zap.Error(err),
 		)
 		return err
 	}
 
-	if wr.Sum > balance.Current {
+	if last == nil || wr.Sum > last.Balance {
 		return loyalty.ErrWithdrawNotEnoughPoints
 	}
 
-	_, err = pg.db.ExecContext(ctx, "INSERT INTO withdrawals (orderID, userID, sum) VALUES ($1, $2, $3)", wr.OrderID, wr.UserID, wr.Sum)
-	return err
+	_, err = tx.ExecContext(ctx, "INSERT INTO withdrawals (orderID, userID, sum) VALUES ($1, $2, $3)", wr.OrderID, wr.UserID, wr.Sum)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return loyalty.ErrWithdrawOrderDuplicate
+		}
+		return err
+	}
+
+	err = appendLedgerEntry(ctx, tx, &loyalty.LedgerEntry{
+		UserID:    wr.UserID,
+		Type:      loyalty.TypeEntryWithdrawal,
+		Reference: wr.OrderID,
+		Amount:    -wr.Sum,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := addOutboxEvent(ctx, tx, outbox.WithdrawCreated(wr)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (pg *PGStorage) GetUnhandledOrders(ctx context.Context) ([]string, error) {
-	orders := make([]string, 0)
+// GetUnhandledOrders claims up to limit not processed orders which are due to check for owner.
+// Claimed orders are skipped by other owners until lease expires or order is updated or released.
+func (pg *PGStorage) GetUnhandledOrders(ctx context.Context, owner string, lease time.Duration, limit int) ([]*loyalty.Order, error) {
+	orders := make([]*loyalty.Order, 0)
 
-	rows, err := pg.db.QueryContext(ctx, "SELECT id from orders where status != $1 and status != $2", loyalty.TypeStatusProcessed, loyalty.TypeStatusInvalid)
+	rows, err := pg.db.QueryContext(ctx, `
+		UPDATE orders SET leaseOwner = $1, leaseUntil = timezone('utc', now()) + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM orders
+			WHERE status != $3 AND status != $4
+				AND nextCheckAt <= timezone('utc', now())
+				AND (leaseUntil IS NULL OR leaseUntil < timezone('utc', now()))
+			ORDER BY nextCheckAt
+			LIMIT $5
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, userID, status, accrual, uploaded, attempts, COALESCE(lastError, ''), nextCheckAt
+	`, owner, lease.Seconds(), loyalty.TypeStatusProcessed, loyalty.TypeStatusInvalid, limit)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var orderID string
-		if err := rows.Scan(&orderID); err != nil {
+		order := &loyalty.Order{}
+		if err := rows.Scan(&order.ID, &order.UserID, &order.Status, &order.Accrual, &order.Uploaded, &order.Attempts, &order.LastError, &order.NextCheckAt); err != nil {
 			logger.Log.Debug(
-				"error on scanning row to string",
+				"error on scanning row to Order",
 				zap.Error(err),
 			)
 			continue
 		}
-		orders = append(orders, orderID)
+		orders = append(orders, order)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -221,7 +306,145 @@ func (pg *PGStorage) GetUnhandledOrders(ctx context.Context) ([]string, error) {
 
 	return orders, nil
 }
-func (pg *PGStorage) UpdateOrder(ctx context.Context, orderInfo *accrual.OrderInfo) error {
-	_, err := pg.db.ExecContext(ctx, "UPDATE orders SET status=$1, accrual=$2 WHERE id=$3", orderInfo.Status, orderInfo.Accrual, orderInfo.Order)
+
+// lockLeasedOrder locks order leased by owner in tx and returns its current state,
+// returns ErrOrderLeaseLost if order isn`t leased by owner
+func lockLeasedOrder(ctx context.Context, tx *sql.Tx, owner string, orderID string) (*loyalty.Order, error) {
+	order := &loyalty.Order{ID: orderID}
+	err := tx.QueryRowContext(ctx, `
+		SELECT userID, status, accrual, COALESCE(lastError, '') FROM orders WHERE id=$1 AND leaseOwner=$2 FOR UPDATE
+	`, orderID, owner).Scan(&order.UserID, &order.Status, &order.Accrual, &order.LastError)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, loyalty.ErrOrderLeaseLost
+		}
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// UpdateOrder saves order status and accrual, schedules next check after nextCheck and releases the lease.
+// Changes of status or accrual are recorded in order history, accrual of processed order is credited
+// to the user ledger. Only lease owner can update the order.
+func (pg *PGStorage) UpdateOrder(ctx context.Context, owner string, order *loyalty.Order, nextCheck time.Duration) error {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := lockLeasedOrder(ctx, tx, owner, order.ID)
+	if err != nil {
+		return err
+	}
+	userID := current.UserID
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE orders SET status=$1, accrual=$2, attempts=attempts+1, lastError=NULL,
+			nextCheckAt=timezone('utc', now()) + make_interval(secs => $3),
+			lastCheckedAt=timezone('utc', now()), leaseOwner=NULL, leaseUntil=NULL
+		WHERE id=$4
+	`, order.Status, order.Accrual, nextCheck.Seconds(), order.ID)
+	if err != nil {
+		return err
+	}
+
+	if current.Status != order.Status || current.Accrual != order.Accrual {
+		if err := addOrderEvent(ctx, tx, order.ID, &loyalty.OrderEvent{Status: order.Status, Accrual: order.Accrual}); err != nil {
+			return err
+		}
+	}
+
+	if order.Status == loyalty.TypeStatusProcessed && order.Accrual > 0 {
+		err = appendLedgerEntry(ctx, tx, &loyalty.LedgerEntry{
+			UserID:    userID,
+			Type:      loyalty.TypeEntryAccrual,
+			Reference: order.ID,
+			Amount:    order.Accrual,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if current.Status != order.Status || current.Accrual != order.Accrual {
+		changed := &loyalty.Order{ID: order.ID, UserID: userID, Status: order.Status, Accrual: order.Accrual}
+		if err := addOutboxEvent(ctx, tx, outbox.OrderStatusChanged(changed)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RescheduleOrder records failed check of order leased by owner and schedules next check after nextCheck.
+// Only changes of the error are recorded in order history.
+func (pg *PGStorage) RescheduleOrder(ctx context.Context, owner string, orderID string, lastError string, nextCheck time.Duration) error {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := lockLeasedOrder(ctx, tx, owner, orderID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE orders SET attempts=attempts+1, lastError=$1,
+			nextCheckAt=timezone('utc', now()) + make_interval(secs => $2),
+			lastCheckedAt=timezone('utc', now()), leaseOwner=NULL, leaseUntil=NULL
+		WHERE id=$3
+	`, lastError, nextCheck.Seconds(), orderID)
+	if err != nil {
+		return err
+	}
+
+	if current.LastError != lastError {
+		if err := addOrderEvent(ctx, tx, orderID, &loyalty.OrderEvent{Status: current.Status, Error: lastError}); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ExpireOrder moves order leased by owner to terminal INVALID status with reason
+func (pg *PGStorage) ExpireOrder(ctx context.Context, owner string, orderID string, reason string) error {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := lockLeasedOrder(ctx, tx, owner, orderID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE orders SET status=$1, reason=$2, leaseOwner=NULL, leaseUntil=NULL WHERE id=$3
+	`, loyalty.TypeStatusInvalid, reason, orderID)
+	if err != nil {
+		return err
+	}
+
+	if err := addOrderEvent(ctx, tx, orderID, &loyalty.OrderEvent{Status: loyalty.TypeStatusInvalid, Reason: reason}); err != nil {
+		return err
+	}
+
+	expired := &loyalty.Order{ID: orderID, UserID: current.UserID, Status: loyalty.TypeStatusInvalid, Reason: reason}
+	if err := addOutboxEvent(ctx, tx, outbox.OrderStatusChanged(expired)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReleaseOrder returns order leased by owner back to the unhandled orders
+func (pg *PGStorage) ReleaseOrder(ctx context.Context, owner string, orderID string) error {
+	_, err := pg.db.ExecContext(ctx, "UPDATE orders SET leaseOwner=NULL, leaseUntil=NULL WHERE id=$1 AND leaseOwner=$2", orderID, owner)
 	return err
 }
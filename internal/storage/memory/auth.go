@@ -0,0 +1,39 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+)
+
+func (ms *MemStorage) IsUserExists(ctx context.Context, userID string) (bool, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	_, ok := ms.users[userID]
+	return ok, nil
+}
+
+func (ms *MemStorage) AddUser(ctx context.Context, userID, passwordHash string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.users[userID]; ok {
+		return auth.ErrUserAlreadyExists
+	}
+
+	ms.users[userID] = passwordHash
+	return nil
+}
+
+func (ms *MemStorage) GetHash(ctx context.Context, userID string) (string, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	passwordHash, ok := ms.users[userID]
+	if !ok {
+		return "", auth.ErrIncorrectUserCredentials
+	}
+
+	return passwordHash, nil
+}
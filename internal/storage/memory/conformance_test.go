@@ -0,0 +1,13 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/renatus-cartesius/gophermart/internal/storage/storagetest"
+)
+
+func TestMemStorage_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storager {
+		return NewMemStorage()
+	})
+}
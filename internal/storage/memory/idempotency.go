@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/server/middlewares"
+)
+
+func (ms *MemStorage) StartIdempotent(ctx context.Context, rec *middlewares.IdempotentRecord, ttl, reservationTTL time.Duration) (*middlewares.IdempotentRecord, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := idempotencyKey{userID: rec.UserID, key: rec.Key}
+	now := time.Now()
+
+	if existing, ok := ms.idempotency[key]; ok {
+		age := now.Sub(existing.created)
+		if age < ttl && (existing.rec.Completed || age < reservationTTL) {
+			res := existing.rec
+			return &res, nil
+		}
+	}
+
+	ms.idempotency[key] = &idempotencyRecord{
+		rec: middlewares.IdempotentRecord{
+			UserID:      rec.UserID,
+			Key:         rec.Key,
+			Fingerprint: rec.Fingerprint,
+		},
+		created: now,
+	}
+	return nil, nil
+}
+
+func (ms *MemStorage) FinishIdempotent(ctx context.Context, rec *middlewares.IdempotentRecord) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	existing, ok := ms.idempotency[idempotencyKey{userID: rec.UserID, key: rec.Key}]
+	if !ok {
+		return nil
+	}
+
+	existing.rec = *rec
+	existing.rec.Body = append([]byte(nil), rec.Body...)
+	return nil
+}
+
+func (ms *MemStorage) CancelIdempotent(ctx context.Context, userID, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	k := idempotencyKey{userID: userID, key: key}
+	if existing, ok := ms.idempotency[k]; ok && !existing.rec.Completed {
+		delete(ms.idempotency, k)
+	}
+	return nil
+}
+
+// PruneIdempotent deletes keys created before the given time
+func (ms *MemStorage) PruneIdempotent(ctx context.Context, before time.Time) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var pruned int64
+	for key, existing := range ms.idempotency {
+		if existing.created.Before(before) {
+			delete(ms.idempotency, key)
+			pruned++
+		}
+	}
+
+	return pruned, nil
+}
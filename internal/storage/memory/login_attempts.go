@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"context"
+	"time"
+)
+
+type loginAttemptsRecord struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+func (ms *MemStorage) ReserveLoginAttempt(ctx context.Context, key string, now time.Time, window time.Duration, threshold int, hold time.Duration) (int, time.Time, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	record, ok := ms.loginAttempts[key]
+	if !ok {
+		record = &loginAttemptsRecord{}
+		ms.loginAttempts[key] = record
+	}
+
+	if record.lockedUntil.After(now) {
+		return record.failures, record.lockedUntil, nil
+	}
+
+	if record.lastFailure.Before(now.Add(-window)) {
+		record.failures = 0
+	}
+	record.failures++
+	record.lastFailure = now
+
+	if held := now.Add(hold); record.failures >= threshold && held.After(record.lockedUntil) {
+		record.lockedUntil = held
+	}
+
+	return record.failures, time.Time{}, nil
+}
+
+func (ms *MemStorage) ReleaseLoginAttempt(ctx context.Context, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if record, ok := ms.loginAttempts[key]; ok && record.failures > 0 {
+		record.failures--
+	}
+
+	return nil
+}
+
+func (ms *MemStorage) LockLogin(ctx context.Context, key string, until time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if record, ok := ms.loginAttempts[key]; ok && until.After(record.lockedUntil) {
+		record.lockedUntil = until
+	}
+
+	return nil
+}
+
+func (ms *MemStorage) ResetLoginFailures(ctx context.Context, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.loginAttempts, key)
+	return nil
+}
+
+// PruneLoginAttempts deletes counters of attempts made and locked before the given time
+func (ms *MemStorage) PruneLoginAttempts(ctx context.Context, before time.Time) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var pruned int64
+	for key, record := range ms.loginAttempts {
+		if record.lastFailure.Before(before) && record.lockedUntil.Before(before) {
+			delete(ms.loginAttempts, key)
+			pruned++
+		}
+	}
+
+	return pruned, nil
+}
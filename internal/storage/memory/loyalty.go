@@ -0,0 +1,406 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/internal/outbox"
+)
+
+func (ms *MemStorage) AddOrder(ctx context.Context, userID string, orderID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if existing, ok := ms.orders[orderID]; ok {
+		if existing.order.UserID != userID {
+			return loyalty.ErrOrderUploadedAnotherUser
+		}
+		return loyalty.ErrOrderAlreadyUploaded
+	}
+	if _, ok := ms.withdrawals[orderID]; ok {
+		return loyalty.ErrOrderUsedByWithdrawal
+	}
+
+	ms.insertOrder(userID, orderID, time.Now().UTC())
+
+	return nil
+}
+
+// insertOrder adds new order with upload event in its history and in outbox, ms.mu must be held
+func (ms *MemStorage) insertOrder(userID string, orderID string, uploaded time.Time) {
+	record := &orderRecord{
+		order: loyalty.Order{
+			UserID:      userID,
+			ID:          orderID,
+			Status:      loyalty.TypeStatusNew,
+			Uploaded:    uploaded,
+			NextCheckAt: uploaded,
+		},
+	}
+	ms.addOrderEvent(record, loyalty.OrderEvent{Status: loyalty.TypeStatusNew})
+	ms.orders[orderID] = record
+	ms.userOrders[userID] = append(ms.userOrders[userID], orderID)
+	ms.addOutboxEvent(outbox.OrderUploaded(userID, orderID))
+}
+
+func (ms *MemStorage) AddOrders(ctx context.Context, userID string, orderIDs []string) ([]error, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now().UTC()
+	errs := make([]error, len(orderIDs))
+	for i, orderID := range orderIDs {
+		if existing, ok := ms.orders[orderID]; ok {
+			if existing.order.UserID != userID {
+				errs[i] = loyalty.ErrOrderUploadedAnotherUser
+			} else {
+				errs[i] = loyalty.ErrOrderAlreadyUploaded
+			}
+			continue
+		}
+		if _, ok := ms.withdrawals[orderID]; ok {
+			errs[i] = loyalty.ErrOrderUsedByWithdrawal
+			continue
+		}
+
+		ms.insertOrder(userID, orderID, now)
+	}
+
+	return errs, nil
+}
+
+// GetOrders returns page of user orders in order of upload selected by filter
+func (ms *MemStorage) GetOrders(ctx context.Context, userID string, filter loyalty.ListFilter) ([]*loyalty.Order, *loyalty.Cursor, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	orders := make([]*loyalty.Order, 0)
+	for _, orderID := range ms.userOrders[userID] {
+		order := ms.orders[orderID].order
+		if filter.Match(order.Status, order.Uploaded, order.ID) {
+			orders = append(orders, &order)
+		}
+	}
+
+	// Orders uploaded at the same time are ordered by number like in SQL storages
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].Uploaded.Equal(orders[j].Uploaded) {
+			return orders[i].ID < orders[j].ID
+		}
+		return orders[i].Uploaded.Before(orders[j].Uploaded)
+	})
+
+	orders, next := loyalty.Paginate(orders, filter.Limit, loyalty.OrderCursor)
+	return orders, next, nil
+}
+
+func (ms *MemStorage) GetOrder(ctx context.Context, orderID string) (*loyalty.Order, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	record, ok := ms.orders[orderID]
+	if !ok {
+		return nil, loyalty.ErrOrderNotFound
+	}
+
+	order := record.order
+	return &order, nil
+}
+
+// GetOrderHistory returns processing history of order from the oldest event to the newest
+func (ms *MemStorage) GetOrderHistory(ctx context.Context, orderID string) ([]*loyalty.OrderEvent, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	history := make([]*loyalty.OrderEvent, 0)
+	if record, ok := ms.orders[orderID]; ok {
+		for _, event := range record.history {
+			event := event
+			history = append(history, &event)
+		}
+	}
+
+	return history, nil
+}
+
+// GetOrderStatusEvents returns up to limit changes of order status recorded after event afterID
+func (ms *MemStorage) GetOrderStatusEvents(ctx context.Context, afterID uint64, limit int) ([]*loyalty.OrderStatusEvent, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	events := make([]*loyalty.OrderStatusEvent, 0)
+	start := sort.Search(len(ms.statusEvents), func(i int) bool { return ms.statusEvents[i].ID > afterID })
+	for _, event := range ms.statusEvents[start:] {
+		if len(events) == limit {
+			break
+		}
+		event := *event
+		events = append(events, &event)
+	}
+
+	return events, nil
+}
+
+// GetLastOrderEventID returns ID of the newest recorded order event, 0 if there are none
+func (ms *MemStorage) GetLastOrderEventID(ctx context.Context) (uint64, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return ms.orderEventSeq, nil
+}
+
+// GetWithdrawals returns page of user withdrawals in order of creation selected by filter
+func (ms *MemStorage) GetWithdrawals(ctx context.Context, userID string, filter loyalty.ListFilter) ([]*loyalty.Withdraw, *loyalty.Cursor, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	withdrawals := make([]*loyalty.Withdraw, 0)
+	for _, orderID := range ms.userWithdrawals[userID] {
+		withdraw := *ms.withdrawals[orderID]
+		if filter.Match("", withdraw.Created, withdraw.OrderID) {
+			withdrawals = append(withdrawals, &withdraw)
+		}
+	}
+
+	sort.Slice(withdrawals, func(i, j int) bool {
+		if withdrawals[i].Created.Equal(withdrawals[j].Created) {
+			return withdrawals[i].OrderID < withdrawals[j].OrderID
+		}
+		return withdrawals[i].Created.Before(withdrawals[j].Created)
+	})
+
+	withdrawals, next := loyalty.Paginate(withdrawals, filter.Limit, loyalty.WithdrawCursor)
+	return withdrawals, next, nil
+}
+
+// lastLedgerEntry returns the newest ledger entry of user or nil, ms.mu must be held
+func (ms *MemStorage) lastLedgerEntry(userID string) *loyalty.LedgerEntry {
+	entries := ms.ledger[userID]
+	if len(entries) == 0 {
+		return nil
+	}
+	return entries[len(entries)-1]
+}
+
+// appendLedgerEntry computes running totals of entry and appends it to the user ledger
+// as transaction with balanced postings, ms.mu must be held
+func (ms *MemStorage) appendLedgerEntry(entry *loyalty.LedgerEntry) {
+	loyalty.NextLedgerEntry(ms.lastLedgerEntry(entry.UserID), entry)
+
+	ms.ledgerSeq++
+	entry.ID = ms.ledgerSeq
+	entry.Created = time.Now().UTC()
+
+	stored := *entry
+	ms.ledger[entry.UserID] = append(ms.ledger[entry.UserID], &stored)
+	ms.postings[entry.ID] = loyalty.Postings(entry)
+}
+
+// GetLedgerPostings returns legs of ledger transaction
+func (ms *MemStorage) GetLedgerPostings(ctx context.Context, transactionID int64) ([]loyalty.Posting, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return append(make([]loyalty.Posting, 0), ms.postings[transactionID]...), nil
+}
+
+func (ms *MemStorage) GetBalance(ctx context.Context, userID string) (*loyalty.Balance, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	balance := &loyalty.Balance{}
+	if last := ms.lastLedgerEntry(userID); last != nil {
+		balance.Current = last.Balance
+		balance.Withdrawn = last.Withdrawn
+	}
+
+	return balance, nil
+}
+
+func (ms *MemStorage) AddWithdraw(ctx context.Context, wr *loyalty.Withdraw) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.withdrawals[wr.OrderID]; ok {
+		return loyalty.ErrWithdrawOrderDuplicate
+	}
+	if _, ok := ms.orders[wr.OrderID]; ok {
+		return loyalty.ErrWithdrawOrderDuplicate
+	}
+
+	last := ms.lastLedgerEntry(wr.UserID)
+	if last == nil || wr.Sum > last.Balance {
+		return loyalty.ErrWithdrawNotEnoughPoints
+	}
+
+	withdraw := *wr
+	withdraw.Created = time.Now().UTC()
+	ms.withdrawals[wr.OrderID] = &withdraw
+	ms.userWithdrawals[wr.UserID] = append(ms.userWithdrawals[wr.UserID], wr.OrderID)
+
+	ms.appendLedgerEntry(&loyalty.LedgerEntry{
+		UserID:    wr.UserID,
+		Type:      loyalty.TypeEntryWithdrawal,
+		Reference: wr.OrderID,
+		Amount:    -wr.Sum,
+	})
+	ms.addOutboxEvent(outbox.WithdrawCreated(wr))
+
+	return nil
+}
+
+func (ms *MemStorage) GetLedger(ctx context.Context, userID string) ([]*loyalty.LedgerEntry, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	entries := make([]*loyalty.LedgerEntry, 0, len(ms.ledger[userID]))
+	for _, stored := range ms.ledger[userID] {
+		entry := *stored
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+}
+
+func (ms *MemStorage) AddLedgerEntry(ctx context.Context, entry *loyalty.LedgerEntry) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	balance := entry.Amount
+	if last := ms.lastLedgerEntry(entry.UserID); last != nil {
+		balance += last.Balance
+	}
+	if balance < 0 {
+		return loyalty.ErrLedgerNegativeBalance
+	}
+
+	ms.appendLedgerEntry(entry)
+	return nil
+}
+
+// GetUnhandledOrders claims up to limit not processed orders which are due to check for owner
+func (ms *MemStorage) GetUnhandledOrders(ctx context.Context, owner string, lease time.Duration, limit int) ([]*loyalty.Order, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	due := make([]*orderRecord, 0)
+
+	for _, record := range ms.orders {
+		if loyalty.IsTerminalStatus(record.order.Status) || record.order.NextCheckAt.After(now) || record.leaseUntil.After(now) {
+			continue
+		}
+		due = append(due, record)
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].order.NextCheckAt.Before(due[j].order.NextCheckAt)
+	})
+
+	orders := make([]*loyalty.Order, 0, min(limit, len(due)))
+	for _, record := range due[:min(limit, len(due))] {
+		record.leaseOwner = owner
+		record.leaseUntil = now.Add(lease)
+
+		order := record.order
+		orders = append(orders, &order)
+	}
+
+	return orders, nil
+}
+
+// leasedOrder returns record of order leased by owner, ms.mu must be held
+func (ms *MemStorage) leasedOrder(owner string, orderID string) (*orderRecord, error) {
+	record, ok := ms.orders[orderID]
+	if !ok || record.leaseOwner != owner {
+		return nil, loyalty.ErrOrderLeaseLost
+	}
+	return record, nil
+}
+
+func (ms *MemStorage) UpdateOrder(ctx context.Context, owner string, order *loyalty.Order, nextCheck time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	record, err := ms.leasedOrder(owner, order.ID)
+	if err != nil {
+		return err
+	}
+
+	if record.order.Status != order.Status || record.order.Accrual != order.Accrual {
+		ms.addOrderEvent(record, loyalty.OrderEvent{Status: order.Status, Accrual: order.Accrual})
+		ms.addOutboxEvent(outbox.OrderStatusChanged(&loyalty.Order{ID: order.ID, UserID: record.order.UserID, Status: order.Status, Accrual: order.Accrual}))
+	}
+
+	record.order.Status = order.Status
+	record.order.Accrual = order.Accrual
+	record.order.Attempts++
+	record.order.LastError = ""
+	record.order.LastCheckedAt = time.Now().UTC()
+	record.order.NextCheckAt = time.Now().Add(nextCheck)
+	record.leaseOwner, record.leaseUntil = "", time.Time{}
+
+	if order.Status == loyalty.TypeStatusProcessed && order.Accrual > 0 {
+		ms.appendLedgerEntry(&loyalty.LedgerEntry{
+			UserID:    record.order.UserID,
+			Type:      loyalty.TypeEntryAccrual,
+			Reference: order.ID,
+			Amount:    order.Accrual,
+		})
+	}
+
+	return nil
+}
+
+func (ms *MemStorage) RescheduleOrder(ctx context.Context, owner string, orderID string, lastError string, nextCheck time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	record, err := ms.leasedOrder(owner, orderID)
+	if err != nil {
+		return err
+	}
+
+	if record.order.LastError != lastError {
+		ms.addOrderEvent(record, loyalty.OrderEvent{Status: record.order.Status, Error: lastError})
+	}
+
+	record.order.Attempts++
+	record.order.LastError = lastError
+	record.order.LastCheckedAt = time.Now().UTC()
+	record.order.NextCheckAt = time.Now().Add(nextCheck)
+	record.leaseOwner, record.leaseUntil = "", time.Time{}
+
+	return nil
+}
+
+func (ms *MemStorage) ExpireOrder(ctx context.Context, owner string, orderID string, reason string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	record, err := ms.leasedOrder(owner, orderID)
+	if err != nil {
+		return err
+	}
+
+	record.order.Status = loyalty.TypeStatusInvalid
+	record.order.Reason = reason
+	ms.addOrderEvent(record, loyalty.OrderEvent{Status: loyalty.TypeStatusInvalid, Reason: reason})
+	ms.addOutboxEvent(outbox.OrderStatusChanged(&record.order))
+	record.leaseOwner, record.leaseUntil = "", time.Time{}
+
+	return nil
+}
+
+func (ms *MemStorage) ReleaseOrder(ctx context.Context, owner string, orderID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if record, err := ms.leasedOrder(owner, orderID); err == nil {
+		record.leaseOwner, record.leaseUntil = "", time.Time{}
+	}
+
+	return nil
+}
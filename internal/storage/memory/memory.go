@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/internal/outbox"
+	"github.com/renatus-cartesius/gophermart/internal/server/middlewares"
+	"github.com/renatus-cartesius/gophermart/internal/webhooks"
+)
+
+type orderRecord struct {
+	order      loyalty.Order
+	leaseOwner string
+	leaseUntil time.Time
+	history    []loyalty.OrderEvent
+}
+
+// addOrderEvent appends event to the order history and status changes to the stream of status events,
+// ms.mu must be held
+func (ms *MemStorage) addOrderEvent(record *orderRecord, event loyalty.OrderEvent) {
+	ms.orderEventSeq++
+	event.Created = time.Now().UTC()
+	record.history = append(record.history, event)
+
+	if event.Error != "" || event.Status == loyalty.TypeStatusNew {
+		return
+	}
+	ms.statusEvents = append(ms.statusEvents, &loyalty.OrderStatusEvent{
+		ID:      ms.orderEventSeq,
+		UserID:  record.order.UserID,
+		OrderID: record.order.ID,
+		Status:  event.Status,
+		Accrual: event.Accrual,
+		Reason:  event.Reason,
+		Created: event.Created,
+	})
+}
+
+type idempotencyKey struct {
+	userID string
+	key    string
+}
+
+type idempotencyRecord struct {
+	rec     middlewares.IdempotentRecord
+	created time.Time
+}
+
+// MemStorage keeps all the data in memory guarded by one mutex. It implements the same storage
+// interfaces as storage.PGStorage and is used when no database is configured, data is lost on restart.
+type MemStorage struct {
+	mu sync.RWMutex
+
+	users map[string]string
+
+	sessions      map[string]*sessionRecord
+	userSessions  map[string][]string
+	refreshTokens map[string]*refreshTokenRecord
+	loginAttempts map[string]*loginAttemptsRecord
+
+	orders     map[string]*orderRecord
+	userOrders map[string][]string
+
+	statusEvents  []*loyalty.OrderStatusEvent
+	orderEventSeq uint64
+
+	withdrawals     map[string]*loyalty.Withdraw
+	userWithdrawals map[string][]string
+
+	ledger    map[string][]*loyalty.LedgerEntry
+	postings  map[int64][]loyalty.Posting
+	ledgerSeq int64
+
+	idempotency map[idempotencyKey]*idempotencyRecord
+
+	hooks       map[string]*webhooks.Webhook
+	userHooks   map[string][]string
+	deliveries  map[int64]*webhooks.Delivery
+	deliverySeq int64
+
+	outbox    []*outboxRecord
+	outboxSeq int64
+}
+
+func NewMemStorage() *MemStorage {
+	return &MemStorage{
+		users:           make(map[string]string),
+		sessions:        make(map[string]*sessionRecord),
+		userSessions:    make(map[string][]string),
+		refreshTokens:   make(map[string]*refreshTokenRecord),
+		loginAttempts:   make(map[string]*loginAttemptsRecord),
+		orders:          make(map[string]*orderRecord),
+		userOrders:      make(map[string][]string),
+		withdrawals:     make(map[string]*loyalty.Withdraw),
+		userWithdrawals: make(map[string][]string),
+		ledger:          make(map[string][]*loyalty.LedgerEntry),
+		postings:        make(map[int64][]loyalty.Posting),
+		idempotency:     make(map[idempotencyKey]*idempotencyRecord),
+		hooks:           make(map[string]*webhooks.Webhook),
+		userHooks:       make(map[string][]string),
+		deliveries:      make(map[int64]*webhooks.Delivery),
+	}
+}
+
+var (
+	_ auth.AuthStorager               = (*MemStorage)(nil)
+	_ loyalty.LoyaltyStorager         = (*MemStorage)(nil)
+	_ middlewares.IdempotencyStorager = (*MemStorage)(nil)
+	_ webhooks.WebhookStorager        = (*MemStorage)(nil)
+	_ outbox.OutboxStorager           = (*MemStorage)(nil)
+)
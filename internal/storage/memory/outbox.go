@@ -0,0 +1,148 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/outbox"
+)
+
+type outboxRecord struct {
+	event      outbox.Event
+	leaseOwner string
+	leaseUntil time.Time
+	lastError  string
+	// nextAttemptAt is time after which failed event and the following events of its user are claimed again
+	nextAttemptAt time.Time
+	published     bool
+	// failed is terminal state of event which isn`t published anymore
+	failed bool
+	// publishedAt is time of publication used for pruning
+	publishedAt time.Time
+}
+
+// addOutboxEvent appends event to outbox, ms.mu must be held
+func (ms *MemStorage) addOutboxEvent(event *outbox.Event) {
+	ms.outboxSeq++
+
+	record := &outboxRecord{event: *event}
+	record.event.ID = ms.outboxSeq
+	record.event.Created = time.Now().UTC()
+	ms.outbox = append(ms.outbox, record)
+}
+
+// outboxEvent returns unpublished event leased by owner, ms.mu must be held
+func (ms *MemStorage) outboxEvent(owner string, eventID int64) (*outboxRecord, error) {
+	for _, record := range ms.outbox {
+		if record.event.ID == eventID && !record.published && !record.failed && record.leaseOwner == owner {
+			return record, nil
+		}
+	}
+	return nil, outbox.ErrEventLeaseLost
+}
+
+func (ms *MemStorage) ClaimOutboxEvents(ctx context.Context, owner string, lease time.Duration, limit int) ([]*outbox.Event, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+
+	// Users with events leased by another owner or waiting for retry are skipped entirely
+	busy := make(map[string]bool)
+	for _, record := range ms.outbox {
+		if record.published || record.failed {
+			continue
+		}
+		if record.leaseOwner != "" && record.leaseOwner != owner && !record.leaseUntil.Before(now) || record.nextAttemptAt.After(now) {
+			busy[record.event.UserID] = true
+		}
+	}
+
+	events := make([]*outbox.Event, 0)
+	for _, record := range ms.outbox {
+		if len(events) >= limit {
+			break
+		}
+		if record.published || record.failed || busy[record.event.UserID] {
+			continue
+		}
+
+		record.leaseOwner = owner
+		record.leaseUntil = now.Add(lease)
+
+		event := record.event
+		events = append(events, &event)
+	}
+
+	return events, nil
+}
+
+func (ms *MemStorage) MarkOutboxPublished(ctx context.Context, owner string, eventID int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	record, err := ms.outboxEvent(owner, eventID)
+	if err != nil {
+		return err
+	}
+
+	record.published, record.publishedAt = true, time.Now()
+	record.leaseOwner, record.leaseUntil = "", time.Time{}
+	return nil
+}
+
+func (ms *MemStorage) FailOutboxEvent(ctx context.Context, owner string, eventID int64, lastError string, retryAfter time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	failed, err := ms.outboxEvent(owner, eventID)
+	if err != nil {
+		return err
+	}
+
+	failed.event.Attempts++
+	failed.lastError = lastError
+	failed.nextAttemptAt = time.Now().Add(retryAfter)
+	ms.releaseOutboxEvents(owner, failed.event.UserID)
+
+	return nil
+}
+
+func (ms *MemStorage) DeadLetterOutboxEvent(ctx context.Context, owner string, eventID int64, lastError string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	failed, err := ms.outboxEvent(owner, eventID)
+	if err != nil {
+		return err
+	}
+
+	failed.event.Attempts++
+	failed.lastError = lastError
+	failed.failed = true
+	ms.releaseOutboxEvents(owner, failed.event.UserID)
+
+	return nil
+}
+
+// releaseOutboxEvents releases unpublished events of user leased by owner, ms.mu must be held
+func (ms *MemStorage) releaseOutboxEvents(owner string, userID string) {
+	for _, record := range ms.outbox {
+		if record.event.UserID == userID && !record.published && record.leaseOwner == owner {
+			record.leaseOwner, record.leaseUntil = "", time.Time{}
+		}
+	}
+}
+
+func (ms *MemStorage) PruneOutboxEvents(ctx context.Context, before time.Time) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	count := len(ms.outbox)
+	ms.outbox = slices.DeleteFunc(ms.outbox, func(record *outboxRecord) bool {
+		return record.published && record.publishedAt.Before(before)
+	})
+
+	return int64(count - len(ms.outbox)), nil
+}
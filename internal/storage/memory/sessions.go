@@ -0,0 +1,130 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+)
+
+type sessionRecord struct {
+	session auth.Session
+	// revoked is zero if session isn`t revoked
+	revoked time.Time
+}
+
+// active reports whether session may be refreshed, ms.mu must be held
+func (r *sessionRecord) active() bool {
+	return r.revoked.IsZero() && r.session.Expires.After(time.Now())
+}
+
+type refreshTokenRecord struct {
+	sessionID string
+	used      bool
+}
+
+func (ms *MemStorage) AddSession(ctx context.Context, session *auth.Session, tokenHash string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.sessions[session.ID] = &sessionRecord{session: *session}
+	ms.userSessions[session.UserID] = append(ms.userSessions[session.UserID], session.ID)
+	ms.refreshTokens[tokenHash] = &refreshTokenRecord{sessionID: session.ID}
+
+	return nil
+}
+
+func (ms *MemStorage) RotateRefreshToken(ctx context.Context, tokenHash, nextHash string, expires time.Time) (*auth.Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	token, ok := ms.refreshTokens[tokenHash]
+	if !ok {
+		return nil, auth.ErrRefreshTokenInvalid
+	}
+
+	record := ms.sessions[token.sessionID]
+	if !record.active() {
+		return nil, auth.ErrRefreshTokenInvalid
+	}
+
+	if token.used {
+		record.revoked = time.Now().UTC()
+		return nil, auth.ErrRefreshTokenReused
+	}
+
+	token.used = true
+	ms.refreshTokens[nextHash] = &refreshTokenRecord{sessionID: token.sessionID}
+
+	record.session.LastUsed = time.Now().UTC()
+	record.session.Expires = expires
+
+	session := record.session
+	return &session, nil
+}
+
+func (ms *MemStorage) GetSessions(ctx context.Context, userID string) ([]*auth.Session, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	sessions := make([]*auth.Session, 0)
+	for _, id := range ms.userSessions[userID] {
+		record := ms.sessions[id]
+		if !record.active() {
+			continue
+		}
+		session := record.session
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, nil
+}
+
+func (ms *MemStorage) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	record, ok := ms.sessions[sessionID]
+	if !ok || record.session.UserID != userID || !record.active() {
+		return auth.ErrSessionNotFound
+	}
+
+	record.revoked = time.Now().UTC()
+	return nil
+}
+
+func (ms *MemStorage) IsSessionActive(ctx context.Context, userID, sessionID string) (bool, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	record, ok := ms.sessions[sessionID]
+	return ok && record.session.UserID == userID && record.active(), nil
+}
+
+// PruneSessions deletes sessions expired or revoked before the given time with their refresh tokens
+func (ms *MemStorage) PruneSessions(ctx context.Context, before time.Time) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var pruned int64
+	for sessionID, record := range ms.sessions {
+		if !record.session.Expires.Before(before) && (record.revoked.IsZero() || !record.revoked.Before(before)) {
+			continue
+		}
+
+		delete(ms.sessions, sessionID)
+		ms.userSessions[record.session.UserID] = slices.DeleteFunc(ms.userSessions[record.session.UserID], func(id string) bool {
+			return id == sessionID
+		})
+		pruned++
+	}
+
+	for hash, token := range ms.refreshTokens {
+		if _, ok := ms.sessions[token.sessionID]; !ok {
+			delete(ms.refreshTokens, hash)
+		}
+	}
+
+	return pruned, nil
+}
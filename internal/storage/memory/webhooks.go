@@ -0,0 +1,205 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/webhooks"
+)
+
+func (ms *MemStorage) AddWebhook(ctx context.Context, hook *webhooks.Webhook, limit int) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if len(ms.userHooks[hook.UserID]) >= limit {
+		return webhooks.ErrWebhookLimit
+	}
+
+	stored := *hook
+	if stored.Created.IsZero() {
+		stored.Created = time.Now().UTC()
+	}
+
+	ms.hooks[hook.ID] = &stored
+	ms.userHooks[hook.UserID] = append(ms.userHooks[hook.UserID], hook.ID)
+	return nil
+}
+
+func (ms *MemStorage) GetWebhooks(ctx context.Context, userID string) ([]*webhooks.Webhook, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	hooks := make([]*webhooks.Webhook, 0, len(ms.userHooks[userID]))
+	for _, id := range ms.userHooks[userID] {
+		hook := *ms.hooks[id]
+		hooks = append(hooks, &hook)
+	}
+
+	return hooks, nil
+}
+
+func (ms *MemStorage) DeleteWebhook(ctx context.Context, userID string, webhookID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	hook, ok := ms.hooks[webhookID]
+	if !ok || hook.UserID != userID {
+		return webhooks.ErrWebhookNotFound
+	}
+
+	delete(ms.hooks, webhookID)
+	ms.userHooks[userID] = slices.DeleteFunc(ms.userHooks[userID], func(id string) bool {
+		return id == webhookID
+	})
+
+	for id, delivery := range ms.deliveries {
+		if delivery.WebhookID == webhookID {
+			delete(ms.deliveries, id)
+		}
+	}
+
+	return nil
+}
+
+func (ms *MemStorage) EnqueueEvent(ctx context.Context, event *webhooks.Event) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	// Event already enqueued for webhook isn`t enqueued again
+	enqueued := make(map[string]bool)
+	for _, delivery := range ms.deliveries {
+		if delivery.EventID == event.ID {
+			enqueued[delivery.WebhookID] = true
+		}
+	}
+
+	now := time.Now().UTC()
+	for _, id := range ms.userHooks[event.UserID] {
+		if enqueued[id] {
+			continue
+		}
+
+		ms.deliverySeq++
+		ms.deliveries[ms.deliverySeq] = &webhooks.Delivery{
+			ID:            ms.deliverySeq,
+			WebhookID:     id,
+			UserID:        event.UserID,
+			EventID:       event.ID,
+			EventType:     event.Type,
+			Payload:       payload,
+			Status:        webhooks.TypeDeliveryPending,
+			NextAttemptAt: now,
+			Created:       now,
+		}
+	}
+
+	return nil
+}
+
+// copyDelivery returns copy of delivery safe to be used without ms.mu
+func copyDelivery(delivery *webhooks.Delivery) *webhooks.Delivery {
+	res := *delivery
+	if delivery.DeliveredAt != nil {
+		deliveredAt := *delivery.DeliveredAt
+		res.DeliveredAt = &deliveredAt
+	}
+	return &res
+}
+
+func (ms *MemStorage) GetDueDeliveries(ctx context.Context, lease time.Duration, limit int) ([]*webhooks.Delivery, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	due := make([]*webhooks.Delivery, 0)
+	for _, delivery := range ms.deliveries {
+		if delivery.Status == webhooks.TypeDeliveryPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].ID < due[j].ID
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	res := make([]*webhooks.Delivery, 0, len(due))
+	for _, delivery := range due {
+		delivery.NextAttemptAt = now.Add(lease)
+
+		claimed := copyDelivery(delivery)
+		hook := ms.hooks[delivery.WebhookID]
+		claimed.URL = hook.URL
+		claimed.Secret = hook.Secret
+		res = append(res, claimed)
+	}
+
+	return res, nil
+}
+
+func (ms *MemStorage) UpdateDelivery(ctx context.Context, delivery *webhooks.Delivery) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored, ok := ms.deliveries[delivery.ID]
+	if !ok {
+		return webhooks.ErrDeliveryNotFound
+	}
+
+	updated := copyDelivery(delivery)
+	stored.Status = updated.Status
+	stored.Attempts = updated.Attempts
+	stored.NextAttemptAt = updated.NextAttemptAt
+	stored.LastStatusCode = updated.LastStatusCode
+	stored.LastError = updated.LastError
+	stored.DeliveredAt = updated.DeliveredAt
+	return nil
+}
+
+func (ms *MemStorage) GetDeliveries(ctx context.Context, userID string, webhookID string, limit int) ([]*webhooks.Delivery, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	deliveries := make([]*webhooks.Delivery, 0)
+	for _, delivery := range ms.deliveries {
+		if delivery.UserID == userID && delivery.WebhookID == webhookID {
+			deliveries = append(deliveries, copyDelivery(delivery))
+		}
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID > deliveries[j].ID
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+
+	return deliveries, nil
+}
+
+func (ms *MemStorage) RedeliverDelivery(ctx context.Context, userID string, deliveryID int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delivery, ok := ms.deliveries[deliveryID]
+	if !ok || delivery.UserID != userID {
+		return webhooks.ErrDeliveryNotFound
+	}
+
+	delivery.Status = webhooks.TypeDeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now().UTC()
+	delivery.DeliveredAt = nil
+	return nil
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/outbox"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// outboxLockSpace is the first key of advisory locks serializing outbox events of one user
+	outboxLockSpace = 2
+	// outboxClaimLockSpace is the first key of advisory lock serializing claims of outbox events
+	outboxClaimLockSpace = 3
+)
+
+// lockOutbox takes transaction-level advisory lock on outbox events of user. Events of one user are
+// serialized until tx ends, so their ids follow the order of commits.
+func lockOutbox(ctx context.Context, tx *sql.Tx, userID string) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1, hashtext($2))", outboxLockSpace, userID)
+	return err
+}
+
+// addOutboxEvent writes event to outbox in tx
+func addOutboxEvent(ctx context.Context, tx *sql.Tx, event *outbox.Event) error {
+	if err := lockOutbox(ctx, tx, event.UserID); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO outbox (userID, type, aggregateID, payload) VALUES ($1, $2, $3, $4)
+	`, event.UserID, event.Type, event.AggregateID, string(event.Payload))
+	return err
+}
+
+func (pg *PGStorage) ClaimOutboxEvents(ctx context.Context, owner string, lease time.Duration, limit int) ([]*outbox.Event, error) {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Claims are serialized, so two relays never take events of one user at the same time
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1, 0)", outboxClaimLockSpace); err != nil {
+		return nil, err
+	}
+
+	events := make([]*outbox.Event, 0)
+
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE outbox SET leaseOwner = $1, leaseUntil = timezone('utc', now()) + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT o.id FROM outbox o
+			WHERE o.published IS NULL AND o.failed IS NULL AND NOT EXISTS (
+				SELECT * FROM outbox l
+				WHERE l.userID = o.userID AND l.published IS NULL AND l.failed IS NULL AND (
+					(l.leaseOwner != $1 AND l.leaseUntil >= timezone('utc', now()))
+					OR l.nextAttemptAt > timezone('utc', now())
+				)
+			)
+			ORDER BY o.id
+			LIMIT $3
+		)
+		RETURNING id, userID, type, aggregateID, payload::text, created, attempts
+	`, owner, lease.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event := &outbox.Event{}
+		if err := rows.Scan(&event.ID, &event.UserID, &event.Type, &event.AggregateID, (*[]byte)(&event.Payload), &event.Created, &event.Attempts); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to outbox Event",
+				zap.Error(err),
+			)
+			continue
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING doesn`t keep the order of subquery
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+
+	return events, tx.Commit()
+}
+
+func (pg *PGStorage) MarkOutboxPublished(ctx context.Context, owner string, eventID int64) error {
+	res, err := pg.db.ExecContext(ctx, `
+		UPDATE outbox SET published = timezone('utc', now()), leaseOwner = NULL, leaseUntil = NULL
+		WHERE id = $1 AND leaseOwner = $2 AND published IS NULL
+	`, eventID, owner)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return outbox.ErrEventLeaseLost
+	}
+
+	return nil
+}
+
+func (pg *PGStorage) FailOutboxEvent(ctx context.Context, owner string, eventID int64, lastError string, retryAfter time.Duration) error {
+	return pg.failOutboxEvent(ctx, owner, eventID, `
+		UPDATE outbox SET attempts = attempts + 1, lastError = $1,
+			nextAttemptAt = timezone('utc', now()) + make_interval(secs => $4)
+		WHERE id = $2 AND leaseOwner = $3 AND published IS NULL AND failed IS NULL
+		RETURNING userID
+	`, lastError, eventID, owner, retryAfter.Seconds())
+}
+
+func (pg *PGStorage) DeadLetterOutboxEvent(ctx context.Context, owner string, eventID int64, lastError string) error {
+	return pg.failOutboxEvent(ctx, owner, eventID, `
+		UPDATE outbox SET attempts = attempts + 1, lastError = $1, failed = timezone('utc', now()),
+			leaseOwner = NULL, leaseUntil = NULL
+		WHERE id = $2 AND leaseOwner = $3 AND published IS NULL AND failed IS NULL
+		RETURNING userID
+	`, lastError, eventID, owner)
+}
+
+// failOutboxEvent records failure of event leased by owner with query returning its user
+// and releases all the events of the user leased by owner
+func (pg *PGStorage) failOutboxEvent(ctx context.Context, owner string, eventID int64, query string, args ...any) error {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID string
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return outbox.ErrEventLeaseLost
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE outbox SET leaseOwner = NULL, leaseUntil = NULL WHERE userID = $1 AND leaseOwner = $2 AND published IS NULL
+	`, userID, owner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (pg *PGStorage) PruneOutboxEvents(ctx context.Context, before time.Time) (int64, error) {
+	res, err := pg.db.ExecContext(ctx, "DELETE FROM outbox WHERE published < $1", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
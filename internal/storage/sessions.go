@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+func (pg *PGStorage) AddSession(ctx context.Context, session *auth.Session, tokenHash string) error {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO sessions (id, userID, userAgent, ip, created, lastUsed, expires) VALUES ($1, $2, $3, $4, $5, $5, $6)
+	`, session.ID, session.UserID, session.UserAgent, session.IP, session.Created, session.Expires)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO refresh_tokens (hash, sessionID) VALUES ($1, $2)", tokenHash, session.ID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (pg *PGStorage) RotateRefreshToken(ctx context.Context, tokenHash, nextHash string, expires time.Time) (*auth.Session, error) {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		sessionID string
+		used      bool
+		active    bool
+	)
+
+	// Locking the session serializes concurrent refreshes, so only one of them rotates the token
+	err = tx.QueryRowContext(ctx, `
+		SELECT s.id, t.used, s.revoked IS NULL AND s.expires > timezone('utc', now())
+		FROM refresh_tokens t JOIN sessions s ON s.id = t.sessionID
+		WHERE t.hash = $1
+		FOR UPDATE OF s
+	`, tokenHash).Scan(&sessionID, &used, &active)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, auth.ErrRefreshTokenInvalid
+		}
+		return nil, err
+	}
+
+	if !active {
+		return nil, auth.ErrRefreshTokenInvalid
+	}
+
+	if used {
+		if _, err := tx.ExecContext(ctx, "UPDATE sessions SET revoked = timezone('utc', now()) WHERE id = $1", sessionID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, auth.ErrRefreshTokenReused
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used = true WHERE hash = $1", tokenHash); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO refresh_tokens (hash, sessionID) VALUES ($1, $2)", nextHash, sessionID); err != nil {
+		return nil, err
+	}
+
+	session := &auth.Session{}
+	err = tx.QueryRowContext(ctx, `
+		UPDATE sessions SET lastUsed = timezone('utc', now()), expires = $2 WHERE id = $1
+		RETURNING id, userID, userAgent, ip, created, lastUsed, expires
+	`, sessionID, expires).Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP, &session.Created, &session.LastUsed, &session.Expires)
+	if err != nil {
+		return nil, err
+	}
+
+	return session, tx.Commit()
+}
+
+func (pg *PGStorage) GetSessions(ctx context.Context, userID string) ([]*auth.Session, error) {
+
+	sessions := make([]*auth.Session, 0)
+
+	rows, err := pg.db.QueryContext(ctx, `
+		SELECT id, userID, userAgent, ip, created, lastUsed, expires FROM sessions
+		WHERE userID = $1 AND revoked IS NULL AND expires > timezone('utc', now())
+		ORDER BY created, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		session := &auth.Session{}
+		if err := rows.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP, &session.Created, &session.LastUsed, &session.Expires); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to Session",
+				zap.Error(err),
+			)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (pg *PGStorage) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	res, err := pg.db.ExecContext(ctx, `
+		UPDATE sessions SET revoked = timezone('utc', now())
+		WHERE id = $1 AND userID = $2 AND revoked IS NULL AND expires > timezone('utc', now())
+	`, sessionID, userID)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return auth.ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (pg *PGStorage) IsSessionActive(ctx context.Context, userID, sessionID string) (bool, error) {
+	var active bool
+	err := pg.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT * FROM sessions
+			WHERE id = $1 AND userID = $2 AND revoked IS NULL AND expires > timezone('utc', now())
+		)
+	`, sessionID, userID).Scan(&active)
+	return active, err
+}
+
+// PruneSessions deletes sessions expired or revoked before the given time, their refresh tokens are deleted by cascade
+func (pg *PGStorage) PruneSessions(ctx context.Context, before time.Time) (int64, error) {
+	res, err := pg.db.ExecContext(ctx, "DELETE FROM sessions WHERE expires < $1 OR revoked < $1", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
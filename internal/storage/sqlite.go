@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteTimeLayout is fixed width, so times stored by SQLiteStorage are compared and ordered as strings
+const sqliteTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// SQLiteStorage implements the same storage interfaces as PGStorage on top of SQLite.
+// It is intended for small deployments and local tests, db must be limited to one open connection.
+type SQLiteStorage struct {
+	db *sql.DB
+}
+
+func NewSQLiteStorage(db *sql.DB) *SQLiteStorage {
+	return &SQLiteStorage{
+		db: db,
+	}
+}
+
+// sqliteTime formats t to be stored in SQLite
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeLayout)
+}
+
+// sqliteNow returns current time formatted to be stored in SQLite
+func sqliteNow() string {
+	return sqliteTime(time.Now())
+}
+
+type sqliteTimeScanner struct {
+	t *time.Time
+}
+
+func (s sqliteTimeScanner) Scan(src any) error {
+	var value string
+	switch v := src.(type) {
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	case nil:
+		*s.t = time.Time{}
+		return nil
+	default:
+		return fmt.Errorf("can`t scan %T to time", src)
+	}
+
+	t, err := time.Parse(sqliteTimeLayout, value)
+	if err != nil {
+		return err
+	}
+
+	*s.t = t
+	return nil
+}
+
+// scanSQLiteTime returns scanner of time stored by SQLiteStorage into t
+func scanSQLiteTime(t *time.Time) sql.Scanner {
+	return sqliteTimeScanner{t: t}
+}
+
+// isSQLiteUniqueViolation reports whether err is sqlite unique or primary key constraint violation
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
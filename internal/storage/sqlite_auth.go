@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+func (s *SQLiteStorage) IsUserExists(ctx context.Context, userID string) (bool, error) {
+
+	row := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT * FROM users WHERE id = ?)", userID)
+
+	var userExists bool
+	if err := row.Scan(&userExists); err != nil {
+		logger.Log.Debug(
+			"error on scanning row into bool",
+			zap.Error(err),
+		)
+		return false, err
+	}
+
+	return userExists, row.Err()
+}
+
+func (s *SQLiteStorage) AddUser(ctx context.Context, userID, passwordHash string) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO users (id, passwordHash) VALUES (?, ?)", userID, passwordHash)
+	if isSQLiteUniqueViolation(err) {
+		return auth.ErrUserAlreadyExists
+	}
+	return err
+}
+
+func (s *SQLiteStorage) GetHash(ctx context.Context, userID string) (string, error) {
+	var realpasswordHash string
+	hashRow := s.db.QueryRowContext(ctx, "SELECT passwordHash FROM users WHERE id = ?", userID)
+	if err := hashRow.Scan(&realpasswordHash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", auth.ErrIncorrectUserCredentials
+		}
+		logger.Log.Debug(
+			"error on scanning row into string",
+			zap.Error(err),
+		)
+		return "", err
+	}
+
+	return realpasswordHash, hashRow.Err()
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/internal/outbox"
+)
+
+// AddOrders inserts new orders of user together with their history and outbox events in one transaction.
+// Orders uploaded before are reported per order.
+func (s *SQLiteStorage) AddOrders(ctx context.Context, userID string, orderIDs []string) ([]error, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := sqliteNow()
+	errs := make([]error, len(orderIDs))
+	for i, orderID := range orderIDs {
+		var withdrawn bool
+		err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT * FROM withdrawals WHERE orderID = ?)", orderID).Scan(&withdrawn)
+		if err != nil {
+			return nil, err
+		}
+		if withdrawn {
+			errs[i] = loyalty.ErrOrderUsedByWithdrawal
+			continue
+		}
+
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO orders (id, userID, status, uploaded, nextCheckAt) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (id) DO NOTHING
+		`, orderID, userID, loyalty.TypeStatusNew, now, now)
+		if err != nil {
+			return nil, err
+		}
+
+		inserted, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+
+		if inserted == 0 {
+			var owner string
+			if err := tx.QueryRowContext(ctx, "SELECT userID FROM orders WHERE id = ?", orderID).Scan(&owner); err != nil {
+				return nil, err
+			}
+			errs[i] = uploadError(false, owner, userID)
+			continue
+		}
+
+		if err := sqliteAddOrderEvent(ctx, tx, orderID, &loyalty.OrderEvent{Status: loyalty.TypeStatusNew}); err != nil {
+			return nil, err
+		}
+
+		if err := sqliteAddOutboxEvent(ctx, tx, outbox.OrderUploaded(userID, orderID)); err != nil {
+			return nil, err
+		}
+	}
+
+	return errs, tx.Commit()
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// sqliteAddOrderEvent appends event to the order processing history in tx
+func sqliteAddOrderEvent(ctx context.Context, tx *sql.Tx, orderID string, event *loyalty.OrderEvent) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO order_events (orderID, status, accrual, error, reason, created) VALUES (?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?)
+	`, orderID, event.Status, event.Accrual, event.Error, event.Reason, sqliteNow())
+	return err
+}
+
+// GetOrderHistory returns processing history of order from the oldest event to the newest
+func (s *SQLiteStorage) GetOrderHistory(ctx context.Context, orderID string) ([]*loyalty.OrderEvent, error) {
+
+	history := make([]*loyalty.OrderEvent, 0)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT status, accrual, COALESCE(error, ''), COALESCE(reason, ''), created FROM order_events WHERE orderID = ? ORDER BY id
+	`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event := &loyalty.OrderEvent{}
+		if err := rows.Scan(&event.Status, &event.Accrual, &event.Error, &event.Reason, scanSQLiteTime(&event.Created)); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to OrderEvent",
+				zap.Error(err),
+			)
+			continue
+		}
+		history = append(history, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// GetOrderStatusEvents returns up to limit changes of order status recorded after event afterID
+func (s *SQLiteStorage) GetOrderStatusEvents(ctx context.Context, afterID uint64, limit int) ([]*loyalty.OrderStatusEvent, error) {
+
+	events := make([]*loyalty.OrderStatusEvent, 0)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT e.id, o.userID, e.orderID, e.status, e.accrual, COALESCE(e.reason, ''), e.created
+		FROM order_events e JOIN orders o ON o.id = e.orderID
+		WHERE e.id > ? AND e.error IS NULL AND e.status != 'NEW'
+		ORDER BY e.id LIMIT ?
+	`, int64(afterID), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event := &loyalty.OrderStatusEvent{}
+		if err := rows.Scan(&event.ID, &event.UserID, &event.OrderID, &event.Status, &event.Accrual, &event.Reason, scanSQLiteTime(&event.Created)); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to OrderStatusEvent",
+				zap.Error(err),
+			)
+			continue
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// GetLastOrderEventID returns ID of the newest recorded order event, 0 if there are none
+func (s *SQLiteStorage) GetLastOrderEventID(ctx context.Context) (uint64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM order_events").Scan(&id)
+	return uint64(id), err
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/server/middlewares"
+)
+
+func (s *SQLiteStorage) StartIdempotent(ctx context.Context, rec *middlewares.IdempotentRecord, ttl, reservationTTL time.Duration) (*middlewares.IdempotentRecord, error) {
+	now := time.Now()
+
+	// Reserving new key or taking over the expired one or unfinished one left by crashed request
+	var reserved bool
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO idempotency_keys (userID, key, fingerprint, created) VALUES (?, ?, ?, ?)
+		ON CONFLICT (userID, key) DO UPDATE
+			SET fingerprint = excluded.fingerprint, completed = 0, statusCode = 0, contentType = '', body = NULL,
+				created = excluded.created
+			WHERE idempotency_keys.created < ?
+				OR (NOT idempotency_keys.completed AND idempotency_keys.created < ?)
+		RETURNING true
+	`, rec.UserID, rec.Key, rec.Fingerprint, sqliteTime(now), sqliteTime(now.Add(-ttl)), sqliteTime(now.Add(-reservationTTL))).Scan(&reserved)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	existing := &middlewares.IdempotentRecord{
+		UserID: rec.UserID,
+		Key:    rec.Key,
+	}
+	err = s.db.QueryRowContext(ctx, `
+		SELECT fingerprint, completed, statusCode, contentType, body FROM idempotency_keys WHERE userID = ? AND key = ?
+	`, rec.UserID, rec.Key).Scan(&existing.Fingerprint, &existing.Completed, &existing.StatusCode, &existing.ContentType, &existing.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return existing, nil
+}
+
+func (s *SQLiteStorage) FinishIdempotent(ctx context.Context, rec *middlewares.IdempotentRecord) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE idempotency_keys SET completed = 1, statusCode = ?, contentType = ?, body = ?
+		WHERE userID = ? AND key = ?
+	`, rec.StatusCode, rec.ContentType, rec.Body, rec.UserID, rec.Key)
+	return err
+}
+
+func (s *SQLiteStorage) CancelIdempotent(ctx context.Context, userID, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE userID = ? AND key = ? AND NOT completed", userID, key)
+	return err
+}
+
+// PruneIdempotent deletes keys created before the given time
+func (s *SQLiteStorage) PruneIdempotent(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created < ?", sqliteTime(before))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
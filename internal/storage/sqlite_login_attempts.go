@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+func (s *SQLiteStorage) ReserveLoginAttempt(ctx context.Context, key string, now time.Time, window time.Duration, threshold int, hold time.Duration) (int, time.Time, error) {
+	// The first attempt holds further ones if threshold is 1
+	var held any
+	if threshold <= 1 {
+		held = sqliteTime(now.Add(hold))
+	}
+
+	// Counting and holding are done by one statement, so concurrent attempts see each other
+	var attempts int
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO login_attempts (key, failures, lastFailure, lockedUntil) VALUES (?1, 1, ?2, ?6)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.lastFailure < ?3 THEN 1 ELSE login_attempts.failures + 1 END,
+			lastFailure = excluded.lastFailure,
+			lockedUntil = CASE
+				WHEN (CASE WHEN login_attempts.lastFailure < ?3 THEN 1 ELSE login_attempts.failures + 1 END) >= ?4
+				THEN MAX(COALESCE(login_attempts.lockedUntil, ?5), ?5)
+				ELSE login_attempts.lockedUntil
+			END
+		WHERE login_attempts.lockedUntil IS NULL OR login_attempts.lockedUntil <= ?2
+		RETURNING failures
+	`, key, sqliteTime(now), sqliteTime(now.Add(-window)), threshold, sqliteTime(now.Add(hold)), held).Scan(&attempts)
+	if err == nil {
+		return attempts, time.Time{}, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, time.Time{}, err
+	}
+
+	// Nothing is updated while attempts are locked
+	var lockedUntil time.Time
+	err = s.db.QueryRowContext(ctx, "SELECT failures, lockedUntil FROM login_attempts WHERE key = ?", key).Scan(&attempts, scanSQLiteTime(&lockedUntil))
+	return attempts, lockedUntil, err
+}
+
+func (s *SQLiteStorage) ReleaseLoginAttempt(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE login_attempts SET failures = MAX(failures - 1, 0) WHERE key = ?", key)
+	return err
+}
+
+func (s *SQLiteStorage) LockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE login_attempts SET lockedUntil = MAX(COALESCE(lockedUntil, ?), ?) WHERE key = ?
+	`, sqliteTime(until), sqliteTime(until), key)
+	return err
+}
+
+func (s *SQLiteStorage) ResetLoginFailures(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE key = ?", key)
+	return err
+}
+
+// PruneLoginAttempts deletes counters of attempts made and locked before the given time
+func (s *SQLiteStorage) PruneLoginAttempts(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM login_attempts WHERE lastFailure < ?1 AND (lockedUntil IS NULL OR lockedUntil < ?1)
+	`, sqliteTime(before))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
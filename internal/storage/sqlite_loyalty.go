@@ -0,0 +1,411 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/internal/outbox"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+func (s *SQLiteStorage) AddOrder(ctx context.Context, userID string, orderID string) error {
+	err := s.insertOrder(ctx, userID, orderID)
+	if err == nil {
+		return nil
+	}
+	if !isSQLiteUniqueViolation(err) {
+		return err
+	}
+
+	var uID string
+	if err := s.db.QueryRowContext(ctx, "SELECT userID FROM orders WHERE id = ?", orderID).Scan(&uID); err != nil {
+		return err
+	}
+
+	if uID != userID {
+		return loyalty.ErrOrderUploadedAnotherUser
+	}
+	return loyalty.ErrOrderAlreadyUploaded
+}
+
+// insertOrder inserts new order with upload event in its history and in outbox
+func (s *SQLiteStorage) insertOrder(ctx context.Context, userID string, orderID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Order number spent by withdrawal can`t be uploaded as accrual order,
+	// the only connection serializes the check with withdrawals
+	var withdrawn bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT * FROM withdrawals WHERE orderID = ?)", orderID).Scan(&withdrawn)
+	if err != nil {
+		return err
+	}
+	if withdrawn {
+		return loyalty.ErrOrderUsedByWithdrawal
+	}
+
+	now := sqliteNow()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO orders (id, userID, status, uploaded, nextCheckAt) VALUES (?, ?, ?, ?, ?)
+	`, orderID, userID, loyalty.TypeStatusNew, now, now)
+	if err != nil {
+		return err
+	}
+
+	if err := sqliteAddOrderEvent(ctx, tx, orderID, &loyalty.OrderEvent{Status: loyalty.TypeStatusNew}); err != nil {
+		return err
+	}
+
+	if err := sqliteAddOutboxEvent(ctx, tx, outbox.OrderUploaded(userID, orderID)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetOrders returns page of user orders in order of upload selected by filter
+func (s *SQLiteStorage) GetOrders(ctx context.Context, userID string, filter loyalty.ListFilter) ([]*loyalty.Order, *loyalty.Cursor, error) {
+
+	orders := make([]*loyalty.Order, 0)
+
+	query, args := sqliteOrdersQuery.build("SELECT id, userID, status, accrual, uploaded, COALESCE(reason, '') FROM orders WHERE userID = ?", userID, filter)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		order := &loyalty.Order{}
+		if err := rows.Scan(&order.ID, &order.UserID, &order.Status, &order.Accrual, scanSQLiteTime(&order.Uploaded), &order.Reason); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to Order",
+				zap.Error(err),
+			)
+			continue
+		}
+		orders = append(orders, order)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	orders, next := loyalty.Paginate(orders, filter.Limit, loyalty.OrderCursor)
+	return orders, next, nil
+}
+
+// GetWithdrawals returns page of user withdrawals in order of creation selected by filter
+func (s *SQLiteStorage) GetWithdrawals(ctx context.Context, userID string, filter loyalty.ListFilter) ([]*loyalty.Withdraw, *loyalty.Cursor, error) {
+
+	withdrawals := make([]*loyalty.Withdraw, 0)
+
+	query, args := sqliteWithdrawalsQuery.build("SELECT orderID, userID, sum, created FROM withdrawals WHERE userID = ?", userID, filter)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		withdraw := &loyalty.Withdraw{}
+		if err := rows.Scan(&withdraw.OrderID, &withdraw.UserID, &withdraw.Sum, scanSQLiteTime(&withdraw.Created)); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to Withdraw",
+				zap.Error(err),
+			)
+			continue
+		}
+		withdrawals = append(withdrawals, withdraw)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	withdrawals, next := loyalty.Paginate(withdrawals, filter.Limit, loyalty.WithdrawCursor)
+	return withdrawals, next, nil
+}
+
+func (s *SQLiteStorage) GetOrder(ctx context.Context, orderID string) (*loyalty.Order, error) {
+	orderRow := s.db.QueryRowContext(ctx, "SELECT id, userID, status, accrual, uploaded, COALESCE(reason, ''), lastCheckedAt FROM orders WHERE id = ?", orderID)
+
+	order := &loyalty.Order{}
+
+	if err := orderRow.Scan(&order.ID, &order.UserID, &order.Status, &order.Accrual, scanSQLiteTime(&order.Uploaded), &order.Reason, scanSQLiteTime(&order.LastCheckedAt)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, loyalty.ErrOrderNotFound
+		}
+		logger.Log.Debug(
+			"error on scanning row to Order",
+			zap.Error(err),
+		)
+		return nil, err
+	}
+
+	return order, orderRow.Err()
+}
+
+// GetBalance returns running balances of the newest postings to user accounts
+func (s *SQLiteStorage) GetBalance(ctx context.Context, userID string) (*loyalty.Balance, error) {
+	row := s.db.QueryRowContext(ctx, sqliteLastBalancesQuery, loyalty.UserAccount(userID), loyalty.WithdrawnAccount(userID))
+
+	balance := &loyalty.Balance{}
+	if err := row.Scan(&balance.Current, &balance.Withdrawn); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return balance, nil
+		}
+		return nil, err
+	}
+
+	return balance, row.Err()
+}
+
+func (s *SQLiteStorage) AddWithdraw(ctx context.Context, wr *loyalty.Withdraw) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Withdrawal order number must be used neither by another withdrawal nor by uploaded accrual order
+	var orderUsed bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT * FROM withdrawals WHERE orderID = ?1) OR EXISTS(SELECT * FROM orders WHERE id = ?1)
+	`, wr.OrderID).Scan(&orderUsed)
+	if err != nil {
+		return err
+	}
+	if orderUsed {
+		return loyalty.ErrWithdrawOrderDuplicate
+	}
+
+	last, err := sqliteLastLedgerEntry(ctx, tx, wr.UserID)
+	if err != nil {
+		return err
+	}
+
+	if last == nil || wr.Sum > last.Balance {
+		return loyalty.ErrWithdrawNotEnoughPoints
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO withdrawals (orderID, userID, sum, created) VALUES (?, ?, ?, ?)", wr.OrderID, wr.UserID, wr.Sum, sqliteNow())
+	if err != nil {
+		if isSQLiteUniqueViolation(err) {
+			return loyalty.ErrWithdrawOrderDuplicate
+		}
+		return err
+	}
+
+	err = sqliteAppendLedgerEntry(ctx, tx, &loyalty.LedgerEntry{
+		UserID:    wr.UserID,
+		Type:      loyalty.TypeEntryWithdrawal,
+		Reference: wr.OrderID,
+		Amount:    -wr.Sum,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := sqliteAddOutboxEvent(ctx, tx, outbox.WithdrawCreated(wr)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetUnhandledOrders claims up to limit not processed orders which are due to check for owner
+func (s *SQLiteStorage) GetUnhandledOrders(ctx context.Context, owner string, lease time.Duration, limit int) ([]*loyalty.Order, error) {
+	orders := make([]*loyalty.Order, 0)
+
+	now := time.Now()
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE orders SET leaseOwner = ?, leaseUntil = ?
+		WHERE id IN (
+			SELECT id FROM orders
+			WHERE status != ? AND status != ?
+				AND nextCheckAt <= ?
+				AND (leaseUntil IS NULL OR leaseUntil < ?)
+			ORDER BY nextCheckAt
+			LIMIT ?
+		)
+		RETURNING id, userID, status, accrual, uploaded, attempts, COALESCE(lastError, ''), nextCheckAt
+	`, owner, sqliteTime(now.Add(lease)), loyalty.TypeStatusProcessed, loyalty.TypeStatusInvalid, sqliteTime(now), sqliteTime(now), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		order := &loyalty.Order{}
+		if err := rows.Scan(&order.ID, &order.UserID, &order.Status, &order.Accrual, scanSQLiteTime(&order.Uploaded), &order.Attempts, &order.LastError, scanSQLiteTime(&order.NextCheckAt)); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to Order",
+				zap.Error(err),
+			)
+			continue
+		}
+		orders = append(orders, order)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING doesn`t keep the order of subquery
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].NextCheckAt.Before(orders[j].NextCheckAt)
+	})
+
+	return orders, nil
+}
+
+// sqliteLeasedOrder returns current state of order leased by owner,
+// returns ErrOrderLeaseLost if order isn`t leased by owner
+func sqliteLeasedOrder(ctx context.Context, tx *sql.Tx, owner string, orderID string) (*loyalty.Order, error) {
+	order := &loyalty.Order{ID: orderID}
+	err := tx.QueryRowContext(ctx, `
+		SELECT userID, status, accrual, COALESCE(lastError, '') FROM orders WHERE id=? AND leaseOwner=?
+	`, orderID, owner).Scan(&order.UserID, &order.Status, &order.Accrual, &order.LastError)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, loyalty.ErrOrderLeaseLost
+		}
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// UpdateOrder saves order status and accrual, schedules next check after nextCheck and releases the lease.
+// Changes of status or accrual are recorded in order history, accrual of processed order is credited
+// to the user ledger. Only lease owner can update the order.
+func (s *SQLiteStorage) UpdateOrder(ctx context.Context, owner string, order *loyalty.Order, nextCheck time.Duration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := sqliteLeasedOrder(ctx, tx, owner, order.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `
+		UPDATE orders SET status=?, accrual=?, attempts=attempts+1, lastError=NULL,
+			nextCheckAt=?, lastCheckedAt=?, leaseOwner=NULL, leaseUntil=NULL
+		WHERE id=?
+	`, order.Status, order.Accrual, sqliteTime(now.Add(nextCheck)), sqliteTime(now), order.ID)
+	if err != nil {
+		return err
+	}
+
+	if current.Status != order.Status || current.Accrual != order.Accrual {
+		if err := sqliteAddOrderEvent(ctx, tx, order.ID, &loyalty.OrderEvent{Status: order.Status, Accrual: order.Accrual}); err != nil {
+			return err
+		}
+	}
+
+	if order.Status == loyalty.TypeStatusProcessed && order.Accrual > 0 {
+		err = sqliteAppendLedgerEntry(ctx, tx, &loyalty.LedgerEntry{
+			UserID:    current.UserID,
+			Type:      loyalty.TypeEntryAccrual,
+			Reference: order.ID,
+			Amount:    order.Accrual,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if current.Status != order.Status || current.Accrual != order.Accrual {
+		changed := &loyalty.Order{ID: order.ID, UserID: current.UserID, Status: order.Status, Accrual: order.Accrual}
+		if err := sqliteAddOutboxEvent(ctx, tx, outbox.OrderStatusChanged(changed)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RescheduleOrder records failed check of order leased by owner and schedules next check after nextCheck.
+// Only changes of the error are recorded in order history.
+func (s *SQLiteStorage) RescheduleOrder(ctx context.Context, owner string, orderID string, lastError string, nextCheck time.Duration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := sqliteLeasedOrder(ctx, tx, owner, orderID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `
+		UPDATE orders SET attempts=attempts+1, lastError=?, nextCheckAt=?, lastCheckedAt=?, leaseOwner=NULL, leaseUntil=NULL
+		WHERE id=?
+	`, lastError, sqliteTime(now.Add(nextCheck)), sqliteTime(now), orderID)
+	if err != nil {
+		return err
+	}
+
+	if current.LastError != lastError {
+		if err := sqliteAddOrderEvent(ctx, tx, orderID, &loyalty.OrderEvent{Status: current.Status, Error: lastError}); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ExpireOrder moves order leased by owner to terminal INVALID status with reason
+func (s *SQLiteStorage) ExpireOrder(ctx context.Context, owner string, orderID string, reason string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := sqliteLeasedOrder(ctx, tx, owner, orderID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE orders SET status=?, reason=?, leaseOwner=NULL, leaseUntil=NULL WHERE id=?
+	`, loyalty.TypeStatusInvalid, reason, orderID)
+	if err != nil {
+		return err
+	}
+
+	if err := sqliteAddOrderEvent(ctx, tx, orderID, &loyalty.OrderEvent{Status: loyalty.TypeStatusInvalid, Reason: reason}); err != nil {
+		return err
+	}
+
+	expired := &loyalty.Order{ID: orderID, UserID: current.UserID, Status: loyalty.TypeStatusInvalid, Reason: reason}
+	if err := sqliteAddOutboxEvent(ctx, tx, outbox.OrderStatusChanged(expired)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReleaseOrder returns order leased by owner back to the unhandled orders
+func (s *SQLiteStorage) ReleaseOrder(ctx context.Context, owner string, orderID string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE orders SET leaseOwner=NULL, leaseUntil=NULL WHERE id=? AND leaseOwner=?", orderID, owner)
+	return err
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/outbox"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// sqliteAddOutboxEvent writes event to outbox in tx
+func sqliteAddOutboxEvent(ctx context.Context, tx *sql.Tx, event *outbox.Event) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO outbox (userID, type, aggregateID, payload, created) VALUES (?, ?, ?, ?, ?)
+	`, event.UserID, event.Type, event.AggregateID, []byte(event.Payload), sqliteNow())
+	return err
+}
+
+func (s *SQLiteStorage) ClaimOutboxEvents(ctx context.Context, owner string, lease time.Duration, limit int) ([]*outbox.Event, error) {
+	events := make([]*outbox.Event, 0)
+
+	now := time.Now()
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE outbox SET leaseOwner = ?1, leaseUntil = ?2
+		WHERE id IN (
+			SELECT o.id FROM outbox o
+			WHERE o.published IS NULL AND o.failed IS NULL AND NOT EXISTS (
+				SELECT * FROM outbox l
+				WHERE l.userID = o.userID AND l.published IS NULL AND l.failed IS NULL AND (
+					(l.leaseOwner != ?1 AND l.leaseUntil >= ?3) OR l.nextAttemptAt > ?3
+				)
+			)
+			ORDER BY o.id
+			LIMIT ?4
+		)
+		RETURNING id, userID, type, aggregateID, payload, created, attempts
+	`, owner, sqliteTime(now.Add(lease)), sqliteTime(now), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event := &outbox.Event{}
+		if err := rows.Scan(&event.ID, &event.UserID, &event.Type, &event.AggregateID, (*[]byte)(&event.Payload), scanSQLiteTime(&event.Created), &event.Attempts); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to outbox Event",
+				zap.Error(err),
+			)
+			continue
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING doesn`t keep the order of subquery
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+
+	return events, nil
+}
+
+func (s *SQLiteStorage) MarkOutboxPublished(ctx context.Context, owner string, eventID int64) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE outbox SET published = ?, leaseOwner = NULL, leaseUntil = NULL
+		WHERE id = ? AND leaseOwner = ? AND published IS NULL
+	`, sqliteNow(), eventID, owner)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return outbox.ErrEventLeaseLost
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) FailOutboxEvent(ctx context.Context, owner string, eventID int64, lastError string, retryAfter time.Duration) error {
+	return s.failOutboxEvent(ctx, owner, eventID, `
+		UPDATE outbox SET attempts = attempts + 1, lastError = ?, nextAttemptAt = ?
+		WHERE id = ? AND leaseOwner = ? AND published IS NULL AND failed IS NULL
+		RETURNING userID
+	`, lastError, sqliteTime(time.Now().Add(retryAfter)), eventID, owner)
+}
+
+func (s *SQLiteStorage) DeadLetterOutboxEvent(ctx context.Context, owner string, eventID int64, lastError string) error {
+	return s.failOutboxEvent(ctx, owner, eventID, `
+		UPDATE outbox SET attempts = attempts + 1, lastError = ?, failed = ?, leaseOwner = NULL, leaseUntil = NULL
+		WHERE id = ? AND leaseOwner = ? AND published IS NULL AND failed IS NULL
+		RETURNING userID
+	`, lastError, sqliteNow(), eventID, owner)
+}
+
+// failOutboxEvent records failure of event leased by owner with query returning its user
+// and releases all the events of the user leased by owner
+func (s *SQLiteStorage) failOutboxEvent(ctx context.Context, owner string, eventID int64, query string, args ...any) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID string
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return outbox.ErrEventLeaseLost
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE outbox SET leaseOwner = NULL, leaseUntil = NULL WHERE userID = ? AND leaseOwner = ? AND published IS NULL
+	`, userID, owner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) PruneOutboxEvents(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM outbox WHERE published < ?", sqliteTime(before))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
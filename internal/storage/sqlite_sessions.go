@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+func (s *SQLiteStorage) AddSession(ctx context.Context, session *auth.Session, tokenHash string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO sessions (id, userID, userAgent, ip, created, lastUsed, expires) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, session.ID, session.UserID, session.UserAgent, session.IP, sqliteTime(session.Created), sqliteTime(session.Created), sqliteTime(session.Expires))
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO refresh_tokens (hash, sessionID, created) VALUES (?, ?, ?)", tokenHash, session.ID, sqliteNow()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) RotateRefreshToken(ctx context.Context, tokenHash, nextHash string, expires time.Time) (*auth.Session, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		sessionID string
+		used      bool
+		active    bool
+	)
+
+	now := sqliteNow()
+	err = tx.QueryRowContext(ctx, `
+		SELECT s.id, t.used, s.revoked IS NULL AND s.expires > ?
+		FROM refresh_tokens t JOIN sessions s ON s.id = t.sessionID
+		WHERE t.hash = ?
+	`, now, tokenHash).Scan(&sessionID, &used, &active)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, auth.ErrRefreshTokenInvalid
+		}
+		return nil, err
+	}
+
+	if !active {
+		return nil, auth.ErrRefreshTokenInvalid
+	}
+
+	if used {
+		if _, err := tx.ExecContext(ctx, "UPDATE sessions SET revoked = ? WHERE id = ?", now, sessionID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, auth.ErrRefreshTokenReused
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used = 1 WHERE hash = ?", tokenHash); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO refresh_tokens (hash, sessionID, created) VALUES (?, ?, ?)", nextHash, sessionID, now); err != nil {
+		return nil, err
+	}
+
+	session := &auth.Session{}
+	err = tx.QueryRowContext(ctx, `
+		UPDATE sessions SET lastUsed = ?, expires = ? WHERE id = ?
+		RETURNING id, userID, userAgent, ip, created, lastUsed, expires
+	`, now, sqliteTime(expires), sessionID).Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP, scanSQLiteTime(&session.Created), scanSQLiteTime(&session.LastUsed), scanSQLiteTime(&session.Expires))
+	if err != nil {
+		return nil, err
+	}
+
+	return session, tx.Commit()
+}
+
+func (s *SQLiteStorage) GetSessions(ctx context.Context, userID string) ([]*auth.Session, error) {
+
+	sessions := make([]*auth.Session, 0)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, userID, userAgent, ip, created, lastUsed, expires FROM sessions
+		WHERE userID = ? AND revoked IS NULL AND expires > ?
+		ORDER BY created, id
+	`, userID, sqliteNow())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		session := &auth.Session{}
+		if err := rows.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP, scanSQLiteTime(&session.Created), scanSQLiteTime(&session.LastUsed), scanSQLiteTime(&session.Expires)); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to Session",
+				zap.Error(err),
+			)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (s *SQLiteStorage) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	now := sqliteNow()
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE sessions SET revoked = ?
+		WHERE id = ? AND userID = ? AND revoked IS NULL AND expires > ?
+	`, now, sessionID, userID, now)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return auth.ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) IsSessionActive(ctx context.Context, userID, sessionID string) (bool, error) {
+	var active bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT * FROM sessions
+			WHERE id = ? AND userID = ? AND revoked IS NULL AND expires > ?
+		)
+	`, sessionID, userID, sqliteNow()).Scan(&active)
+	return active, err
+}
+
+// PruneSessions deletes sessions expired or revoked before the given time with their refresh tokens
+func (s *SQLiteStorage) PruneSessions(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Tokens are deleted explicitly, cascade works only if foreign keys are enabled for connection
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM refresh_tokens WHERE sessionID IN (SELECT id FROM sessions WHERE expires < ?1 OR revoked < ?1)
+	`, sqliteTime(before))
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM sessions WHERE expires < ?1 OR revoked < ?1", sqliteTime(before))
+	if err != nil {
+		return 0, err
+	}
+
+	pruned, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return pruned, tx.Commit()
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/renatus-cartesius/gophermart/cmd/gophermart/migrations"
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+)
+
+// newTestSQLiteStorage creates SQLite database in temporary directory and applies migrations
+func newTestSQLiteStorage(t *testing.T) *SQLiteStorage {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "gophermart.db")+"?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatalf("error on opening database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if err := migrations.UpSQLite(db); err != nil {
+		t.Fatalf("error on applying migrations: %v", err)
+	}
+
+	return NewSQLiteStorage(db)
+}
+
+func TestSQLiteStorage_AddUser(t *testing.T) {
+	s := newTestSQLiteStorage(t)
+
+	ctx := context.Background()
+
+	if err := s.AddUser(ctx, "user", "hash"); err != nil {
+		t.Fatalf("SQLiteStorage.AddUser() error = %v", err)
+	}
+
+	if err := s.AddUser(ctx, "user", "hash"); !errors.Is(err, auth.ErrUserAlreadyExists) {
+		t.Errorf("SQLiteStorage.AddUser() for existing user error = %v, want %v", err, auth.ErrUserAlreadyExists)
+	}
+
+	if exists, err := s.IsUserExists(ctx, "user"); err != nil || !exists {
+		t.Errorf("SQLiteStorage.IsUserExists() = %v, %v, want true", exists, err)
+	}
+
+	if hash, err := s.GetHash(ctx, "user"); err != nil || hash != "hash" {
+		t.Errorf("SQLiteStorage.GetHash() = %v, %v, want hash", hash, err)
+	}
+}
@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/webhooks"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+func (s *SQLiteStorage) AddWebhook(ctx context.Context, hook *webhooks.Webhook, limit int) error {
+	// Insert is skipped if user has limit webhooks, the only connection makes count and insert atomic
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO webhooks (id, userID, url, secret, created)
+		SELECT ?1, ?2, ?3, ?4, ?5 WHERE (SELECT count(*) FROM webhooks WHERE userID = ?2) < ?6
+	`, hook.ID, hook.UserID, hook.URL, hook.Secret, sqliteTime(hook.Created), limit)
+	if err != nil {
+		return err
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return webhooks.ErrWebhookLimit
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) GetWebhooks(ctx context.Context, userID string) ([]*webhooks.Webhook, error) {
+
+	hooks := make([]*webhooks.Webhook, 0)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, userID, url, secret, created FROM webhooks WHERE userID = ? ORDER BY created, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		hook := &webhooks.Webhook{}
+		if err := rows.Scan(&hook.ID, &hook.UserID, &hook.URL, &hook.Secret, scanSQLiteTime(&hook.Created)); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to Webhook",
+				zap.Error(err),
+			)
+			continue
+		}
+		hooks = append(hooks, hook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hooks, nil
+}
+
+func (s *SQLiteStorage) DeleteWebhook(ctx context.Context, userID string, webhookID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ? AND userID = ?", webhookID, userID)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return webhooks.ErrWebhookNotFound
+	}
+
+	// Deliveries are deleted explicitly, because cascade works only with foreign_keys pragma enabled
+	if _, err := tx.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE webhookID = ?", webhookID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) EnqueueEvent(ctx context.Context, event *webhooks.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	now := sqliteNow()
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhookID, userID, eventID, eventType, payload, nextAttemptAt, created)
+		SELECT id, userID, ?, ?, ?, ?, ? FROM webhooks WHERE userID = ?
+		ON CONFLICT (webhookID, eventID) DO NOTHING
+	`, event.ID, event.Type, payload, now, now, event.UserID)
+	return err
+}
+
+func (s *SQLiteStorage) GetDueDeliveries(ctx context.Context, lease time.Duration, limit int) ([]*webhooks.Delivery, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	deliveries := make([]*webhooks.Delivery, 0)
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT d.id, d.webhookID, d.userID, w.url, w.secret, d.eventID, d.eventType, d.payload, d.status, d.attempts,
+			d.nextAttemptAt, d.lastStatusCode, COALESCE(d.lastError, ''), d.created
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhookID
+		WHERE d.status = 'PENDING' AND d.nextAttemptAt <= ?
+		ORDER BY d.id
+		LIMIT ?
+	`, sqliteTime(now), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		delivery := &webhooks.Delivery{}
+		if err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.UserID, &delivery.URL, &delivery.Secret, &delivery.EventID,
+			&delivery.EventType, &delivery.Payload, &delivery.Status, &delivery.Attempts, scanSQLiteTime(&delivery.NextAttemptAt),
+			&delivery.LastStatusCode, &delivery.LastError, scanSQLiteTime(&delivery.Created)); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to Delivery",
+				zap.Error(err),
+			)
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(deliveries) == 0 {
+		return deliveries, nil
+	}
+
+	// Claiming selected deliveries by pushing their next attempt time
+	ids := make([]any, 0, len(deliveries)+1)
+	ids = append(ids, sqliteTime(now.Add(lease)))
+	for _, delivery := range deliveries {
+		ids = append(ids, delivery.ID)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(deliveries)), ", ")
+	if _, err := tx.ExecContext(ctx, "UPDATE webhook_deliveries SET nextAttemptAt = ? WHERE id IN ("+placeholders+")", ids...); err != nil {
+		return nil, err
+	}
+
+	return deliveries, tx.Commit()
+}
+
+func (s *SQLiteStorage) UpdateDelivery(ctx context.Context, delivery *webhooks.Delivery) error {
+	var deliveredAt any
+	if delivery.DeliveredAt != nil {
+		deliveredAt = sqliteTime(*delivery.DeliveredAt)
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = ?, attempts = ?, nextAttemptAt = ?, lastStatusCode = ?, lastError = NULLIF(?, ''), deliveredAt = ?
+		WHERE id = ?
+	`, delivery.Status, delivery.Attempts, sqliteTime(delivery.NextAttemptAt), delivery.LastStatusCode, delivery.LastError, deliveredAt, delivery.ID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return webhooks.ErrDeliveryNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) GetDeliveries(ctx context.Context, userID string, webhookID string, limit int) ([]*webhooks.Delivery, error) {
+
+	deliveries := make([]*webhooks.Delivery, 0)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, webhookID, userID, eventID, eventType, payload, status, attempts, nextAttemptAt, lastStatusCode,
+			COALESCE(lastError, ''), created, deliveredAt
+		FROM webhook_deliveries WHERE userID = ? AND webhookID = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, userID, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		delivery := &webhooks.Delivery{}
+		var deliveredAt time.Time
+		if err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.UserID, &delivery.EventID, &delivery.EventType,
+			&delivery.Payload, &delivery.Status, &delivery.Attempts, scanSQLiteTime(&delivery.NextAttemptAt), &delivery.LastStatusCode,
+			&delivery.LastError, scanSQLiteTime(&delivery.Created), scanSQLiteTime(&deliveredAt)); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to Delivery",
+				zap.Error(err),
+			)
+			continue
+		}
+		if !deliveredAt.IsZero() {
+			delivery.DeliveredAt = &deliveredAt
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (s *SQLiteStorage) RedeliverDelivery(ctx context.Context, userID string, deliveryID int64) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = 'PENDING', attempts = 0, nextAttemptAt = ?, deliveredAt = NULL
+		WHERE id = ? AND userID = ?
+	`, sqliteNow(), deliveryID, userID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return webhooks.ErrDeliveryNotFound
+	}
+
+	return nil
+}
@@ -2,8 +2,14 @@ package storage
 
 import (
 	"database/sql"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+// pgUniqueViolation is postgres error code of unique constraint violation
+const pgUniqueViolation = "23505"
+
 type PGStorage struct {
 	db *sql.DB
 }
@@ -13,3 +19,9 @@ func NewPGStorage(db *sql.DB) *PGStorage {
 		db: db,
 	}
 }
+
+// isUniqueViolation reports whether err is postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
@@ -0,0 +1,1804 @@
+// Package storagetest implements conformance suite checking the contract of storage backends
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/auth"
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/internal/outbox"
+	"github.com/renatus-cartesius/gophermart/internal/server/middlewares"
+	"github.com/renatus-cartesius/gophermart/internal/webhooks"
+	"github.com/renatus-cartesius/gophermart/pkg/money"
+)
+
+// Storager is implemented by every storage backend
+type Storager interface {
+	loyalty.LoyaltyStorager
+	// GetLedgerPostings returns legs of ledger transaction, the suite checks they are balanced
+	GetLedgerPostings(ctx context.Context, transactionID int64) ([]loyalty.Posting, error)
+	auth.AuthStorager
+	webhooks.WebhookStorager
+	outbox.OutboxStorager
+	middlewares.IdempotencyStorager
+}
+
+// Factory returns storage under the test, it may be shared by several tests,
+// so the suite uses unique users and orders in every test
+type Factory func(t *testing.T) Storager
+
+var seq atomic.Int64
+
+// uniqueID returns identifier which isn`t used by other tests even in shared database
+func uniqueID(prefix string) string {
+	return fmt.Sprintf("%s-%d-%d", prefix, time.Now().UnixNano(), seq.Add(1))
+}
+
+// Run runs the whole conformance suite against storages created by newStorage
+func Run(t *testing.T, newStorage Factory) {
+	t.Run("Auth", func(t *testing.T) { testAuth(t, newStorage(t)) })
+	t.Run("Sessions", func(t *testing.T) { testSessions(t, newStorage(t)) })
+	t.Run("LoginAttempts", func(t *testing.T) { testLoginAttempts(t, newStorage(t)) })
+	t.Run("PruneSessions", func(t *testing.T) { testPruneSessions(t, newStorage(t)) })
+	t.Run("PruneLoginAttempts", func(t *testing.T) { testPruneLoginAttempts(t, newStorage(t)) })
+	t.Run("ReserveLoginAttemptConcurrent", func(t *testing.T) { testReserveLoginAttemptConcurrent(t, newStorage(t)) })
+	t.Run("AddOrder", func(t *testing.T) { testAddOrder(t, newStorage(t)) })
+	t.Run("AddOrderConcurrent", func(t *testing.T) { testAddOrderConcurrent(t, newStorage(t)) })
+	t.Run("AddOrders", func(t *testing.T) { testAddOrders(t, newStorage(t)) })
+	t.Run("GetOrder", func(t *testing.T) { testGetOrder(t, newStorage(t)) })
+	t.Run("GetOrders", func(t *testing.T) { testGetOrders(t, newStorage(t)) })
+	t.Run("GetOrdersPage", func(t *testing.T) { testGetOrdersPage(t, newStorage(t)) })
+	t.Run("GetWithdrawalsPage", func(t *testing.T) { testGetWithdrawalsPage(t, newStorage(t)) })
+	t.Run("GetBalance", func(t *testing.T) { testGetBalance(t, newStorage(t)) })
+	t.Run("AddWithdraw", func(t *testing.T) { testAddWithdraw(t, newStorage(t)) })
+	t.Run("AddWithdrawConcurrent", func(t *testing.T) { testAddWithdrawConcurrent(t, newStorage(t)) })
+	t.Run("GetWithdrawals", func(t *testing.T) { testGetWithdrawals(t, newStorage(t)) })
+	t.Run("AddLedgerEntry", func(t *testing.T) { testAddLedgerEntry(t, newStorage(t)) })
+	t.Run("LedgerPostings", func(t *testing.T) { testLedgerPostings(t, newStorage(t)) })
+	t.Run("OrderLease", func(t *testing.T) { testOrderLease(t, newStorage(t)) })
+	t.Run("GetUnhandledOrdersLease", func(t *testing.T) { testGetUnhandledOrdersLease(t, newStorage(t)) })
+	t.Run("GetUnhandledOrdersLimit", func(t *testing.T) { testGetUnhandledOrdersLimit(t, newStorage(t)) })
+	t.Run("RescheduleOrder", func(t *testing.T) { testRescheduleOrder(t, newStorage(t)) })
+	t.Run("ExpireOrder", func(t *testing.T) { testExpireOrder(t, newStorage(t)) })
+	t.Run("OrderHistory", func(t *testing.T) { testOrderHistory(t, newStorage(t)) })
+	t.Run("OrderStatusEvents", func(t *testing.T) { testOrderStatusEvents(t, newStorage(t)) })
+	t.Run("Webhooks", func(t *testing.T) { testWebhooks(t, newStorage(t)) })
+	t.Run("AddWebhookConcurrent", func(t *testing.T) { testAddWebhookConcurrent(t, newStorage(t)) })
+	t.Run("WebhookDeliveries", func(t *testing.T) { testWebhookDeliveries(t, newStorage(t)) })
+	t.Run("Outbox", func(t *testing.T) { testOutbox(t, newStorage(t)) })
+	t.Run("OutboxRetry", func(t *testing.T) { testOutboxRetry(t, newStorage(t)) })
+	t.Run("Idempotency", func(t *testing.T) { testIdempotency(t, newStorage(t)) })
+	t.Run("IdempotencyConcurrent", func(t *testing.T) { testIdempotencyConcurrent(t, newStorage(t)) })
+	t.Run("PruneIdempotent", func(t *testing.T) { testPruneIdempotent(t, newStorage(t)) })
+}
+
+// claimBatch is limit of orders claimed by one GetUnhandledOrders call in tests
+const claimBatch = 1000
+
+// addFunds credits amount to the user ledger
+func addFunds(t *testing.T, s Storager, userID string, amount money.Amount) {
+	t.Helper()
+
+	err := s.AddLedgerEntry(context.Background(), &loyalty.LedgerEntry{
+		UserID:    userID,
+		Type:      loyalty.TypeEntryAdjustment,
+		Reference: "test funds",
+		Amount:    amount,
+	})
+	if err != nil {
+		t.Fatalf("AddLedgerEntry() error = %v", err)
+	}
+}
+
+// claimOrder claims unhandled orders for owner and reports whether orderID is among them
+func claimOrder(t *testing.T, s Storager, owner, orderID string) bool {
+	t.Helper()
+
+	orders, err := s.GetUnhandledOrders(context.Background(), owner, time.Minute, claimBatch)
+	if err != nil {
+		t.Fatalf("GetUnhandledOrders() error = %v", err)
+	}
+
+	for _, order := range orders {
+		if order.ID == orderID {
+			return true
+		}
+	}
+	return false
+}
+
+func testAuth(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID := uniqueID("user")
+
+	exists, err := s.IsUserExists(ctx, userID)
+	if err != nil || exists {
+		t.Errorf("IsUserExists() for unknown user = %v, %v, want false", exists, err)
+	}
+
+	if _, err := s.GetHash(ctx, userID); !errors.Is(err, auth.ErrIncorrectUserCredentials) {
+		t.Errorf("GetHash() for unknown user error = %v, want %v", err, auth.ErrIncorrectUserCredentials)
+	}
+
+	if err := s.AddUser(ctx, userID, "hash"); err != nil {
+		t.Fatalf("AddUser() error = %v", err)
+	}
+
+	if err := s.AddUser(ctx, userID, "another hash"); !errors.Is(err, auth.ErrUserAlreadyExists) {
+		t.Errorf("AddUser() for existing user error = %v, want %v", err, auth.ErrUserAlreadyExists)
+	}
+
+	exists, err = s.IsUserExists(ctx, userID)
+	if err != nil || !exists {
+		t.Errorf("IsUserExists() = %v, %v, want true", exists, err)
+	}
+
+	hash, err := s.GetHash(ctx, userID)
+	if err != nil || hash != "hash" {
+		t.Errorf("GetHash() = %v, %v, want hash", hash, err)
+	}
+}
+
+// addSession adds session of user expiring after ttl with refresh token tokenHash
+func addSession(t *testing.T, s Storager, userID, tokenHash string, ttl time.Duration) *auth.Session {
+	t.Helper()
+
+	now := time.Now().UTC()
+	session := &auth.Session{
+		ID:        uniqueID("session"),
+		UserID:    userID,
+		UserAgent: "test",
+		IP:        "127.0.0.1",
+		Created:   now,
+		LastUsed:  now,
+		Expires:   now.Add(ttl),
+	}
+	if err := s.AddSession(context.Background(), session, tokenHash); err != nil {
+		t.Fatalf("AddSession() error = %v", err)
+	}
+	return session
+}
+
+func testSessions(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID := uniqueID("user")
+
+	first := uniqueID("token")
+	session := addSession(t, s, userID, first, time.Hour)
+	expired := addSession(t, s, userID, uniqueID("token"), -time.Minute)
+
+	sessions, err := s.GetSessions(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetSessions() error = %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != session.ID || sessions[0].UserAgent != "test" || sessions[0].IP != "127.0.0.1" {
+		t.Fatalf("GetSessions() = %+v, want only active session %v", sessions, session.ID)
+	}
+
+	if _, err := s.RotateRefreshToken(ctx, uniqueID("token"), uniqueID("token"), time.Now().Add(time.Hour)); !errors.Is(err, auth.ErrRefreshTokenInvalid) {
+		t.Errorf("RotateRefreshToken() of unknown token error = %v, want %v", err, auth.ErrRefreshTokenInvalid)
+	}
+	if err := s.RevokeSession(ctx, userID, expired.ID); !errors.Is(err, auth.ErrSessionNotFound) {
+		t.Errorf("RevokeSession() of expired session error = %v, want %v", err, auth.ErrSessionNotFound)
+	}
+
+	for _, tt := range []struct {
+		userID, sessionID string
+		want              bool
+	}{
+		{userID, session.ID, true},
+		{userID, expired.ID, false},
+		{uniqueID("user"), session.ID, false},
+		{userID, uniqueID("session"), false},
+	} {
+		if active, err := s.IsSessionActive(ctx, tt.userID, tt.sessionID); err != nil || active != tt.want {
+			t.Errorf("IsSessionActive(%v, %v) = %v, %v, want %v", tt.userID, tt.sessionID, active, err, tt.want)
+		}
+	}
+
+	second := uniqueID("token")
+	expires := time.Now().UTC().Add(2 * time.Hour)
+	rotated, err := s.RotateRefreshToken(ctx, first, second, expires)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken() error = %v", err)
+	}
+	if rotated.ID != session.ID || rotated.UserID != userID || rotated.Expires.Sub(expires).Abs() > time.Second {
+		t.Errorf("RotateRefreshToken() = %+v, want session %v prolonged till %v", rotated, session.ID, expires)
+	}
+
+	third := uniqueID("token")
+	if _, err := s.RotateRefreshToken(ctx, second, third, expires); err != nil {
+		t.Fatalf("RotateRefreshToken() of rotated token error = %v", err)
+	}
+
+	// Reusing the first token revokes the session, so its newest token can`t be used either
+	if _, err := s.RotateRefreshToken(ctx, first, uniqueID("token"), expires); !errors.Is(err, auth.ErrRefreshTokenReused) {
+		t.Fatalf("RotateRefreshToken() of used token error = %v, want %v", err, auth.ErrRefreshTokenReused)
+	}
+	if _, err := s.RotateRefreshToken(ctx, third, uniqueID("token"), expires); !errors.Is(err, auth.ErrRefreshTokenInvalid) {
+		t.Errorf("RotateRefreshToken() of revoked session error = %v, want %v", err, auth.ErrRefreshTokenInvalid)
+	}
+
+	sessions, err = s.GetSessions(ctx, userID)
+	if err != nil || len(sessions) != 0 {
+		t.Errorf("GetSessions() after reuse = %v, %v, want no sessions", len(sessions), err)
+	}
+
+	another := addSession(t, s, userID, uniqueID("token"), time.Hour)
+	if err := s.RevokeSession(ctx, uniqueID("user"), another.ID); !errors.Is(err, auth.ErrSessionNotFound) {
+		t.Errorf("RevokeSession() of another user error = %v, want %v", err, auth.ErrSessionNotFound)
+	}
+	if err := s.RevokeSession(ctx, userID, another.ID); err != nil {
+		t.Fatalf("RevokeSession() error = %v", err)
+	}
+	if active, err := s.IsSessionActive(ctx, userID, another.ID); err != nil || active {
+		t.Errorf("IsSessionActive() of revoked session = %v, %v, want false", active, err)
+	}
+	if err := s.RevokeSession(ctx, userID, another.ID); !errors.Is(err, auth.ErrSessionNotFound) {
+		t.Errorf("RevokeSession() of revoked session error = %v, want %v", err, auth.ErrSessionNotFound)
+	}
+}
+
+func testPruneSessions(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID := uniqueID("user")
+
+	activeToken := uniqueID("token")
+	active := addSession(t, s, userID, activeToken, time.Hour)
+	addSession(t, s, userID, uniqueID("token"), -time.Minute)
+	revoked := addSession(t, s, userID, uniqueID("token"), time.Hour)
+	if err := s.RevokeSession(ctx, userID, revoked.ID); err != nil {
+		t.Fatalf("RevokeSession() error = %v", err)
+	}
+
+	// Only sessions which ended before the given time are pruned
+	if _, err := s.PruneSessions(ctx, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("PruneSessions() error = %v", err)
+	}
+	if pruned, err := s.PruneSessions(ctx, time.Now().Add(time.Minute)); err != nil || pruned < 2 {
+		t.Errorf("PruneSessions() = %v, %v, want expired and revoked sessions pruned", pruned, err)
+	}
+	if pruned, err := s.PruneSessions(ctx, time.Now().Add(time.Minute)); err != nil || pruned != 0 {
+		t.Errorf("PruneSessions() again = %v, %v, want 0", pruned, err)
+	}
+
+	if isActive, err := s.IsSessionActive(ctx, userID, active.ID); err != nil || !isActive {
+		t.Errorf("IsSessionActive() of active session after pruning = %v, %v, want true", isActive, err)
+	}
+	if _, err := s.RotateRefreshToken(ctx, activeToken, uniqueID("token"), time.Now().Add(time.Hour)); err != nil {
+		t.Errorf("RotateRefreshToken() of active session after pruning error = %v", err)
+	}
+}
+
+func testPruneLoginAttempts(t *testing.T, s Storager) {
+	ctx := context.Background()
+	stale, locked := uniqueID("login"), uniqueID("login")
+	past := time.Now().UTC().Add(-2 * time.Hour)
+
+	if _, _, err := s.ReserveLoginAttempt(ctx, stale, past, time.Hour, 3, time.Minute); err != nil {
+		t.Fatalf("ReserveLoginAttempt() error = %v", err)
+	}
+	// The only attempt locks the key till an hour later than now
+	if _, _, err := s.ReserveLoginAttempt(ctx, locked, past, time.Hour, 1, 3*time.Hour); err != nil {
+		t.Fatalf("ReserveLoginAttempt() error = %v", err)
+	}
+
+	if pruned, err := s.PruneLoginAttempts(ctx, time.Now().Add(-time.Hour)); err != nil || pruned < 1 {
+		t.Errorf("PruneLoginAttempts() = %v, %v, want stale attempts pruned", pruned, err)
+	}
+	if pruned, err := s.PruneLoginAttempts(ctx, time.Now().Add(-time.Hour)); err != nil || pruned != 0 {
+		t.Errorf("PruneLoginAttempts() again = %v, %v, want 0", pruned, err)
+	}
+
+	if _, lockedUntil, err := s.ReserveLoginAttempt(ctx, locked, time.Now().UTC(), time.Hour, 1, 3*time.Hour); err != nil || lockedUntil.IsZero() {
+		t.Errorf("ReserveLoginAttempt() of locked key after pruning = %v, %v, want lock kept", lockedUntil, err)
+	}
+}
+
+func testLoginAttempts(t *testing.T, s Storager) {
+	ctx := context.Background()
+	key := uniqueID("login")
+	now := time.Now().UTC()
+
+	reserve := func(now time.Time) (int, time.Time) {
+		t.Helper()
+
+		attempts, lockedUntil, err := s.ReserveLoginAttempt(ctx, key, now, 24*time.Hour, 3, time.Minute)
+		if err != nil {
+			t.Fatalf("ReserveLoginAttempt() error = %v", err)
+		}
+		return attempts, lockedUntil
+	}
+
+	for want := 1; want <= 2; want++ {
+		if attempts, lockedUntil := reserve(now); attempts != want || !lockedUntil.IsZero() {
+			t.Fatalf("ReserveLoginAttempt() = %v, %v, want %v without lock", attempts, lockedUntil, want)
+		}
+	}
+
+	// Successful attempt is uncounted
+	if err := s.ReleaseLoginAttempt(ctx, key); err != nil {
+		t.Fatalf("ReleaseLoginAttempt() error = %v", err)
+	}
+	for want := 2; want <= 3; want++ {
+		if attempts, lockedUntil := reserve(now); attempts != want || !lockedUntil.IsZero() {
+			t.Fatalf("ReserveLoginAttempt() after release = %v, %v, want %v without lock", attempts, lockedUntil, want)
+		}
+	}
+
+	// Attempt reaching threshold holds the following ones, they aren`t counted
+	attempts, lockedUntil := reserve(now)
+	if attempts != 3 || lockedUntil.Sub(now.Add(time.Minute)).Abs() > time.Millisecond {
+		t.Errorf("ReserveLoginAttempt() after threshold = %v, %v, want 3 held for 1m", attempts, lockedUntil)
+	}
+
+	lock := now.Add(time.Hour)
+	if err := s.LockLogin(ctx, key, lock); err != nil {
+		t.Fatalf("LockLogin() error = %v", err)
+	}
+	if err := s.LockLogin(ctx, key, now.Add(time.Minute)); err != nil {
+		t.Fatalf("LockLogin() error = %v", err)
+	}
+
+	if _, lockedUntil := reserve(now.Add(30 * time.Minute)); lockedUntil.Sub(lock).Abs() > time.Millisecond {
+		t.Errorf("ReserveLoginAttempt() while locked = %v, want longer lock %v", lockedUntil, lock)
+	}
+
+	// Attempt after lock is counted and held again
+	unlocked := now.Add(time.Hour + time.Second)
+	if attempts, lockedUntil := reserve(unlocked); attempts != 4 || !lockedUntil.IsZero() {
+		t.Errorf("ReserveLoginAttempt() after lock = %v, %v, want 4 without lock", attempts, lockedUntil)
+	}
+	if _, lockedUntil := reserve(unlocked); lockedUntil.Sub(unlocked.Add(time.Minute)).Abs() > time.Millisecond {
+		t.Errorf("ReserveLoginAttempt() held by previous attempt = %v, want lock till %v", lockedUntil, unlocked.Add(time.Minute))
+	}
+
+	// Attempt after window passed starts counting from scratch
+	if attempts, lockedUntil := reserve(unlocked.Add(48 * time.Hour)); attempts != 1 || !lockedUntil.IsZero() {
+		t.Errorf("ReserveLoginAttempt() after window = %v, %v, want 1 without lock", attempts, lockedUntil)
+	}
+
+	if err := s.ResetLoginFailures(ctx, key); err != nil {
+		t.Fatalf("ResetLoginFailures() error = %v", err)
+	}
+	if attempts, lockedUntil := reserve(now); attempts != 1 || !lockedUntil.IsZero() {
+		t.Errorf("ReserveLoginAttempt() after reset = %v, %v, want 1 without lock", attempts, lockedUntil)
+	}
+}
+
+func testReserveLoginAttemptConcurrent(t *testing.T, s Storager) {
+	ctx := context.Background()
+	key := uniqueID("login")
+	now := time.Now().UTC()
+
+	const attempts = 50
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		reserved int
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, lockedUntil, err := s.ReserveLoginAttempt(ctx, key, now, time.Hour, 3, time.Minute)
+			if err != nil {
+				t.Errorf("ReserveLoginAttempt() error = %v", err)
+				return
+			}
+			if lockedUntil.IsZero() {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if reserved != 3 {
+		t.Errorf("ReserveLoginAttempt() reserved %v of %v concurrent attempts, want 3", reserved, attempts)
+	}
+}
+
+func testAddOrder(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID, anotherUserID, orderID := uniqueID("user"), uniqueID("user"), uniqueID("order")
+
+	if err := s.AddOrder(ctx, userID, orderID); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+
+	if err := s.AddOrder(ctx, userID, orderID); !errors.Is(err, loyalty.ErrOrderAlreadyUploaded) {
+		t.Errorf("AddOrder() for order of the same user error = %v, want %v", err, loyalty.ErrOrderAlreadyUploaded)
+	}
+
+	if err := s.AddOrder(ctx, anotherUserID, orderID); !errors.Is(err, loyalty.ErrOrderUploadedAnotherUser) {
+		t.Errorf("AddOrder() for order of another user error = %v, want %v", err, loyalty.ErrOrderUploadedAnotherUser)
+	}
+
+	order, err := s.GetOrder(ctx, orderID)
+	if err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+	if order.UserID != userID {
+		t.Errorf("order owner = %v, want %v", order.UserID, userID)
+	}
+
+	addFunds(t, s, userID, money.New(1, 0))
+	withdrawOrderID := uniqueID("withdraw")
+	if err := s.AddWithdraw(ctx, &loyalty.Withdraw{OrderID: withdrawOrderID, UserID: userID, Sum: money.New(1, 0)}); err != nil {
+		t.Fatalf("AddWithdraw() error = %v", err)
+	}
+
+	for _, uploader := range []string{userID, anotherUserID} {
+		if err := s.AddOrder(ctx, uploader, withdrawOrderID); !errors.Is(err, loyalty.ErrOrderUsedByWithdrawal) {
+			t.Errorf("AddOrder() for withdrawn order error = %v, want %v", err, loyalty.ErrOrderUsedByWithdrawal)
+		}
+	}
+	if _, err := s.GetOrder(ctx, withdrawOrderID); !errors.Is(err, loyalty.ErrOrderNotFound) {
+		t.Errorf("GetOrder() of withdrawn order error = %v, want %v", err, loyalty.ErrOrderNotFound)
+	}
+}
+
+func testAddOrders(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID, anotherUserID := uniqueID("user"), uniqueID("user")
+	uploaded, foreign, first, second := uniqueID("order"), uniqueID("order"), uniqueID("order"), uniqueID("order")
+	withdrawn := uniqueID("withdraw")
+
+	if err := s.AddOrder(ctx, userID, uploaded); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+	if err := s.AddOrder(ctx, anotherUserID, foreign); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+	addFunds(t, s, anotherUserID, money.New(1, 0))
+	if err := s.AddWithdraw(ctx, &loyalty.Withdraw{OrderID: withdrawn, UserID: anotherUserID, Sum: money.New(1, 0)}); err != nil {
+		t.Fatalf("AddWithdraw() error = %v", err)
+	}
+
+	errs, err := s.AddOrders(ctx, userID, []string{first, uploaded, foreign, withdrawn, second})
+	if err != nil {
+		t.Fatalf("AddOrders() error = %v", err)
+	}
+
+	want := []error{nil, loyalty.ErrOrderAlreadyUploaded, loyalty.ErrOrderUploadedAnotherUser, loyalty.ErrOrderUsedByWithdrawal, nil}
+	if len(errs) != len(want) {
+		t.Fatalf("AddOrders() returned %v errors, want %v", len(errs), len(want))
+	}
+	for i := range want {
+		if !errors.Is(errs[i], want[i]) || (want[i] == nil && errs[i] != nil) {
+			t.Errorf("AddOrders()[%v] error = %v, want %v", i, errs[i], want[i])
+		}
+	}
+
+	for _, orderID := range []string{first, second} {
+		order, err := s.GetOrder(ctx, orderID)
+		if err != nil {
+			t.Fatalf("GetOrder() error = %v", err)
+		}
+		if order.UserID != userID || order.Status != loyalty.TypeStatusNew {
+			t.Errorf("GetOrder() = %+v, want new order of %v", order, userID)
+		}
+
+		history, err := s.GetOrderHistory(ctx, orderID)
+		if err != nil {
+			t.Fatalf("GetOrderHistory() error = %v", err)
+		}
+		if len(history) != 1 || history[0].Status != loyalty.TypeStatusNew {
+			t.Errorf("GetOrderHistory() = %+v, want upload event", history)
+		}
+	}
+
+	foreignOrder, err := s.GetOrder(ctx, foreign)
+	if err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+	if foreignOrder.UserID != anotherUserID {
+		t.Errorf("GetOrder() user = %v, order of another user was taken over", foreignOrder.UserID)
+	}
+
+	events := claimOutbox(t, s, uniqueID("relay"), userID)
+	var uploads []string
+	for _, event := range events {
+		uploads = append(uploads, event.AggregateID)
+	}
+	if len(uploads) != 3 || uploads[0] != uploaded || uploads[1] != first || uploads[2] != second {
+		t.Errorf("outbox uploads = %v, want %v", uploads, []string{uploaded, first, second})
+	}
+}
+
+func testGetOrder(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID, orderID := uniqueID("user"), uniqueID("order")
+
+	if _, err := s.GetOrder(ctx, orderID); !errors.Is(err, loyalty.ErrOrderNotFound) {
+		t.Errorf("GetOrder() for unknown order error = %v, want %v", err, loyalty.ErrOrderNotFound)
+	}
+
+	before := time.Now().Add(-time.Second)
+	if err := s.AddOrder(ctx, userID, orderID); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+
+	order, err := s.GetOrder(ctx, orderID)
+	if err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+
+	if order.ID != orderID || order.UserID != userID || order.Status != loyalty.TypeStatusNew || order.Accrual != 0 {
+		t.Errorf("GetOrder() = %+v, want new order %v of user %v", order, orderID, userID)
+	}
+	if order.Uploaded.Before(before) {
+		t.Errorf("GetOrder() uploaded = %v, want after %v", order.Uploaded, before)
+	}
+}
+
+func testGetOrders(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID, anotherUserID := uniqueID("user"), uniqueID("user")
+
+	orders, _, err := s.GetOrders(ctx, userID, loyalty.ListFilter{})
+	if err != nil {
+		t.Fatalf("GetOrders() error = %v", err)
+	}
+	if orders == nil || len(orders) != 0 {
+		t.Errorf("GetOrders() for user without orders = %v, want empty slice", orders)
+	}
+
+	orderIDs := []string{uniqueID("order"), uniqueID("order"), uniqueID("order")}
+	for _, orderID := range orderIDs {
+		if err := s.AddOrder(ctx, userID, orderID); err != nil {
+			t.Fatalf("AddOrder() error = %v", err)
+		}
+		if err := s.AddOrder(ctx, anotherUserID, uniqueID("order")); err != nil {
+			t.Fatalf("AddOrder() error = %v", err)
+		}
+		// Orders must have different upload time in every backend
+		time.Sleep(time.Millisecond)
+	}
+
+	orders, _, err = s.GetOrders(ctx, userID, loyalty.ListFilter{})
+	if err != nil {
+		t.Fatalf("GetOrders() error = %v", err)
+	}
+
+	if len(orders) != len(orderIDs) {
+		t.Fatalf("GetOrders() returned %v orders, want %v", len(orders), len(orderIDs))
+	}
+
+	// Orders are sorted from the oldest to the newest
+	for i, order := range orders {
+		if order.ID != orderIDs[i] || order.UserID != userID {
+			t.Errorf("GetOrders()[%v] = %v of user %v, want %v of user %v", i, order.ID, order.UserID, orderIDs[i], userID)
+		}
+	}
+}
+
+func testGetOrdersPage(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID, owner := uniqueID("user"), uniqueID("owner")
+
+	orderIDs := make([]string, 5)
+	for i := range orderIDs {
+		orderIDs[i] = uniqueID("order")
+		if err := s.AddOrder(ctx, userID, orderIDs[i]); err != nil {
+			t.Fatalf("AddOrder() error = %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The second order becomes processed
+	if !claimOrder(t, s, owner, orderIDs[1]) {
+		t.Fatalf("GetUnhandledOrders() didn`t claim new order")
+	}
+	if err := s.UpdateOrder(ctx, owner, &loyalty.Order{ID: orderIDs[1], Status: loyalty.TypeStatusProcessed}, 0); err != nil {
+		t.Fatalf("UpdateOrder() error = %v", err)
+	}
+
+	got := make([]string, 0)
+	filter := loyalty.ListFilter{Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > len(orderIDs) {
+			t.Fatalf("GetOrders() pagination doesn`t end")
+		}
+
+		orders, next, err := s.GetOrders(ctx, userID, filter)
+		if err != nil {
+			t.Fatalf("GetOrders() error = %v", err)
+		}
+		if len(orders) > filter.Limit {
+			t.Fatalf("GetOrders() returned %v orders, want at most %v", len(orders), filter.Limit)
+		}
+		for _, order := range orders {
+			got = append(got, order.ID)
+		}
+
+		if next == nil {
+			break
+		}
+		if filter.After, err = loyalty.ParseCursor(next.String()); err != nil {
+			t.Fatalf("ParseCursor() error = %v", err)
+		}
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(orderIDs) {
+		t.Errorf("paginated GetOrders() = %v, want %v", got, orderIDs)
+	}
+
+	orders, next, err := s.GetOrders(ctx, userID, loyalty.ListFilter{Status: loyalty.TypeStatusNew})
+	if err != nil {
+		t.Fatalf("GetOrders() error = %v", err)
+	}
+	if len(orders) != 4 || next != nil {
+		t.Errorf("GetOrders() with status NEW returned %v orders and cursor %v, want 4 orders and no cursor", len(orders), next)
+	}
+
+	first, err := s.GetOrder(ctx, orderIDs[1])
+	if err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+	last, err := s.GetOrder(ctx, orderIDs[3])
+	if err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+
+	orders, _, err = s.GetOrders(ctx, userID, loyalty.ListFilter{From: first.Uploaded, To: last.Uploaded})
+	if err != nil {
+		t.Fatalf("GetOrders() error = %v", err)
+	}
+	if len(orders) != 2 || orders[0].ID != orderIDs[1] || orders[1].ID != orderIDs[2] {
+		t.Errorf("GetOrders() from second to fourth order returned %v orders, want second and third", len(orders))
+	}
+}
+
+func testGetWithdrawalsPage(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID := uniqueID("user")
+
+	addFunds(t, s, userID, money.New(100, 0))
+
+	orderIDs := make([]string, 3)
+	for i := range orderIDs {
+		orderIDs[i] = uniqueID("withdraw")
+		if err := s.AddWithdraw(ctx, &loyalty.Withdraw{OrderID: orderIDs[i], UserID: userID, Sum: money.New(1, 0)}); err != nil {
+			t.Fatalf("AddWithdraw() error = %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	withdrawals, next, err := s.GetWithdrawals(ctx, userID, loyalty.ListFilter{Limit: 2})
+	if err != nil {
+		t.Fatalf("GetWithdrawals() error = %v", err)
+	}
+	if len(withdrawals) != 2 || next == nil || withdrawals[1].OrderID != orderIDs[1] {
+		t.Fatalf("GetWithdrawals() first page = %v withdrawals with cursor %v, want 2 withdrawals with cursor", len(withdrawals), next)
+	}
+
+	withdrawals, next, err = s.GetWithdrawals(ctx, userID, loyalty.ListFilter{Limit: 2, After: next})
+	if err != nil {
+		t.Fatalf("GetWithdrawals() error = %v", err)
+	}
+	if len(withdrawals) != 1 || next != nil || withdrawals[0].OrderID != orderIDs[2] {
+		t.Errorf("GetWithdrawals() last page = %v withdrawals with cursor %v, want the last withdrawal without cursor", len(withdrawals), next)
+	}
+}
+
+func testGetBalance(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID := uniqueID("user")
+
+	balance, err := s.GetBalance(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetBalance() error = %v", err)
+	}
+	if balance.Current != 0 || balance.Withdrawn != 0 {
+		t.Errorf("GetBalance() for new user = %+v, want zero balance", balance)
+	}
+
+	addFunds(t, s, userID, money.MustParse("100.5"))
+
+	balance, err = s.GetBalance(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetBalance() error = %v", err)
+	}
+	if balance.Current != money.MustParse("100.5") || balance.Withdrawn != 0 {
+		t.Errorf("GetBalance() = %+v, want current 100.5", balance)
+	}
+}
+
+func testAddWithdraw(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID := uniqueID("user")
+
+	err := s.AddWithdraw(ctx, &loyalty.Withdraw{OrderID: uniqueID("withdraw"), UserID: userID, Sum: money.New(1, 0)})
+	if !errors.Is(err, loyalty.ErrWithdrawNotEnoughPoints) {
+		t.Errorf("AddWithdraw() without funds error = %v, want %v", err, loyalty.ErrWithdrawNotEnoughPoints)
+	}
+
+	addFunds(t, s, userID, money.New(100, 0))
+
+	err = s.AddWithdraw(ctx, &loyalty.Withdraw{OrderID: uniqueID("withdraw"), UserID: userID, Sum: money.MustParse("100.01")})
+	if !errors.Is(err, loyalty.ErrWithdrawNotEnoughPoints) {
+		t.Errorf("AddWithdraw() above balance error = %v, want %v", err, loyalty.ErrWithdrawNotEnoughPoints)
+	}
+
+	withdrawOrderID := uniqueID("withdraw")
+	if err := s.AddWithdraw(ctx, &loyalty.Withdraw{OrderID: withdrawOrderID, UserID: userID, Sum: money.MustParse("60.25")}); err != nil {
+		t.Fatalf("AddWithdraw() error = %v", err)
+	}
+
+	err = s.AddWithdraw(ctx, &loyalty.Withdraw{OrderID: withdrawOrderID, UserID: userID, Sum: money.New(1, 0)})
+	if !errors.Is(err, loyalty.ErrWithdrawOrderDuplicate) {
+		t.Errorf("AddWithdraw() for withdrawn order error = %v, want %v", err, loyalty.ErrWithdrawOrderDuplicate)
+	}
+
+	uploadedOrderID := uniqueID("order")
+	if err := s.AddOrder(ctx, userID, uploadedOrderID); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+
+	err = s.AddWithdraw(ctx, &loyalty.Withdraw{OrderID: uploadedOrderID, UserID: userID, Sum: money.New(1, 0)})
+	if !errors.Is(err, loyalty.ErrWithdrawOrderDuplicate) {
+		t.Errorf("AddWithdraw() for uploaded order error = %v, want %v", err, loyalty.ErrWithdrawOrderDuplicate)
+	}
+
+	balance, err := s.GetBalance(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetBalance() error = %v", err)
+	}
+	if balance.Current != money.MustParse("39.75") || balance.Withdrawn != money.MustParse("60.25") {
+		t.Errorf("GetBalance() = %+v, want current 39.75 and withdrawn 60.25", balance)
+	}
+}
+
+// testAddOrderConcurrent checks that concurrent uploads of one number by different users
+// store it once and report the owner to the others instead of failing
+func testAddOrderConcurrent(t *testing.T, s Storager) {
+	ctx := context.Background()
+	orderID := uniqueID("order")
+
+	const uploads = 20
+
+	wg := &sync.WaitGroup{}
+	errs := make(chan error, uploads)
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(userID string) {
+			defer wg.Done()
+			errs <- s.AddOrder(ctx, userID, orderID)
+		}(uniqueID("user"))
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case errors.Is(err, loyalty.ErrOrderUploadedAnotherUser):
+		default:
+			t.Errorf("AddOrder() unexpected error = %v", err)
+		}
+	}
+
+	if succeeded != 1 {
+		t.Errorf("%v uploads succeeded, want 1", succeeded)
+	}
+}
+
+// testAddWithdrawConcurrent checks that concurrent withdrawals of one user never overdraw the balance
+func testAddWithdrawConcurrent(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID := uniqueID("user")
+
+	addFunds(t, s, userID, money.New(100, 0))
+
+	const withdrawals = 50
+
+	wg := &sync.WaitGroup{}
+	errs := make(chan error, withdrawals)
+	for i := 0; i < withdrawals; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.AddWithdraw(ctx, &loyalty.Withdraw{
+				OrderID: uniqueID("withdraw"),
+				UserID:  userID,
+				Sum:     money.MustParse("10.01"),
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case errors.Is(err, loyalty.ErrWithdrawNotEnoughPoints):
+		default:
+			t.Errorf("AddWithdraw() unexpected error = %v", err)
+		}
+	}
+
+	if succeeded != 9 {
+		t.Errorf("%v withdrawals succeeded, want 9", succeeded)
+	}
+
+	balance, err := s.GetBalance(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetBalance() error = %v", err)
+	}
+	if balance.Current != money.MustParse("9.91") || balance.Withdrawn != money.MustParse("90.09") {
+		t.Errorf("GetBalance() = %+v, want current 9.91 and withdrawn 90.09", balance)
+	}
+
+	withdrawn, _, err := s.GetWithdrawals(ctx, userID, loyalty.ListFilter{})
+	if err != nil {
+		t.Fatalf("GetWithdrawals() error = %v", err)
+	}
+	if len(withdrawn) != 9 {
+		t.Errorf("GetWithdrawals() returned %v withdrawals, want 9", len(withdrawn))
+	}
+
+	ledger, err := s.GetLedger(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetLedger() error = %v", err)
+	}
+	for _, entry := range ledger {
+		if entry.Balance < 0 {
+			t.Errorf("ledger entry %v has negative balance %v", entry.ID, entry.Balance)
+		}
+	}
+}
+
+func testGetWithdrawals(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID := uniqueID("user")
+
+	withdrawals, _, err := s.GetWithdrawals(ctx, userID, loyalty.ListFilter{})
+	if err != nil {
+		t.Fatalf("GetWithdrawals() error = %v", err)
+	}
+	if withdrawals == nil || len(withdrawals) != 0 {
+		t.Errorf("GetWithdrawals() for user without withdrawals = %v, want empty slice", withdrawals)
+	}
+
+	addFunds(t, s, userID, money.New(100, 0))
+
+	orderIDs := []string{uniqueID("withdraw"), uniqueID("withdraw")}
+	for _, orderID := range orderIDs {
+		if err := s.AddWithdraw(ctx, &loyalty.Withdraw{OrderID: orderID, UserID: userID, Sum: money.MustParse("0.5")}); err != nil {
+			t.Fatalf("AddWithdraw() error = %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	withdrawals, _, err = s.GetWithdrawals(ctx, userID, loyalty.ListFilter{})
+	if err != nil {
+		t.Fatalf("GetWithdrawals() error = %v", err)
+	}
+
+	if len(withdrawals) != len(orderIDs) {
+		t.Fatalf("GetWithdrawals() returned %v withdrawals, want %v", len(withdrawals), len(orderIDs))
+	}
+
+	for i, withdraw := range withdrawals {
+		if withdraw.OrderID != orderIDs[i] || withdraw.UserID != userID || withdraw.Sum != money.MustParse("0.5") || withdraw.Created.IsZero() {
+			t.Errorf("GetWithdrawals()[%v] = %+v, want withdrawal %v of 0.5", i, withdraw, orderIDs[i])
+		}
+	}
+}
+
+func testAddLedgerEntry(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID := uniqueID("user")
+
+	addFunds(t, s, userID, money.New(10, 0))
+
+	err := s.AddLedgerEntry(ctx, &loyalty.LedgerEntry{
+		UserID:    userID,
+		Type:      loyalty.TypeEntryReversal,
+		Reference: "test reversal",
+		Amount:    -money.MustParse("10.01"),
+	})
+	if !errors.Is(err, loyalty.ErrLedgerNegativeBalance) {
+		t.Errorf("AddLedgerEntry() below zero error = %v, want %v", err, loyalty.ErrLedgerNegativeBalance)
+	}
+
+	entries, err := s.GetLedger(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetLedger() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Balance != money.New(10, 0) || entries[0].Type != loyalty.TypeEntryAdjustment {
+		t.Errorf("GetLedger() = %+v, want single adjustment with balance 10", entries)
+	}
+}
+
+// testLedgerPostings checks that every ledger transaction consists of legs summing to zero
+func testLedgerPostings(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID := uniqueID("user")
+
+	addFunds(t, s, userID, money.New(100, 0))
+	if err := s.AddWithdraw(ctx, &loyalty.Withdraw{OrderID: uniqueID("withdraw"), UserID: userID, Sum: money.New(30, 0)}); err != nil {
+		t.Fatalf("AddWithdraw() error = %v", err)
+	}
+
+	entries, err := s.GetLedger(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetLedger() error = %v", err)
+	}
+	if len(entries) != 2 || entries[1].Balance != money.New(70, 0) || entries[1].Withdrawn != money.New(30, 0) {
+		t.Fatalf("GetLedger() = %+v, want adjustment and withdrawal with balance 70 and withdrawn 30", entries)
+	}
+
+	for _, entry := range entries {
+		postings, err := s.GetLedgerPostings(ctx, entry.ID)
+		if err != nil {
+			t.Fatalf("GetLedgerPostings() error = %v", err)
+		}
+
+		var sum money.Amount
+		for _, posting := range postings {
+			sum += posting.Amount
+		}
+		if len(postings) < 2 || sum != 0 {
+			t.Errorf("GetLedgerPostings(%v) = %+v, want legs summing to zero", entry.ID, postings)
+			continue
+		}
+
+		if postings[0].Account != loyalty.UserAccount(userID) || postings[0].Amount != entry.Amount || postings[0].Balance != entry.Balance {
+			t.Errorf("GetLedgerPostings(%v) user leg = %+v, want amount %v and balance %v", entry.ID, postings[0], entry.Amount, entry.Balance)
+		}
+	}
+
+	balance, err := s.GetBalance(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetBalance() error = %v", err)
+	}
+	if balance.Current != money.New(70, 0) || balance.Withdrawn != money.New(30, 0) {
+		t.Errorf("GetBalance() = %+v, want current 70 and withdrawn 30", balance)
+	}
+}
+
+func testOrderLease(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID, orderID := uniqueID("user"), uniqueID("order")
+	first, second := uniqueID("owner"), uniqueID("owner")
+
+	if err := s.AddOrder(ctx, userID, orderID); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+
+	if !claimOrder(t, s, first, orderID) {
+		t.Fatalf("GetUnhandledOrders() didn`t claim new order")
+	}
+	if claimOrder(t, s, second, orderID) {
+		t.Fatalf("GetUnhandledOrders() claimed order leased by another owner")
+	}
+
+	order := &loyalty.Order{ID: orderID, Status: loyalty.TypeStatusProcessing}
+	if err := s.UpdateOrder(ctx, second, order, 0); !errors.Is(err, loyalty.ErrOrderLeaseLost) {
+		t.Errorf("UpdateOrder() by not owner error = %v, want %v", err, loyalty.ErrOrderLeaseLost)
+	}
+
+	if err := s.ReleaseOrder(ctx, first, orderID); err != nil {
+		t.Fatalf("ReleaseOrder() error = %v", err)
+	}
+	if !claimOrder(t, s, second, orderID) {
+		t.Fatalf("GetUnhandledOrders() didn`t claim released order")
+	}
+
+	order.Status, order.Accrual = loyalty.TypeStatusProcessed, money.MustParse("729.98")
+	if err := s.UpdateOrder(ctx, second, order, 0); err != nil {
+		t.Fatalf("UpdateOrder() error = %v", err)
+	}
+
+	if claimOrder(t, s, first, orderID) {
+		t.Errorf("GetUnhandledOrders() claimed processed order")
+	}
+
+	stored, err := s.GetOrder(ctx, orderID)
+	if err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+	if stored.Status != loyalty.TypeStatusProcessed || stored.Accrual != order.Accrual {
+		t.Errorf("GetOrder() = %+v, want processed with accrual %v", stored, order.Accrual)
+	}
+
+	balance, err := s.GetBalance(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetBalance() error = %v", err)
+	}
+	if balance.Current != order.Accrual {
+		t.Errorf("GetBalance() current = %v, want %v", balance.Current, order.Accrual)
+	}
+}
+
+// testGetUnhandledOrdersLease checks that concurrent owners never claim one order twice
+// and order with expired lease is claimed again
+func testGetUnhandledOrdersLease(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID := uniqueID("user")
+
+	orderIDs := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		orderID := uniqueID("order")
+		if err := s.AddOrder(ctx, userID, orderID); err != nil {
+			t.Fatalf("AddOrder() error = %v", err)
+		}
+		orderIDs[orderID] = true
+	}
+
+	const owners = 5
+
+	wg := &sync.WaitGroup{}
+	claims := make(chan []*loyalty.Order, owners)
+	for i := 0; i < owners; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			orders, err := s.GetUnhandledOrders(ctx, uniqueID("owner"), time.Minute, claimBatch)
+			if err != nil {
+				t.Errorf("GetUnhandledOrders() error = %v", err)
+			}
+			claims <- orders
+		}()
+	}
+	wg.Wait()
+	close(claims)
+
+	claimed := make(map[string]int)
+	for orders := range claims {
+		for _, order := range orders {
+			if orderIDs[order.ID] {
+				claimed[order.ID]++
+			}
+		}
+	}
+	for orderID := range orderIDs {
+		if claimed[orderID] != 1 {
+			t.Errorf("order %v is claimed %v times, want once", orderID, claimed[orderID])
+		}
+	}
+
+	expiredOrderID := uniqueID("order")
+	if err := s.AddOrder(ctx, userID, expiredOrderID); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+
+	first, second := uniqueID("owner"), uniqueID("owner")
+	if _, err := s.GetUnhandledOrders(ctx, first, -time.Second, claimBatch); err != nil {
+		t.Fatalf("GetUnhandledOrders() error = %v", err)
+	}
+	if !claimOrder(t, s, second, expiredOrderID) {
+		t.Fatalf("GetUnhandledOrders() didn`t claim order with expired lease")
+	}
+
+	order := &loyalty.Order{ID: expiredOrderID, Status: loyalty.TypeStatusProcessing}
+	if err := s.UpdateOrder(ctx, first, order, 0); !errors.Is(err, loyalty.ErrOrderLeaseLost) {
+		t.Errorf("UpdateOrder() by owner of expired lease error = %v, want %v", err, loyalty.ErrOrderLeaseLost)
+	}
+	if err := s.UpdateOrder(ctx, second, order, 0); err != nil {
+		t.Errorf("UpdateOrder() error = %v", err)
+	}
+}
+
+// testGetUnhandledOrdersLimit checks that one claim leases no more than limit orders
+func testGetUnhandledOrdersLimit(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID := uniqueID("user")
+
+	orderIDs := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		orderID := uniqueID("order")
+		if err := s.AddOrder(ctx, userID, orderID); err != nil {
+			t.Fatalf("AddOrder() error = %v", err)
+		}
+		orderIDs[orderID] = true
+	}
+
+	orders, err := s.GetUnhandledOrders(ctx, uniqueID("owner"), time.Minute, 2)
+	if err != nil {
+		t.Fatalf("GetUnhandledOrders() error = %v", err)
+	}
+	if len(orders) != 2 {
+		t.Errorf("GetUnhandledOrders() claimed %v orders, want 2", len(orders))
+	}
+
+	orders, err = s.GetUnhandledOrders(ctx, uniqueID("owner"), time.Minute, claimBatch)
+	if err != nil {
+		t.Fatalf("GetUnhandledOrders() error = %v", err)
+	}
+	rest := 0
+	for _, order := range orders {
+		if orderIDs[order.ID] {
+			rest++
+		}
+	}
+	if rest != 3 {
+		t.Errorf("GetUnhandledOrders() claimed %v of the rest orders, want 3", rest)
+	}
+}
+
+func testRescheduleOrder(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID, orderID, owner := uniqueID("user"), uniqueID("order"), uniqueID("owner")
+
+	if err := s.AddOrder(ctx, userID, orderID); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+
+	if err := s.RescheduleOrder(ctx, owner, orderID, "not leased", time.Hour); !errors.Is(err, loyalty.ErrOrderLeaseLost) {
+		t.Errorf("RescheduleOrder() of not leased order error = %v, want %v", err, loyalty.ErrOrderLeaseLost)
+	}
+
+	if !claimOrder(t, s, owner, orderID) {
+		t.Fatalf("GetUnhandledOrders() didn`t claim new order")
+	}
+
+	if err := s.RescheduleOrder(ctx, owner, orderID, "accrual is unavailable", time.Hour); err != nil {
+		t.Fatalf("RescheduleOrder() error = %v", err)
+	}
+
+	if claimOrder(t, s, owner, orderID) {
+		t.Errorf("GetUnhandledOrders() claimed order scheduled to the future")
+	}
+}
+
+func testExpireOrder(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID, orderID, owner := uniqueID("user"), uniqueID("order"), uniqueID("owner")
+
+	if err := s.AddOrder(ctx, userID, orderID); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+
+	if !claimOrder(t, s, owner, orderID) {
+		t.Fatalf("GetUnhandledOrders() didn`t claim new order")
+	}
+
+	if err := s.ExpireOrder(ctx, owner, orderID, "expired"); err != nil {
+		t.Fatalf("ExpireOrder() error = %v", err)
+	}
+
+	order, err := s.GetOrder(ctx, orderID)
+	if err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+	if order.Status != loyalty.TypeStatusInvalid || order.Reason != "expired" {
+		t.Errorf("GetOrder() = %+v, want invalid order with reason", order)
+	}
+
+	if claimOrder(t, s, owner, orderID) {
+		t.Errorf("GetUnhandledOrders() claimed expired order")
+	}
+
+	history, err := s.GetOrderHistory(ctx, orderID)
+	if err != nil {
+		t.Fatalf("GetOrderHistory() error = %v", err)
+	}
+	if len(history) != 2 || history[1].Status != loyalty.TypeStatusInvalid || history[1].Reason != "expired" {
+		t.Errorf("GetOrderHistory() = %+v, want upload and expiration events", history)
+	}
+}
+
+func testOrderHistory(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID, orderID, owner := uniqueID("user"), uniqueID("order"), uniqueID("owner")
+
+	history, err := s.GetOrderHistory(ctx, orderID)
+	if err != nil || history == nil || len(history) != 0 {
+		t.Errorf("GetOrderHistory() for unknown order = %v, %v, want empty slice", history, err)
+	}
+
+	if err := s.AddOrder(ctx, userID, orderID); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+
+	order, err := s.GetOrder(ctx, orderID)
+	if err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+	if !order.LastCheckedAt.IsZero() {
+		t.Errorf("GetOrder() last checked = %v for not checked order, want zero", order.LastCheckedAt)
+	}
+
+	// Repeated failures with the same error are recorded once
+	for i := 0; i < 2; i++ {
+		if !claimOrder(t, s, owner, orderID) {
+			t.Fatalf("GetUnhandledOrders() didn`t claim order")
+		}
+		if err := s.RescheduleOrder(ctx, owner, orderID, "accrual is unavailable", 0); err != nil {
+			t.Fatalf("RescheduleOrder() error = %v", err)
+		}
+	}
+
+	// Checks without changes aren`t recorded
+	for _, status := range []string{loyalty.TypeStatusProcessing, loyalty.TypeStatusProcessing, loyalty.TypeStatusProcessed} {
+		if !claimOrder(t, s, owner, orderID) {
+			t.Fatalf("GetUnhandledOrders() didn`t claim order")
+		}
+
+		updated := &loyalty.Order{ID: orderID, Status: status}
+		if status == loyalty.TypeStatusProcessed {
+			updated.Accrual = money.MustParse("331.3")
+		}
+		if err := s.UpdateOrder(ctx, owner, updated, 0); err != nil {
+			t.Fatalf("UpdateOrder() error = %v", err)
+		}
+	}
+
+	order, err = s.GetOrder(ctx, orderID)
+	if err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+	if order.LastCheckedAt.IsZero() || order.LastCheckedAt.Before(order.Uploaded) {
+		t.Errorf("GetOrder() last checked = %v, want time after upload %v", order.LastCheckedAt, order.Uploaded)
+	}
+
+	history, err = s.GetOrderHistory(ctx, orderID)
+	if err != nil {
+		t.Fatalf("GetOrderHistory() error = %v", err)
+	}
+
+	want := []loyalty.OrderEvent{
+		{Status: loyalty.TypeStatusNew},
+		{Status: loyalty.TypeStatusNew, Error: "accrual is unavailable"},
+		{Status: loyalty.TypeStatusProcessing},
+		{Status: loyalty.TypeStatusProcessed, Accrual: money.MustParse("331.3")},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("GetOrderHistory() returned %v events, want %v", len(history), len(want))
+	}
+	for i, event := range history {
+		if event.Status != want[i].Status || event.Accrual != want[i].Accrual || event.Error != want[i].Error || event.Reason != "" || event.Created.IsZero() {
+			t.Errorf("GetOrderHistory()[%v] = %+v, want %+v", i, event, want[i])
+		}
+	}
+}
+
+func testOrderStatusEvents(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID, owner := uniqueID("user"), uniqueID("owner")
+	processedID, expiredID := uniqueID("order"), uniqueID("order")
+
+	lastID, err := s.GetLastOrderEventID(ctx)
+	if err != nil {
+		t.Fatalf("GetLastOrderEventID() error = %v", err)
+	}
+
+	for _, orderID := range []string{processedID, expiredID} {
+		if err := s.AddOrder(ctx, userID, orderID); err != nil {
+			t.Fatalf("AddOrder() error = %v", err)
+		}
+	}
+
+	// Both orders are leased by one claim
+	if !claimOrder(t, s, owner, processedID) {
+		t.Fatalf("GetUnhandledOrders() didn`t claim order")
+	}
+	if err := s.ExpireOrder(ctx, owner, expiredID, "expired"); err != nil {
+		t.Fatalf("ExpireOrder() error = %v", err)
+	}
+	if err := s.RescheduleOrder(ctx, owner, processedID, "accrual is unavailable", 0); err != nil {
+		t.Fatalf("RescheduleOrder() error = %v", err)
+	}
+	for _, status := range []string{loyalty.TypeStatusProcessing, loyalty.TypeStatusProcessed} {
+		if !claimOrder(t, s, owner, processedID) {
+			t.Fatalf("GetUnhandledOrders() didn`t claim order")
+		}
+
+		updated := &loyalty.Order{ID: processedID, Status: status}
+		if status == loyalty.TypeStatusProcessed {
+			updated.Accrual = money.MustParse("331.3")
+		}
+		if err := s.UpdateOrder(ctx, owner, updated, 0); err != nil {
+			t.Fatalf("UpdateOrder() error = %v", err)
+		}
+	}
+
+	newLastID, err := s.GetLastOrderEventID(ctx)
+	if err != nil || newLastID <= lastID {
+		t.Errorf("GetLastOrderEventID() = %v, %v, want ID after %v", newLastID, err, lastID)
+	}
+
+	events, err := s.GetOrderStatusEvents(ctx, lastID, claimBatch)
+	if err != nil {
+		t.Fatalf("GetOrderStatusEvents() error = %v", err)
+	}
+
+	// Uploads and failed checks aren`t status changes, events of other tests sharing the database are skipped
+	want := []loyalty.OrderStatusEvent{
+		{OrderID: expiredID, Status: loyalty.TypeStatusInvalid, Reason: "expired"},
+		{OrderID: processedID, Status: loyalty.TypeStatusProcessing},
+		{OrderID: processedID, Status: loyalty.TypeStatusProcessed, Accrual: money.MustParse("331.3")},
+	}
+	got := make([]*loyalty.OrderStatusEvent, 0)
+	for i, event := range events {
+		if i > 0 && event.ID <= events[i-1].ID {
+			t.Errorf("GetOrderStatusEvents() returned event %v after %v, want increasing IDs", event.ID, events[i-1].ID)
+		}
+		if event.UserID == userID {
+			got = append(got, event)
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("GetOrderStatusEvents() returned %v events of user, want %v", len(got), len(want))
+	}
+	for i, event := range got {
+		if event.ID <= lastID || event.OrderID != want[i].OrderID || event.Status != want[i].Status ||
+			event.Accrual != want[i].Accrual || event.Reason != want[i].Reason || event.Created.IsZero() {
+			t.Errorf("GetOrderStatusEvents()[%v] = %+v, want %+v", i, event, want[i])
+		}
+	}
+
+	events, err = s.GetOrderStatusEvents(ctx, lastID, 1)
+	if err != nil || len(events) != 1 {
+		t.Errorf("GetOrderStatusEvents() with limit 1 = %v, %v, want 1 event", events, err)
+	}
+}
+
+func testWebhooks(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID := uniqueID("user")
+
+	for _, id := range []string{uniqueID("hook"), uniqueID("hook")} {
+		hook := &webhooks.Webhook{ID: id, UserID: userID, URL: "https://example.com/" + id, Secret: "secret", Created: time.Now().UTC()}
+		if err := s.AddWebhook(ctx, hook, 2); err != nil {
+			t.Fatalf("AddWebhook() error = %v", err)
+		}
+	}
+
+	over := &webhooks.Webhook{ID: uniqueID("hook"), UserID: userID, URL: "https://example.com/over", Secret: "secret", Created: time.Now().UTC()}
+	if err := s.AddWebhook(ctx, over, 2); !errors.Is(err, webhooks.ErrWebhookLimit) {
+		t.Errorf("AddWebhook() over limit error = %v, want %v", err, webhooks.ErrWebhookLimit)
+	}
+
+	hooks, err := s.GetWebhooks(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetWebhooks() error = %v", err)
+	}
+	if len(hooks) != 2 || hooks[0].UserID != userID || hooks[0].Secret != "secret" || hooks[0].Created.IsZero() {
+		t.Fatalf("GetWebhooks() = %+v, want 2 webhooks of user", hooks)
+	}
+
+	if err := s.DeleteWebhook(ctx, uniqueID("user"), hooks[0].ID); !errors.Is(err, webhooks.ErrWebhookNotFound) {
+		t.Errorf("DeleteWebhook() of another user error = %v, want %v", err, webhooks.ErrWebhookNotFound)
+	}
+	if err := s.DeleteWebhook(ctx, userID, hooks[0].ID); err != nil {
+		t.Fatalf("DeleteWebhook() error = %v", err)
+	}
+	if err := s.DeleteWebhook(ctx, userID, hooks[0].ID); !errors.Is(err, webhooks.ErrWebhookNotFound) {
+		t.Errorf("DeleteWebhook() of deleted webhook error = %v, want %v", err, webhooks.ErrWebhookNotFound)
+	}
+
+	hooks, err = s.GetWebhooks(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetWebhooks() error = %v", err)
+	}
+	if len(hooks) != 1 {
+		t.Errorf("GetWebhooks() returned %v webhooks after delete, want 1", len(hooks))
+	}
+}
+
+// testAddWebhookConcurrent checks that concurrent registrations don`t exceed the limit
+func testAddWebhookConcurrent(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID := uniqueID("user")
+
+	const (
+		registrations = 20
+		limit         = 5
+	)
+
+	wg := &sync.WaitGroup{}
+	errs := make(chan error, registrations)
+	for i := 0; i < registrations; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := uniqueID("hook")
+			errs <- s.AddWebhook(ctx, &webhooks.Webhook{ID: id, UserID: userID, URL: "https://example.com/" + id, Secret: "secret", Created: time.Now().UTC()}, limit)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil && !errors.Is(err, webhooks.ErrWebhookLimit) {
+			t.Errorf("AddWebhook() unexpected error = %v", err)
+		}
+	}
+
+	hooks, err := s.GetWebhooks(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetWebhooks() error = %v", err)
+	}
+	if len(hooks) != limit {
+		t.Errorf("GetWebhooks() returned %v webhooks, want %v", len(hooks), limit)
+	}
+}
+
+// claimDeliveries claims due deliveries and returns the ones of webhook
+func claimDeliveries(t *testing.T, s Storager, webhookID string) []*webhooks.Delivery {
+	t.Helper()
+
+	deliveries, err := s.GetDueDeliveries(context.Background(), time.Minute, 1000)
+	if err != nil {
+		t.Fatalf("GetDueDeliveries() error = %v", err)
+	}
+
+	res := make([]*webhooks.Delivery, 0)
+	for _, delivery := range deliveries {
+		if delivery.WebhookID == webhookID {
+			res = append(res, delivery)
+		}
+	}
+	return res
+}
+
+func testWebhookDeliveries(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID := uniqueID("user")
+	hookID := uniqueID("hook")
+
+	hook := &webhooks.Webhook{ID: hookID, UserID: userID, URL: "https://example.com/hook", Secret: "secret", Created: time.Now().UTC()}
+	if err := s.AddWebhook(ctx, hook, 10); err != nil {
+		t.Fatalf("AddWebhook() error = %v", err)
+	}
+
+	// Events of users without webhooks are dropped
+	if err := s.EnqueueEvent(ctx, &webhooks.Event{ID: uniqueID("event"), Type: webhooks.TypeEventWithdrawCreated, UserID: uniqueID("user"), Data: []byte("{}")}); err != nil {
+		t.Fatalf("EnqueueEvent() error = %v", err)
+	}
+
+	eventID := uniqueID("event")
+	event := &webhooks.Event{ID: eventID, Type: webhooks.TypeEventOrderProcessed, UserID: userID, Created: time.Now().UTC(), Data: []byte(`{"number":"1"}`)}
+	// Event published again by outbox relay is enqueued once
+	for i := 0; i < 2; i++ {
+		if err := s.EnqueueEvent(ctx, event); err != nil {
+			t.Fatalf("EnqueueEvent() error = %v", err)
+		}
+	}
+
+	due := claimDeliveries(t, s, hookID)
+	if len(due) != 1 {
+		t.Fatalf("GetDueDeliveries() returned %v deliveries of webhook, want 1", len(due))
+	}
+
+	delivery := due[0]
+	if delivery.EventID != eventID || delivery.EventType != webhooks.TypeEventOrderProcessed || delivery.URL != hook.URL ||
+		delivery.Secret != hook.Secret || delivery.Status != webhooks.TypeDeliveryPending || len(delivery.Payload) == 0 {
+		t.Errorf("GetDueDeliveries() = %+v, want pending delivery of event %v", delivery, eventID)
+	}
+
+	if again := claimDeliveries(t, s, hookID); len(again) != 0 {
+		t.Errorf("GetDueDeliveries() returned %v claimed deliveries, want 0", len(again))
+	}
+
+	// Failed attempt retried right now
+	delivery.Attempts = 1
+	delivery.LastStatusCode = 500
+	delivery.LastError = "webhook responded with status 500"
+	delivery.NextAttemptAt = time.Now().UTC().Add(-time.Second)
+	if err := s.UpdateDelivery(ctx, delivery); err != nil {
+		t.Fatalf("UpdateDelivery() error = %v", err)
+	}
+
+	due = claimDeliveries(t, s, hookID)
+	if len(due) != 1 || due[0].Attempts != 1 || due[0].LastError != delivery.LastError {
+		t.Fatalf("GetDueDeliveries() = %+v, want retried delivery", due)
+	}
+
+	deliveredAt := time.Now().UTC()
+	delivery = due[0]
+	delivery.Attempts = 2
+	delivery.Status = webhooks.TypeDeliveryDelivered
+	delivery.LastStatusCode = 200
+	delivery.LastError = ""
+	delivery.DeliveredAt = &deliveredAt
+	if err := s.UpdateDelivery(ctx, delivery); err != nil {
+		t.Fatalf("UpdateDelivery() error = %v", err)
+	}
+
+	deliveries, err := s.GetDeliveries(ctx, userID, hookID, 10)
+	if err != nil {
+		t.Fatalf("GetDeliveries() error = %v", err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != webhooks.TypeDeliveryDelivered || deliveries[0].Attempts != 2 ||
+		deliveries[0].LastStatusCode != 200 || deliveries[0].DeliveredAt == nil {
+		t.Fatalf("GetDeliveries() = %+v, want delivered delivery", deliveries)
+	}
+
+	if err := s.RedeliverDelivery(ctx, uniqueID("user"), delivery.ID); !errors.Is(err, webhooks.ErrDeliveryNotFound) {
+		t.Errorf("RedeliverDelivery() of another user error = %v, want %v", err, webhooks.ErrDeliveryNotFound)
+	}
+	if err := s.RedeliverDelivery(ctx, userID, delivery.ID); err != nil {
+		t.Fatalf("RedeliverDelivery() error = %v", err)
+	}
+
+	due = claimDeliveries(t, s, hookID)
+	if len(due) != 1 || due[0].Status != webhooks.TypeDeliveryPending || due[0].Attempts != 0 {
+		t.Fatalf("GetDueDeliveries() = %+v after redelivery, want pending delivery", due)
+	}
+
+	// Deleting webhook deletes its deliveries
+	if err := s.DeleteWebhook(ctx, userID, hookID); err != nil {
+		t.Fatalf("DeleteWebhook() error = %v", err)
+	}
+	if err := s.RedeliverDelivery(ctx, userID, delivery.ID); !errors.Is(err, webhooks.ErrDeliveryNotFound) {
+		t.Errorf("RedeliverDelivery() of deleted webhook error = %v, want %v", err, webhooks.ErrDeliveryNotFound)
+	}
+}
+
+// claimOutbox claims outbox events for owner and returns the ones of user
+func claimOutbox(t *testing.T, s Storager, owner, userID string) []*outbox.Event {
+	t.Helper()
+
+	events, err := s.ClaimOutboxEvents(context.Background(), owner, time.Minute, 1000)
+	if err != nil {
+		t.Fatalf("ClaimOutboxEvents() error = %v", err)
+	}
+
+	res := make([]*outbox.Event, 0)
+	for _, event := range events {
+		if event.UserID == userID {
+			res = append(res, event)
+		}
+	}
+	return res
+}
+
+func testOutbox(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID := uniqueID("user")
+	orderID := uniqueID("order")
+	first, second := uniqueID("relay"), uniqueID("relay")
+
+	if err := s.AddOrder(ctx, userID, orderID); err != nil {
+		t.Fatalf("AddOrder() error = %v", err)
+	}
+	if !claimOrder(t, s, first, orderID) {
+		t.Fatalf("GetUnhandledOrders() didn`t claim order")
+	}
+	if err := s.UpdateOrder(ctx, first, &loyalty.Order{ID: orderID, Status: loyalty.TypeStatusProcessed, Accrual: money.New(500, 0)}, 0); err != nil {
+		t.Fatalf("UpdateOrder() error = %v", err)
+	}
+	if err := s.AddWithdraw(ctx, &loyalty.Withdraw{OrderID: uniqueID("withdraw"), UserID: userID, Sum: money.New(100, 0)}); err != nil {
+		t.Fatalf("AddWithdraw() error = %v", err)
+	}
+
+	// Rejected withdrawal leaves no event
+	if err := s.AddWithdraw(ctx, &loyalty.Withdraw{OrderID: uniqueID("withdraw"), UserID: userID, Sum: money.New(1000, 0)}); !errors.Is(err, loyalty.ErrWithdrawNotEnoughPoints) {
+		t.Fatalf("AddWithdraw() error = %v, want %v", err, loyalty.ErrWithdrawNotEnoughPoints)
+	}
+
+	events := claimOutbox(t, s, first, userID)
+
+	wantTypes := []string{outbox.TypeEventOrderUploaded, outbox.TypeEventOrderStatusChanged, outbox.TypeEventWithdrawCreated}
+	if len(events) != len(wantTypes) {
+		t.Fatalf("ClaimOutboxEvents() returned %v events of user, want %v", len(events), len(wantTypes))
+	}
+	for i, event := range events {
+		if event.Type != wantTypes[i] || len(event.Payload) == 0 || event.Created.IsZero() || (i > 0 && event.ID <= events[i-1].ID) {
+			t.Errorf("ClaimOutboxEvents()[%v] = %+v, want %v event in order", i, event, wantTypes[i])
+		}
+	}
+	if events[1].AggregateID != orderID {
+		t.Errorf("ClaimOutboxEvents()[1] aggregate = %v, want %v", events[1].AggregateID, orderID)
+	}
+
+	// Events of user leased by first relay aren`t claimed by second one
+	if leased := claimOutbox(t, s, second, userID); len(leased) != 0 {
+		t.Fatalf("ClaimOutboxEvents() by second relay returned %v leased events, want 0", len(leased))
+	}
+
+	if err := s.MarkOutboxPublished(ctx, second, events[0].ID); !errors.Is(err, outbox.ErrEventLeaseLost) {
+		t.Errorf("MarkOutboxPublished() by not owner error = %v, want %v", err, outbox.ErrEventLeaseLost)
+	}
+	if err := s.MarkOutboxPublished(ctx, first, events[0].ID); err != nil {
+		t.Fatalf("MarkOutboxPublished() error = %v", err)
+	}
+	if err := s.MarkOutboxPublished(ctx, first, events[0].ID); !errors.Is(err, outbox.ErrEventLeaseLost) {
+		t.Errorf("MarkOutboxPublished() of published event error = %v, want %v", err, outbox.ErrEventLeaseLost)
+	}
+
+	// Failed event releases all the user events, so any relay continues from it
+	if err := s.FailOutboxEvent(ctx, first, events[1].ID, "broker is unavailable", 0); err != nil {
+		t.Fatalf("FailOutboxEvent() error = %v", err)
+	}
+
+	retried := claimOutbox(t, s, second, userID)
+	if len(retried) != 2 || retried[0].ID != events[1].ID || retried[0].Attempts != 1 || retried[1].ID != events[2].ID {
+		t.Fatalf("ClaimOutboxEvents() after failure = %+v, want the last 2 events with failed one first", retried)
+	}
+
+	// Only events published before the given time are pruned
+	if _, err := s.PruneOutboxEvents(ctx, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("PruneOutboxEvents() error = %v", err)
+	}
+	if pruned, err := s.PruneOutboxEvents(ctx, time.Now().Add(time.Minute)); err != nil || pruned < 1 {
+		t.Errorf("PruneOutboxEvents() = %v, %v, want published event pruned", pruned, err)
+	}
+	if pruned, err := s.PruneOutboxEvents(ctx, time.Now().Add(time.Minute)); err != nil || pruned != 0 {
+		t.Errorf("PruneOutboxEvents() again = %v, %v, want 0", pruned, err)
+	}
+	if err := s.MarkOutboxPublished(ctx, second, retried[0].ID); err != nil {
+		t.Errorf("MarkOutboxPublished() of unpublished event after pruning error = %v", err)
+	}
+}
+
+func testOutboxRetry(t *testing.T, s Storager) {
+	ctx := context.Background()
+	waiting, dead := uniqueID("user"), uniqueID("user")
+	first, second := uniqueID("relay"), uniqueID("relay")
+
+	for _, userID := range []string{waiting, waiting, dead, dead} {
+		if err := s.AddOrder(ctx, userID, uniqueID("order")); err != nil {
+			t.Fatalf("AddOrder() error = %v", err)
+		}
+	}
+
+	// Failed event holds back the following events of its user until retry time
+	events := claimOutbox(t, s, first, waiting)
+	if len(events) != 2 {
+		t.Fatalf("ClaimOutboxEvents() returned %v events of user, want 2", len(events))
+	}
+	if err := s.FailOutboxEvent(ctx, first, events[0].ID, "broker is unavailable", time.Hour); err != nil {
+		t.Fatalf("FailOutboxEvent() error = %v", err)
+	}
+	for _, owner := range []string{first, second} {
+		if retried := claimOutbox(t, s, owner, waiting); len(retried) != 0 {
+			t.Errorf("ClaimOutboxEvents() before retry time = %+v, want no events of user", retried)
+		}
+	}
+
+	// Event in FAILED state isn`t claimed anymore and doesn`t hold back the following events
+	events = claimOutbox(t, s, first, dead)
+	if len(events) != 2 {
+		t.Fatalf("ClaimOutboxEvents() returned %v events of user, want 2", len(events))
+	}
+	if err := s.DeadLetterOutboxEvent(ctx, first, events[0].ID, "payload is rejected"); err != nil {
+		t.Fatalf("DeadLetterOutboxEvent() error = %v", err)
+	}
+	if err := s.DeadLetterOutboxEvent(ctx, first, events[0].ID, "payload is rejected"); !errors.Is(err, outbox.ErrEventLeaseLost) {
+		t.Errorf("DeadLetterOutboxEvent() of failed event error = %v, want %v", err, outbox.ErrEventLeaseLost)
+	}
+	if err := s.MarkOutboxPublished(ctx, first, events[0].ID); !errors.Is(err, outbox.ErrEventLeaseLost) {
+		t.Errorf("MarkOutboxPublished() of failed event error = %v, want %v", err, outbox.ErrEventLeaseLost)
+	}
+
+	rest := claimOutbox(t, s, second, dead)
+	if len(rest) != 1 || rest[0].ID != events[1].ID {
+		t.Fatalf("ClaimOutboxEvents() after dead letter = %+v, want only the following event", rest)
+	}
+
+	if err := s.MarkOutboxPublished(ctx, second, rest[0].ID); err != nil {
+		t.Errorf("MarkOutboxPublished() of the following event error = %v", err)
+	}
+}
+
+func testIdempotency(t *testing.T, s Storager) {
+	ctx := context.Background()
+	rec := &middlewares.IdempotentRecord{UserID: uniqueID("user"), Key: uniqueID("key"), Fingerprint: "fingerprint"}
+
+	if existing, err := s.StartIdempotent(ctx, rec, time.Hour, time.Hour); err != nil || existing != nil {
+		t.Fatalf("StartIdempotent() = %+v, %v, want new key", existing, err)
+	}
+
+	existing, err := s.StartIdempotent(ctx, rec, time.Hour, time.Hour)
+	if err != nil || existing == nil || existing.Completed || existing.Fingerprint != rec.Fingerprint {
+		t.Fatalf("StartIdempotent() for key in progress = %+v, %v", existing, err)
+	}
+
+	// Key of another user is independent
+	another := &middlewares.IdempotentRecord{UserID: uniqueID("user"), Key: rec.Key, Fingerprint: "another"}
+	if existing, err := s.StartIdempotent(ctx, another, time.Hour, time.Hour); err != nil || existing != nil {
+		t.Errorf("StartIdempotent() for key of another user = %+v, %v, want new key", existing, err)
+	}
+
+	rec.Completed, rec.StatusCode, rec.ContentType, rec.Body = true, 202, "text/plain", []byte("accepted")
+	if err := s.FinishIdempotent(ctx, rec); err != nil {
+		t.Fatalf("FinishIdempotent() error = %v", err)
+	}
+
+	existing, err = s.StartIdempotent(ctx, rec, time.Hour, time.Hour)
+	if err != nil || existing == nil || !existing.Completed || existing.StatusCode != 202 || existing.ContentType != "text/plain" || string(existing.Body) != "accepted" {
+		t.Errorf("StartIdempotent() for completed key = %+v, %v", existing, err)
+	}
+
+	// Completed key is replayed after reservation TTL and isn`t cancelled
+	existing, err = s.StartIdempotent(ctx, rec, time.Hour, -time.Second)
+	if err != nil || existing == nil || !existing.Completed {
+		t.Errorf("StartIdempotent() for completed key after reservation TTL = %+v, %v, want completed key", existing, err)
+	}
+	if err := s.CancelIdempotent(ctx, rec.UserID, rec.Key); err != nil {
+		t.Fatalf("CancelIdempotent() error = %v", err)
+	}
+	if existing, err := s.StartIdempotent(ctx, rec, time.Hour, time.Hour); err != nil || existing == nil || !existing.Completed {
+		t.Errorf("StartIdempotent() for completed key after cancel = %+v, %v, want completed key", existing, err)
+	}
+
+	if existing, err := s.StartIdempotent(ctx, rec, -time.Second, time.Hour); err != nil || existing != nil {
+		t.Errorf("StartIdempotent() for expired key = %+v, %v, want new key", existing, err)
+	}
+
+	// Reservation left by crashed request is taken over after reservation TTL
+	if existing, err := s.StartIdempotent(ctx, rec, time.Hour, -time.Second); err != nil || existing != nil {
+		t.Errorf("StartIdempotent() for abandoned reservation = %+v, %v, want new key", existing, err)
+	}
+
+	if err := s.CancelIdempotent(ctx, rec.UserID, rec.Key); err != nil {
+		t.Fatalf("CancelIdempotent() error = %v", err)
+	}
+	if existing, err := s.StartIdempotent(ctx, rec, time.Hour, time.Hour); err != nil || existing != nil {
+		t.Errorf("StartIdempotent() for cancelled key = %+v, %v, want new key", existing, err)
+	}
+}
+
+// testIdempotencyConcurrent checks that only one of concurrent requests reserves the key
+func testIdempotencyConcurrent(t *testing.T, s Storager) {
+	ctx := context.Background()
+	userID, key := uniqueID("user"), uniqueID("key")
+
+	const requests = 20
+
+	wg := &sync.WaitGroup{}
+	reserved := atomic.Int64{}
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			existing, err := s.StartIdempotent(ctx, &middlewares.IdempotentRecord{UserID: userID, Key: key, Fingerprint: "fingerprint"}, time.Hour, time.Hour)
+			if err != nil {
+				t.Errorf("StartIdempotent() error = %v", err)
+				return
+			}
+			if existing == nil {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if reserved.Load() != 1 {
+		t.Errorf("key is reserved by %v requests, want 1", reserved.Load())
+	}
+}
+
+func testPruneIdempotent(t *testing.T, s Storager) {
+	ctx := context.Background()
+	rec := &middlewares.IdempotentRecord{UserID: uniqueID("user"), Key: uniqueID("key"), Fingerprint: "fingerprint"}
+
+	if existing, err := s.StartIdempotent(ctx, rec, time.Hour, time.Hour); err != nil || existing != nil {
+		t.Fatalf("StartIdempotent() = %+v, %v, want new key", existing, err)
+	}
+
+	// Only keys created before the given time are pruned
+	if _, err := s.PruneIdempotent(ctx, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("PruneIdempotent() error = %v", err)
+	}
+	if existing, err := s.StartIdempotent(ctx, rec, time.Hour, time.Hour); err != nil || existing == nil {
+		t.Fatalf("StartIdempotent() after pruning older keys = %+v, %v, want key kept", existing, err)
+	}
+
+	if pruned, err := s.PruneIdempotent(ctx, time.Now().Add(time.Minute)); err != nil || pruned < 1 {
+		t.Errorf("PruneIdempotent() = %v, %v, want key pruned", pruned, err)
+	}
+	if existing, err := s.StartIdempotent(ctx, rec, time.Hour, time.Hour); err != nil || existing != nil {
+		t.Errorf("StartIdempotent() after pruning = %+v, %v, want new key", existing, err)
+	}
+}
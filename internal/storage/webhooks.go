@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/webhooks"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// webhooksLockSpace is the first key of advisory locks serializing registrations of webhooks of one user
+const webhooksLockSpace = 5
+
+func (pg *PGStorage) AddWebhook(ctx context.Context, hook *webhooks.Webhook, limit int) error {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Registrations of one user are serialized, so the count can`t change between check and insert
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1, hashtext($2))", webhooksLockSpace, hook.UserID); err != nil {
+		return err
+	}
+
+	var count int
+	if err := tx.QueryRowContext(ctx, "SELECT count(*) FROM webhooks WHERE userID = $1", hook.UserID).Scan(&count); err != nil {
+		return err
+	}
+	if count >= limit {
+		return webhooks.ErrWebhookLimit
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO webhooks (id, userID, url, secret, created) VALUES ($1, $2, $3, $4, $5)
+	`, hook.ID, hook.UserID, hook.URL, hook.Secret, hook.Created.UTC())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (pg *PGStorage) GetWebhooks(ctx context.Context, userID string) ([]*webhooks.Webhook, error) {
+
+	hooks := make([]*webhooks.Webhook, 0)
+
+	rows, err := pg.db.QueryContext(ctx, `
+		SELECT id, userID, url, secret, created FROM webhooks WHERE userID = $1 ORDER BY created, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		hook := &webhooks.Webhook{}
+		if err := rows.Scan(&hook.ID, &hook.UserID, &hook.URL, &hook.Secret, &hook.Created); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to Webhook",
+				zap.Error(err),
+			)
+			continue
+		}
+		hooks = append(hooks, hook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hooks, nil
+}
+
+func (pg *PGStorage) DeleteWebhook(ctx context.Context, userID string, webhookID string) error {
+	res, err := pg.db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = $1 AND userID = $2", webhookID, userID)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return webhooks.ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+func (pg *PGStorage) EnqueueEvent(ctx context.Context, event *webhooks.Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = pg.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhookID, userID, eventID, eventType, payload)
+		SELECT id, userID, $2, $3, $4 FROM webhooks WHERE userID = $1
+		ON CONFLICT (webhookID, eventID) DO NOTHING
+	`, event.UserID, event.ID, event.Type, payload)
+	return err
+}
+
+func (pg *PGStorage) GetDueDeliveries(ctx context.Context, lease time.Duration, limit int) ([]*webhooks.Delivery, error) {
+
+	deliveries := make([]*webhooks.Delivery, 0)
+
+	// Claiming due deliveries by pushing their next attempt time, so concurrent dispatchers skip them
+	rows, err := pg.db.QueryContext(ctx, `
+		WITH claimed AS (
+			UPDATE webhook_deliveries SET nextAttemptAt = timezone('utc', now()) + make_interval(secs => $1)
+			WHERE id IN (
+				SELECT id FROM webhook_deliveries
+				WHERE status = 'PENDING' AND nextAttemptAt <= timezone('utc', now())
+				ORDER BY id
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, webhookID, userID, eventID, eventType, payload, status, attempts, nextAttemptAt, lastStatusCode, COALESCE(lastError, ''), created
+		)
+		SELECT c.id, c.webhookID, c.userID, w.url, w.secret, c.eventID, c.eventType, c.payload, c.status, c.attempts,
+			c.nextAttemptAt, c.lastStatusCode, c.lastError, c.created
+		FROM claimed c JOIN webhooks w ON w.id = c.webhookID
+		ORDER BY c.id
+	`, lease.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		delivery := &webhooks.Delivery{}
+		if err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.UserID, &delivery.URL, &delivery.Secret, &delivery.EventID,
+			&delivery.EventType, &delivery.Payload, &delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt,
+			&delivery.LastStatusCode, &delivery.LastError, &delivery.Created); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to Delivery",
+				zap.Error(err),
+			)
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (pg *PGStorage) UpdateDelivery(ctx context.Context, delivery *webhooks.Delivery) error {
+	var deliveredAt sql.NullTime
+	if delivery.DeliveredAt != nil {
+		deliveredAt = sql.NullTime{Time: delivery.DeliveredAt.UTC(), Valid: true}
+	}
+
+	res, err := pg.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, nextAttemptAt = $3, lastStatusCode = $4, lastError = NULLIF($5, ''), deliveredAt = $6
+		WHERE id = $7
+	`, delivery.Status, delivery.Attempts, delivery.NextAttemptAt.UTC(), delivery.LastStatusCode, delivery.LastError, deliveredAt, delivery.ID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return webhooks.ErrDeliveryNotFound
+	}
+
+	return nil
+}
+
+func (pg *PGStorage) GetDeliveries(ctx context.Context, userID string, webhookID string, limit int) ([]*webhooks.Delivery, error) {
+
+	deliveries := make([]*webhooks.Delivery, 0)
+
+	rows, err := pg.db.QueryContext(ctx, `
+		SELECT id, webhookID, userID, eventID, eventType, payload, status, attempts, nextAttemptAt, lastStatusCode,
+			COALESCE(lastError, ''), created, deliveredAt
+		FROM webhook_deliveries WHERE userID = $1 AND webhookID = $2
+		ORDER BY id DESC
+		LIMIT $3
+	`, userID, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		delivery := &webhooks.Delivery{}
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.UserID, &delivery.EventID, &delivery.EventType,
+			&delivery.Payload, &delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt, &delivery.LastStatusCode,
+			&delivery.LastError, &delivery.Created, &deliveredAt); err != nil {
+			logger.Log.Debug(
+				"error on scanning row to Delivery",
+				zap.Error(err),
+			)
+			continue
+		}
+		if deliveredAt.Valid {
+			delivery.DeliveredAt = &deliveredAt.Time
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (pg *PGStorage) RedeliverDelivery(ctx context.Context, userID string, deliveryID int64) error {
+	res, err := pg.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = 'PENDING', attempts = 0, nextAttemptAt = timezone('utc', now()), deliveredAt = NULL
+		WHERE id = $1 AND userID = $2
+	`, deliveryID, userID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return webhooks.ErrDeliveryNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package webhooks
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_deliveryRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		want     time.Duration
+	}{
+		{
+			name:     "FirstRetry",
+			attempts: 1,
+			want:     30 * time.Second,
+		},
+		{
+			name:     "ThirdRetry",
+			attempts: 3,
+			want:     2 * time.Minute,
+		},
+		{
+			name:     "MaxBackoff",
+			attempts: 9,
+			want:     time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deliveryRetryBackoff(tt.attempts); got != tt.want {
+				t.Errorf("deliveryRetryBackoff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
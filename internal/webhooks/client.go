@@ -0,0 +1,89 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+var ErrAddressNotAllowed = errors.New("webhook address isn`t public")
+
+// blockedPrefixes are ranges not covered by netip.Addr predicates which must not be reachable by webhooks
+var blockedPrefixes = []netip.Prefix{
+	// shared address space of carrier-grade NAT, also used by cloud metadata services
+	netip.MustParsePrefix("100.64.0.0/10"),
+	// IETF protocol assignments
+	netip.MustParsePrefix("192.0.0.0/24"),
+	// benchmarking
+	netip.MustParsePrefix("198.18.0.0/15"),
+	// reserved and limited broadcast
+	netip.MustParsePrefix("240.0.0.0/4"),
+	// NAT64 of IPv4 addresses
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// isPublicAddr reports whether webhook may be delivered to addr. Loopback, private, unique-local,
+// link-local (including cloud metadata at 169.254.169.254) and other special ranges are rejected.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	if !addr.IsValid() || addr.IsUnspecified() || addr.IsLoopback() || addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// dialControl rejects connections to addresses which aren`t public. It is called with resolved address
+// right before connect, so names resolving to internal hosts and DNS rebinding are rejected too.
+func dialControl(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrAddressNotAllowed, err)
+	}
+
+	if !isPublicAddr(addrPort.Addr()) {
+		return fmt.Errorf("%w: %v", ErrAddressNotAllowed, addrPort.Addr())
+	}
+
+	return nil
+}
+
+// NewClient returns client for webhook deliveries which connects only to public addresses
+// and doesn`t follow redirects, so webhooks can`t be used to reach internal services
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control:   dialControl,
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// Proxy isn`t used, it would connect to the webhook host instead of the checked dialer
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,32 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+)
+
+// Sign returns base64 HMAC-SHA256 of body keyed with secret
+func Sign(secret string, body []byte) string {
+	hash := hmac.New(sha256.New, []byte(secret))
+	hash.Write(body)
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil))
+}
+
+// Verify reports whether signature is valid signature of body keyed with secret
+func Verify(secret string, body []byte, signature string) bool {
+	sum, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	hash := hmac.New(sha256.New, []byte(secret))
+	hash.Write(body)
+	return hmac.Equal(sum, hash.Sum(nil))
+}
+
+func bytesReader(b []byte) io.Reader {
+	return bytes.NewReader(b)
+}
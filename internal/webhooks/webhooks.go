@@ -0,0 +1,464 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/internal/outbox"
+	"github.com/renatus-cartesius/gophermart/pkg/logger"
+	"github.com/renatus-cartesius/gophermart/pkg/money"
+	"go.uber.org/zap"
+)
+
+const (
+	TypeEventOrderProcessed  = "order.processed"
+	TypeEventOrderInvalid    = "order.invalid"
+	TypeEventWithdrawCreated = "withdrawal.created"
+)
+
+const (
+	TypeDeliveryPending   = "PENDING"
+	TypeDeliveryDelivered = "DELIVERED"
+	TypeDeliveryFailed    = "FAILED"
+)
+
+const (
+	// SignatureHeader carries base64 HMAC-SHA256 of request body keyed with webhook secret,
+	// it is checked the same way as middlewares.HmacValidator does
+	SignatureHeader = "HashSHA256"
+	EventHeader     = "X-Gophermart-Event"
+	DeliveryHeader  = "X-Gophermart-Delivery"
+)
+
+const (
+	// maxWebhooks limits count of webhooks registered by one user
+	maxWebhooks = 10
+	// deliveryLogLimit is count of the newest deliveries returned in delivery log
+	deliveryLogLimit = 100
+	// minSecretLength is minimal length of secret passed by user in bytes, it is stated in ErrWebhookSecret
+	minSecretLength = 32
+	// deliveryBatch is count of due deliveries sent in one pass
+	deliveryBatch = 100
+	// deliveryWorkers is count of deliveries of the batch sent concurrently
+	deliveryWorkers = 20
+	// deliveryLease is time during which claimed delivery isn`t claimed again
+	deliveryLease = time.Minute
+	// deliveryTimeout limits time of one delivery attempt
+	deliveryTimeout = 10 * time.Second
+	// deliveryBackoff is delay before the first retry of delivery, doubled with every attempt
+	deliveryBackoff = 30 * time.Second
+	// deliveryMaxBackoff limits delay between delivery attempts
+	deliveryMaxBackoff = time.Hour
+	// deliveryMaxAttempts is count of attempts after which delivery is failed
+	deliveryMaxAttempts = 10
+)
+
+var (
+	ErrWebhookNotFound   = errors.New("webhook not found")
+	ErrWebhookInvalidURL = errors.New("webhook url must be absolute https url of public host")
+	ErrWebhookSecret     = errors.New("webhook secret must be at least 32 bytes")
+	ErrWebhookLimit      = errors.New("too many webhooks registered")
+	ErrDeliveryNotFound  = errors.New("webhook delivery not found")
+)
+
+// Webhook is callback URL registered by user
+type Webhook struct {
+	ID     string `json:"id"`
+	UserID string `json:"-"`
+	URL    string `json:"url"`
+	// Secret is key of delivery signatures, it is shown only on registration
+	Secret  string    `json:"secret,omitempty"`
+	Created time.Time `json:"created_at"`
+}
+
+// Event is notification sent to every webhook of the user
+type Event struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	UserID  string          `json:"-"`
+	Created time.Time       `json:"created_at"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Delivery is event sent or to be sent to one webhook
+type Delivery struct {
+	ID        int64  `json:"id"`
+	WebhookID string `json:"webhook_id"`
+	UserID    string `json:"-"`
+	// URL and Secret of webhook are filled for due deliveries only
+	URL            string          `json:"-"`
+	Secret         string          `json:"-"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"-"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	Created        time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+type WebhookStorager interface {
+	// AddWebhook adds webhook unless user already has limit webhooks, then ErrWebhookLimit is returned.
+	// Count check and insert are atomic.
+	AddWebhook(ctx context.Context, hook *Webhook, limit int) error
+	GetWebhooks(ctx context.Context, userID string) ([]*Webhook, error)
+	DeleteWebhook(ctx context.Context, userID string, webhookID string) error
+	// EnqueueEvent creates pending delivery of event to every webhook of the event user,
+	// webhooks which already have delivery of the event ID are skipped
+	EnqueueEvent(ctx context.Context, event *Event) error
+	// GetDueDeliveries claims up to limit pending deliveries which are due to send for lease
+	GetDueDeliveries(ctx context.Context, lease time.Duration, limit int) ([]*Delivery, error)
+	// UpdateDelivery saves result of delivery attempt
+	UpdateDelivery(ctx context.Context, delivery *Delivery) error
+	// GetDeliveries returns up to limit the newest deliveries to webhook of user
+	GetDeliveries(ctx context.Context, userID string, webhookID string, limit int) ([]*Delivery, error)
+	// RedeliverDelivery schedules delivery of user to be sent again right now
+	RedeliverDelivery(ctx context.Context, userID string, deliveryID int64) error
+}
+
+type Webhooks struct {
+	storage WebhookStorager
+	client  *http.Client
+}
+
+// NewWebhooks creates Webhooks delivering events with client
+func NewWebhooks(storage WebhookStorager, client *http.Client) *Webhooks {
+	return &Webhooks{
+		storage: storage,
+		client:  client,
+	}
+}
+
+func randomID(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validateURL checks that callbackURL is absolute https URL. Host given as IP address must be public,
+// names are checked on every delivery by client, because they may resolve to another address later.
+func validateURL(callbackURL string) (*url.URL, error) {
+	u, err := url.Parse(callbackURL)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" || u.User != nil {
+		return nil, ErrWebhookInvalidURL
+	}
+
+	if addr, err := netip.ParseAddr(u.Hostname()); err == nil && !isPublicAddr(addr) {
+		return nil, ErrWebhookInvalidURL
+	}
+	if u.Hostname() == "localhost" {
+		return nil, ErrWebhookInvalidURL
+	}
+
+	return u, nil
+}
+
+// Register adds webhook of user, random secret is generated if secret is empty
+func (wh *Webhooks) Register(ctx context.Context, userID string, callbackURL string, secret string) (*Webhook, error) {
+	u, err := validateURL(callbackURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret != "" && len(secret) < minSecretLength {
+		return nil, ErrWebhookSecret
+	}
+
+	id, err := randomID(16)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret == "" {
+		if secret, err = randomID(32); err != nil {
+			return nil, err
+		}
+	}
+
+	hook := &Webhook{
+		ID:      id,
+		UserID:  userID,
+		URL:     u.String(),
+		Secret:  secret,
+		Created: time.Now().UTC(),
+	}
+
+	if err := wh.storage.AddWebhook(ctx, hook, maxWebhooks); err != nil {
+		return nil, err
+	}
+
+	return hook, nil
+}
+
+// GetWebhooks returns webhooks of user without secrets
+func (wh *Webhooks) GetWebhooks(ctx context.Context, userID string) ([]*Webhook, error) {
+	hooks, err := wh.storage.GetWebhooks(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hook := range hooks {
+		hook.Secret = ""
+	}
+
+	return hooks, nil
+}
+
+func (wh *Webhooks) Delete(ctx context.Context, userID string, webhookID string) error {
+	return wh.storage.DeleteWebhook(ctx, userID, webhookID)
+}
+
+// GetDeliveries returns delivery log of webhook from the newest delivery
+func (wh *Webhooks) GetDeliveries(ctx context.Context, userID string, webhookID string) ([]*Delivery, error) {
+	hooks, err := wh.storage.GetWebhooks(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hook := range hooks {
+		if hook.ID == webhookID {
+			return wh.storage.GetDeliveries(ctx, userID, webhookID, deliveryLogLimit)
+		}
+	}
+
+	return nil, ErrWebhookNotFound
+}
+
+func (wh *Webhooks) Redeliver(ctx context.Context, userID string, deliveryID int64) error {
+	return wh.storage.RedeliverDelivery(ctx, userID, deliveryID)
+}
+
+// Publish enqueues webhook event for outbox event about order which became processed or invalid or about
+// withdrawal, other events are skipped. It is driven by outbox relay, so failed enqueueing is retried and
+// event is enqueued at most once for every webhook.
+func (wh *Webhooks) Publish(ctx context.Context, event *outbox.Event) error {
+	var (
+		eventType string
+		data      any
+	)
+
+	switch event.Type {
+	case outbox.TypeEventOrderStatusChanged:
+		order := struct {
+			Number  string       `json:"number"`
+			Status  string       `json:"status"`
+			Accrual money.Amount `json:"accrual"`
+			Reason  string       `json:"reason"`
+		}{}
+		if err := json.Unmarshal(event.Payload, &order); err != nil {
+			return fmt.Errorf("error on unmarshalling %v event payload: %w", event.Type, err)
+		}
+
+		switch order.Status {
+		case loyalty.TypeStatusProcessed:
+			eventType = TypeEventOrderProcessed
+			data = struct {
+				Number  string       `json:"number"`
+				Status  string       `json:"status"`
+				Accrual money.Amount `json:"accrual"`
+			}{order.Number, order.Status, order.Accrual}
+		case loyalty.TypeStatusInvalid:
+			eventType = TypeEventOrderInvalid
+			data = struct {
+				Number string `json:"number"`
+				Status string `json:"status"`
+				Reason string `json:"reason,omitempty"`
+			}{order.Number, order.Status, order.Reason}
+		default:
+			return nil
+		}
+	case outbox.TypeEventWithdrawCreated:
+		wr := &loyalty.Withdraw{}
+		if err := json.Unmarshal(event.Payload, wr); err != nil {
+			return fmt.Errorf("error on unmarshalling %v event payload: %w", event.Type, err)
+		}
+		wr.Created = event.Created
+
+		eventType = TypeEventWithdrawCreated
+		data = wr
+	default:
+		return nil
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	// ID of outbox event is kept, so webhook event published again isn`t enqueued twice
+	return wh.storage.EnqueueEvent(ctx, &Event{
+		ID:      strconv.FormatInt(event.ID, 10),
+		Type:    eventType,
+		UserID:  event.UserID,
+		Created: event.Created.UTC(),
+		Data:    payload,
+	})
+}
+
+// Dispatch sends due deliveries until ctx is done
+func (wh *Webhooks) Dispatch(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Log.Info(
+				"closing webhooks dispatcher",
+			)
+			return
+		case <-ticker.C:
+			if _, err := wh.ProcessDeliveries(ctx); err != nil {
+				logger.Log.Error(
+					"error on processing webhook deliveries",
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+// ProcessDeliveries sends due deliveries by pool of deliveryWorkers and returns count of delivered ones.
+// Attempt is started only if it ends before lease of the batch expires, so another instance never
+// claims delivery being sent. Deliveries not reached in time are claimed again after the lease.
+func (wh *Webhooks) ProcessDeliveries(ctx context.Context) (int, error) {
+	// the last moment attempt may start to finish within the lease
+	deadline := time.Now().Add(deliveryLease - deliveryTimeout)
+
+	deliveries, err := wh.storage.GetDueDeliveries(ctx, deliveryLease, deliveryBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	jobs := make(chan *Delivery)
+	go func() {
+		defer close(jobs)
+		for _, delivery := range deliveries {
+			if ctx.Err() != nil || time.Now().After(deadline) {
+				return
+			}
+			jobs <- delivery
+		}
+	}()
+
+	mu := &sync.Mutex{}
+	delivered := 0
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < min(deliveryWorkers, len(deliveries)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for delivery := range jobs {
+				if ctx.Err() != nil || time.Now().After(deadline) {
+					continue
+				}
+
+				wh.deliver(ctx, delivery)
+
+				if err := wh.storage.UpdateDelivery(context.WithoutCancel(ctx), delivery); err != nil {
+					logger.Log.Error(
+						"error on saving webhook delivery",
+						zap.Int64("deliveryID", delivery.ID),
+						zap.Error(err),
+					)
+					continue
+				}
+
+				if delivery.Status == TypeDeliveryDelivered {
+					mu.Lock()
+					delivered++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return delivered, nil
+}
+
+// deliver makes one attempt to send delivery and records its result in delivery
+func (wh *Webhooks) deliver(ctx context.Context, delivery *Delivery) {
+	delivery.Attempts++
+
+	statusCode, err := wh.send(ctx, delivery)
+	delivery.LastStatusCode = statusCode
+
+	if err == nil {
+		now := time.Now().UTC()
+		delivery.Status = TypeDeliveryDelivered
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= deliveryMaxAttempts {
+		delivery.Status = TypeDeliveryFailed
+		logger.Log.Warn(
+			"webhook delivery failed",
+			zap.Int64("deliveryID", delivery.ID),
+			zap.String("webhookID", delivery.WebhookID),
+			zap.Error(err),
+		)
+		return
+	}
+
+	delivery.Status = TypeDeliveryPending
+	delivery.NextAttemptAt = time.Now().UTC().Add(deliveryRetryBackoff(delivery.Attempts))
+}
+
+// send posts signed payload of delivery to webhook URL, any status except 2xx is an error
+func (wh *Webhooks) send(ctx context.Context, delivery *Delivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytesReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, delivery.Payload))
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, fmt.Sprint(delivery.ID))
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// deliveryRetryBackoff returns delay before the next attempt of delivery already tried attempts times
+func deliveryRetryBackoff(attempts int) time.Duration {
+	backoff := deliveryBackoff
+	for i := 1; i < attempts && backoff < deliveryMaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, deliveryMaxBackoff)
+}
@@ -0,0 +1,289 @@
+package webhooks_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/renatus-cartesius/gophermart/internal/loyalty"
+	"github.com/renatus-cartesius/gophermart/internal/outbox"
+	"github.com/renatus-cartesius/gophermart/internal/storage/memory"
+	"github.com/renatus-cartesius/gophermart/internal/webhooks"
+	"github.com/renatus-cartesius/gophermart/pkg/money"
+)
+
+const userID = "e713ebf8-bb4b-11ef-9718-a7e5292ccfb8"
+
+var secret = strings.Repeat("s", 32)
+
+func TestWebhooks_Register(t *testing.T) {
+	wh := webhooks.NewWebhooks(memory.NewMemStorage(), http.DefaultClient)
+
+	tests := []struct {
+		name    string
+		url     string
+		secret  string
+		wantErr error
+	}{
+		{
+			name:   "SimpleRegister",
+			url:    "https://example.com/hook",
+			secret: secret,
+		},
+		{
+			name: "GeneratedSecret",
+			url:  "https://example.com/hook",
+		},
+		{
+			name:    "ShortSecret",
+			url:     "https://example.com/hook",
+			secret:  "secret",
+			wantErr: webhooks.ErrWebhookSecret,
+		},
+		{
+			name:    "NotHTTPS",
+			url:     "http://example.com/hook",
+			wantErr: webhooks.ErrWebhookInvalidURL,
+		},
+		{
+			name:    "RelativeURL",
+			url:     "/hook",
+			wantErr: webhooks.ErrWebhookInvalidURL,
+		},
+		{
+			name:    "Loopback",
+			url:     "https://127.0.0.1:8080/hook",
+			wantErr: webhooks.ErrWebhookInvalidURL,
+		},
+		{
+			name:    "LoopbackIPv6",
+			url:     "https://[::1]/hook",
+			wantErr: webhooks.ErrWebhookInvalidURL,
+		},
+		{
+			name:    "Localhost",
+			url:     "https://localhost/hook",
+			wantErr: webhooks.ErrWebhookInvalidURL,
+		},
+		{
+			name:    "PrivateNetwork",
+			url:     "https://10.0.0.5/hook",
+			wantErr: webhooks.ErrWebhookInvalidURL,
+		},
+		{
+			name:    "Metadata",
+			url:     "https://169.254.169.254/latest/meta-data",
+			wantErr: webhooks.ErrWebhookInvalidURL,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook, err := wh.Register(context.Background(), userID, tt.url, tt.secret)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Webhooks.Register() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if hook.ID == "" || hook.URL != tt.url || hook.Secret == "" || (tt.secret != "" && hook.Secret != tt.secret) {
+				t.Errorf("Webhooks.Register() = %+v", hook)
+			}
+		})
+	}
+
+	hooks, err := wh.GetWebhooks(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("Webhooks.GetWebhooks() error = %v", err)
+	}
+	if len(hooks) != 2 {
+		t.Fatalf("Webhooks.GetWebhooks() returned %v webhooks, want 2", len(hooks))
+	}
+	for _, hook := range hooks {
+		if hook.Secret != "" {
+			t.Errorf("Webhooks.GetWebhooks() returned secret of webhook %v", hook.ID)
+		}
+	}
+}
+
+// receiver is webhook endpoint which answers with status and records delivered bodies
+type receiver struct {
+	mu        sync.Mutex
+	status    int
+	bodies    [][]byte
+	signature []string
+	events    []string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.bodies = append(rc.bodies, body)
+	rc.signature = append(rc.signature, r.Header.Get(webhooks.SignatureHeader))
+	rc.events = append(rc.events, r.Header.Get(webhooks.EventHeader))
+	w.WriteHeader(rc.status)
+}
+
+// receiverClient returns client of TLS server srv which connects to it whatever host is requested
+func receiverClient(srv *httptest.Server) *http.Client {
+	client := srv.Client()
+	client.Transport.(*http.Transport).DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+	}
+	return client
+}
+
+func TestWebhooks_ProcessDeliveries(t *testing.T) {
+	ctx := context.Background()
+
+	rc := &receiver{status: http.StatusInternalServerError}
+	srv := httptest.NewTLSServer(rc)
+	defer srv.Close()
+
+	store := memory.NewMemStorage()
+	wh := webhooks.NewWebhooks(store, receiverClient(srv))
+
+	// Certificate of test server is valid for example.com, loopback URL isn`t accepted by Register
+	u, _ := url.Parse(srv.URL)
+	hook, err := wh.Register(ctx, userID, "https://example.com:"+u.Port()+"/hook", secret)
+	if err != nil {
+		t.Fatalf("Webhooks.Register() error = %v", err)
+	}
+
+	processing := outbox.OrderStatusChanged(&loyalty.Order{UserID: userID, ID: "79927398713", Status: loyalty.TypeStatusProcessing})
+	processing.ID = 1
+	processed := outbox.OrderStatusChanged(&loyalty.Order{UserID: userID, ID: "79927398713", Status: loyalty.TypeStatusProcessed, Accrual: money.MustParse("729.98")})
+	processed.ID = 2
+
+	// Orders not in terminal status aren`t notified, event published again by relay is enqueued once
+	for _, event := range []*outbox.Event{processing, processed, processed} {
+		if err := wh.Publish(ctx, event); err != nil {
+			t.Fatalf("Webhooks.Publish() error = %v", err)
+		}
+	}
+
+	delivered, err := wh.ProcessDeliveries(ctx)
+	if err != nil || delivered != 0 {
+		t.Fatalf("Webhooks.ProcessDeliveries() = %v, %v, want 0 delivered", delivered, err)
+	}
+
+	deliveries, err := wh.GetDeliveries(ctx, userID, hook.ID)
+	if err != nil {
+		t.Fatalf("Webhooks.GetDeliveries() error = %v", err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("Webhooks.GetDeliveries() returned %v deliveries, want 1", len(deliveries))
+	}
+
+	failed := deliveries[0]
+	if failed.Status != webhooks.TypeDeliveryPending || failed.Attempts != 1 || failed.LastStatusCode != http.StatusInternalServerError || failed.LastError == "" {
+		t.Errorf("delivery after failed attempt = %+v, want pending delivery with status 500", failed)
+	}
+
+	// Failed delivery waits for backoff
+	if delivered, _ := wh.ProcessDeliveries(ctx); delivered != 0 || len(rc.bodies) != 1 {
+		t.Fatalf("Webhooks.ProcessDeliveries() delivered %v, sent %v times, want delivery to wait for backoff", delivered, len(rc.bodies))
+	}
+
+	rc.mu.Lock()
+	rc.status = http.StatusNoContent
+	rc.mu.Unlock()
+
+	if err := wh.Redeliver(ctx, userID, failed.ID); err != nil {
+		t.Fatalf("Webhooks.Redeliver() error = %v", err)
+	}
+	if err := wh.Redeliver(ctx, "5c18f4b8-bbb8-11ef-bd1a-8bd0750e0c51", failed.ID); !errors.Is(err, webhooks.ErrDeliveryNotFound) {
+		t.Errorf("Webhooks.Redeliver() of another user error = %v, want %v", err, webhooks.ErrDeliveryNotFound)
+	}
+
+	if delivered, err := wh.ProcessDeliveries(ctx); err != nil || delivered != 1 {
+		t.Fatalf("Webhooks.ProcessDeliveries() = %v, %v, want 1 delivered", delivered, err)
+	}
+
+	deliveries, _ = wh.GetDeliveries(ctx, userID, hook.ID)
+	if deliveries[0].Status != webhooks.TypeDeliveryDelivered || deliveries[0].DeliveredAt == nil || deliveries[0].LastError != "" {
+		t.Errorf("delivery after successful attempt = %+v, want delivered", deliveries[0])
+	}
+
+	if len(rc.bodies) != 2 {
+		t.Fatalf("webhook received %v requests, want 2", len(rc.bodies))
+	}
+	for i, body := range rc.bodies {
+		if !webhooks.Verify(secret, body, rc.signature[i]) {
+			t.Errorf("request %v signature %q isn`t valid", i, rc.signature[i])
+		}
+		if rc.events[i] != webhooks.TypeEventOrderProcessed {
+			t.Errorf("request %v event header = %q, want %q", i, rc.events[i], webhooks.TypeEventOrderProcessed)
+		}
+	}
+
+	payload := struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Number  string       `json:"number"`
+			Accrual money.Amount `json:"accrual"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(rc.bodies[1], &payload); err != nil {
+		t.Fatalf("error on unmarshalling webhook payload: %v", err)
+	}
+	if payload.ID != "2" || payload.Type != webhooks.TypeEventOrderProcessed || payload.Data.Number != "79927398713" || payload.Data.Accrual != money.MustParse("729.98") {
+		t.Errorf("webhook payload = %+v", payload)
+	}
+
+	if _, err := wh.GetDeliveries(ctx, "5c18f4b8-bbb8-11ef-bd1a-8bd0750e0c51", hook.ID); !errors.Is(err, webhooks.ErrWebhookNotFound) {
+		t.Errorf("Webhooks.GetDeliveries() of another user error = %v, want %v", err, webhooks.ErrWebhookNotFound)
+	}
+}
+
+func TestWebhooks_Publish(t *testing.T) {
+	ctx := context.Background()
+	wh := webhooks.NewWebhooks(memory.NewMemStorage(), http.DefaultClient)
+
+	hook, err := wh.Register(ctx, userID, "https://example.com/hook", secret)
+	if err != nil {
+		t.Fatalf("Webhooks.Register() error = %v", err)
+	}
+
+	uploaded := outbox.OrderUploaded(userID, "79927398713")
+	uploaded.ID = 1
+	withdrawn := outbox.WithdrawCreated(&loyalty.Withdraw{UserID: userID, OrderID: "2377225624", Sum: money.New(500, 0)})
+	withdrawn.ID, withdrawn.Created = 2, time.Date(2024, 12, 30, 10, 0, 0, 0, time.UTC)
+
+	for _, event := range []*outbox.Event{uploaded, withdrawn} {
+		if err := wh.Publish(ctx, event); err != nil {
+			t.Fatalf("Webhooks.Publish() error = %v", err)
+		}
+	}
+
+	deliveries, err := wh.GetDeliveries(ctx, userID, hook.ID)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("Webhooks.GetDeliveries() = %v, %v, want only withdrawal delivery", len(deliveries), err)
+	}
+	if deliveries[0].EventID != "2" || deliveries[0].EventType != webhooks.TypeEventWithdrawCreated {
+		t.Errorf("delivery = %+v, want delivery of withdrawal event 2", deliveries[0])
+	}
+
+	payload := struct {
+		Data map[string]any `json:"data"`
+	}{}
+	if err := json.Unmarshal(deliveries[0].Payload, &payload); err != nil {
+		t.Fatalf("error on unmarshalling delivery payload: %v", err)
+	}
+	if payload.Data["order"] != "2377225624" || payload.Data["sum"] != 500.0 || payload.Data["processed_at"] != "2024-12-30T10:00:00Z" {
+		t.Errorf("delivery payload data = %v", payload.Data)
+	}
+}
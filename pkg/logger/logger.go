@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/go-chi/chi/v5/middleware"
 	"go.uber.org/zap"
 )
 
@@ -47,6 +48,11 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.statusCode = statusCode
 }
 
+// Unwrap allows http.ResponseController to reach the underlying writer, e.g. to flush streamed responses
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 func RequestLogger(h http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -67,6 +73,7 @@ func RequestLogger(h http.HandlerFunc) http.HandlerFunc {
 			"incoming request",
 			zap.String("method", r.Method),
 			zap.String("uri", r.URL.Path),
+			zap.String("requestID", middleware.GetReqID(r.Context())),
 			zap.Int("status", lw.responseData.statusCode),
 			zap.Int("size", lw.responseData.size),
 			zap.Duration("time", duration),
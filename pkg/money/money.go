@@ -0,0 +1,182 @@
+package money
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Scale is count of minor units in one point
+const Scale = 100
+
+var (
+	ErrInvalidAmount = errors.New("invalid amount")
+	ErrAmountRange   = errors.New("amount is out of range")
+)
+
+// Amount is exact fixed-point amount of points stored in minor units (hundredths)
+type Amount int64
+
+// New returns amount of units points and cents hundredths
+func New(units, cents int64) Amount {
+	return Amount(units*Scale + cents)
+}
+
+// amountPattern is decimal with at most two fractional digits, fractions, hex and exponents aren`t amounts
+var amountPattern = regexp.MustCompile(`^-?\d+(\.\d{1,2})?$`)
+
+// Parse converts decimal string like "729.98" or "400" to Amount.
+// Values with more than two fractional digits are rejected, not rounded.
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if !amountPattern.MatchString(s) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+
+	// at most two fractional digits, so amount in minor units is integer
+	minor := r.Mul(r, big.NewRat(Scale, 1)).Num()
+	if !minor.IsInt64() {
+		return 0, fmt.Errorf("%w: %q", ErrAmountRange, s)
+	}
+
+	return Amount(minor.Int64()), nil
+}
+
+// numberPattern is JSON number with any count of fractional digits, exponent is short to keep parsing cheap
+var numberPattern = regexp.MustCompile(`^-?\d+(\.\d+)?([eE][+-]?\d{1,3})?$`)
+
+// ParseRounded converts JSON number like "729.985" or "1.5e3" to Amount rounding it half away
+// from zero to minor units. It is for amounts computed by external systems, client input must use Parse.
+func ParseRounded(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if !numberPattern.MatchString(s) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	r.Mul(r, big.NewRat(Scale, 1))
+
+	minor, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if rem.Abs(rem).Lsh(rem, 1).Cmp(r.Denom()) >= 0 {
+		minor.Add(minor, big.NewInt(int64(r.Sign())))
+	}
+
+	if !minor.IsInt64() {
+		return 0, fmt.Errorf("%w: %q", ErrAmountRange, s)
+	}
+
+	return Amount(minor.Int64()), nil
+}
+
+// MustParse is like Parse but panics if s isn`t valid amount
+func MustParse(s string) Amount {
+	a, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// String formats amount as decimal without trailing zeros, e.g. "729.98", "331.3", "400"
+func (a Amount) String() string {
+	sign := ""
+	v := uint64(a)
+	if a < 0 {
+		sign = "-"
+		v = uint64(-a)
+	}
+
+	units, cents := v/Scale, v%Scale
+	if cents == 0 {
+		return sign + strconv.FormatUint(units, 10)
+	}
+
+	return strings.TrimRight(fmt.Sprintf("%s%d.%02d", sign, units, cents), "0")
+}
+
+// Float64 returns approximate float value of amount, it must be used only for logging and metrics
+func (a Amount) Float64() float64 {
+	return float64(a) / Scale
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts JSON number or string containing number
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		data = []byte(s)
+	}
+
+	v, err := Parse(string(data))
+	if err != nil {
+		return err
+	}
+
+	*a = v
+	return nil
+}
+
+// Scan implements sql.Scanner for numeric, integer and float columns
+func (a *Amount) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*a = 0
+	case string:
+		return a.scanString(v)
+	case []byte:
+		return a.scanString(string(v))
+	case int64:
+		if v > math.MaxInt64/Scale || v < math.MinInt64/Scale {
+			return ErrAmountRange
+		}
+		*a = Amount(v * Scale)
+	case float64:
+		// float columns are rounded to minor units
+		return a.scanString(strconv.FormatFloat(v, 'f', 2, 64))
+	default:
+		return fmt.Errorf("%w: can`t scan %T", ErrInvalidAmount, src)
+	}
+
+	return nil
+}
+
+func (a *Amount) scanString(s string) error {
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+
+	*a = v
+	return nil
+}
+
+// Value implements driver.Valuer, amount is passed to database as decimal string
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
@@ -0,0 +1,265 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Amount
+		wantErr bool
+	}{
+		{
+			name:  "Integer",
+			value: "400",
+			want:  40000,
+		},
+		{
+			name:  "Fraction",
+			value: "729.98",
+			want:  72998,
+		},
+		{
+			name:  "Negative",
+			value: "-0.05",
+			want:  -5,
+		},
+		{
+			name:    "Exponent",
+			value:   "1.5e3",
+			wantErr: true,
+		},
+		{
+			name:    "Rational",
+			value:   "1/3",
+			wantErr: true,
+		},
+		{
+			name:    "Hex",
+			value:   "0x10",
+			wantErr: true,
+		},
+		{
+			name:    "ThreeFractionalDigits",
+			value:   "1.005",
+			wantErr: true,
+		},
+		{
+			name:    "NoFractionalDigits",
+			value:   "1.",
+			wantErr: true,
+		},
+		{
+			name:    "Garbage",
+			value:   "12,5",
+			wantErr: true,
+		},
+		{
+			name:    "Overflow",
+			value:   "100000000000000000000",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRounded(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Amount
+		wantErr bool
+	}{
+		{
+			name:  "Fraction",
+			value: "729.98",
+			want:  72998,
+		},
+		{
+			name:  "RoundedDown",
+			value: "729.984",
+			want:  72998,
+		},
+		{
+			name:  "RoundedHalfUp",
+			value: "1.005",
+			want:  101,
+		},
+		{
+			name:  "NegativeRoundedHalfAway",
+			value: "-1.005",
+			want:  -101,
+		},
+		{
+			name:  "Exponent",
+			value: "1.5e3",
+			want:  150000,
+		},
+		{
+			name:    "HugeExponent",
+			value:   "1e100000",
+			wantErr: true,
+		},
+		{
+			name:    "Hex",
+			value:   "0x10",
+			wantErr: true,
+		},
+		{
+			name:    "Overflow",
+			value:   "100000000000000000000",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRounded(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseRounded() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseRounded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAmount_String(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount Amount
+		want   string
+	}{
+		{
+			name:   "Integer",
+			amount: New(400, 0),
+			want:   "400",
+		},
+		{
+			name:   "OneDigitFraction",
+			amount: New(331, 30),
+			want:   "331.3",
+		},
+		{
+			name:   "Cents",
+			amount: New(0, 5),
+			want:   "0.05",
+		},
+		{
+			name:   "Negative",
+			amount: -New(729, 98),
+			want:   "-729.98",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.amount.String(); got != tt.want {
+				t.Errorf("Amount.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAmount_NoDrift(t *testing.T) {
+	balance := MustParse("729.98")
+	for i := 0; i < 1000; i++ {
+		balance -= MustParse("0.1")
+	}
+
+	if got := balance.String(); got != "629.98" {
+		t.Errorf("balance after withdrawals = %v, want 629.98", got)
+	}
+}
+
+func TestAmount_JSON(t *testing.T) {
+	var got struct {
+		Sum Amount `json:"sum"`
+	}
+
+	if err := json.Unmarshal([]byte(`{"sum": 751.1}`), &got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if got.Sum != New(751, 10) {
+		t.Errorf("unmarshalled sum = %v, want 751.1", got.Sum)
+	}
+
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if string(data) != `{"sum":751.1}` {
+		t.Errorf("json.Marshal() = %s, want {\"sum\":751.1}", data)
+	}
+}
+
+func TestAmount_Scan(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     any
+		want    Amount
+		wantErr bool
+	}{
+		{
+			name: "Numeric",
+			src:  "729.98",
+			want: 72998,
+		},
+		{
+			name: "Bytes",
+			src:  []byte("0.1"),
+			want: 10,
+		},
+		{
+			name: "Integer",
+			src:  int64(5),
+			want: 500,
+		},
+		{
+			name: "Float",
+			src:  729.98,
+			want: 72998,
+		},
+		{
+			name: "FloatRounded",
+			src:  331.29999999999995,
+			want: 33130,
+		},
+		{
+			name: "Null",
+			src:  nil,
+			want: 0,
+		},
+		{
+			name:    "Bool",
+			src:     true,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Amount
+			if err := got.Scan(tt.src); (err != nil) != tt.wantErr {
+				t.Errorf("Amount.Scan() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Amount.Scan() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
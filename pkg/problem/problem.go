@@ -0,0 +1,80 @@
+// Package problem writes error responses as RFC 7807 problem details
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// ContentType is media type of problem details responses
+const ContentType = "application/problem+json"
+
+// typePrefix makes problem types URIs which don`t have to be resolvable
+const typePrefix = "urn:gophermart:problem:"
+
+// Problem is body of error response
+type Problem struct {
+	// Type is stable machine-readable identifier of the problem
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Detail explains this occurrence of the problem
+	Detail string `json:"detail,omitempty"`
+	// Instance is path of the request the problem occurred on
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Kind is class of problems sharing type, title and status
+type Kind struct {
+	Type   string
+	Title  string
+	Status int
+}
+
+// New returns kind of problems with type made from name
+func New(status int, name string, title string) Kind {
+	return Kind{
+		Type:   typePrefix + name,
+		Title:  title,
+		Status: status,
+	}
+}
+
+var (
+	BadRequest      = New(http.StatusBadRequest, "bad-request", "Request is malformed")
+	Unauthorized    = New(http.StatusUnauthorized, "unauthorized", "Authentication is required")
+	NotFound        = New(http.StatusNotFound, "not-found", "Resource not found")
+	Conflict        = New(http.StatusConflict, "conflict", "Request conflicts with current state of resource")
+	TooLarge        = New(http.StatusRequestEntityTooLarge, "too-large", "Request is too large")
+	Unprocessable   = New(http.StatusUnprocessableEntity, "unprocessable", "Request can`t be processed")
+	TooManyRequests = New(http.StatusTooManyRequests, "too-many-requests", "Too many requests")
+	Internal        = New(http.StatusInternalServerError, "internal", "Internal server error")
+)
+
+// Write writes problem of kind with detail as response to r
+func (k Kind) Write(w http.ResponseWriter, r *http.Request, detail string) {
+	Write(w, r, &Problem{
+		Type:   k.Type,
+		Title:  k.Title,
+		Status: k.Status,
+		Detail: detail,
+	})
+}
+
+// Write writes p as response to r, instance and request ID are taken from r if p has none
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.RequestID == "" {
+		p.RequestID = middleware.GetReqID(r.Context())
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Del("Content-Length")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,43 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestKind_Write(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/api/user/orders", nil)
+	r = r.WithContext(context.WithValue(r.Context(), middleware.RequestIDKey, "host/abc-000001"))
+	w := httptest.NewRecorder()
+
+	New(http.StatusUnprocessableEntity, "order-invalid", "Order number is invalid").Write(w, r, "order is invalid")
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Kind.Write() status = %v, want %v", w.Code, http.StatusUnprocessableEntity)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Kind.Write() Content-Type = %v, want %v", ct, ContentType)
+	}
+
+	got := &Problem{}
+	if err := json.Unmarshal(w.Body.Bytes(), got); err != nil {
+		t.Fatalf("error on unmarshalling problem: %v", err)
+	}
+
+	want := &Problem{
+		Type:      "urn:gophermart:problem:order-invalid",
+		Title:     "Order number is invalid",
+		Status:    http.StatusUnprocessableEntity,
+		Detail:    "order is invalid",
+		Instance:  "/api/user/orders",
+		RequestID: "host/abc-000001",
+	}
+	if *got != *want {
+		t.Errorf("Kind.Write() = %+v, want %+v", got, want)
+	}
+}